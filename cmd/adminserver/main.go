@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/looprock/email-to-api/internal/admin"
 	"github.com/looprock/email-to-api/internal/config"
@@ -19,7 +21,10 @@ func main() {
 	defer stop()
 
 	// Load configuration
-	cfg, err := config.LoadConfig()
+	profile := flag.String("profile", "", "configuration profile to apply (overrides EMAILTOAPI_PROFILE)")
+	flag.Parse()
+
+	cfg, err := config.LoadConfigProfile(*profile)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
@@ -30,6 +35,19 @@ func main() {
 		DSN:        cfg.Database.Path, // For SQLite
 		MigrateURL: "file://migrations",
 		Domain:     cfg.MailServer.Domain,
+		// Share the mail server's accepted domains so mappings are only
+		// created for addresses it will receive
+		AcceptedDomains:      cfg.AcceptedDomains(),
+		AllowedEndpointHosts: cfg.AdminServer.AllowedEndpointHosts,
+		Vanity: database.VanityConfig{
+			Enabled:      cfg.Vanity.Enabled,
+			MinLength:    cfg.Vanity.MinLength,
+			Blocklist:    cfg.Vanity.Blocklist,
+			BlockPattern: cfg.Vanity.BlockPattern,
+		},
+		DeleteGracePeriod: time.Duration(cfg.AdminServer.DeleteGraceHours) * time.Hour,
+		ConnectAttempts:   cfg.Database.ConnectAttempts,
+		ConnectBackoff:    time.Duration(cfg.Database.ConnectBackoff) * time.Second,
 	}
 	if cfg.Database.Driver == "postgres" {
 		dbConfig.DSN = fmt.Sprintf("host=%s port=%d user=%s dbname=%s password=%s sslmode=%s",
@@ -47,6 +65,11 @@ func main() {
 	}
 	defer db.Close()
 
+	// Purge deleted mappings once their grace period has passed
+	if dbConfig.DeleteGracePeriod > 0 {
+		go db.RunPurge(ctx, time.Hour)
+	}
+
 	// Start admin interface
 	adminServer, err := admin.New(db, cfg)
 	if err != nil {
@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/looprock/email-to-api/internal/config"
 	"github.com/looprock/email-to-api/internal/database"
+	"github.com/looprock/email-to-api/internal/doctor"
 	"github.com/looprock/email-to-api/internal/email"
 )
 
@@ -24,7 +29,18 @@ func main() {
 	defer stop()
 
 	// Load configuration
-	cfg, err := config.LoadConfig()
+	profile := flag.String("profile", "", "configuration profile to apply (overrides EMAILTOAPI_PROFILE)")
+	forceMigration := flag.Int("force-migration", -1, "mark this migration version as clean before migrating; repairs a dirty schema after fixing it by hand")
+	migrateOnlyFlag := flag.Bool("migrate-only", false, "apply database migrations and exit without serving")
+	skipMigrationsFlag := flag.Bool("skip-migrations", false, "serve without applying database migrations; run -migrate-only separately")
+	flag.Parse()
+
+	mode, err := parseMigrationMode(*migrateOnlyFlag, *skipMigrationsFlag, *forceMigration)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+
+	cfg, err := config.LoadConfigProfile(*profile)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
@@ -35,6 +51,9 @@ func main() {
 		DSN:        cfg.Database.Path,                              // For SQLite
 		MigrateURL: fmt.Sprintf("sqlite3://%s", cfg.Database.Path), // Database URL for migrations
 		Domain:     cfg.MailServer.Domain,
+		// Wait for the database rather than failing on its first query
+		ConnectAttempts: cfg.Database.ConnectAttempts,
+		ConnectBackoff:  time.Duration(cfg.Database.ConnectBackoff) * time.Second,
 	}
 	if cfg.Database.Driver == "postgres" {
 		dbConfig.DSN = fmt.Sprintf("host=%s port=%d user=%s dbname=%s password=%s sslmode=%s",
@@ -45,29 +64,157 @@ func main() {
 			cfg.Database.Port, cfg.Database.Name, cfg.Database.SSLMode)
 	}
 
+	// "check" reports on the configuration and its services, then exits
+	if flag.Arg(0) == "check" {
+		if !doctor.Run(cfg, dbConfig, os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	db, err := database.New(dbConfig)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
 
-	// Run database migrations
-	if err := db.Migrate(); err != nil {
-		log.Fatalf("Failed to run database migrations: %v", err)
+	// Run database migrations unless another process does, first repairing
+	// a dirty version if asked to
+	serve, err := prepareSchema(db, mode, *forceMigration)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !serve {
+		return
+	}
+
+	// Attachments are inlined unless object storage is configured
+	attachments := email.AttachmentConfig{
+		InlineMaxBytes: cfg.Attachments.InlineMaxBytes,
+		URLExpiry:      time.Duration(cfg.Attachments.URLExpiry) * time.Hour,
+	}
+	if cfg.Attachments.S3.Bucket != "" {
+		store, err := email.NewS3Store(email.S3Config{
+			Endpoint:  cfg.Attachments.S3.Endpoint,
+			Region:    cfg.Attachments.S3.Region,
+			Bucket:    cfg.Attachments.S3.Bucket,
+			AccessKey: cfg.Attachments.S3.AccessKey,
+			SecretKey: cfg.Attachments.S3.SecretKey,
+			PathStyle: cfg.Attachments.S3.PathStyle,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure attachment storage: %v", err)
+		}
+		attachments.Store = store
+	}
+
+	// Expose processing metrics for scraping when configured
+	var metrics *email.Metrics
+	if cfg.MailServer.MetricsAddr != "" {
+		metrics = email.NewMetrics(cfg.MailServer.MetricsPerMappingLabels)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		go func() {
+			log.Printf("Serving metrics on %s/metrics", cfg.MailServer.MetricsAddr)
+			if err := http.ListenAndServe(cfg.MailServer.MetricsAddr, mux); err != nil {
+				log.Printf("Metrics server error: %v", err)
+			}
+		}()
 	}
 
 	// Initialize email processor
+	backoff := email.BackoffConfig{
+		Jitter:     cfg.MailServer.RetryJitter,
+		MaxElapsed: time.Duration(cfg.MailServer.RetryMaxElapsed) * time.Second,
+	}
+	var webhookAuth *email.WebhookAuth
+	if cfg.MailServer.ReceiveMethod != "smtp" {
+		webhookAuth = email.NewWebhookAuth(cfg.WebhookSigningKey(), cfg.MailServer.WebhookInsecureSkipVerify)
+	}
+	var bounceTemplate *email.BounceTemplate
+	var bounceNotifier email.BounceNotifier
+	if cfg.MailServer.UnknownRecipient == email.UnknownRecipientBounce {
+		bounceTemplate, err = email.NewBounceTemplate(cfg.MailServer.BounceSubject, cfg.MailServer.BounceTemplate)
+		if err != nil {
+			log.Fatalf("Failed to configure bounces: %v", err)
+		}
+		sender, err := email.NewMailgunSender(cfg.Mailgun.SiteDomain, email.MailgunValidation{
+			Mode:    cfg.Mailgun.Validate,
+			Timeout: time.Duration(cfg.Mailgun.ValidateTimeout) * time.Second,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure bounces: %v", err)
+		}
+		if sender == nil {
+			log.Fatalf("mailserver.unknownrecipient is bounce but Mailgun is not configured")
+		}
+		bounceNotifier = sender
+	}
 	processor := email.New(db, email.ProcessorConfig{
-		MaxSize:       cfg.MailServer.MaxEmailSize,
-		RetryAttempts: cfg.MailServer.MaxRetries,
-		RetryDelay:    cfg.MailServer.RetryDelay,
+		MaxSize:             cfg.MailServer.MaxEmailSize,
+		RetryAttempts:       cfg.MailServer.MaxRetries,
+		RetryDelay:          cfg.MailServer.RetryDelay,
+		Backoff:             backoff,
+		Synchronous:         cfg.MailServer.Synchronous,
+		AcceptedDomains:     cfg.AcceptedDomains(),
+		MaxInFlight:         cfg.MailServer.MaxInFlight,
+		InstanceLabel:       cfg.InstanceLabel,
+		Attachments:         attachments,
+		MaxResponseBytes:    cfg.MailServer.MaxResponseBytes,
+		DeadLetterMaxBytes:  cfg.MailServer.DeadLetterMaxBytes,
+		MaxIdleConnsPerHost: cfg.MailServer.MaxIdleConnsPerHost,
+		IdleConnTimeout:     time.Duration(cfg.MailServer.IdleConnTimeout) * time.Second,
+		SpoolDir:            cfg.MailServer.SpoolDir,
+		SynthesizeHeaders:   cfg.MailServer.SynthesizeHeaders,
+		LookupError:         cfg.MailServer.LookupError,
+		InvalidSender:       cfg.MailServer.InvalidSender,
+		MaxLineLength:       cfg.MailServer.MaxLineLength,
+		MaxConnections:      cfg.MailServer.MaxConnections,
+		RejectInactive:      cfg.MailServer.RejectInactive,
+		IncludeTLSInfo:      cfg.MailServer.IncludeTLSInfo,
+		MaxTags:             cfg.MailServer.MaxTags,
+		MaxTagLength:        cfg.MailServer.MaxTagLength,
+		Metrics:             metrics,
+		WebhookAuth:         webhookAuth,
+		UnknownRecipient:    cfg.MailServer.UnknownRecipient,
+		BounceNotifier:      bounceNotifier,
+		BounceTemplate:      bounceTemplate,
 	})
+	if cfg.MailServer.SpoolDir != "" {
+		go processor.RunSpoolReplay(ctx, time.Duration(cfg.MailServer.SpoolReplayInterval)*time.Second)
+	}
+
+	// Send digests of mappings that collect emails into a periodic summary
+	go processor.RunDigests(ctx, time.Minute)
+
+	// Resend failed emails and dead letters requeued from the admin interface
+	go processor.RunRequeuedRetries(ctx, 10*time.Second)
+
+	// Watch the pending-delivery queue for a backlog
+	if cfg.MailServer.QueueAlertThreshold > 0 {
+		monitor := email.NewQueueMonitor(db, email.QueueAlertConfig{
+			Threshold:  cfg.MailServer.QueueAlertThreshold,
+			WebhookURL: cfg.MailServer.QueueAlertWebhook,
+			Interval:   time.Duration(cfg.MailServer.QueueAlertInterval) * time.Second,
+		})
+		go monitor.Run(ctx)
+	}
 
 	// Start the appropriate email receiver based on configuration
+	var receiverDone chan struct{}
 	switch cfg.MailServer.ReceiveMethod {
 	case "smtp":
 		go func() {
-			if err := email.StartSMTPServer(processor, cfg.MailServer.SMTPHost, cfg.MailServer.SMTPPort); err != nil {
+			tlsConfig := email.TLSConfig{
+				CertFile:            cfg.MailServer.TLS.CertFile,
+				KeyFile:             cfg.MailServer.TLS.KeyFile,
+				ClientCAFile:        cfg.MailServer.TLS.ClientCAFile,
+				MapClientCertToUser: cfg.MailServer.TLS.MapClientCertToUser,
+				MinVersion:          cfg.MailServer.TLS.MinVersion,
+				CipherSuites:        cfg.MailServer.TLS.CipherSuites,
+				RequireForAuth:      cfg.MailServer.TLS.RequireForAuth,
+			}
+			if err := email.StartSMTPServer(processor, cfg.MailServer.SMTPHost, cfg.MailServer.SMTPPort, tlsConfig); err != nil {
 				log.Printf("SMTP server error: %v", err)
 				stop()
 			}
@@ -75,8 +222,26 @@ func main() {
 		log.Printf("Started SMTP server on %s:%d", cfg.MailServer.SMTPHost, cfg.MailServer.SMTPPort)
 
 	case "webhook":
-		// TODO: Implement webhook receiver
-		log.Fatal("Webhook receiver not yet implemented")
+		receiverDone = make(chan struct{})
+		go func() {
+			defer close(receiverDone)
+			if err := email.StartWebhookServer(processor, cfg.MailServer.Host, cfg.MailServer.Port); err != nil {
+				log.Printf("Webhook server error: %v", err)
+				stop()
+			}
+		}()
+		log.Printf("Started webhook server on %s:%d", cfg.MailServer.Host, cfg.MailServer.Port)
+
+	case "mailgun-webhook":
+		receiverDone = make(chan struct{})
+		go func() {
+			defer close(receiverDone)
+			if err := email.StartMailgunWebhookServer(processor, cfg.MailServer.Host, cfg.MailServer.Port); err != nil {
+				log.Printf("Mailgun webhook server error: %v", err)
+				stop()
+			}
+		}()
+		log.Printf("Started Mailgun webhook server on %s:%d", cfg.MailServer.Host, cfg.MailServer.Port)
 
 	default:
 		log.Fatalf("Unknown email receive method: %s", cfg.MailServer.ReceiveMethod)
@@ -85,4 +250,84 @@ func main() {
 	// Keep the application running until we receive an interrupt signal
 	<-ctx.Done()
 	log.Println("Shutting down mail server...")
+
+	// Give in-flight deliveries a bounded window to finish
+	processor.Shutdown(time.Duration(cfg.MailServer.DrainTimeout) * time.Second)
+
+	// Let the webhook receiver answer its open requests before exiting
+	if receiverDone != nil {
+		<-receiverDone
+	}
+}
+
+// migrationMode says whether a run migrates the schema and whether it
+// serves mail afterwards
+type migrationMode int
+
+const (
+	// migrateAndServe applies pending migrations, then serves (the default)
+	migrateAndServe migrationMode = iota
+	// migrateOnly applies pending migrations and exits, for an init
+	// container or job that migrates ahead of the servers
+	migrateOnly
+	// skipMigrations serves without migrating, leaving that to a
+	// -migrate-only run so replicas don't migrate concurrently
+	skipMigrations
+)
+
+// parseMigrationMode picks the mode from the -migrate-only,
+// -skip-migrations and -force-migration flags
+func parseMigrationMode(only, skip bool, forceVersion int) (migrationMode, error) {
+	switch {
+	case only && skip:
+		return 0, errors.New("-migrate-only and -skip-migrations can't be used together")
+	case skip && forceVersion >= 0:
+		return 0, errors.New("-force-migration needs a run that migrates; use it with -migrate-only instead of -skip-migrations")
+	case only:
+		return migrateOnly, nil
+	case skip:
+		return skipMigrations, nil
+	}
+	return migrateAndServe, nil
+}
+
+// prepareSchema runs the migrations the mode calls for, first repairing a
+// dirty version when forceVersion is set, and reports whether the server
+// should start
+func prepareSchema(db *database.DB, mode migrationMode, forceVersion int) (bool, error) {
+	if mode == skipMigrations {
+		// Serving on an outdated schema fails on the first query that needs
+		// a new column, so say so up front
+		status, err := db.MigrationStatus()
+		switch {
+		case err != nil:
+			log.Printf("Warning: Skipping migrations without checking the schema: %v", err)
+		case status.Dirty:
+			log.Printf("Warning: Skipping migrations, but the schema is dirty at version %d", status.Version)
+		case status.Pending():
+			log.Printf("Warning: Skipping migrations, but the schema is at version %d of %d", status.Version, status.Latest)
+		default:
+			log.Printf("Skipping migrations; the schema is at version %d", status.Version)
+		}
+		return true, nil
+	}
+
+	if forceVersion >= 0 {
+		if err := db.ForceMigrationVersion(forceVersion); err != nil {
+			return false, fmt.Errorf("failed to force migration version: %w", err)
+		}
+	}
+	if err := db.Migrate(); err != nil {
+		var dirty *database.DirtyMigrationError
+		if errors.As(err, &dirty) {
+			return false, fmt.Errorf("%w with -force-migration=<version>", err)
+		}
+		return false, fmt.Errorf("failed to run database migrations: %w", err)
+	}
+
+	if mode == migrateOnly {
+		log.Println("Migrations applied; exiting without serving (-migrate-only)")
+		return false, nil
+	}
+	return true, nil
 }
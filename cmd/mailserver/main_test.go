@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// newMigrationTestDB creates an unmigrated SQLite database with one
+// migration available
+func newMigrationTestDB(t *testing.T) *database.DB {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, sql := range map[string]string{
+		"001_create_widgets.up.sql":   "CREATE TABLE widgets (id INTEGER PRIMARY KEY);",
+		"001_create_widgets.down.sql": "DROP TABLE widgets;",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(sql), 0644); err != nil {
+			t.Fatalf("Failed to write migration: %v", err)
+		}
+	}
+
+	dsn := filepath.Join(t.TempDir(), "migrate.db")
+	db, err := database.New(&database.Config{
+		Driver:           "sqlite",
+		DSN:              dsn,
+		MigrateURL:       "sqlite3://" + dsn,
+		MigrationsSource: "file://" + dir,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestParseMigrationMode(t *testing.T) {
+	tests := []struct {
+		only, skip bool
+		force      int
+		want       migrationMode
+		wantErr    bool
+	}{
+		{force: -1, want: migrateAndServe},
+		{only: true, force: -1, want: migrateOnly},
+		{only: true, force: 3, want: migrateOnly},
+		{skip: true, force: -1, want: skipMigrations},
+		{only: true, skip: true, force: -1, wantErr: true},
+		{skip: true, force: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMigrationMode(tt.only, tt.skip, tt.force)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseMigrationMode(%v, %v, %d) = %v, %v; want %v, error %v", tt.only, tt.skip, tt.force, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPrepareSchema_MigrateOnly(t *testing.T) {
+	db := newMigrationTestDB(t)
+
+	serve, err := prepareSchema(db, migrateOnly, -1)
+	if err != nil {
+		t.Fatalf("prepareSchema() error = %v", err)
+	}
+	if serve {
+		t.Error("Expected -migrate-only to exit without serving")
+	}
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus() error = %v", err)
+	}
+	if status.Version != 1 || status.Pending() {
+		t.Errorf("Expected migrations to be applied, got %+v", status)
+	}
+}
+
+func TestPrepareSchema_SkipMigrations(t *testing.T) {
+	db := newMigrationTestDB(t)
+
+	serve, err := prepareSchema(db, skipMigrations, -1)
+	if err != nil {
+		t.Fatalf("prepareSchema() error = %v", err)
+	}
+	if !serve {
+		t.Error("Expected -skip-migrations to serve")
+	}
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus() error = %v", err)
+	}
+	if status.Version != 0 || !status.Pending() {
+		t.Errorf("Expected no migrations to be applied, got %+v", status)
+	}
+}
+
+func TestPrepareSchema_MigrateAndServe(t *testing.T) {
+	db := newMigrationTestDB(t)
+
+	serve, err := prepareSchema(db, migrateAndServe, -1)
+	if err != nil {
+		t.Fatalf("prepareSchema() error = %v", err)
+	}
+	if !serve {
+		t.Error("Expected the default mode to serve after migrating")
+	}
+	if status, _ := db.MigrationStatus(); status.Version != 1 {
+		t.Errorf("Expected migrations to be applied, got %+v", status)
+	}
+}
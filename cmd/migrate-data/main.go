@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/looprock/email-to-api/internal/config"
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// migrate-data copies users, mappings and logs from a SQLite database into
+// the database configured for the servers (normally Postgres), keeping IDs.
+func main() {
+	// Configure logging
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	log.SetPrefix("[migrate-data] ")
+
+	source := flag.String("source", "", "path to the SQLite database to copy from")
+	profile := flag.String("profile", "", "configuration profile to apply (overrides EMAILTOAPI_PROFILE)")
+	flag.Parse()
+
+	if *source == "" {
+		log.Fatal("-source is required")
+	}
+
+	cfg, err := config.LoadConfigProfile(*profile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if cfg.Database.Driver != "postgres" {
+		log.Fatalf("Target database driver is %q; configure the Postgres target in database settings", cfg.Database.Driver)
+	}
+
+	src, err := database.New(&database.Config{Driver: "sqlite", DSN: *source})
+	if err != nil {
+		log.Fatalf("Failed to open source database: %v", err)
+	}
+	defer src.Close()
+
+	dst, err := database.New(&database.Config{
+		Driver: "postgres",
+		DSN: fmt.Sprintf("host=%s port=%d user=%s dbname=%s password=%s sslmode=%s",
+			cfg.Database.Host, cfg.Database.Port, cfg.Database.User,
+			cfg.Database.Name, cfg.Database.Password, cfg.Database.SSLMode),
+		MigrateURL: fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
+			cfg.Database.User, cfg.Database.Password, cfg.Database.Host,
+			cfg.Database.Port, cfg.Database.Name, cfg.Database.SSLMode),
+	})
+	if err != nil {
+		log.Fatalf("Failed to open target database: %v", err)
+	}
+	defer dst.Close()
+
+	// Bring the target schema up to date before copying into it
+	if err := dst.Migrate(); err != nil {
+		log.Fatalf("Failed to run target database migrations: %v", err)
+	}
+
+	log.Printf("Copying data from %s to %s/%s", *source, cfg.Database.Host, cfg.Database.Name)
+	stats, err := database.CopyData(src, dst)
+	if err != nil {
+		log.Fatalf("Failed to copy data: %v", err)
+	}
+	log.Printf("Copied %d users, %d mappings and %d logs", stats.Users, stats.Mappings, stats.Logs)
+}
@@ -3,8 +3,13 @@ package admin
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"time"
 
@@ -19,20 +24,39 @@ type Session struct {
 	ExpiresAt time.Time
 }
 
-// SessionManager handles user sessions
+// csrfToken is an issued CSRF token and its expiry
+type csrfToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// SessionManager handles user sessions. Sessions and CSRF tokens are keyed by
+// tokenKey(token) and confirmed with secureCompare, so lookups don't leak
+// timing information about the stored tokens.
 type SessionManager struct {
 	sessions   map[string]Session
-	csrfTokens map[string]time.Time
+	csrfTokens map[string]csrfToken
 }
 
 // NewSessionManager creates a new session manager
 func NewSessionManager() *SessionManager {
 	return &SessionManager{
 		sessions:   make(map[string]Session),
-		csrfTokens: make(map[string]time.Time),
+		csrfTokens: make(map[string]csrfToken),
 	}
 }
 
+// tokenKey derives the map key for a secret token
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// secureCompare reports whether two secrets are equal in constant time
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
 // CreateSession creates a new session for a user
 func (sm *SessionManager) CreateSession(userID uint, role string) (string, error) {
 	// Generate random token
@@ -43,7 +67,7 @@ func (sm *SessionManager) CreateSession(userID uint, role string) (string, error
 	token := base64.URLEncoding.EncodeToString(tokenBytes)
 
 	// Store session
-	sm.sessions[token] = Session{
+	sm.sessions[tokenKey(token)] = Session{
 		Token:     token,
 		UserID:    userID,
 		Role:      role,
@@ -55,18 +79,19 @@ func (sm *SessionManager) CreateSession(userID uint, role string) (string, error
 
 // GetSession retrieves a session by token
 func (sm *SessionManager) GetSession(token string) *Session {
-	if session, exists := sm.sessions[token]; exists {
+	key := tokenKey(token)
+	if session, exists := sm.sessions[key]; exists && secureCompare(session.Token, token) {
 		if time.Now().Before(session.ExpiresAt) {
 			return &session
 		}
-		delete(sm.sessions, token)
+		delete(sm.sessions, key)
 	}
 	return nil
 }
 
 // ClearSession removes a session
 func (sm *SessionManager) ClearSession(token string) {
-	delete(sm.sessions, token)
+	delete(sm.sessions, tokenKey(token))
 }
 
 // GenerateCSRFToken generates a new CSRF token
@@ -79,18 +104,19 @@ func (sm *SessionManager) GenerateCSRFToken() string {
 	token := base64.URLEncoding.EncodeToString(tokenBytes)
 
 	// Store token with expiration
-	sm.csrfTokens[token] = time.Now().Add(1 * time.Hour)
+	sm.csrfTokens[tokenKey(token)] = csrfToken{Token: token, ExpiresAt: time.Now().Add(1 * time.Hour)}
 
 	return token
 }
 
 // ValidateCSRFToken validates a CSRF token
 func (sm *SessionManager) ValidateCSRFToken(token string) bool {
-	if expiresAt, exists := sm.csrfTokens[token]; exists {
-		if time.Now().Before(expiresAt) {
+	key := tokenKey(token)
+	if stored, exists := sm.csrfTokens[key]; exists && secureCompare(stored.Token, token) {
+		if time.Now().Before(stored.ExpiresAt) {
 			return true
 		}
-		delete(sm.csrfTokens, token)
+		delete(sm.csrfTokens, key)
 	}
 	return false
 }
@@ -101,22 +127,24 @@ func (s *Server) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 		// Check for session cookie
 		cookie, err := r.Cookie("session")
 		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			s.redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
 		// Validate session
 		session := s.sessions.GetSession(cookie.Value)
 		if session == nil {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			s.redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
 		// Fetch user email from DB
 		user, err := s.db.GetUserByID(session.UserID)
 		userEmail := ""
+		var display displayPreferences
 		if err == nil && user != nil {
 			userEmail = user.Email
+			display = displayPreferences{Timezone: user.Timezone, Locale: user.Locale}
 		}
 
 		// Add user info to context
@@ -124,6 +152,7 @@ func (s *Server) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 		ctx = context.WithValue(ctx, userIDKey, session.UserID)
 		ctx = context.WithValue(ctx, userRoleKey, session.Role)
 		ctx = context.WithValue(ctx, "userEmail", userEmail)
+		ctx = context.WithValue(ctx, userDisplayKey, display)
 		next(w, r.WithContext(ctx))
 	}
 }
@@ -143,7 +172,7 @@ func (s *Server) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
 // HandleLogin handles user login
 func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		s.tmpl.ExecuteTemplate(w, "login.html", nil)
+		s.renderLogin(w, "")
 		return
 	}
 
@@ -161,9 +190,7 @@ func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("INFO: No user found for email")
 	}
 	if err != nil || user == nil {
-		s.tmpl.ExecuteTemplate(w, "login.html", map[string]string{
-			"Error": "Invalid email or password",
-		})
+		s.renderLogin(w, "Invalid email or password")
 		return
 	}
 
@@ -172,34 +199,66 @@ func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
 		// fmt.Printf("DEBUG: Password check failed: %v\n", err)
-		s.tmpl.ExecuteTemplate(w, "login.html", map[string]string{
-			"Error": "Invalid email or password",
-		})
+		s.renderLogin(w, "Invalid email or password")
 		return
 	}
 
+	s.startSession(w, r, user.ID, user.Role, http.SameSiteStrictMode)
+}
+
+// renderLogin renders the login page with an optional error message
+func (s *Server) renderLogin(w http.ResponseWriter, errMsg string) {
+	s.tmpl.ExecuteTemplate(w, "login.html", map[string]interface{}{
+		"Error":  errMsg,
+		"OIDC":   s.oidc != nil,
+		"Signup": s.signup.Enabled,
+	})
+}
+
+// startSession creates a session for the user, sets its cookie and
+// redirects to the landing page for their role
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, userID uint, role string, sameSite http.SameSite) {
 	// Create session
-	token, err := s.sessions.CreateSession(user.ID, user.Role)
+	token, err := s.sessions.CreateSession(userID, role)
 	if err != nil {
-		s.tmpl.ExecuteTemplate(w, "login.html", map[string]string{
-			"Error": "Failed to create session",
-		})
+		s.renderLogin(w, "Failed to create session")
 		return
 	}
 
+	// Audit where the login came from; failing to record it doesn't block the login
+	if err := s.db.UpdateLastLogin(userID, remoteIP(r), r.UserAgent()); err != nil {
+		log.Printf("Failed to record last login for user %d: %v", userID, err)
+	}
+
 	// Set session cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session",
 		Value:    token,
-		Path:     "/",
+		Path:     s.url("/"),
 		HttpOnly: true,
 		Secure:   r.TLS != nil,
-		SameSite: http.SameSiteStrictMode,
+		SameSite: sameSite,
 		MaxAge:   86400, // 24 hours
 	})
 
-	// Redirect to home
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	s.redirect(w, r, s.landingPath(role), http.StatusSeeOther)
+}
+
+// landingPath is the route users with role are sent to after logging in
+func (s *Server) landingPath(role string) string {
+	if path, ok := s.loginRedirects[role]; ok {
+		return path
+	}
+	return "/"
+}
+
+// remoteIP returns the client IP of a request without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 // HandleLogout handles user logout
@@ -208,7 +267,7 @@ func (s *Server) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session",
 		Value:    "",
-		Path:     "/",
+		Path:     s.url("/"),
 		MaxAge:   -1,
 		HttpOnly: true,
 	})
@@ -219,5 +278,5 @@ func (s *Server) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Redirect to login
-	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	s.redirect(w, r, "/login", http.StatusSeeOther)
 }
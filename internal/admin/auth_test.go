@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSecureCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"token", "token", true},
+		{"token", "tokem", false},
+		{"token", "tok", false},
+		{"token", "token-suffix", false},
+		{"", "", true},
+		{"token", "", false},
+	}
+
+	for _, tt := range tests {
+		if got := secureCompare(tt.a, tt.b); got != tt.want {
+			t.Errorf("secureCompare(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSessionManager_TokenValidation(t *testing.T) {
+	sm := NewSessionManager()
+
+	csrf := sm.GenerateCSRFToken()
+	if !sm.ValidateCSRFToken(csrf) {
+		t.Error("Expected issued CSRF token to validate")
+	}
+	// Change the first character to one it isn't, so the forgery always differs
+	first := "x"
+	if csrf[0] == 'x' {
+		first = "y"
+	}
+	for _, forged := range []string{"", csrf[:len(csrf)-1], csrf + "x", first + csrf[1:]} {
+		if sm.ValidateCSRFToken(forged) {
+			t.Errorf("Expected forged CSRF token %q to be rejected", forged)
+		}
+	}
+
+	session, err := sm.CreateSession(7, "user")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if got := sm.GetSession(session); got == nil || got.UserID != 7 {
+		t.Errorf("Expected session for user 7, got %v", got)
+	}
+	if sm.GetSession(session[:len(session)-1]) != nil {
+		t.Error("Expected truncated session token to be rejected")
+	}
+
+	sm.ClearSession(session)
+	if sm.GetSession(session) != nil {
+		t.Error("Expected cleared session to be gone")
+	}
+}
+
+func TestHandleLogin_RecordsLastLogin(t *testing.T) {
+	s := newTestServer(t)
+	user, err := s.db.CreateUser("alice@example.com", "user")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := s.db.ChangePassword(user.ID, "", "correct horse"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	form := url.Values{"email": {"alice@example.com"}, "password": {"correct horse"}}
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "TestBrowser/1.0")
+	req.RemoteAddr = "203.0.113.7:54321"
+	rec := httptest.NewRecorder()
+	s.HandleLogin(rec, req)
+
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect after login, got %d", rec.Code)
+	}
+
+	updated, err := s.db.GetUserByID(user.ID)
+	if err != nil || updated == nil {
+		t.Fatalf("Failed to reload user: %v", err)
+	}
+	if updated.LastLogin == nil {
+		t.Error("Expected last login time to be recorded")
+	}
+	if updated.LastLoginIP != "203.0.113.7" {
+		t.Errorf("Expected last login IP 203.0.113.7, got %q", updated.LastLoginIP)
+	}
+	if updated.LastLoginUA != "TestBrowser/1.0" {
+		t.Errorf("Expected last login user agent TestBrowser/1.0, got %q", updated.LastLoginUA)
+	}
+}
+
+func TestHandleLogin_RoleRedirects(t *testing.T) {
+	s := newTestServer(t)
+	s.basePath = "/email-admin"
+	s.loginRedirects = map[string]string{"admin": "/users", "user": "/logs"}
+
+	for _, tt := range []struct {
+		email, role, want string
+	}{
+		{"root@example.com", "admin", "/email-admin/users"},
+		{"bob@example.com", "user", "/email-admin/logs"},
+	} {
+		user, err := s.db.CreateUser(tt.email, tt.role)
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if err := s.db.ChangePassword(user.ID, "", "correct horse"); err != nil {
+			t.Fatalf("Failed to set password: %v", err)
+		}
+
+		form := url.Values{"email": {tt.email}, "password": {"correct horse"}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.HandleLogin(rec, req)
+
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("Expected redirect after %s login, got %d", tt.role, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("Expected %s to land on %s, got %s", tt.role, tt.want, got)
+		}
+	}
+
+	s.loginRedirects = nil
+	if got := s.landingPath("admin"); got != "/" {
+		t.Errorf("Expected default landing path /, got %s", got)
+	}
+}
@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// APIRetryResult is the response of the bulk retry endpoint
+type APIRetryResult struct {
+	database.RequeueResult
+	// Pending is the number of requeued emails the mail server has yet to send
+	Pending int64 `json:"pending"`
+}
+
+// handleAPILogsRetry is a handler for the /api/logs/retry endpoint. A POST
+// requeues the failed emails matching the optional mapping_id and
+// since/until form values; the mail server sends them again in the
+// background. A GET reports how many requeued emails are still pending.
+func (s *Server) handleAPILogsRetry(w http.ResponseWriter, r *http.Request) {
+	var result APIRetryResult
+	switch r.Method {
+	case "GET":
+	case "POST":
+		// Validate CSRF token
+		if !s.sessions.ValidateCSRFToken(r.FormValue("token")) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+
+		var filter database.RequeueFilter
+		if value := r.FormValue("mapping_id"); value != "" {
+			mappingID, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				http.Error(w, "Invalid mapping_id", http.StatusBadRequest)
+				return
+			}
+			filter.MappingID = uint(mappingID)
+		}
+		var err error
+		if filter.Since, err = parseDateParam(r.FormValue("since"), false); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid since: %v", err), http.StatusBadRequest)
+			return
+		}
+		if filter.Until, err = parseDateParam(r.FormValue("until"), true); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid until: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		result.RequeueResult, err = s.db.RequeueFailedLogs(filter)
+		if err != nil {
+			log.Printf("Failed to requeue failed emails: %v", err)
+			http.Error(w, "Failed to requeue failed emails", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Requeued %d failed emails (%d without a stored payload skipped)", result.Requeued, result.Skipped)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pending, err := s.db.CountRequeuedLogs()
+	if err != nil {
+		log.Printf("Failed to count requeued emails: %v", err)
+		http.Error(w, "Failed to count requeued emails", http.StatusInternalServerError)
+		return
+	}
+	result.Pending = pending
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
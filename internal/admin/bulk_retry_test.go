@@ -0,0 +1,110 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestHandleAPILogsRetry_RequeuesFailed(t *testing.T) {
+	s := newTestServer(t)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	mapping := seedLogs(t, s, 1, start, "error", "error", "error", "error", "error", "success")
+	seedLogs(t, s, 2, start, "error")
+
+	// Every failure but the fifth has a stored payload
+	for _, requestID := range []string{"req-1-0", "req-1-1", "req-1-2", "req-1-3", "req-2-0"} {
+		if err := s.db.SaveFailedPayload(requestID, `{"data":{"subject":"retry me"}}`); err != nil {
+			t.Fatalf("Failed to save payload: %v", err)
+		}
+	}
+
+	// The first failure falls before the time range
+	form := url.Values{
+		"token":      {s.sessions.GenerateCSRFToken()},
+		"mapping_id": {strconv.FormatUint(uint64(mapping.ID), 10)},
+		"since":      {"2026-03-02"},
+	}
+	r := httptest.NewRequest("POST", "/api/logs/retry", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleAPILogsRetry(rec, asAdmin(r))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var result APIRetryResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Requeued != 3 || result.Skipped != 1 || result.Pending != 3 {
+		t.Errorf("Expected 3 requeued, 1 skipped and 3 pending, got %+v", result)
+	}
+
+	statuses := map[string]string{}
+	var logs []database.EmailLog
+	if err := s.db.Order("id").Find(&logs).Error; err != nil {
+		t.Fatalf("Failed to load logs: %v", err)
+	}
+	for _, entry := range logs {
+		statuses[entry.RequestID] = entry.Status
+	}
+	want := map[string]string{
+		"req-1-0": "error",
+		"req-1-1": database.LogStatusRequeued,
+		"req-1-2": database.LogStatusRequeued,
+		"req-1-3": database.LogStatusRequeued,
+		"req-1-4": "error",
+		"req-1-5": "success",
+		"req-2-0": "error",
+	}
+	for requestID, status := range want {
+		if statuses[requestID] != status {
+			t.Errorf("Expected log %s to be %q, got %q", requestID, status, statuses[requestID])
+		}
+	}
+
+	// The requeued emails are claimed once
+	claimed, err := s.db.ClaimRequeuedLogs(10)
+	if err != nil {
+		t.Fatalf("Failed to claim requeued logs: %v", err)
+	}
+	if len(claimed) != 3 || claimed[0].Mapping.ID != mapping.ID {
+		t.Fatalf("Expected 3 claimed logs of mapping %d, got %+v", mapping.ID, claimed)
+	}
+	if claimed, _ := s.db.ClaimRequeuedLogs(10); len(claimed) != 0 {
+		t.Errorf("Expected nothing left to claim, got %d logs", len(claimed))
+	}
+}
+
+func TestHandleAPILogsRetry_RejectsBadRequests(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		name string
+		form url.Values
+		want int
+	}{
+		{"missing token", url.Values{}, http.StatusForbidden},
+		{"bad mapping", url.Values{"token": {s.sessions.GenerateCSRFToken()}, "mapping_id": {"abc"}}, http.StatusBadRequest},
+		{"bad since", url.Values{"token": {s.sessions.GenerateCSRFToken()}, "since": {"yesterday"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/logs/retry", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			s.handleAPILogsRetry(rec, asAdmin(r))
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// handleCloneMapping is a handler for the POST /api/mappings/clone endpoint.
+// It copies the caller's mapping to a new mapping with a new address.
+func (s *Server) handleCloneMapping(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value(userIDKey).(uint)
+
+	// Validate CSRF token
+	if !s.sessions.ValidateCSRFToken(r.FormValue("token")) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	emailAddress := r.FormValue("email")
+	if emailAddress == "" {
+		http.Error(w, "Email address required", http.StatusBadRequest)
+		return
+	}
+
+	clone, err := s.db.CloneEmailMapping(emailAddress, userID)
+	if err != nil {
+		log.Printf("Error cloning mapping %s: %v", emailAddress, err)
+		http.Error(w, fmt.Sprintf("Failed to clone mapping: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d cloned mapping %s to %s", userID, emailAddress, clone.GeneratedEmail)
+
+	// Redirect back to mappings page
+	s.redirect(w, r, "/", http.StatusSeeOther)
+}
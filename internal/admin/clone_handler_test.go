@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestHandleCloneMapping(t *testing.T) {
+	s := newTestServer(t)
+
+	source, err := s.db.CreateEmailMapping(1, "https://hooks.example.com/email", "invoices", map[string]string{"Authorization": "Bearer x"})
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+	opts := database.MappingOptions{DropAutoSubmitted: true, FieldNaming: "camelCase", LogLevel: database.LogLevelErrors}
+	if err := s.db.UpdateMappingOptions(source.GeneratedEmail, 1, opts); err != nil {
+		t.Fatalf("Failed to set options: %v", err)
+	}
+	if err := s.db.SetMappingSigningSecret(source.GeneratedEmail, 1, "s3cret"); err != nil {
+		t.Fatalf("Failed to set signing secret: %v", err)
+	}
+
+	form := url.Values{"email": {source.GeneratedEmail}, "token": {s.sessions.GenerateCSRFToken()}}
+	req := httptest.NewRequest("POST", "/api/mappings/clone", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleCloneMapping(rec, asAdmin(req))
+
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var mappings []database.EmailMapping
+	if err := s.db.Where("user_id = ?", 1).Order("id").Find(&mappings).Error; err != nil {
+		t.Fatalf("Failed to list mappings: %v", err)
+	}
+	if len(mappings) != 2 {
+		t.Fatalf("Expected 2 mappings after clone, got %d", len(mappings))
+	}
+
+	original, clone := mappings[0], mappings[1]
+	if clone.GeneratedEmail == original.GeneratedEmail {
+		t.Error("Expected clone to have a distinct address")
+	}
+	if clone.EndpointURL != original.EndpointURL || clone.Description != original.Description {
+		t.Errorf("Expected identical endpoint and description, got %q %q", clone.EndpointURL, clone.Description)
+	}
+	if !reflect.DeepEqual(clone.Headers, original.Headers) {
+		t.Errorf("Expected identical headers, got %v", clone.Headers)
+	}
+	if !reflect.DeepEqual(clone.MappingOptions, original.MappingOptions) {
+		t.Errorf("Expected identical options, got %+v want %+v", clone.MappingOptions, original.MappingOptions)
+	}
+	if clone.SigningSecret != "s3cret" {
+		t.Errorf("Expected the signing secret to be copied, got %q", clone.SigningSecret)
+	}
+}
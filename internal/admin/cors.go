@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CORSConfig holds cross-origin settings for the /api/* routes
+type CORSConfig struct {
+	AllowedOrigins []string // "*" allows any origin without credentials; empty means same-origin only
+	AllowedMethods []string
+	AllowedHeaders []string
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for the given
+// Origin header value, or "" if it isn't allowed. Only listed origins may
+// send credentials; "*" lets any other origin read responses, but without
+// the session cookie, so a wildcard can't expose a signed-in user's data.
+func (c CORSConfig) allowOrigin(origin string) (allow string, credentials bool) {
+	wildcard := false
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			wildcard = true
+		} else if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	if wildcard {
+		return "*", false
+	}
+	return "", false
+}
+
+// CORS middleware adds cross-origin headers for allowed origins and answers
+// preflight requests. Requests without an Origin header are passed through.
+func (s *Server) CORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowOrigin, credentials := s.cors.allowOrigin(origin)
+		allowed := allowOrigin != ""
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		}
+		if credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// Handle preflight requests
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(s.cors.AllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(s.cors.AllowedHeaders, ", "))
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next(w, r)
+	}
+}
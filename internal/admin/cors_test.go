@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	s := &Server{cors: CORSConfig{
+		AllowedOrigins: []string{"https://tools.example.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type"},
+	}}
+	handler := s.CORS(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		origin     string
+		method     string
+		wantStatus int
+		wantOrigin string
+	}{
+		{name: "allowed origin", origin: "https://tools.example.com", method: "GET", wantStatus: http.StatusOK, wantOrigin: "https://tools.example.com"},
+		{name: "allowed preflight", origin: "https://tools.example.com", method: "OPTIONS", wantStatus: http.StatusNoContent, wantOrigin: "https://tools.example.com"},
+		{name: "disallowed origin", origin: "https://evil.example.com", method: "GET", wantStatus: http.StatusOK, wantOrigin: ""},
+		{name: "disallowed preflight", origin: "https://evil.example.com", method: "OPTIONS", wantStatus: http.StatusForbidden, wantOrigin: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/mappings", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.method == "OPTIONS" {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			rec := httptest.NewRecorder()
+
+			handler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Expected Access-Control-Allow-Origin %q, got %q", tt.wantOrigin, got)
+			}
+		})
+	}
+}
+
+func TestCORS_WildcardWithoutCredentials(t *testing.T) {
+	s := &Server{cors: CORSConfig{
+		AllowedOrigins: []string{"*", "https://tools.example.com"},
+		AllowedMethods: []string{"GET"},
+	}}
+	handler := s.CORS(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		origin          string
+		method          string
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{origin: "https://evil.example.com", method: "GET", wantOrigin: "*"},
+		{origin: "https://evil.example.com", method: "OPTIONS", wantOrigin: "*"},
+		{origin: "https://tools.example.com", method: "GET", wantOrigin: "https://tools.example.com", wantCredentials: "true"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/api/logs", nil)
+		req.Header.Set("Origin", tt.origin)
+		if tt.method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		rec := httptest.NewRecorder()
+
+		handler(rec, req)
+
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+			t.Errorf("%s %s: expected Access-Control-Allow-Origin %q, got %q", tt.method, tt.origin, tt.wantOrigin, got)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+			t.Errorf("%s %s: expected Access-Control-Allow-Credentials %q, got %q", tt.method, tt.origin, tt.wantCredentials, got)
+		}
+	}
+}
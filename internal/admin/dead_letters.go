@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// deadLettersPageSize caps how many dead letters the page lists
+const deadLettersPageSize = 200
+
+// DeadLettersData represents the data for the dead letters page
+type DeadLettersData struct {
+	DeadLetters []database.DeadLetter
+	Error       string
+	CurrentPage string
+	UserRole    string
+	UserEmail   string
+	Token       string
+}
+
+// handleDeadLetters lists emails that failed after every retry, newest first
+func (s *Server) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	data := DeadLettersData{
+		CurrentPage: "dead_letters",
+		UserRole:    r.Context().Value(userRoleKey).(string),
+		UserEmail:   r.Context().Value("userEmail").(string),
+		Token:       s.sessions.GenerateCSRFToken(),
+	}
+
+	letters, err := s.db.ListDeadLetters(deadLettersPageSize)
+	if err != nil {
+		log.Printf("Failed to fetch dead letters: %v", err)
+		data.Error = fmt.Sprintf("Failed to fetch dead letters: %v", err)
+	} else {
+		data.DeadLetters = letters
+	}
+
+	s.templates(r).ExecuteTemplate(w, "layout.html", data)
+}
+
+// handleDeadLetterReplay queues the dead letter named by the dead_letter_id
+// form value; the mail server sends it again in the background
+func (s *Server) handleDeadLetterReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Validate CSRF token
+	if !s.sessions.ValidateCSRFToken(r.FormValue("token")) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	parsed, err := strconv.ParseUint(r.FormValue("dead_letter_id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid dead letter ID", http.StatusBadRequest)
+		return
+	}
+	id := uint(parsed)
+
+	if err := s.db.QueueDeadLetterReplay(id); err != nil {
+		log.Printf("Error queueing dead letter %d: %v", id, err)
+		http.Error(w, fmt.Sprintf("Failed to replay dead letter: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Dead letter %d queued for replay", id)
+
+	s.redirect(w, r, "/dead-letters", http.StatusSeeOther)
+}
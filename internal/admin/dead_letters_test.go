@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// createTestDeadLetter creates a mapping with an email that failed after every retry
+func createTestDeadLetter(t *testing.T, s *Server) (*database.EmailMapping, *database.DeadLetter) {
+	t.Helper()
+
+	mapping, err := s.db.CreateEmailMapping(1, "https://example.com/hook", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+	letter := &database.DeadLetter{
+		MappingID:   mapping.ID,
+		RequestID:   "req-1",
+		Subject:     "undeliverable",
+		FromAddress: "sender@example.com",
+		EndpointURL: mapping.EndpointURL,
+		Payload:     `{"data":{"subject":"undeliverable"}}`,
+		PayloadSize: 36,
+		LastError:   "API returned status 500",
+	}
+	if err := s.db.CreateDeadLetter(letter); err != nil {
+		t.Fatalf("Failed to create dead letter: %v", err)
+	}
+
+	return mapping, letter
+}
+
+func TestHandleDeadLetters_Lists(t *testing.T) {
+	s := newTestServer(t)
+	mapping, _ := createTestDeadLetter(t, s)
+
+	rec := httptest.NewRecorder()
+	s.handleDeadLetters(rec, asAdmin(httptest.NewRequest("GET", "/dead-letters", nil)))
+
+	body := rec.Body.String()
+	for _, want := range []string{mapping.GeneratedEmail, "undeliverable", "API returned status 500", "Replay"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected dead letters page to contain %q", want)
+		}
+	}
+}
+
+func TestHandleDeadLetterReplay(t *testing.T) {
+	s := newTestServer(t)
+	_, letter := createTestDeadLetter(t, s)
+
+	form := url.Values{
+		"dead_letter_id": {strconv.FormatUint(uint64(letter.ID), 10)},
+		"token":          {s.sessions.GenerateCSRFToken()},
+	}
+	req := httptest.NewRequest("POST", "/dead-letters/replay", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleDeadLetterReplay(rec, asAdmin(req))
+
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	updated, err := s.db.GetDeadLetter(letter.ID)
+	if err != nil {
+		t.Fatalf("Failed to get dead letter: %v", err)
+	}
+	if updated.Status != database.DeadLetterQueued {
+		t.Errorf("Expected the dead letter to be queued, got %q", updated.Status)
+	}
+}
@@ -64,5 +64,5 @@ func (s *Server) handleDeleteMapping(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Redirect back to mappings page
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	s.redirect(w, r, "/", http.StatusSeeOther)
 }
\ No newline at end of file
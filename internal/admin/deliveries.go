@@ -0,0 +1,72 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// DeliveriesData represents the data for the pending deliveries page
+type DeliveriesData struct {
+	Deliveries  []database.Delivery
+	Error       string
+	CurrentPage string
+	UserRole    string
+	UserEmail   string
+}
+
+// handleDeliveries lists queued and retrying deliveries
+func (s *Server) handleDeliveries(w http.ResponseWriter, r *http.Request) {
+	data := DeliveriesData{
+		CurrentPage: "deliveries",
+		UserRole:    r.Context().Value(userRoleKey).(string),
+		UserEmail:   r.Context().Value("userEmail").(string),
+	}
+
+	deliveries, err := s.db.GetPendingDeliveries()
+	if err != nil {
+		log.Printf("Failed to fetch pending deliveries: %v", err)
+		data.Error = fmt.Sprintf("Failed to fetch pending deliveries: %v", err)
+	} else {
+		data.Deliveries = deliveries
+	}
+
+	s.templates(r).ExecuteTemplate(w, "layout.html", data)
+}
+
+// handleDeliveryRetry schedules a pending delivery's next attempt immediately
+func (s *Server) handleDeliveryRetry(w http.ResponseWriter, r *http.Request) {
+	s.handleDeliveryAction(w, r, "retried", s.db.RetryDeliveryNow)
+}
+
+// handleDeliveryCancel cancels a pending delivery
+func (s *Server) handleDeliveryCancel(w http.ResponseWriter, r *http.Request) {
+	s.handleDeliveryAction(w, r, "canceled", s.db.CancelDelivery)
+}
+
+// handleDeliveryAction applies action to the delivery named by the delivery_id form value
+func (s *Server) handleDeliveryAction(w http.ResponseWriter, r *http.Request, verb string, action func(uint) error) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parsed, err := strconv.ParseUint(r.FormValue("delivery_id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+	deliveryID := uint(parsed)
+
+	if err := action(deliveryID); err != nil {
+		log.Printf("Error updating delivery %d: %v", deliveryID, err)
+		http.Error(w, fmt.Sprintf("Failed to update delivery: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Delivery %d %s", deliveryID, verb)
+
+	s.redirect(w, r, "/deliveries", http.StatusSeeOther)
+}
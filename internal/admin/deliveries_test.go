@@ -0,0 +1,113 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+	"github.com/looprock/email-to-api/internal/email"
+)
+
+// newTestServer creates a server backed by a migrated SQLite database
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	db, err := database.New(&database.Config{
+		Driver: "sqlite",
+		DSN:    filepath.Join(t.TempDir(), "test.db"),
+		Domain: "example.com",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.AutoMigrate(&database.User{}, &database.RegistrationToken{}, &database.EmailMapping{}, &database.EmailLog{}, &database.Delivery{}, &database.EmailChangeToken{}, &database.Setting{}, &database.DeadLetter{}); err != nil {
+		t.Fatalf("Failed to create test tables: %v", err)
+	}
+
+	tmpl, err := parseTemplates(time.UTC, "", "", "")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v", err)
+	}
+
+	return &Server{
+		db:        db,
+		tmpl:      tmpl,
+		sessions:  NewSessionManager(),
+		previewer: email.New(db, email.ProcessorConfig{}),
+		location:  time.UTC,
+	}
+}
+
+// asAdmin attaches an authenticated admin session to the request context
+func asAdmin(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), userIDKey, uint(1))
+	ctx = context.WithValue(ctx, userRoleKey, "admin")
+	ctx = context.WithValue(ctx, "userEmail", "admin@example.com")
+	return r.WithContext(ctx)
+}
+
+// createTestDelivery creates a mapping with a delivery waiting to be retried
+func createTestDelivery(t *testing.T, s *Server) (*database.EmailMapping, *database.Delivery) {
+	t.Helper()
+
+	mapping, err := s.db.CreateEmailMapping(1, "https://example.com/hook", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+	delivery, err := s.db.CreateDelivery(mapping.ID, "req-1", "stuck email")
+	if err != nil {
+		t.Fatalf("Failed to create delivery: %v", err)
+	}
+	if err := s.db.RecordDeliveryAttempt(delivery.ID, 2, "connection refused", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to record attempt: %v", err)
+	}
+
+	return mapping, delivery
+}
+
+func TestHandleDeliveries_ListsPending(t *testing.T) {
+	s := newTestServer(t)
+	mapping, _ := createTestDelivery(t, s)
+
+	rec := httptest.NewRecorder()
+	s.handleDeliveries(rec, asAdmin(httptest.NewRequest("GET", "/deliveries", nil)))
+
+	body := rec.Body.String()
+	for _, want := range []string{mapping.GeneratedEmail, "stuck email", "retrying", "connection refused"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected deliveries page to contain %q", want)
+		}
+	}
+}
+
+func TestHandleDeliveryRetry(t *testing.T) {
+	s := newTestServer(t)
+	_, delivery := createTestDelivery(t, s)
+
+	form := url.Values{"delivery_id": {strconv.FormatUint(uint64(delivery.ID), 10)}}
+	req := httptest.NewRequest("POST", "/deliveries/retry", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleDeliveryRetry(rec, asAdmin(req))
+
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	updated, err := s.db.GetDelivery(delivery.ID)
+	if err != nil {
+		t.Fatalf("Failed to get delivery: %v", err)
+	}
+	if updated.NextAttemptAt.After(time.Now()) {
+		t.Errorf("Expected next attempt to be due now, got %v", updated.NextAttemptAt)
+	}
+}
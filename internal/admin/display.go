@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// displayPreferences are a user's choices for how timestamps are shown;
+// empty values use the admin server's defaults
+type displayPreferences struct {
+	Timezone string
+	Locale   string
+}
+
+// supportedLocales lists the locales users can choose, sorted
+func supportedLocales() []string {
+	locales := make([]string, 0, len(timeLayouts))
+	for locale := range timeLayouts {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// templates returns the page templates for the request's user, rendering
+// timestamps in their time zone and locale. Users without preferences, or
+// with a time zone that no longer loads, get the server's templates.
+func (s *Server) templates(r *http.Request) *template.Template {
+	prefs, _ := r.Context().Value(userDisplayKey).(displayPreferences)
+	if prefs == (displayPreferences{}) {
+		return s.tmpl
+	}
+
+	key := prefs.Timezone + "|" + prefs.Locale
+	s.userTemplatesMu.Lock()
+	defer s.userTemplatesMu.Unlock()
+	if tmpl, ok := s.userTemplates[key]; ok {
+		return tmpl
+	}
+
+	location := s.location
+	if prefs.Timezone != "" {
+		loc, err := time.LoadLocation(prefs.Timezone)
+		if err != nil {
+			log.Printf("Ignoring invalid user timezone %q: %v", prefs.Timezone, err)
+			return s.tmpl
+		}
+		location = loc
+	}
+	tmpl, err := parseTemplates(location, prefs.Locale, s.templateDir, s.basePath)
+	if err != nil {
+		log.Printf("Failed to parse templates for timezone %q and locale %q: %v", prefs.Timezone, prefs.Locale, err)
+		return s.tmpl
+	}
+	if s.userTemplates == nil {
+		s.userTemplates = make(map[string]*template.Template)
+	}
+	s.userTemplates[key] = tmpl
+	return tmpl
+}
+
+// handleProfileDisplay saves the user's time zone and locale
+func (s *Server) handleProfileDisplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Validate CSRF token
+	if !s.sessions.ValidateCSRFToken(r.FormValue("token")) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	timezone, locale := r.FormValue("timezone"), r.FormValue("locale")
+	if _, ok := timeLayouts[locale]; locale != "" && !ok {
+		data := s.newProfileData(r)
+		data.Error = fmt.Sprintf("Unsupported locale %q", locale)
+		s.templates(r).ExecuteTemplate(w, "layout.html", data)
+		return
+	}
+
+	userID := r.Context().Value(userIDKey).(uint)
+	if err := s.db.UpdateUserDisplayPreferences(userID, timezone, locale); err != nil {
+		log.Printf("Failed to save display preferences: %v", err)
+		data := s.newProfileData(r)
+		data.Error = fmt.Sprintf("Failed to save display preferences: %v", err)
+		s.templates(r).ExecuteTemplate(w, "layout.html", data)
+		return
+	}
+
+	// Redirect so the page is rendered with the new preferences
+	log.Printf("User %d updated display preferences", userID)
+	s.redirect(w, r, "/profile?display_saved=1", http.StatusSeeOther)
+}
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"encoding/json"
+	"html/template"
+	"time"
+)
+
+// statusBadgeClasses maps log statuses to badge colors
+var statusBadgeClasses = map[string]string{
+	"success":               "bg-green-100 text-green-800",
+	"filtered":              "bg-yellow-100 text-yellow-800",
+	"dropped":               "bg-gray-100 text-gray-800",
+	"dropped-oversize":      "bg-gray-100 text-gray-800",
+	"rejected-at-smtp-size": "bg-gray-100 text-gray-800",
+	"bounce":                "bg-orange-100 text-orange-800",
+	"error":                 "bg-red-100 text-red-800",
+}
+
+// defaultTimeLayout renders timestamps for users without a supported locale
+const defaultTimeLayout = "2006-01-02 15:04:05 MST"
+
+// timeLayouts are the timestamp formats of the locales users can choose
+var timeLayouts = map[string]string{
+	"en-US": "01/02/2006 3:04:05 PM MST",
+	"en-GB": "02/01/2006 15:04:05 MST",
+	"de-DE": "02.01.2006 15:04:05 MST",
+	"fr-FR": "02/01/2006 15:04:05 MST",
+}
+
+// templateFuncs returns the helper functions available to all templates;
+// timestamps are rendered in the given location using the locale's format
+// and links are prefixed with basePath
+func templateFuncs(location *time.Location, locale, basePath string) template.FuncMap {
+	layout, ok := timeLayouts[locale]
+	if !ok {
+		layout = defaultTimeLayout
+	}
+
+	return template.FuncMap{
+		"eq": func(a, b string) bool { return a == b },
+
+		// url returns an admin route's path under the base path, used as {{url "/logs"}}
+		"url": func(path string) string { return basePath + path },
+
+		// formatTime renders a timestamp in the user's or configured time zone
+		"formatTime": func(t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.In(location).Format(layout)
+		},
+
+		// truncate shortens s to at most n characters, used as {{.Text | truncate 80}}
+		"truncate": func(n int, s string) string {
+			runes := []rune(s)
+			if n < 0 || len(runes) <= n {
+				return s
+			}
+			return string(runes[:n]) + "…"
+		},
+
+		// statusBadge renders a colored pill for a processing status
+		"statusBadge": func(status string) template.HTML {
+			classes, ok := statusBadgeClasses[status]
+			if !ok {
+				classes = "bg-red-100 text-red-800"
+			}
+			return template.HTML(`<span class="px-2 inline-flex text-xs leading-5 font-semibold rounded-full ` +
+				classes + `">` + template.HTMLEscapeString(status) + `</span>`)
+		},
+
+		// json renders a value as JSON, e.g. for headers or payload previews
+		"json": func(v interface{}) string {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return ""
+			}
+			return string(data)
+		},
+	}
+}
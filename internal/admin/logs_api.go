@@ -0,0 +1,158 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Paging limits for the logs API
+const (
+	defaultLogsPerPage = 50
+	maxLogsPerPage     = 500
+)
+
+// APILogEntry is a processing log entry as returned by the logs API
+type APILogEntry struct {
+	ID             int64     `json:"id" gorm:"column:id"`
+	EmailAddress   string    `json:"email_address" gorm:"column:from_address"`
+	Subject        string    `json:"subject" gorm:"column:subject"`
+	Status         string    `json:"status" gorm:"column:status"`
+	ErrorMessage   string    `json:"error_message,omitempty" gorm:"column:error_message"`
+	RequestID      string    `json:"request_id,omitempty" gorm:"column:request_id"`
+	ClientIP       string    `json:"client_ip,omitempty" gorm:"column:client_ip"`
+	ProcessedAt    time.Time `json:"processed_at" gorm:"column:processed_at"`
+	APIEndpoint    string    `json:"endpoint_url" gorm:"column:endpoint_url"`
+	GeneratedEmail string    `json:"generated_email" gorm:"column:generated_email"`
+	UserEmail      string    `json:"user_email" gorm:"column:user_email"`
+}
+
+// APILogsPage is one page of the logs API response
+type APILogsPage struct {
+	Logs    []APILogEntry `json:"logs"`
+	Page    int           `json:"page"`
+	PerPage int           `json:"per_page"`
+	Total   int64         `json:"total"`
+}
+
+// handleAPILogs is a handler for the GET /api/logs endpoint. It returns
+// processing logs as JSON, newest first, paged with page and per_page and
+// optionally filtered by status, client_ip and a since/until date range. Non-admins
+// only see logs for their own mappings.
+func (s *Server) handleAPILogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value(userIDKey).(uint)
+	userRole := r.Context().Value(userRoleKey).(string)
+	q := r.URL.Query()
+
+	page, err := positiveIntParam(q.Get("page"), 1)
+	if err != nil {
+		http.Error(w, "Invalid page", http.StatusBadRequest)
+		return
+	}
+	perPage, err := positiveIntParam(q.Get("per_page"), defaultLogsPerPage)
+	if err != nil {
+		http.Error(w, "Invalid per_page", http.StatusBadRequest)
+		return
+	}
+	perPage = min(perPage, maxLogsPerPage)
+
+	since, err := parseDateParam(q.Get("since"), false)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid since: %v", err), http.StatusBadRequest)
+		return
+	}
+	until, err := parseDateParam(q.Get("until"), true)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid until: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Builds the filtered query; called twice since counting consumes it
+	filtered := func() *gorm.DB {
+		query := s.db.DB.
+			Table("email_logs l").
+			Joins("LEFT JOIN email_mappings m ON l.mapping_id = m.id").
+			Joins("LEFT JOIN users u ON m.user_id = u.id").
+			Where("l.deleted_at IS NULL")
+		if userRole != "admin" {
+			// Regular users only see their own logs
+			query = query.Where("m.user_id = ?", userID)
+		}
+		if status := q.Get("status"); status != "" {
+			query = query.Where("l.status = ?", status)
+		}
+		if clientIP := q.Get("client_ip"); clientIP != "" {
+			query = query.Where("l.client_ip = ?", clientIP)
+		}
+		if !since.IsZero() {
+			query = query.Where("l.processed_at >= ?", since)
+		}
+		if !until.IsZero() {
+			query = query.Where("l.processed_at < ?", until)
+		}
+		return query
+	}
+
+	result := APILogsPage{Logs: []APILogEntry{}, Page: page, PerPage: perPage}
+	if err := filtered().Count(&result.Total).Error; err != nil {
+		log.Printf("Failed to count logs: %v", err)
+		http.Error(w, "Failed to fetch logs", http.StatusInternalServerError)
+		return
+	}
+	err = filtered().
+		Select(`l.id, l.from_address, l.subject, l.status, l.error_message, l.request_id,
+			l.client_ip, l.processed_at, m.endpoint_url, m.generated_email, u.email as user_email`).
+		Order("l.processed_at DESC, l.id DESC").
+		Limit(perPage).
+		Offset((page - 1) * perPage).
+		Find(&result.Logs).Error
+	if err != nil {
+		log.Printf("Failed to fetch logs: %v", err)
+		http.Error(w, "Failed to fetch logs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// positiveIntParam parses an optional positive integer query parameter
+func positiveIntParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("must be a positive integer")
+	}
+	return n, nil
+}
+
+// parseDateParam parses an optional RFC 3339 timestamp or YYYY-MM-DD date.
+// A bare date used as an upper bound covers the whole day.
+func parseDateParam(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 or YYYY-MM-DD")
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
@@ -0,0 +1,152 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// seedLogs creates a mapping for userID and a log entry per status, one day
+// apart starting at start
+func seedLogs(t *testing.T, s *Server, userID uint, start time.Time, statuses ...string) *database.EmailMapping {
+	t.Helper()
+
+	mapping, err := s.db.CreateEmailMapping(userID, "https://example.com/hook", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+	for i, status := range statuses {
+		entry := database.EmailLog{
+			MappingID:   mapping.ID,
+			FromAddress: mapping.GeneratedEmail,
+			Subject:     fmt.Sprintf("email %d", i),
+			Status:      status,
+			RequestID:   fmt.Sprintf("req-%d-%d", userID, i),
+			ProcessedAt: start.AddDate(0, 0, i),
+		}
+		if err := s.db.Create(&entry).Error; err != nil {
+			t.Fatalf("Failed to create log: %v", err)
+		}
+	}
+	return mapping
+}
+
+// getLogs calls the logs API and decodes a successful response
+func getLogs(t *testing.T, s *Server, r *http.Request) (int, APILogsPage) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	s.handleAPILogs(rec, r)
+
+	var page APILogsPage
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Failed to decode response %s: %v", rec.Body.String(), err)
+		}
+	}
+	return rec.Code, page
+}
+
+// asUser attaches an authenticated non-admin session to the request context
+func asUser(r *http.Request, userID uint) *http.Request {
+	ctx := context.WithValue(r.Context(), userIDKey, userID)
+	ctx = context.WithValue(ctx, userRoleKey, "user")
+	ctx = context.WithValue(ctx, "userEmail", "user@example.com")
+	return r.WithContext(ctx)
+}
+
+func TestHandleAPILogs_Paging(t *testing.T) {
+	s := newTestServer(t)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	seedLogs(t, s, 1, start, "success", "success", "error", "success", "dropped")
+
+	code, page := getLogs(t, s, asAdmin(httptest.NewRequest("GET", "/api/logs?page=2&per_page=2", nil)))
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if page.Page != 2 || page.PerPage != 2 || page.Total != 5 {
+		t.Errorf("Expected page 2 of 2 per page with 5 total, got %+v", page)
+	}
+	// Newest first: page 2 holds the third and second oldest entries
+	if len(page.Logs) != 2 || page.Logs[0].Subject != "email 2" || page.Logs[1].Subject != "email 1" {
+		t.Fatalf("Unexpected page contents %+v", page.Logs)
+	}
+	if page.Logs[0].Status != "error" || page.Logs[0].RequestID != "req-1-2" || page.Logs[0].GeneratedEmail == "" {
+		t.Errorf("Unexpected entry %+v", page.Logs[0])
+	}
+
+	code, page = getLogs(t, s, asAdmin(httptest.NewRequest("GET", "/api/logs?page=4&per_page=2", nil)))
+	if code != http.StatusOK || len(page.Logs) != 0 || page.Total != 5 {
+		t.Errorf("Expected an empty page past the end, got %d %+v", code, page)
+	}
+
+	code, _ = getLogs(t, s, asAdmin(httptest.NewRequest("GET", "/api/logs?page=0", nil)))
+	if code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid page, got %d", code)
+	}
+}
+
+func TestHandleAPILogs_Filtering(t *testing.T) {
+	s := newTestServer(t)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	seedLogs(t, s, 1, start, "success", "error", "success", "error")
+	seedLogs(t, s, 2, start, "error", "success")
+
+	tests := []struct {
+		name      string
+		req       *http.Request
+		wantTotal int64
+	}{
+		{"status", asAdmin(httptest.NewRequest("GET", "/api/logs?status=error", nil)), 3},
+		{"date range", asAdmin(httptest.NewRequest("GET", "/api/logs?since=2024-03-02&until=2024-03-03", nil)), 3},
+		{"status and date", asAdmin(httptest.NewRequest("GET", "/api/logs?status=error&since=2024-03-02T00:00:00Z", nil)), 2},
+		{"user scoping", asUser(httptest.NewRequest("GET", "/api/logs", nil), 2), 2},
+		{"user scoping with status", asUser(httptest.NewRequest("GET", "/api/logs?status=error", nil), 2), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, page := getLogs(t, s, tt.req)
+			if code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d", code)
+			}
+			if page.Total != tt.wantTotal || int64(len(page.Logs)) != tt.wantTotal {
+				t.Errorf("Expected %d logs, got total %d with %d entries", tt.wantTotal, page.Total, len(page.Logs))
+			}
+		})
+	}
+
+	code, _ := getLogs(t, s, asAdmin(httptest.NewRequest("GET", "/api/logs?since=yesterday", nil)))
+	if code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid date, got %d", code)
+	}
+}
+
+func TestRateLimit_RejectsOverLimit(t *testing.T) {
+	s := newTestServer(t)
+	s.apiLimiter = newRateLimiter(2, time.Minute)
+	handler := s.RateLimit(s.handleAPILogs)
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		handler(rec, asAdmin(httptest.NewRequest("GET", "/api/logs", nil)))
+		if rec.Code != want {
+			t.Errorf("Request %d: expected %d, got %d", i+1, want, rec.Code)
+		}
+		if want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Error("Expected Retry-After on a rate-limited response")
+		}
+	}
+
+	// Other users have their own allowance
+	rec := httptest.NewRecorder()
+	handler(rec, asUser(httptest.NewRequest("GET", "/api/logs", nil), 2))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected another user to be allowed, got %d", rec.Code)
+	}
+}
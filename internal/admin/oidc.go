@@ -0,0 +1,374 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCConfig configures optional single sign-on via an OpenID Connect provider
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   // e.g. https://admin.example.com/login/oidc/callback
+	DefaultRole  string   // Role given to users provisioned on first login
+	Scopes       []string // Defaults to openid, email
+}
+
+// oidcLoginTimeout bounds how long a started login may take to complete
+const oidcLoginTimeout = 10 * time.Minute
+
+// oidcStateCookie ties a started login to the browser that started it, so
+// a callback URL made for one browser can't log in another
+const oidcStateCookie = "oidc_state"
+
+// oidcProvider runs the authorization code flow against an OIDC provider
+type oidcProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	pending   map[string]oidcLogin // Started logins keyed by tokenKey(state)
+}
+
+// oidcDiscovery holds the endpoints from the provider's discovery document
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// oidcLogin is a login that has been redirected to the provider
+type oidcLogin struct {
+	state     string
+	nonce     string
+	expiresAt time.Time
+}
+
+// idTokenClaims are the ID token claims used to identify the user
+type idTokenClaims struct {
+	Issuer        string          `json:"iss"`
+	Audience      json.RawMessage `json:"aud"`
+	Expiry        int64           `json:"exp"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified *bool           `json:"email_verified"`
+}
+
+// newOIDCProvider returns a provider, or nil if OIDC is not configured. The
+// issuer must use https: ID tokens are trusted because they come from the
+// token endpoint over TLS, see parseIDToken.
+func newOIDCProvider(config OIDCConfig) (*oidcProvider, error) {
+	if config.Issuer == "" || config.ClientID == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(config.Issuer); err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid OIDC issuer %q: must be an https URL", config.Issuer)
+	}
+	if config.DefaultRole == "" {
+		config.DefaultRole = "user"
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email"}
+	}
+	return &oidcProvider{
+		config:  config,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		pending: make(map[string]oidcLogin),
+	}, nil
+}
+
+// discover fetches and caches the provider's discovery document
+func (p *oidcProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	resp, err := p.client.Get(strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery returned status %d", resp.StatusCode)
+	}
+
+	var doc oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode OIDC discovery document: %w", err)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" {
+		return nil, fmt.Errorf("OIDC discovery document is missing endpoints")
+	}
+	if !strings.HasPrefix(doc.TokenEndpoint, "https://") {
+		return nil, fmt.Errorf("OIDC token endpoint %q must use https", doc.TokenEndpoint)
+	}
+
+	p.discovery = &doc
+	return p.discovery, nil
+}
+
+// authCodeURL starts a login and returns the provider URL to redirect to
+// and the login's state
+func (p *oidcProvider) authCodeURL() (string, string, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	p.mu.Lock()
+	for key, login := range p.pending {
+		if time.Now().After(login.expiresAt) {
+			delete(p.pending, key)
+		}
+	}
+	p.pending[tokenKey(state)] = oidcLogin{state: state, nonce: nonce, expiresAt: time.Now().Add(oidcLoginTimeout)}
+	p.mu.Unlock()
+
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.config.ClientID},
+		"redirect_uri":  {p.config.RedirectURL},
+		"scope":         {strings.Join(p.config.Scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	sep := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return doc.AuthorizationEndpoint + sep + params.Encode(), state, nil
+}
+
+// exchange completes a login: it checks the state, redeems the code and
+// returns the authenticated user's email address
+func (p *oidcProvider) exchange(state, code string) (string, error) {
+	p.mu.Lock()
+	login, ok := p.pending[tokenKey(state)]
+	if ok && secureCompare(login.state, state) {
+		delete(p.pending, tokenKey(state))
+	}
+	p.mu.Unlock()
+	if !ok || !secureCompare(login.state, state) || time.Now().After(login.expiresAt) {
+		return "", fmt.Errorf("unknown or expired login state")
+	}
+
+	doc, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.config.RedirectURL},
+	}
+	req, err := http.NewRequest("POST", doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+
+	claims, err := parseIDToken(token.IDToken)
+	if err != nil {
+		return "", err
+	}
+	if err := p.validateClaims(claims, doc.Issuer, login.nonce); err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(claims.Email), nil
+}
+
+// parseIDToken decodes the claims of an ID token. The token comes straight
+// from the token endpoint over TLS, so per OIDC Core 3.1.3.7 the TLS server
+// validation stands in for checking the signature.
+func parseIDToken(idToken string) (*idTokenClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token payload: %w", err)
+	}
+
+	var claims idTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	return &claims, nil
+}
+
+// validateClaims checks the ID token was issued to us, for this login
+func (p *oidcProvider) validateClaims(claims *idTokenClaims, issuer, nonce string) error {
+	if issuer == "" {
+		issuer = p.config.Issuer
+	}
+	if claims.Issuer != issuer {
+		return fmt.Errorf("ID token issuer %q does not match %q", claims.Issuer, issuer)
+	}
+
+	var audiences []string
+	if err := json.Unmarshal(claims.Audience, &audiences); err != nil {
+		var single string
+		if err := json.Unmarshal(claims.Audience, &single); err != nil {
+			return fmt.Errorf("invalid ID token audience")
+		}
+		audiences = []string{single}
+	}
+	found := false
+	for _, aud := range audiences {
+		if aud == p.config.ClientID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("ID token was not issued for this client")
+	}
+
+	if time.Now().After(time.Unix(claims.Expiry, 0)) {
+		return fmt.Errorf("ID token has expired")
+	}
+	if !secureCompare(claims.Nonce, nonce) {
+		return fmt.Errorf("ID token nonce does not match")
+	}
+	if claims.Email == "" {
+		return fmt.Errorf("ID token has no email claim")
+	}
+	if claims.EmailVerified != nil && !*claims.EmailVerified {
+		return fmt.Errorf("email address %s is not verified", claims.Email)
+	}
+	return nil
+}
+
+// randomToken returns a URL-safe random string
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HandleOIDCLogin redirects the browser to the identity provider
+func (s *Server) HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	authURL, state, err := s.oidc.authCodeURL()
+	if err != nil {
+		log.Printf("Failed to start OIDC login: %v", err)
+		s.renderLogin(w, "Single sign-on is unavailable")
+		return
+	}
+
+	// Lax, so the cookie comes back on the provider's redirect to the callback
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     s.url("/login/oidc"),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidcLoginTimeout.Seconds()),
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// HandleOIDCCallback completes single sign-on, provisioning the user on first login
+func (s *Server) HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		log.Printf("OIDC provider returned error: %s", errCode)
+		s.renderLogin(w, "Single sign-on failed")
+		return
+	}
+
+	// The state must be the one this browser was sent off with; the cookie
+	// is single use either way
+	state := r.URL.Query().Get("state")
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     s.url("/login/oidc"),
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	if cookie, err := r.Cookie(oidcStateCookie); err != nil || !secureCompare(cookie.Value, state) {
+		log.Printf("OIDC login failed: state does not match this browser's login")
+		w.WriteHeader(http.StatusBadRequest)
+		s.renderLogin(w, "Single sign-on failed")
+		return
+	}
+
+	email, err := s.oidc.exchange(state, r.URL.Query().Get("code"))
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		s.renderLogin(w, "Single sign-on failed")
+		return
+	}
+
+	// CreateUser returns the existing user if there is one
+	user, err := s.db.CreateUser(email, s.oidc.config.DefaultRole)
+	if err != nil {
+		log.Printf("Failed to provision OIDC user %s: %v", email, err)
+		s.renderLogin(w, "Single sign-on failed")
+		return
+	}
+	if !user.IsActive {
+		s.renderLogin(w, "Account is disabled")
+		return
+	}
+
+	log.Printf("OIDC login for %s", user.Email)
+	// The callback is a cross-site navigation from the provider, so a Strict
+	// cookie would not be sent on the redirect that follows
+	s.startSession(w, r, user.ID, user.Role, http.SameSiteLaxMode)
+}
@@ -0,0 +1,195 @@
+package admin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newTestOIDCProvider serves discovery and a token endpoint that issues an
+// ID token for email with the nonce of the most recent authorization request
+func newTestOIDCProvider(t *testing.T, email string) (*httptest.Server, *string) {
+	t.Helper()
+
+	var nonce string
+	var ts *httptest.Server
+	ts = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 ts.URL,
+				"authorization_endpoint": ts.URL + "/authorize",
+				"token_endpoint":         ts.URL + "/token",
+			})
+		case "/token":
+			if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" {
+				http.Error(w, "invalid client", http.StatusUnauthorized)
+				return
+			}
+			if r.FormValue("code") != "good-code" {
+				http.Error(w, "invalid grant", http.StatusBadRequest)
+				return
+			}
+			claims, _ := json.Marshal(map[string]interface{}{
+				"iss":   ts.URL,
+				"aud":   "client",
+				"exp":   time.Now().Add(time.Hour).Unix(),
+				"nonce": nonce,
+				"email": email,
+			})
+			idToken := "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+			json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "access_token": "at"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts, &nonce
+}
+
+// newTestOIDCServer creates a server using idp for single sign-on
+func newTestOIDCServer(t *testing.T, idp *httptest.Server) *Server {
+	t.Helper()
+
+	s := newTestServer(t)
+	provider, err := newOIDCProvider(OIDCConfig{
+		Issuer:       idp.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "https://admin.example.com/login/oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create OIDC provider: %v", err)
+	}
+	provider.client = idp.Client()
+	s.oidc = provider
+	return s
+}
+
+// startOIDCLogin begins a login and returns the state sent to the provider
+// and the cookie tying it to the browser
+func startOIDCLogin(t *testing.T, s *Server, nonce *string) (string, *http.Cookie) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	s.HandleOIDCLogin(rec, httptest.NewRequest("GET", "/login/oidc", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected redirect to provider, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Invalid redirect location: %v", err)
+	}
+	if location.Query().Get("client_id") != "client" {
+		t.Errorf("Expected client_id in authorization URL, got %s", location)
+	}
+	*nonce = location.Query().Get("nonce")
+
+	var stateCookie *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil || !stateCookie.HttpOnly || stateCookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("Expected an HttpOnly, SameSite=Lax state cookie, got %v", stateCookie)
+	}
+
+	return location.Query().Get("state"), stateCookie
+}
+
+func TestHandleOIDCCallback_ProvisionsUser(t *testing.T) {
+	idp, nonce := newTestOIDCProvider(t, "New.User@example.com")
+	s := newTestOIDCServer(t, idp)
+
+	state, stateCookie := startOIDCLogin(t, s, nonce)
+
+	req := httptest.NewRequest("GET", "/login/oidc/callback?code=good-code&state="+state, nil)
+	req.AddCookie(stateCookie)
+	rec := httptest.NewRecorder()
+	s.HandleOIDCCallback(rec, req)
+
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect after login, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var session *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "session" {
+			session = cookie
+		}
+	}
+	if session == nil || s.sessions.GetSession(session.Value) == nil {
+		t.Fatalf("Expected a valid session cookie, got %v", rec.Result().Cookies())
+	}
+
+	user, err := s.db.GetUserByEmail("new.user@example.com")
+	if err != nil || user == nil {
+		t.Fatalf("Expected user to be provisioned, got %v, %v", user, err)
+	}
+	if user.Role != "user" {
+		t.Errorf("Expected default role user, got %q", user.Role)
+	}
+}
+
+func TestHandleOIDCCallback_RejectsBadState(t *testing.T) {
+	idp, nonce := newTestOIDCProvider(t, "someone@example.com")
+	s := newTestOIDCServer(t, idp)
+
+	_, stateCookie := startOIDCLogin(t, s, nonce)
+
+	req := httptest.NewRequest("GET", "/login/oidc/callback?code=good-code&state=forged", nil)
+	req.AddCookie(stateCookie)
+	rec := httptest.NewRecorder()
+	s.HandleOIDCCallback(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for unknown state, got %d", rec.Code)
+	}
+	if user, _ := s.db.GetUserByEmail("someone@example.com"); user != nil {
+		t.Error("Expected no user to be provisioned")
+	}
+}
+
+func TestHandleOIDCCallback_RejectsStateFromAnotherBrowser(t *testing.T) {
+	idp, nonce := newTestOIDCProvider(t, "attacker@example.com")
+	s := newTestOIDCServer(t, idp)
+
+	// The attacker starts a login and hands the callback URL to a victim,
+	// whose browser has no state cookie, or one from its own login
+	state, _ := startOIDCLogin(t, s, nonce)
+	_, victimCookie := startOIDCLogin(t, s, new(string))
+
+	for _, cookie := range []*http.Cookie{nil, victimCookie} {
+		req := httptest.NewRequest("GET", "/login/oidc/callback?code=good-code&state="+state, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		s.HandleOIDCCallback(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for a state not started by this browser, got %d", rec.Code)
+		}
+	}
+	if user, _ := s.db.GetUserByEmail("attacker@example.com"); user != nil {
+		t.Error("Expected no user to be provisioned")
+	}
+}
+
+func TestNewOIDCProvider_RequiresHTTPS(t *testing.T) {
+	if _, err := newOIDCProvider(OIDCConfig{Issuer: "http://idp.example.com", ClientID: "client"}); err == nil {
+		t.Error("Expected an http issuer to be refused")
+	}
+	if p, err := newOIDCProvider(OIDCConfig{Issuer: "https://idp.example.com", ClientID: "client"}); err != nil || p == nil {
+		t.Errorf("Expected an https issuer to be accepted, got %v", err)
+	}
+	if p, err := newOIDCProvider(OIDCConfig{}); err != nil || p != nil {
+		t.Errorf("Expected no provider without configuration, got %v, %v", p, err)
+	}
+}
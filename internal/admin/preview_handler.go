@@ -0,0 +1,91 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+
+	"github.com/looprock/email-to-api/internal/email"
+)
+
+// maxPreviewUpload caps the size of an uploaded .eml file
+const maxPreviewUpload = 10 << 20 // 10MB
+
+// handlePreviewMapping is a handler for the POST /api/mappings/preview
+// endpoint. It parses an uploaded .eml file through the same pipeline as
+// received mail and returns the JSON payload that would be posted to the
+// mapping's endpoint, without contacting it.
+func (s *Server) handlePreviewMapping(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value(userIDKey).(uint)
+	userRole := r.Context().Value(userRoleKey).(string)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPreviewUpload)
+	if err := r.ParseMultipartForm(maxPreviewUpload); err != nil {
+		http.Error(w, "Invalid upload", http.StatusBadRequest)
+		return
+	}
+
+	// Validate CSRF token
+	if !s.sessions.ValidateCSRFToken(r.FormValue("token")) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	emailAddress := r.FormValue("email")
+	mapping, err := s.db.GetMappingByEmail(emailAddress)
+	if err != nil || (mapping.UserID != userID && userRole != "admin") {
+		http.Error(w, "Mapping not found", http.StatusNotFound)
+		return
+	}
+
+	file, _, err := r.FormFile("eml")
+	if err != nil {
+		http.Error(w, "An .eml file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Failed to read upload", http.StatusBadRequest)
+		return
+	}
+
+	// Saved .eml files often use bare LF line endings; SMTP delivers CRLF
+	raw = bytes.ReplaceAll(bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+	message := email.ParseMessage(raw)
+
+	// Use the From header as the envelope sender, as most senders do
+	if from := message.Headers["From"]; len(from) > 0 {
+		message.From = from[0]
+		if addr, err := mail.ParseAddress(from[0]); err == nil {
+			message.From = addr.Address
+		}
+	}
+	message.To = mapping.GeneratedEmail
+	message.ReceivedFrom = "preview"
+
+	payload, err := s.previewer.Preview(mapping, message)
+	if err != nil {
+		log.Printf("Error previewing payload for %s: %v", emailAddress, err)
+		http.Error(w, fmt.Sprintf("Failed to build preview: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, payload, "", "  "); err != nil {
+		pretty.Write(payload)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(pretty.Bytes())
+}
@@ -0,0 +1,75 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/email"
+)
+
+const sampleEML = `From: Alice Example <alice@example.com>
+To: billing@example.com
+Subject: Invoice 42 overdue
+Message-ID: <abc123@example.com>
+X-Priority: 1
+
+Please pay invoice 42.
+`
+
+func TestHandlePreviewMapping(t *testing.T) {
+	s := newTestServer(t)
+
+	mapping, err := s.db.CreateEmailMapping(1, "https://hooks.example.com/email", "billing", nil)
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("email", mapping.GeneratedEmail)
+	form.WriteField("token", s.sessions.GenerateCSRFToken())
+	part, _ := form.CreateFormFile("eml", "sample.eml")
+	part.Write([]byte(sampleEML))
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/api/mappings/preview", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	s.handlePreviewMapping(rec, asAdmin(req))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var preview email.ProcessedData
+	if err := json.Unmarshal(rec.Body.Bytes(), &preview); err != nil {
+		t.Fatalf("Failed to decode preview: %v", err)
+	}
+
+	data := preview.Data
+	if data.From != "alice@example.com" {
+		t.Errorf("Expected From alice@example.com, got %q", data.From)
+	}
+	if data.To != mapping.GeneratedEmail {
+		t.Errorf("Expected To %s, got %q", mapping.GeneratedEmail, data.To)
+	}
+	if data.Subject != "Invoice 42 overdue" {
+		t.Errorf("Expected subject from upload, got %q", data.Subject)
+	}
+	if data.MessageID != "<abc123@example.com>" {
+		t.Errorf("Expected Message-ID from upload, got %q", data.MessageID)
+	}
+	if data.Body != "Please pay invoice 42.\r\n" {
+		t.Errorf("Expected body from upload, got %q", data.Body)
+	}
+	if len(data.Tags) != 3 || data.Tags[0] != "invoice" {
+		t.Errorf("Expected subject tags, got %v", data.Tags)
+	}
+	if preview.Source != "email" {
+		t.Errorf("Expected source email, got %q", preview.Source)
+	}
+}
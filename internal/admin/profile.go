@@ -0,0 +1,158 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// Notifier sends account emails to users
+type Notifier interface {
+	SendRegistrationEmail(email, token string) error
+	SendEmailChangeEmail(email, token string) error
+}
+
+// ProfileData represents the data for the profile page
+type ProfileData struct {
+	Error       string
+	Success     string
+	CurrentPage string
+	UserRole    string
+	UserEmail   string
+	Token       string
+
+	// DefaultHeaders are merged into each new mapping the user creates
+	DefaultHeaders map[string]string
+
+	// Timezone and Locale control how timestamps are shown to the user;
+	// Locales lists the locales they can choose from
+	Timezone string
+	Locale   string
+	Locales  []string
+}
+
+// newProfileData builds the profile page data for the current user
+func (s *Server) newProfileData(r *http.Request) ProfileData {
+	data := ProfileData{
+		CurrentPage: "profile",
+		UserRole:    r.Context().Value(userRoleKey).(string),
+		UserEmail:   r.Context().Value("userEmail").(string),
+		Token:       s.sessions.GenerateCSRFToken(),
+		Locales:     supportedLocales(),
+	}
+	user, err := s.db.GetUserByID(r.Context().Value(userIDKey).(uint))
+	if err != nil {
+		log.Printf("Failed to load user for profile: %v", err)
+	} else if user != nil {
+		data.DefaultHeaders = user.DefaultHeaders
+		data.Timezone = user.Timezone
+		data.Locale = user.Locale
+	}
+	return data
+}
+
+// handleProfile shows the user's profile and starts self-service email
+// changes. The new address must confirm the change before it is applied.
+func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
+	data := s.newProfileData(r)
+
+	if r.Method == "GET" {
+		if r.URL.Query().Get("email_changed") != "" {
+			data.Success = "Your email address has been changed"
+		}
+		if r.URL.Query().Get("display_saved") != "" {
+			data.Success = "Display preferences saved"
+		}
+		s.templates(r).ExecuteTemplate(w, "layout.html", data)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Validate CSRF token
+	if !s.sessions.ValidateCSRFToken(r.FormValue("token")) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	if s.emailer == nil {
+		data.Error = "Email sending is not configured, so email changes can't be confirmed. Please ask an administrator."
+		s.templates(r).ExecuteTemplate(w, "layout.html", data)
+		return
+	}
+
+	userID := r.Context().Value(userIDKey).(uint)
+	change, err := s.db.CreateEmailChangeToken(userID, r.FormValue("new_email"))
+	if errors.Is(err, database.ErrEmailTaken) {
+		data.Error = "That email address is already in use"
+		s.templates(r).ExecuteTemplate(w, "layout.html", data)
+		return
+	}
+	if err != nil {
+		data.Error = fmt.Sprintf("Failed to change email: %v", err)
+		s.templates(r).ExecuteTemplate(w, "layout.html", data)
+		return
+	}
+
+	if err := s.emailer.SendEmailChangeEmail(change.NewEmail, change.Token); err != nil {
+		log.Printf("Failed to send email change confirmation: %v", err)
+		data.Error = fmt.Sprintf("Failed to send confirmation email: %v", err)
+		s.templates(r).ExecuteTemplate(w, "layout.html", data)
+		return
+	}
+
+	log.Printf("User %d requested email change to %s", userID, change.NewEmail)
+	data.Success = fmt.Sprintf("A confirmation link has been sent to %s. Your email will change once you follow it.", change.NewEmail)
+	s.templates(r).ExecuteTemplate(w, "layout.html", data)
+}
+
+// handleProfileHeaders saves the user's default mapping headers. Only
+// mappings created afterwards pick them up.
+func (s *Server) handleProfileHeaders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Validate CSRF token
+	if !s.sessions.ValidateCSRFToken(r.FormValue("token")) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	userID := r.Context().Value(userIDKey).(uint)
+	if err := s.db.UpdateUserDefaultHeaders(userID, headersFromForm(r)); err != nil {
+		log.Printf("Failed to save default headers: %v", err)
+		data := s.newProfileData(r)
+		data.Error = fmt.Sprintf("Failed to save default headers: %v", err)
+		s.templates(r).ExecuteTemplate(w, "layout.html", data)
+		return
+	}
+
+	log.Printf("User %d updated default mapping headers", userID)
+	data := s.newProfileData(r)
+	data.Success = "Default headers saved. They apply to mappings you create from now on."
+	s.templates(r).ExecuteTemplate(w, "layout.html", data)
+}
+
+// handleConfirmEmail applies an email change from the link sent to the new address
+func (s *Server) handleConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := s.db.ConfirmEmailChange(r.URL.Query().Get("token"))
+	if errors.Is(err, database.ErrEmailTaken) {
+		http.Error(w, "That email address is already in use", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to confirm email change: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("User %d changed email to %s", user.ID, user.Email)
+	s.redirect(w, r, "/profile?email_changed=1", http.StatusSeeOther)
+}
@@ -0,0 +1,208 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records the account emails it is asked to send
+type fakeNotifier struct {
+	to, token string
+}
+
+func (n *fakeNotifier) SendRegistrationEmail(email, token string) error {
+	n.to, n.token = email, token
+	return nil
+}
+
+func (n *fakeNotifier) SendEmailChangeEmail(email, token string) error {
+	n.to, n.token = email, token
+	return nil
+}
+
+// requestEmailChange posts the profile form as the admin test user
+func requestEmailChange(t *testing.T, s *Server, newEmail string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	form := url.Values{"new_email": {newEmail}, "token": {s.sessions.GenerateCSRFToken()}}
+	req := httptest.NewRequest("POST", "/profile", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleProfile(rec, asAdmin(req))
+	return rec
+}
+
+func TestHandleProfile_EmailChange(t *testing.T) {
+	s := newTestServer(t)
+	notifier := &fakeNotifier{}
+	s.emailer = notifier
+
+	user, err := s.db.CreateUser("admin@example.com", "admin")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	rec := requestEmailChange(t, s, "new@example.com")
+	if !strings.Contains(rec.Body.String(), "confirmation link has been sent") {
+		t.Fatalf("Expected confirmation notice, got %s", rec.Body.String())
+	}
+	if notifier.to != "new@example.com" || notifier.token == "" {
+		t.Fatalf("Expected confirmation sent to the new address, got %q", notifier.to)
+	}
+
+	// Nothing changes until the new address confirms
+	if unchanged, _ := s.db.GetUserByID(user.ID); unchanged.Email != "admin@example.com" {
+		t.Errorf("Expected email to be unchanged before confirmation, got %s", unchanged.Email)
+	}
+
+	req := httptest.NewRequest("GET", "/profile/confirm-email?token="+url.QueryEscape(notifier.token), nil)
+	rec = httptest.NewRecorder()
+	s.handleConfirmEmail(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect after confirmation, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	changed, err := s.db.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if changed.Email != "new@example.com" {
+		t.Errorf("Expected email to be changed, got %s", changed.Email)
+	}
+
+	// Tokens are single use
+	rec = httptest.NewRecorder()
+	s.handleConfirmEmail(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected reused token to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestHandleProfile_EmailChangeConflict(t *testing.T) {
+	s := newTestServer(t)
+	notifier := &fakeNotifier{}
+	s.emailer = notifier
+
+	if _, err := s.db.CreateUser("admin@example.com", "admin"); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if _, err := s.db.CreateUser("taken@example.com", "user"); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	rec := requestEmailChange(t, s, "taken@example.com")
+	if !strings.Contains(rec.Body.String(), "already in use") {
+		t.Errorf("Expected conflict error, got %s", rec.Body.String())
+	}
+	if notifier.token != "" {
+		t.Error("Expected no confirmation to be sent for a taken address")
+	}
+
+	// The address is taken by someone else between request and confirmation
+	requestEmailChange(t, s, "later@example.com")
+	if _, err := s.db.CreateUser("later@example.com", "user"); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/profile/confirm-email?token="+url.QueryEscape(notifier.token), nil)
+	rec = httptest.NewRecorder()
+	s.handleConfirmEmail(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 when the address was taken meanwhile, got %d", rec.Code)
+	}
+}
+
+func TestHandleProfileHeaders_AppliedToNewMappings(t *testing.T) {
+	s := newTestServer(t)
+	user, err := s.db.CreateUser("admin@example.com", "admin")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	form := url.Values{
+		"header_name[]":  {"X-Api-Key", ""},
+		"header_value[]": {"secret", ""},
+		"token":          {s.sessions.GenerateCSRFToken()},
+	}
+	req := httptest.NewRequest("POST", "/profile/headers", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleProfileHeaders(rec, asAdmin(req))
+	if !strings.Contains(rec.Body.String(), "Default headers saved") || !strings.Contains(rec.Body.String(), `value="X-Api-Key"`) {
+		t.Fatalf("Expected saved defaults to be shown, got %s", rec.Body.String())
+	}
+
+	mapping, err := s.db.CreateEmailMapping(user.ID, "https://example.com/hook", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+	if mapping.Headers["X-Api-Key"] != "secret" || len(mapping.Headers) != 1 {
+		t.Errorf("Expected new mapping to inherit default headers, got %v", mapping.Headers)
+	}
+}
+
+func TestHandleProfileDisplay_AppliedToRendering(t *testing.T) {
+	s := newTestServer(t)
+	user, err := s.db.CreateUser("user@example.com", "user")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	seedLogs(t, s, user.ID, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), "success")
+
+	form := url.Values{
+		"timezone": {"Asia/Tokyo"},
+		"locale":   {"de-DE"},
+		"token":    {s.sessions.GenerateCSRFToken()},
+	}
+	req := httptest.NewRequest("POST", "/profile/display", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleProfileDisplay(rec, asUser(req, user.ID))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect after saving, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	updated, err := s.db.GetUserByID(user.ID)
+	if err != nil || updated == nil {
+		t.Fatalf("Failed to reload user: %v", err)
+	}
+	if updated.Timezone != "Asia/Tokyo" || updated.Locale != "de-DE" {
+		t.Fatalf("Expected preferences to persist, got timezone %q locale %q", updated.Timezone, updated.Locale)
+	}
+
+	// A later request picks the preferences up through the session
+	token, err := s.sessions.CreateSession(user.ID, "user")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	req = httptest.NewRequest("GET", "/logs", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: token})
+	rec = httptest.NewRecorder()
+	s.RequireAuth(s.handleLogs)(rec, req)
+
+	if body := rec.Body.String(); !strings.Contains(body, "01.03.2024 21:00:00 JST") {
+		t.Errorf("Expected log time in the user's zone and format, got:\n%s", body)
+	}
+}
+
+func TestHandleProfileDisplay_RejectsInvalidTimezone(t *testing.T) {
+	s := newTestServer(t)
+	user, err := s.db.CreateUser("user@example.com", "user")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	form := url.Values{"timezone": {"Mars/Olympus"}, "token": {s.sessions.GenerateCSRFToken()}}
+	req := httptest.NewRequest("POST", "/profile/display", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleProfileDisplay(rec, asUser(req, user.ID))
+
+	if !strings.Contains(rec.Body.String(), "invalid timezone") {
+		t.Errorf("Expected an invalid timezone error, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,95 @@
+package admin
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter allows each client, keyed by user or IP address, a fixed
+// number of requests per window
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	windows map[string]rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// newRateLimiter creates a limiter allowing limit requests per window per
+// client; it returns nil (no limiting) when limit is not positive
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		now:     time.Now,
+		windows: make(map[string]rateWindow),
+	}
+}
+
+// allow records a request for key, returning false and the time until the
+// window resets once the limit is reached
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	w := l.windows[key]
+	if now.Sub(w.start) >= l.window {
+		w = rateWindow{start: now}
+	}
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.count++
+	l.windows[key] = w
+	return true, 0
+}
+
+// RateLimit middleware limits how often each authenticated user may call an
+// API route, answering 429 with Retry-After once the limit is reached
+func (s *Server) RateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(userIDKey).(uint)
+		if limited(w, s.apiLimiter, "user:"+strconv.FormatUint(uint64(userID), 10)) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// SignupRateLimit middleware limits how often each client IP address may
+// submit the public signup form
+func (s *Server) SignupRateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && limited(w, s.signupLimiter, "ip:"+remoteIP(r)) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// limited records a request for key and answers 429 with Retry-After once
+// the limit is reached; a nil limiter never limits
+func limited(w http.ResponseWriter, l *rateLimiter, key string) bool {
+	if l == nil {
+		return false
+	}
+	if ok, retryAfter := l.allow(key); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return true
+	}
+	return false
+}
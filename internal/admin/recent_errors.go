@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// recentErrorsCookie holds the Unix time the recent errors banner was last
+// dismissed; errors logged before it are not shown again
+const recentErrorsCookie = "recent_errors_dismissed"
+
+// RecentErrors summarizes a user's recent processing failures for the
+// dashboard banner
+type RecentErrors struct {
+	Count     int64
+	Since     time.Time // Start of the counted period
+	CheckedAt int64     // Unix time of the count, stored on dismissal
+}
+
+// recentErrors counts failures on the user's mappings within the recent
+// errors window, or since the banner was dismissed if that is later. It
+// returns nil when there are none or the banner is disabled.
+func (s *Server) recentErrors(r *http.Request, userID uint) *RecentErrors {
+	if s.recentErrorsWindow <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	since := now.Add(-s.recentErrorsWindow)
+	if cookie, err := r.Cookie(recentErrorsCookie); err == nil {
+		if dismissed, err := strconv.ParseInt(cookie.Value, 10, 64); err == nil && time.Unix(dismissed, 0).After(since) {
+			since = time.Unix(dismissed, 0)
+		}
+	}
+
+	count, err := s.db.CountErrorsSince(userID, since)
+	if err != nil {
+		log.Printf("Failed to count recent errors for user %d: %v", userID, err)
+		return nil
+	}
+	if count == 0 {
+		return nil
+	}
+	return &RecentErrors{Count: count, Since: since, CheckedAt: now.Unix()}
+}
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestHandleMappings_RecentErrorsBanner(t *testing.T) {
+	s := newTestServer(t)
+	s.recentErrorsWindow = time.Hour
+
+	// User 2 had a failure within the window and one long before it
+	now := time.Now()
+	seedLogs(t, s, 2, now.Add(-48*time.Hour), "error", "success", "error")
+	seedLogs(t, s, 3, now.Add(-48*time.Hour), "success", "success", "success")
+
+	render := func(r *http.Request) string {
+		rec := httptest.NewRecorder()
+		s.handleMappings(rec, r)
+		return rec.Body.String()
+	}
+
+	body := render(asUser(httptest.NewRequest("GET", "/", nil), 2))
+	if !strings.Contains(body, `id="recent-errors-banner"`) || !strings.Contains(body, "1 email to your mappings failed") {
+		t.Fatalf("Expected a banner for one recent error, got:\n%s", body)
+	}
+	if !strings.Contains(body, `href="/logs?status=error&amp;since=`) {
+		t.Errorf("Expected the banner to link to the filtered logs")
+	}
+
+	// Users without recent failures see no banner
+	if body := render(asUser(httptest.NewRequest("GET", "/", nil), 3)); strings.Contains(body, "recent-errors-banner") {
+		t.Error("Expected no banner without recent errors")
+	}
+
+	// Dismissing hides the banner until a newer error is logged
+	req := asUser(httptest.NewRequest("GET", "/", nil), 2)
+	req.AddCookie(&http.Cookie{Name: recentErrorsCookie, Value: strconv.FormatInt(now.Add(time.Minute).Unix(), 10)})
+	if body := render(req); strings.Contains(body, "recent-errors-banner") {
+		t.Error("Expected no banner after dismissal")
+	}
+}
+
+func TestHandleLogs_StatusAndSinceFilters(t *testing.T) {
+	s := newTestServer(t)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	seedLogs(t, s, 1, start, "error", "success", "error")
+
+	rec := httptest.NewRecorder()
+	s.handleLogs(rec, asAdmin(httptest.NewRequest("GET", "/logs?status=error&since=2024-03-02T00:00:00Z", nil)))
+	body := rec.Body.String()
+	if !strings.Contains(body, "email 2") || strings.Contains(body, "email 0") || strings.Contains(body, "email 1") {
+		t.Errorf("Expected only the later error, got:\n%s", body)
+	}
+}
+
+func TestHandleLogs_ClientIPFilter(t *testing.T) {
+	s := newTestServer(t)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mapping := seedLogs(t, s, 1, start, "success", "success")
+	if err := s.db.Model(&database.EmailLog{}).
+		Where("mapping_id = ? AND subject = ?", mapping.ID, "email 1").
+		Update("client_ip", "203.0.113.7").Error; err != nil {
+		t.Fatalf("Failed to set client IP: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleLogs(rec, asAdmin(httptest.NewRequest("GET", "/logs?client_ip=203.0.113.7", nil)))
+	body := rec.Body.String()
+	if !strings.Contains(body, "email 1") || strings.Contains(body, "email 0") {
+		t.Errorf("Expected only the log from 203.0.113.7, got:\n%s", body)
+	}
+	if !strings.Contains(body, "203.0.113.7") {
+		t.Errorf("Expected the client IP to be shown, got:\n%s", body)
+	}
+}
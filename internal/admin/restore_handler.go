@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// handleRestoreMapping is a handler for the POST /admin/mappings/restore
+// endpoint. It brings back a deleted mapping and its logs while they are
+// still within the delete grace period.
+func (s *Server) handleRestoreMapping(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Validate CSRF token
+	if !s.sessions.ValidateCSRFToken(r.FormValue("token")) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	mappingID, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid mapping ID", http.StatusBadRequest)
+		return
+	}
+
+	mapping, err := s.db.RestoreEmailMapping(uint(mappingID))
+	if err != nil {
+		log.Printf("Error restoring mapping %d: %v", mappingID, err)
+		http.Error(w, fmt.Sprintf("Failed to restore mapping: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d restored mapping %s", r.Context().Value(userIDKey).(uint), mapping.GeneratedEmail)
+
+	// Redirect back to mappings page
+	s.redirect(w, r, "/", http.StatusSeeOther)
+}
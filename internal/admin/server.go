@@ -6,7 +6,10 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/looprock/email-to-api/internal/config"
@@ -26,19 +29,50 @@ const (
 	// userIDKey is the context key for the user ID
 	userIDKey   contextKey = "userID"
 	userRoleKey contextKey = "userRole"
+	// userDisplayKey holds the user's displayPreferences
+	userDisplayKey contextKey = "userDisplay"
 )
 
 // Server represents the admin interface server
 type Server struct {
-	db       *database.DB
-	tmpl     *template.Template
-	sessions *SessionManager
-	emailer  *email.Sender
+	db         *database.DB
+	tmpl       *template.Template
+	sessions   *SessionManager
+	emailer    Notifier // nil unless email sending is configured
+	cors       CORSConfig
+	oidc       *oidcProvider    // nil unless single sign-on is configured
+	signup     SignupConfig     // Public self-service signup; disabled by default
+	location   *time.Location   // Time zone timestamps are displayed in
+	previewer  *email.Processor // Builds payload previews and signature checks; never delivers email
+	apiLimiter *rateLimiter     // nil unless API rate limiting is configured
+
+	// signupLimiter caps signup submissions per client IP; nil disables it
+	signupLimiter *rateLimiter
+
+	// recentErrorsWindow is how far back the dashboard looks for failures;
+	// zero hides the recent errors banner
+	recentErrorsWindow time.Duration
+
+	// basePath is the subpath the admin UI is mounted under, e.g.
+	// "/email-admin"; empty when served at the root
+	basePath string
+
+	// loginRedirects maps roles to the route they land on after login;
+	// unlisted roles land on "/"
+	loginRedirects map[string]string
+
+	// Templates for users with their own time zone or locale, parsed on
+	// first use and keyed by both; templateDir holds the overrides
+	templateDir     string
+	userTemplatesMu sync.Mutex
+	userTemplates   map[string]*template.Template
 }
 
 // EmailMappingData represents the data for email mappings page
 type EmailMappingData struct {
 	Mappings    []database.EmailMapping
+	Deleted     []database.EmailMapping // Restorable deleted mappings, admins only
+	Recent      *RecentErrors           // Recent failures banner; nil hides it
 	Error       string
 	Success     string
 	CurrentPage string
@@ -54,6 +88,9 @@ type LogData struct {
 	CurrentPage string
 	UserRole    string
 	UserEmail   string
+	Status      string    // Status filter, if any
+	Since       time.Time // Only logs from this time on, if set
+	ClientIP    string    // Only logs of emails sent from this IP, if set
 }
 
 // LogEntry represents a log entry with formatted time
@@ -68,6 +105,7 @@ type LogEntry struct {
 	GeneratedEmail string    `gorm:"column:generated_email"`
 	Headers        string    `gorm:"column:headers"`
 	UserEmail      string    `gorm:"column:user_email"`
+	ClientIP       string    `gorm:"column:client_ip"`
 }
 
 // TableName specifies the table name for GORM
@@ -106,64 +144,208 @@ type PasswordData struct {
 
 // New creates a new admin server
 func New(db *database.DB, cfg *config.Config) (*Server, error) {
-	// Parse both templates with a base template
-	tmpl := template.New("").Funcs(template.FuncMap{
-		"eq": func(a, b string) bool { return a == b },
-	})
+	location, err := time.LoadLocation(cfg.AdminServer.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin server timezone %q: %w", cfg.AdminServer.Timezone, err)
+	}
 
-	tmpl, err := tmpl.ParseFS(templateFS, "templates/*.html")
+	basePath := normalizeBasePath(cfg.AdminServer.BasePath)
+	for role, path := range cfg.AdminServer.LoginRedirects {
+		if !isLocalPath(path) {
+			return nil, fmt.Errorf("invalid login redirect %q for role %s: must be an admin route starting with /", path, role)
+		}
+	}
+	tmpl, err := parseTemplates(location, "", cfg.AdminServer.TemplateDir, basePath)
 	if err != nil {
 		return nil, err
 	}
 
-	emailer, err := email.NewMailgunSender(cfg.Mailgun.SiteDomain)
+	emailer, err := email.NewMailgunSender(cfg.Mailgun.SiteDomain, email.MailgunValidation{
+		Mode:    cfg.Mailgun.Validate,
+		Timeout: time.Duration(cfg.Mailgun.ValidateTimeout) * time.Second,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create email sender: %w", err)
 	}
 
-	// Note: emailer can be nil if Mailgun is not configured
+	if cfg.AdminServer.Signup.Enabled {
+		if err := validateSignupRole(cfg.AdminServer.Signup.DefaultRole); err != nil {
+			return nil, err
+		}
+	}
+
+	oidc, err := newOIDCProvider(OIDCConfig{
+		Issuer:       cfg.AdminServer.OIDC.Issuer,
+		ClientID:     cfg.AdminServer.OIDC.ClientID,
+		ClientSecret: cfg.AdminServer.OIDC.ClientSecret,
+		RedirectURL:  cfg.AdminServer.OIDC.RedirectURL,
+		DefaultRole:  cfg.AdminServer.OIDC.DefaultRole,
+		Scopes:       cfg.AdminServer.OIDC.Scopes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Note: emailer is nil if Mailgun is not configured; it is only assigned
+	// below so the Notifier interface stays nil rather than holding a nil *Sender
 	server := &Server{
 		db:       db,
 		tmpl:     tmpl,
 		sessions: NewSessionManager(),
-		emailer:  emailer,
+		cors: CORSConfig{
+			AllowedOrigins: cfg.AdminServer.CORS.AllowedOrigins,
+			AllowedMethods: cfg.AdminServer.CORS.AllowedMethods,
+			AllowedHeaders: cfg.AdminServer.CORS.AllowedHeaders,
+		},
+		location:  location,
+		previewer: email.New(db, email.ProcessorConfig{InstanceLabel: cfg.InstanceLabel}),
+		oidc:      oidc,
+		signup: SignupConfig{
+			Enabled:     cfg.AdminServer.Signup.Enabled,
+			DefaultRole: cfg.AdminServer.Signup.DefaultRole,
+		},
+		apiLimiter: newRateLimiter(cfg.AdminServer.APIRateLimit, time.Minute),
+
+		signupLimiter: newRateLimiter(cfg.AdminServer.Signup.RateLimit, time.Hour),
+
+		recentErrorsWindow: time.Duration(cfg.AdminServer.RecentErrorsMinutes) * time.Minute,
+
+		basePath: basePath,
+
+		loginRedirects: cfg.AdminServer.LoginRedirects,
+
+		templateDir: cfg.AdminServer.TemplateDir,
 	}
 
 	if emailer == nil {
 		log.Println("Warning: Email sending is not configured. Users will need to be configured manually.")
+	} else {
+		server.emailer = emailer
 	}
 
 	return server, nil
 }
 
+// parseTemplates parses the embedded page templates with their helper
+// functions; timestamps are rendered in the given location in the locale's
+// format and links are prefixed with basePath. Templates in overrideDir, if set, replace
+// embedded ones of the same name.
+func parseTemplates(location *time.Location, locale, overrideDir, basePath string) (*template.Template, error) {
+	// Parse both templates with a base template
+	tmpl, err := template.New("").Funcs(templateFuncs(location, locale, basePath)).ParseFS(templateFS, "templates/*.html")
+	if err != nil || overrideDir == "" {
+		return tmpl, err
+	}
+
+	// Parse the overrides into a copy so a broken override leaves the
+	// embedded templates in use
+	files, err := filepath.Glob(filepath.Join(overrideDir, "*.html"))
+	if err != nil || len(files) == 0 {
+		log.Printf("Warning: no templates found in %s, using built-in templates", overrideDir)
+		return tmpl, nil
+	}
+	overridden, err := template.Must(tmpl.Clone()).ParseFiles(files...)
+	if err != nil {
+		log.Printf("Warning: failed to parse templates in %s, using built-in templates: %v", overrideDir, err)
+		return tmpl, nil
+	}
+	log.Printf("Using %d template override(s) from %s", len(files), overrideDir)
+	return overridden, nil
+}
+
 // Start starts the admin server
 func (s *Server) Start(addr string) error {
+	log.Printf("Starting admin server at %s", addr)
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+// Handler returns the admin server's HTTP handler with all routes registered
+func (s *Server) Handler() http.Handler {
 	// Register routes
 	mux := http.NewServeMux()
 
 	// Auth routes
 	mux.HandleFunc("/login", s.HandleLogin)
 	mux.HandleFunc("/logout", s.HandleLogout)
+	mux.HandleFunc("/login/oidc", s.HandleOIDCLogin)
+	mux.HandleFunc("/login/oidc/callback", s.HandleOIDCCallback)
 	mux.HandleFunc("/register", s.handleRegister)
+	mux.HandleFunc("/signup", s.SignupRateLimit(s.handleSignup))
 	mux.HandleFunc("/change-password", s.RequireAuth(s.handleChangePassword))
+	mux.HandleFunc("/profile", s.RequireAuth(s.handleProfile))
+	mux.HandleFunc("/profile/headers", s.RequireAuth(s.handleProfileHeaders))
+	mux.HandleFunc("/profile/display", s.RequireAuth(s.handleProfileDisplay))
+	mux.HandleFunc("/profile/confirm-email", s.handleConfirmEmail)
 
 	// User management routes
 	mux.HandleFunc("/users/role", s.RequireAuth(s.RequireAdmin(s.handleUserRole)))
 	mux.HandleFunc("/users/toggle", s.RequireAuth(s.RequireAdmin(s.handleUserToggle)))
 
+	// Delivery queue routes
+	mux.HandleFunc("/deliveries", s.RequireAuth(s.RequireAdmin(s.handleDeliveries)))
+	mux.HandleFunc("/deliveries/retry", s.RequireAuth(s.RequireAdmin(s.handleDeliveryRetry)))
+	mux.HandleFunc("/deliveries/cancel", s.RequireAuth(s.RequireAdmin(s.handleDeliveryCancel)))
+
+	// Dead letter routes
+	mux.HandleFunc("/dead-letters", s.RequireAuth(s.RequireAdmin(s.handleDeadLetters)))
+	mux.HandleFunc("/dead-letters/replay", s.RequireAuth(s.RequireAdmin(s.handleDeadLetterReplay)))
+
+	// Runtime settings
+	mux.HandleFunc("/settings", s.RequireAuth(s.RequireAdmin(s.handleSettings)))
+
 	// Protected routes
 	mux.HandleFunc("/", s.RequireAuth(s.handleMappings))
 	mux.HandleFunc("/logs", s.RequireAuth(s.handleLogs))
 	mux.HandleFunc("/users", s.RequireAuth(s.RequireAdmin(s.handleUsers)))
-	mux.HandleFunc("/api/mappings", s.RequireAuth(s.handleAPIMappings))
-	mux.HandleFunc("/api/mappings/delete", s.RequireAuth(s.handleDeleteMapping))
+	mux.HandleFunc("/api/mappings", s.CORS(s.RequireAuth(s.handleAPIMappings)))
+	mux.HandleFunc("/api/mappings/delete", s.CORS(s.RequireAuth(s.handleDeleteMapping)))
+	mux.HandleFunc("/api/mappings/clone", s.CORS(s.RequireAuth(s.handleCloneMapping)))
+	mux.HandleFunc("/api/mappings/preview", s.CORS(s.RequireAuth(s.handlePreviewMapping)))
+	mux.HandleFunc("/api/mappings/verify-signature", s.CORS(s.RequireAuth(s.handleVerifySignature)))
+	mux.HandleFunc("/api/logs", s.CORS(s.RequireAuth(s.RateLimit(s.handleAPILogs))))
+	mux.HandleFunc("/api/logs/retry", s.CORS(s.RequireAuth(s.RequireAdmin(s.handleAPILogsRetry))))
 
 	// New HTMX routes
 	mux.HandleFunc("/admin/mappings/add-form", s.RequireAuth(s.handleAddMappingForm))
 	mux.HandleFunc("/admin/mappings/header-row", s.RequireAuth(s.handleHeaderRow))
+	mux.HandleFunc("/admin/mappings/restore", s.RequireAuth(s.RequireAdmin(s.handleRestoreMapping)))
 
-	log.Printf("Starting admin server at %s", addr)
-	return http.ListenAndServe(addr, mux)
+	if s.basePath == "" {
+		return mux
+	}
+
+	// Routes are registered relative to the base path, which is stripped
+	// before they are matched
+	mounted := http.NewServeMux()
+	mounted.Handle(s.basePath+"/", http.StripPrefix(s.basePath, mux))
+	mounted.Handle(s.basePath, http.RedirectHandler(s.basePath+"/", http.StatusMovedPermanently))
+	return mounted
+}
+
+// normalizeBasePath returns basePath with a leading and without a trailing
+// slash, or "" for the root
+func normalizeBasePath(basePath string) string {
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// isLocalPath reports whether path is a route on this server rather than
+// a URL that would redirect elsewhere
+func isLocalPath(path string) bool {
+	return strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "//") && !strings.Contains(path, `\`)
+}
+
+// url returns the absolute path of an admin route under the base path
+func (s *Server) url(path string) string {
+	return s.basePath + path
+}
+
+// redirect redirects to an admin route under the base path
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request, path string, code int) {
+	http.Redirect(w, r, s.url(path), code)
 }
 
 // handleMappings handles the email mappings page
@@ -193,12 +375,18 @@ func (s *Server) handleMappings(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Printf("Database error fetching mappings: %v", err)
 		data.Error = fmt.Sprintf("Failed to fetch mappings: %v", err)
-		s.tmpl.ExecuteTemplate(w, "layout.html", data)
+		s.templates(r).ExecuteTemplate(w, "layout.html", data)
 		return
 	}
 
 	data.Mappings = mappings
-	s.tmpl.ExecuteTemplate(w, "layout.html", data)
+	data.Recent = s.recentErrors(r, userID)
+	if userRole == "admin" {
+		if data.Deleted, err = s.db.GetDeletedMappings(); err != nil {
+			log.Printf("Database error fetching deleted mappings: %v", err)
+		}
+	}
+	s.templates(r).ExecuteTemplate(w, "layout.html", data)
 }
 
 // handleLogs handles the logs page
@@ -217,16 +405,34 @@ func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
 	query := s.db.DB.
 		Table("email_logs l").
 		Select(`l.id, l.from_address, l.subject, l.processed_at, l.status, l.error_message, 
-			l.headers, m.endpoint_url, m.generated_email, u.email as user_email`).
+			l.headers, l.client_ip, m.endpoint_url, m.generated_email, u.email as user_email`).
 		Joins("LEFT JOIN email_mappings m ON l.mapping_id = m.id").
-		Joins("LEFT JOIN users u ON m.user_id = u.id")
+		Joins("LEFT JOIN users u ON m.user_id = u.id").
+		Where("l.deleted_at IS NULL")
 
 	if userRole != "admin" {
 		// Regular users only see their own logs
 		query = query.Where("m.user_id = ?", userID)
 	}
 
-	err := query.
+	// Optional filters, as linked from the recent errors banner
+	since, err := parseDateParam(r.URL.Query().Get("since"), false)
+	if err != nil {
+		data.Error = fmt.Sprintf("Invalid since: %v", err)
+		s.templates(r).ExecuteTemplate(w, "layout.html", data)
+		return
+	}
+	if data.Status = r.URL.Query().Get("status"); data.Status != "" {
+		query = query.Where("l.status = ?", data.Status)
+	}
+	if data.Since = since; !since.IsZero() {
+		query = query.Where("l.processed_at >= ?", since)
+	}
+	if data.ClientIP = strings.TrimSpace(r.URL.Query().Get("client_ip")); data.ClientIP != "" {
+		query = query.Where("l.client_ip = ?", data.ClientIP)
+	}
+
+	err = query.
 		Order("l.processed_at DESC").
 		Limit(100).
 		Find(&logs).Error
@@ -234,23 +440,23 @@ func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Printf("Failed to fetch logs: %v", err)
 		data.Error = "Failed to fetch logs"
-		s.tmpl.ExecuteTemplate(w, "layout.html", data)
+		s.templates(r).ExecuteTemplate(w, "layout.html", data)
 		return
 	}
 
 	data.Logs = logs
-	s.tmpl.ExecuteTemplate(w, "layout.html", data)
+	s.templates(r).ExecuteTemplate(w, "layout.html", data)
 }
 
 // handleAddMappingForm renders the add mapping form template
 func (s *Server) handleAddMappingForm(w http.ResponseWriter, r *http.Request) {
 	token := r.URL.Query().Get("token")
-	s.tmpl.ExecuteTemplate(w, "add-mapping-form", token)
+	s.templates(r).ExecuteTemplate(w, "add-mapping-form", token)
 }
 
 // handleHeaderRow renders a new header row template
 func (s *Server) handleHeaderRow(w http.ResponseWriter, r *http.Request) {
-	s.tmpl.ExecuteTemplate(w, "header-row", nil)
+	s.templates(r).ExecuteTemplate(w, "header-row", nil)
 }
 
 // handleAPIMappings handles API requests for email mappings
@@ -282,30 +488,56 @@ func (s *Server) handleAPIMappings(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Collect headers from form data
-		headers := make(map[string]string)
-		headerNames := r.Form["header_name[]"]
-		headerValues := r.Form["header_value[]"]
-		for i := range headerNames {
-			if headerNames[i] != "" && headerValues[i] != "" {
-				headers[headerNames[i]] = headerValues[i]
-			}
+		headers := headersFromForm(r)
+
+		// Check the options first so a bad one doesn't leave a half-configured mapping
+		opts := mappingOptionsFromForm(r)
+		if err := opts.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		// Create the mapping
-		if _, err := s.db.CreateEmailMapping(
-			userID,
-			r.FormValue("endpoint_url"),
-			r.FormValue("description"),
-			headers,
-		); err != nil {
+		// Create the mapping, using the requested custom address if given
+		var mapping *database.EmailMapping
+		var err error
+		if vanity := r.FormValue("vanity"); vanity != "" {
+			mapping, err = s.db.CreateVanityEmailMapping(
+				userID,
+				vanity,
+				r.FormValue("endpoint_url"),
+				r.FormValue("description"),
+				headers,
+			)
+		} else {
+			mapping, err = s.db.CreateEmailMapping(
+				userID,
+				r.FormValue("endpoint_url"),
+				r.FormValue("description"),
+				headers,
+			)
+		}
+		if err != nil {
 			log.Printf("Error creating mapping: %v", err)
 			http.Error(w, fmt.Sprintf("Failed to create mapping: %v", err), http.StatusInternalServerError)
 			return
 		}
 
+		// Apply optional processing settings
+		if err := s.db.UpdateMappingOptions(mapping.GeneratedEmail, userID, opts); err != nil {
+			log.Printf("Error saving mapping options: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to save mapping options: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if secret := r.FormValue("signing_secret"); secret != "" {
+			if err := s.db.SetMappingSigningSecret(mapping.GeneratedEmail, userID, secret); err != nil {
+				log.Printf("Error saving signing secret: %v", err)
+				http.Error(w, fmt.Sprintf("Failed to save signing secret: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
 		// Redirect back to mappings page
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		s.redirect(w, r, "/", http.StatusSeeOther)
 
 	case "PUT":
 		emailAddress := r.FormValue("email")
@@ -320,7 +552,7 @@ func (s *Server) handleAPIMappings(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Redirect back to mappings page
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		s.redirect(w, r, "/", http.StatusSeeOther)
 
 	case "DELETE":
 		// Forward to dedicated delete handler that handles admin privileges
@@ -328,7 +560,7 @@ func (s *Server) handleAPIMappings(w http.ResponseWriter, r *http.Request) {
 		email := r.URL.Query().Get("email")
 	
 		// Redirect to new delete handler
-		http.Redirect(w, r, fmt.Sprintf("/api/mappings/delete?email=%s&token=%s", email, token), http.StatusSeeOther)
+		s.redirect(w, r, fmt.Sprintf("/api/mappings/delete?email=%s&token=%s", email, token), http.StatusSeeOther)
 		return
 
 	default:
@@ -336,6 +568,107 @@ func (s *Server) handleAPIMappings(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// headersFromForm collects the header_name[]/header_value[] rows of a
+// parsed form, skipping incomplete rows
+func headersFromForm(r *http.Request) map[string]string {
+	headers := make(map[string]string)
+	headerNames := r.Form["header_name[]"]
+	headerValues := r.Form["header_value[]"]
+	for i := range headerNames {
+		if i < len(headerValues) && headerNames[i] != "" && headerValues[i] != "" {
+			headers[headerNames[i]] = headerValues[i]
+		}
+	}
+	return headers
+}
+
+// mappingOptionsFromForm reads the optional mapping settings from the add/edit form
+func mappingOptionsFromForm(r *http.Request) database.MappingOptions {
+	batchSize, _ := strconv.Atoi(r.FormValue("batch_size"))
+	batchWindow, _ := strconv.Atoi(r.FormValue("batch_window"))
+	batchMaxBytes, _ := strconv.Atoi(r.FormValue("batch_max_bytes"))
+	digestHour, _ := strconv.Atoi(r.FormValue("digest_hour"))
+	maxInFlight, _ := strconv.Atoi(r.FormValue("max_in_flight"))
+
+	return database.MappingOptions{
+		DropAutoSubmitted: r.FormValue("drop_auto_submitted") == "on",
+		BatchSize:         batchSize,
+		BatchWindow:       batchWindow,
+		BatchMaxBytes:     batchMaxBytes,
+		FieldNaming:       r.FormValue("field_naming"),
+		FieldNameMap:      parseFieldNameMap(r.FormValue("field_name_map")),
+		StripQuoted:       r.FormValue("strip_quoted") == "on",
+		FlattenHeaders:    r.FormValue("flatten_headers") == "on",
+		LogLevel:          r.FormValue("log_level"),
+		StaticTags:        splitList(r.FormValue("static_tags")),
+		StatusActions:     parseStatusActions(r.FormValue("status_actions")),
+		InlineImages:      r.FormValue("inline_images"),
+		PayloadVersion:    r.FormValue("payload_version"),
+		ChatFormat:        r.FormValue("chat_format"),
+		SuccessPattern:    strings.TrimSpace(r.FormValue("success_pattern")),
+		BounceAction:      r.FormValue("bounce_action"),
+		BounceEndpoint:    strings.TrimSpace(r.FormValue("bounce_endpoint")),
+		StripHeaders:      splitList(r.FormValue("strip_headers")),
+		PayloadFields:     splitList(r.FormValue("payload_fields")),
+		DigestSchedule:    r.FormValue("digest_schedule"),
+		DigestHour:        digestHour,
+		TagCase:           r.FormValue("tag_case"),
+		MaxInFlight:       maxInFlight,
+		PayloadSchema:     strings.TrimSpace(r.FormValue("payload_schema")),
+		HTTPMethod:        strings.ToUpper(r.FormValue("http_method")),
+		BodyTemplate:      r.FormValue("body_template"),
+	}
+}
+
+// parseStatusActions parses "code=action" pairs such as "202=success, 409=drop",
+// skipping entries with a non-numeric code or unknown action
+func parseStatusActions(value string) map[string]string {
+	actions := make(map[string]string)
+	for _, item := range splitList(value) {
+		code, action, ok := strings.Cut(item, "=")
+		code, action = strings.TrimSpace(code), strings.ToLower(strings.TrimSpace(action))
+		if _, err := strconv.Atoi(code); !ok || err != nil || !database.ValidStatusAction(action) {
+			log.Printf("Ignoring invalid status action %q", item)
+			continue
+		}
+		actions[code] = action
+	}
+	if len(actions) == 0 {
+		return nil
+	}
+	return actions
+}
+
+// parseFieldNameMap parses "field=name" pairs such as "from=sender, subject=title",
+// skipping entries without both names
+func parseFieldNameMap(value string) map[string]string {
+	names := make(map[string]string)
+	for _, item := range splitList(value) {
+		field, name, ok := strings.Cut(item, "=")
+		field, name = strings.TrimSpace(field), strings.TrimSpace(name)
+		if !ok || field == "" || name == "" {
+			log.Printf("Ignoring invalid field name mapping %q", item)
+			continue
+		}
+		names[field] = name
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return names
+}
+
+// splitList parses a comma-separated form value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // handleUsers handles the users management page
 func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
 	data := UsersData{
@@ -398,7 +731,7 @@ func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
 		data.Users = users
 	}
 
-	s.tmpl.ExecuteTemplate(w, "layout.html", data)
+	s.templates(r).ExecuteTemplate(w, "layout.html", data)
 }
 
 // handleRegister handles user registration with token
@@ -411,7 +744,7 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		// Verify token exists and is valid
 		if data.Token == "" {
 			log.Printf("Registration attempt with empty token")
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			s.redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
@@ -419,17 +752,17 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		valid, err := s.db.ValidateRegistrationToken(data.Token)
 		if err != nil {
 			log.Printf("Error validating token: %v", err)
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			s.redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 		if !valid {
 			log.Printf("Invalid or expired token: %s", data.Token)
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			s.redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
 		log.Printf("Token valid, rendering registration form")
-		if err := s.tmpl.ExecuteTemplate(w, "register.html", data); err != nil {
+		if err := s.templates(r).ExecuteTemplate(w, "register.html", data); err != nil {
 			log.Printf("Error rendering template: %v", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
@@ -440,7 +773,7 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
 		if err := r.ParseForm(); err != nil {
 			data.Error = "Failed to parse form"
-			s.tmpl.ExecuteTemplate(w, "register.html", data)
+			s.templates(r).ExecuteTemplate(w, "register.html", data)
 			return
 		}
 
@@ -450,23 +783,23 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 
 		if password == "" || confirmPassword == "" {
 			data.Error = "Password is required"
-			s.tmpl.ExecuteTemplate(w, "register.html", data)
+			s.templates(r).ExecuteTemplate(w, "register.html", data)
 			return
 		}
 
 		if password != confirmPassword {
 			data.Error = "Passwords do not match"
-			s.tmpl.ExecuteTemplate(w, "register.html", data)
+			s.templates(r).ExecuteTemplate(w, "register.html", data)
 			return
 		}
 
 		if err := s.db.SetPassword(token, password); err != nil {
 			data.Error = fmt.Sprintf("Failed to set password: %v", err)
-			s.tmpl.ExecuteTemplate(w, "register.html", data)
+			s.templates(r).ExecuteTemplate(w, "register.html", data)
 			return
 		}
 
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		s.redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
@@ -508,7 +841,7 @@ func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == "GET" {
-		if err := s.tmpl.ExecuteTemplate(w, "change_password.html", data); err != nil {
+		if err := s.templates(r).ExecuteTemplate(w, "change_password.html", data); err != nil {
 			log.Printf("Error rendering change password template: %v", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
@@ -522,7 +855,7 @@ func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
 
 		if newPassword != confirmPassword {
 			data.Error = "New passwords do not match"
-			s.tmpl.ExecuteTemplate(w, "change_password.html", data)
+			s.templates(r).ExecuteTemplate(w, "change_password.html", data)
 			return
 		}
 
@@ -533,13 +866,13 @@ func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
 			currentUser, err := s.db.GetUserByID(currentUserID)
 			if err != nil {
 				data.Error = "Failed to verify credentials"
-				s.tmpl.ExecuteTemplate(w, "change_password.html", data)
+				s.templates(r).ExecuteTemplate(w, "change_password.html", data)
 				return
 			}
 
 			if err := bcrypt.CompareHashAndPassword([]byte(currentUser.PasswordHash), []byte(currentPassword)); err != nil {
 				data.Error = "Invalid current password"
-				s.tmpl.ExecuteTemplate(w, "change_password.html", data)
+				s.templates(r).ExecuteTemplate(w, "change_password.html", data)
 				return
 			}
 		}
@@ -547,12 +880,12 @@ func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
 		// Change target user's password
 		if err := s.db.ChangePassword(targetUserID, "", newPassword); err != nil {
 			data.Error = err.Error()
-			s.tmpl.ExecuteTemplate(w, "change_password.html", data)
+			s.templates(r).ExecuteTemplate(w, "change_password.html", data)
 			return
 		}
 
 		data.Success = "Password changed successfully"
-		s.tmpl.ExecuteTemplate(w, "change_password.html", data)
+		s.templates(r).ExecuteTemplate(w, "change_password.html", data)
 		return
 	}
 
@@ -580,7 +913,7 @@ func (s *Server) handleUserRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.Redirect(w, r, "/users", http.StatusSeeOther)
+	s.redirect(w, r, "/users", http.StatusSeeOther)
 }
 
 // handleUserToggle handles toggling a user's active status
@@ -610,5 +943,5 @@ func (s *Server) handleUserToggle(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("User %d %s", userID, status)
 
-	http.Redirect(w, r, "/users", http.StatusSeeOther)
+	s.redirect(w, r, "/users", http.StatusSeeOther)
 }
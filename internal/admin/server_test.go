@@ -0,0 +1,213 @@
+package admin
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestHandler_BasePath(t *testing.T) {
+	s := newTestServer(t)
+	s.basePath = normalizeBasePath("email-admin/")
+	tmpl, err := parseTemplates(time.UTC, "", "", s.basePath)
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v", err)
+	}
+	s.tmpl = tmpl
+
+	user, err := s.db.CreateUser("alice@example.com", "admin")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := s.db.ChangePassword(user.ID, "", "correct horse"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	client := ts.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	// Unauthenticated requests are sent to the mounted login page
+	resp, err := client.Get(ts.URL + "/email-admin/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if loc := resp.Header.Get("Location"); loc != "/email-admin/login" {
+		t.Errorf("Expected redirect to /email-admin/login, got %q", loc)
+	}
+
+	// Routes aren't served at the root
+	resp, err = client.Get(ts.URL + "/login")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for a root route, got %d", resp.StatusCode)
+	}
+
+	form := url.Values{"email": {"alice@example.com"}, "password": {"correct horse"}}
+	resp, err = client.PostForm(ts.URL+"/email-admin/login", form)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	resp.Body.Close()
+	if loc := resp.Header.Get("Location"); loc != "/email-admin/" {
+		t.Errorf("Expected login to redirect to /email-admin/, got %q", loc)
+	}
+	var session *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "session" {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("Expected a session cookie")
+	}
+	if session.Path != "/email-admin/" {
+		t.Errorf("Expected session cookie path /email-admin/, got %q", session.Path)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/email-admin/", nil)
+	req.AddCookie(session)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected mappings page, got %d", resp.StatusCode)
+	}
+	var body strings.Builder
+	if _, err := io.Copy(&body, resp.Body); err != nil {
+		t.Fatalf("Failed to read page: %v", err)
+	}
+	for _, link := range []string{`href="/email-admin/logs"`, `href="/email-admin/logout"`, `hx-get="/email-admin/admin/mappings/add-form?`} {
+		if !strings.Contains(body.String(), link) {
+			t.Errorf("Expected page to link %s", link)
+		}
+	}
+}
+
+func TestHandleAPIMappings_SigningSecretIsWriteOnly(t *testing.T) {
+	s := newTestServer(t)
+
+	form := url.Values{
+		"endpoint_url":   {"https://hooks.example.com/email"},
+		"signing_secret": {"s3cret-value"},
+		"token":          {s.sessions.GenerateCSRFToken()},
+	}
+	req := httptest.NewRequest("POST", "/api/mappings", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleAPIMappings(rec, asAdmin(req))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var mapping database.EmailMapping
+	if err := s.db.Where("user_id = ?", 1).First(&mapping).Error; err != nil {
+		t.Fatalf("Failed to load mapping: %v", err)
+	}
+	if mapping.SigningSecret != "s3cret-value" {
+		t.Errorf("Expected the signing secret to be saved, got %q", mapping.SigningSecret)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleMappings(rec, asAdmin(httptest.NewRequest("GET", "/", nil)))
+	if !strings.Contains(rec.Body.String(), mapping.GeneratedEmail) {
+		t.Fatalf("Expected the mapping to be listed, got %s", rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "s3cret-value") {
+		t.Error("Expected the signing secret not to be rendered")
+	}
+}
+
+func TestHandleAPIMappings_SavesBatchOptions(t *testing.T) {
+	s := newTestServer(t)
+
+	form := url.Values{
+		"endpoint_url":    {"https://hooks.example.com/email"},
+		"batch_size":      {"10"},
+		"batch_window":    {"30"},
+		"batch_max_bytes": {"65536"},
+		"token":           {s.sessions.GenerateCSRFToken()},
+	}
+	req := httptest.NewRequest("POST", "/api/mappings", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleAPIMappings(rec, asAdmin(req))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var mapping database.EmailMapping
+	if err := s.db.Where("user_id = ?", 1).First(&mapping).Error; err != nil {
+		t.Fatalf("Failed to load mapping: %v", err)
+	}
+	if mapping.BatchSize != 10 || mapping.BatchWindow != 30 || mapping.BatchMaxBytes != 65536 {
+		t.Errorf("Expected batch size 10, window 30 and max bytes 65536, got %d, %d and %d",
+			mapping.BatchSize, mapping.BatchWindow, mapping.BatchMaxBytes)
+	}
+}
+
+func TestHandleAPIMappings_SavesFieldNameMap(t *testing.T) {
+	s := newTestServer(t)
+
+	form := url.Values{
+		"endpoint_url":   {"https://hooks.example.com/email"},
+		"field_naming":   {"camelCase"},
+		"field_name_map": {"from=sender, subject = title, invalid"},
+		"token":          {s.sessions.GenerateCSRFToken()},
+	}
+	req := httptest.NewRequest("POST", "/api/mappings", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleAPIMappings(rec, asAdmin(req))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var mapping database.EmailMapping
+	if err := s.db.Where("user_id = ?", 1).First(&mapping).Error; err != nil {
+		t.Fatalf("Failed to load mapping: %v", err)
+	}
+	want := map[string]string{"from": "sender", "subject": "title"}
+	if !reflect.DeepEqual(mapping.FieldNameMap, want) {
+		t.Errorf("Expected field name map %v, got %v", want, mapping.FieldNameMap)
+	}
+}
+
+func TestHandleAPIMappings_RejectsInvalidBodyTemplate(t *testing.T) {
+	s := newTestServer(t)
+
+	form := url.Values{
+		"endpoint_url":  {"https://hooks.example.com/email"},
+		"body_template": {`{"title": {{json .Subject}`},
+		"token":         {s.sessions.GenerateCSRFToken()},
+	}
+	req := httptest.NewRequest("POST", "/api/mappings", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleAPIMappings(rec, asAdmin(req))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid body template") {
+		t.Fatalf("Expected 400 for an invalid body template, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var count int64
+	if err := s.db.Model(&database.EmailMapping{}).Count(&count).Error; err != nil {
+		t.Fatalf("Failed to count mappings: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected no mapping to be created, got %d", count)
+	}
+}
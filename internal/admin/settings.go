@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// SettingsData represents the data for the runtime settings page
+type SettingsData struct {
+	Settings    database.RuntimeSettings
+	Error       string
+	Success     string
+	CurrentPage string
+	UserRole    string
+	UserEmail   string
+}
+
+// handleSettings shows and updates the runtime settings
+func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	data := SettingsData{
+		CurrentPage: "settings",
+		UserRole:    r.Context().Value(userRoleKey).(string),
+		UserEmail:   r.Context().Value("userEmail").(string),
+	}
+
+	if r.Method == "POST" {
+		settings, err := settingsFromForm(r)
+		if err == nil {
+			err = s.db.SaveRuntimeSettings(settings)
+		}
+		if err != nil {
+			log.Printf("Failed to save runtime settings: %v", err)
+			data.Error = fmt.Sprintf("Failed to save settings: %v", err)
+			data.Settings = settings
+			s.templates(r).ExecuteTemplate(w, "layout.html", data)
+			return
+		}
+		log.Printf("Runtime settings updated: %+v", settings)
+		data.Success = "Settings saved"
+	}
+
+	settings, err := s.db.GetRuntimeSettings()
+	if err != nil {
+		log.Printf("Failed to fetch runtime settings: %v", err)
+		data.Error = fmt.Sprintf("Failed to fetch settings: %v", err)
+	} else {
+		data.Settings = settings
+	}
+
+	s.templates(r).ExecuteTemplate(w, "layout.html", data)
+}
+
+// settingsFromForm reads the runtime settings from the settings form; empty
+// numbers mean the configuration file's value applies
+func settingsFromForm(r *http.Request) (database.RuntimeSettings, error) {
+	settings := database.RuntimeSettings{
+		MaintenanceMode: r.FormValue("maintenance_mode") == "true",
+	}
+	if v := r.FormValue("max_email_size"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return settings, fmt.Errorf("invalid max email size %q", v)
+		}
+		settings.MaxEmailSize = size
+	}
+	if v := r.FormValue("retry_attempts"); v != "" {
+		attempts, err := strconv.Atoi(v)
+		if err != nil {
+			return settings, fmt.Errorf("invalid retry attempts %q", v)
+		}
+		settings.RetryAttempts = attempts
+	}
+	return settings, nil
+}
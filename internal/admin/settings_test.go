@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandleSettings_Save(t *testing.T) {
+	s := newTestServer(t)
+
+	form := url.Values{
+		"max_email_size":   {"2048"},
+		"retry_attempts":   {"5"},
+		"maintenance_mode": {"true"},
+	}
+	req := httptest.NewRequest("POST", "/settings", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleSettings(rec, asAdmin(req))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "Settings saved") {
+		t.Errorf("Expected success message, got %s", rec.Body.String())
+	}
+
+	settings, err := s.db.GetRuntimeSettings()
+	if err != nil {
+		t.Fatalf("Failed to get settings: %v", err)
+	}
+	if settings.MaxEmailSize != 2048 || settings.RetryAttempts != 5 || !settings.MaintenanceMode {
+		t.Errorf("Unexpected settings saved: %+v", settings)
+	}
+}
+
+func TestHandleSettings_RejectsNegative(t *testing.T) {
+	s := newTestServer(t)
+
+	form := url.Values{"max_email_size": {"-1"}}
+	req := httptest.NewRequest("POST", "/settings", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleSettings(rec, asAdmin(req))
+
+	if !strings.Contains(rec.Body.String(), "cannot be negative") {
+		t.Errorf("Expected validation error, got %s", rec.Body.String())
+	}
+	settings, err := s.db.GetRuntimeSettings()
+	if err != nil {
+		t.Fatalf("Failed to get settings: %v", err)
+	}
+	if settings.MaxEmailSize != 0 {
+		t.Errorf("Expected nothing saved, got %+v", settings)
+	}
+}
@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+)
+
+// SignupConfig controls public self-service signup
+type SignupConfig struct {
+	Enabled     bool
+	DefaultRole string // Role given to users who sign up; defaults to "user"
+}
+
+// SignupData represents the data for the signup page
+type SignupData struct {
+	Error   string
+	Success string
+	Token   string
+}
+
+// validateSignupRole checks the role given to users who sign up. Signup is
+// open to anyone, so it must not hand out admin accounts.
+func validateSignupRole(role string) error {
+	switch role {
+	case "", "user":
+		return nil
+	case "admin":
+		return fmt.Errorf("invalid signup default role %q: anyone could sign up as an admin", role)
+	}
+	return fmt.Errorf("invalid signup default role %q: must be user", role)
+}
+
+// handleSignup lets visitors create their own account when signup is
+// enabled, and 404s otherwise. The user is created without a password and
+// sent the usual registration link, so the account stays pending until the
+// address has been verified.
+func (s *Server) handleSignup(w http.ResponseWriter, r *http.Request) {
+	if !s.signup.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := SignupData{Token: s.sessions.GenerateCSRFToken()}
+	if r.Method == "GET" {
+		s.templates(r).ExecuteTemplate(w, "signup.html", data)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Validate CSRF token, so other sites can't submit signups from their
+	// visitors' browsers
+	if !s.sessions.ValidateCSRFToken(r.FormValue("token")) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	if s.emailer == nil {
+		data.Error = "Signup is unavailable because email sending is not configured"
+		s.templates(r).ExecuteTemplate(w, "signup.html", data)
+		return
+	}
+
+	address, err := mail.ParseAddress(r.FormValue("email"))
+	if err != nil {
+		data.Error = "Please enter a valid email address"
+		s.templates(r).ExecuteTemplate(w, "signup.html", data)
+		return
+	}
+
+	role := s.signup.DefaultRole
+	if role == "" {
+		role = "user"
+	}
+
+	// CreateUser returns the existing user if there is one
+	user, err := s.db.CreateUser(address.Address, role)
+	if err != nil {
+		log.Printf("Failed to create signup user %s: %v", address.Address, err)
+		data.Error = "Signup failed, please try again later"
+		s.templates(r).ExecuteTemplate(w, "signup.html", data)
+		return
+	}
+
+	// Only pending accounts get a registration link, and only when they don't
+	// have a valid one yet, so signup can't be used to flood an inbox. The
+	// response is the same either way so signup can't be used to discover
+	// who has an account.
+	pending, err := s.db.HasValidRegistrationToken(user.ID)
+	if err != nil {
+		log.Printf("Failed to check registration tokens for %s: %v", user.Email, err)
+		data.Error = "Signup failed, please try again later"
+		s.templates(r).ExecuteTemplate(w, "signup.html", data)
+		return
+	}
+	if pending {
+		log.Printf("Not resending the registration link to %s: the last one is still valid", user.Email)
+	}
+	if user.PasswordHash == "" && user.IsActive && !pending {
+		regToken, err := s.db.CreateRegistrationToken(user.ID)
+		if err != nil {
+			log.Printf("Failed to create registration token for %s: %v", user.Email, err)
+			data.Error = "Signup failed, please try again later"
+			s.templates(r).ExecuteTemplate(w, "signup.html", data)
+			return
+		}
+		if err := s.emailer.SendRegistrationEmail(user.Email, regToken.Token); err != nil {
+			log.Printf("Failed to send registration email to %s: %v", user.Email, err)
+			data.Error = "Failed to send the verification email, please try again later"
+			s.templates(r).ExecuteTemplate(w, "signup.html", data)
+			return
+		}
+		log.Printf("Self-service signup started for %s", user.Email)
+	}
+
+	data.Success = "Check your inbox for a link to verify your address and set your password."
+	s.templates(r).ExecuteTemplate(w, "signup.html", data)
+}
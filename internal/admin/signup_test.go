@@ -0,0 +1,133 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postSignup submits the signup form
+func postSignup(s *Server, address string) *httptest.ResponseRecorder {
+	form := url.Values{"email": {address}, "token": {s.sessions.GenerateCSRFToken()}}
+	req := httptest.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.SignupRateLimit(s.handleSignup)(rec, req)
+	return rec
+}
+
+func TestHandleSignup_CreatesPendingUser(t *testing.T) {
+	s := newTestServer(t)
+	s.signup = SignupConfig{Enabled: true, DefaultRole: "user"}
+	notifier := &fakeNotifier{}
+	s.emailer = notifier
+
+	rec := postSignup(s, "New.User@example.com")
+	if !strings.Contains(rec.Body.String(), "Check your inbox") {
+		t.Fatalf("Expected verification notice, got %s", rec.Body.String())
+	}
+
+	user, err := s.db.GetUserByEmail("new.user@example.com")
+	if err != nil || user == nil {
+		t.Fatalf("Expected user to be created, got %v (err %v)", user, err)
+	}
+	if user.Role != "user" {
+		t.Errorf("Expected default role user, got %q", user.Role)
+	}
+	if user.PasswordHash != "" {
+		t.Error("Expected user to stay pending until the address is verified")
+	}
+	if notifier.to != "new.user@example.com" || notifier.token == "" {
+		t.Errorf("Expected registration link sent to new.user@example.com, got %q (token %q)", notifier.to, notifier.token)
+	}
+	if valid, err := s.db.ValidateRegistrationToken(notifier.token); err != nil || !valid {
+		t.Errorf("Expected a valid registration token, got %v (err %v)", valid, err)
+	}
+}
+
+func TestHandleSignup_DisabledNotFound(t *testing.T) {
+	s := newTestServer(t)
+	s.emailer = &fakeNotifier{}
+
+	for _, method := range []string{"GET", "POST"} {
+		rec := httptest.NewRecorder()
+		s.handleSignup(rec, httptest.NewRequest(method, "/signup", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s with signup disabled, got %d", method, rec.Code)
+		}
+	}
+
+	if user, _ := s.db.GetUserByEmail("new.user@example.com"); user != nil {
+		t.Error("Expected no user to be created")
+	}
+}
+
+func TestHandleSignup_DoesNotResendValidLink(t *testing.T) {
+	s := newTestServer(t)
+	s.signup = SignupConfig{Enabled: true, DefaultRole: "user"}
+	notifier := &fakeNotifier{}
+	s.emailer = notifier
+
+	postSignup(s, "pending@example.com")
+	first := notifier.token
+	notifier.to, notifier.token = "", ""
+
+	rec := postSignup(s, "pending@example.com")
+	if !strings.Contains(rec.Body.String(), "Check your inbox") {
+		t.Fatalf("Expected the same verification notice, got %s", rec.Body.String())
+	}
+	if first == "" || notifier.to != "" {
+		t.Errorf("Expected one registration link while it is valid, resent to %q", notifier.to)
+	}
+}
+
+func TestHandleSignup_RequiresCSRFToken(t *testing.T) {
+	s := newTestServer(t)
+	s.signup = SignupConfig{Enabled: true, DefaultRole: "user"}
+	notifier := &fakeNotifier{}
+	s.emailer = notifier
+
+	form := url.Values{"email": {"victim@example.com"}}
+	req := httptest.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.handleSignup(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without a CSRF token, got %d", rec.Code)
+	}
+	if notifier.to != "" {
+		t.Errorf("Expected no email to be sent, sent to %q", notifier.to)
+	}
+}
+
+func TestHandleSignup_RateLimited(t *testing.T) {
+	s := newTestServer(t)
+	s.signup = SignupConfig{Enabled: true, DefaultRole: "user"}
+	s.emailer = &fakeNotifier{}
+	s.signupLimiter = newRateLimiter(2, time.Hour)
+
+	for i, address := range []string{"a@example.com", "b@example.com"} {
+		if rec := postSignup(s, address); rec.Code != http.StatusOK {
+			t.Fatalf("Signup %d: expected 200, got %d", i+1, rec.Code)
+		}
+	}
+	rec := postSignup(s, "c@example.com")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After once the limit is reached, got %d", rec.Code)
+	}
+	if user, _ := s.db.GetUserByEmail("c@example.com"); user != nil {
+		t.Error("Expected no user to be created over the limit")
+	}
+}
+
+func TestValidateSignupRole(t *testing.T) {
+	for role, wantErr := range map[string]bool{"": false, "user": false, "admin": true, "owner": true} {
+		if err := validateSignupRole(role); (err != nil) != wantErr {
+			t.Errorf("validateSignupRole(%q) error = %v, want error %v", role, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogsTemplate_RendersConfiguredTimezone(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+	tmpl, err := parseTemplates(location, "", "", "")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v", err)
+	}
+
+	data := LogData{
+		CurrentPage: "logs",
+		Logs: []LogEntry{{
+			Subject:     "hello",
+			Status:      "success",
+			ProcessedAt: time.Date(2024, 7, 1, 16, 30, 0, 0, time.UTC),
+		}},
+	}
+
+	var out strings.Builder
+	if err := tmpl.ExecuteTemplate(&out, "layout.html", data); err != nil {
+		t.Fatalf("Failed to render logs: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "2024-07-01 12:30:00 EDT") {
+		t.Errorf("Expected log time in America/New_York, got:\n%s", out.String())
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	tmpl, err := template.New("test").Funcs(templateFuncs(time.UTC, "", "")).Parse(
+		`{{statusBadge .Status}}|{{.Error | truncate 5}}|{{json .Headers}}|{{formatTime .At}}`)
+	if err != nil {
+		t.Fatalf("Failed to parse template: %v", err)
+	}
+
+	var out strings.Builder
+	err = tmpl.Execute(&out, map[string]interface{}{
+		"Status":  "filtered",
+		"Error":   "connection refused",
+		"Headers": map[string]string{"X-Key": "b"},
+		"At":      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+
+	parts := strings.Split(out.String(), "|")
+	if len(parts) != 4 {
+		t.Fatalf("Unexpected output: %s", out.String())
+	}
+	if !strings.Contains(parts[0], "bg-yellow-100") || !strings.Contains(parts[0], ">filtered</span>") {
+		t.Errorf("Expected filtered badge, got %s", parts[0])
+	}
+	if parts[1] != "conne…" {
+		t.Errorf("Expected truncated error, got %q", parts[1])
+	}
+	// html/template escapes the JSON text for the HTML context
+	if parts[2] != `{&#34;X-Key&#34;:&#34;b&#34;}` {
+		t.Errorf("Unexpected json output %q", parts[2])
+	}
+	if parts[3] != "2024-01-02 03:04:05 UTC" {
+		t.Errorf("Unexpected formatted time %q", parts[3])
+	}
+}
+
+func TestParseTemplates_OverrideDir(t *testing.T) {
+	dir := t.TempDir()
+	override := `<html><body>Acme Corp sign-in{{if .Error}} {{.Error}}{{end}}</body></html>`
+	if err := os.WriteFile(filepath.Join(dir, "login.html"), []byte(override), 0o644); err != nil {
+		t.Fatalf("Failed to write override: %v", err)
+	}
+
+	tmpl, err := parseTemplates(time.UTC, "", dir, "")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v", err)
+	}
+	var out strings.Builder
+	if err := tmpl.ExecuteTemplate(&out, "login.html", map[string]interface{}{"Error": "bad password"}); err != nil {
+		t.Fatalf("Failed to render login: %v", err)
+	}
+	if out.String() != "<html><body>Acme Corp sign-in bad password</body></html>" {
+		t.Errorf("Expected the override login template, got:\n%s", out.String())
+	}
+	// Templates without an override keep the embedded version
+	if tmpl.Lookup("register.html") == nil {
+		t.Error("Expected embedded register template to remain")
+	}
+
+	// A template that fails to parse falls back to the embedded set
+	if err := os.WriteFile(filepath.Join(dir, "login.html"), []byte("{{if}"), 0o644); err != nil {
+		t.Fatalf("Failed to write override: %v", err)
+	}
+	tmpl, err = parseTemplates(time.UTC, "", dir, "")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v", err)
+	}
+	out.Reset()
+	if err := tmpl.ExecuteTemplate(&out, "login.html", map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to render login: %v", err)
+	}
+	if strings.Contains(out.String(), "Acme Corp") || !strings.Contains(out.String(), "Login") {
+		t.Errorf("Expected the embedded login template after a broken override, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// handleVerifySignature is a handler for the POST
+// /api/mappings/verify-signature endpoint. It sends a test payload signed
+// with the mapping's secret to the mapping's endpoint and returns whether the
+// endpoint reported verifying the signature.
+func (s *Server) handleVerifySignature(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value(userIDKey).(uint)
+	userRole := r.Context().Value(userRoleKey).(string)
+
+	// Validate CSRF token
+	if !s.sessions.ValidateCSRFToken(r.FormValue("token")) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	emailAddress := r.FormValue("email")
+	mapping, err := s.db.GetMappingByEmail(emailAddress)
+	if err != nil || (mapping.UserID != userID && userRole != "admin") {
+		http.Error(w, "Mapping not found", http.StatusNotFound)
+		return
+	}
+	if mapping.SigningSecret == "" {
+		http.Error(w, "Mapping has no signing secret", http.StatusBadRequest)
+		return
+	}
+
+	check, err := s.previewer.CheckSignature(mapping)
+	if err != nil {
+		log.Printf("Error checking signature setup for %s: %v", emailAddress, err)
+		http.Error(w, fmt.Sprintf("Failed to check signature: %v", err), http.StatusBadGateway)
+		return
+	}
+	log.Printf("User %d checked signature setup for %s: %s", userID, emailAddress, check.Result)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(check)
+}
@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/email"
+)
+
+// verifyingEndpoint checks X-Signature with secret the way a consumer should
+// and echoes the result
+func verifyingEndpoint(t *testing.T, secret string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, signature, _ := strings.Cut(strings.TrimPrefix(r.Header.Get("X-Signature"), "t="), ",v1=")
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "." + string(body)))
+		verified := hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil))))
+		if !verified {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		fmt.Fprintf(w, `{"verified": %t}`, verified)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestHandleVerifySignature(t *testing.T) {
+	s := newTestServer(t)
+	endpoint := verifyingEndpoint(t, "s3cret")
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer plain.Close()
+
+	newMapping := func(endpoint, secret string) string {
+		mapping, err := s.db.CreateEmailMapping(1, endpoint, "signed", nil)
+		if err != nil {
+			t.Fatalf("Failed to create mapping: %v", err)
+		}
+		if secret != "" {
+			if err := s.db.SetMappingSigningSecret(mapping.GeneratedEmail, 1, secret); err != nil {
+				t.Fatalf("Failed to set signing secret: %v", err)
+			}
+		}
+		return mapping.GeneratedEmail
+	}
+	check := func(address, token string) *httptest.ResponseRecorder {
+		form := url.Values{"email": {address}, "token": {token}}
+		req := httptest.NewRequest("POST", "/api/mappings/verify-signature", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.handleVerifySignature(rec, asAdmin(req))
+		return rec
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		want    string
+	}{
+		{"matching secret", newMapping(endpoint.URL, "s3cret"), email.SignatureCheckPass},
+		{"different secret", newMapping(endpoint.URL, "other"), email.SignatureCheckFail},
+		{"no verification result", newMapping(plain.URL, "s3cret"), email.SignatureCheckUnparseable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := check(tt.address, s.sessions.GenerateCSRFToken())
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			var result email.SignatureCheck
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("Failed to decode result: %v", err)
+			}
+			if result.Result != tt.want {
+				t.Errorf("Expected result %q, got %+v", tt.want, result)
+			}
+		})
+	}
+
+	if rec := check(newMapping(endpoint.URL, ""), s.sessions.GenerateCSRFToken()); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a mapping without a secret, got %d", rec.Code)
+	}
+	if rec := check(newMapping(endpoint.URL, "s3cret"), "bogus"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without a CSRF token, got %d", rec.Code)
+	}
+}
@@ -9,6 +9,10 @@ import (
 
 // Config holds all configuration for the application
 type Config struct {
+	// InstanceLabel identifies this deployment (e.g. "staging") and is sent
+	// as "origin" in forwarded payloads
+	InstanceLabel string
+
 	// Database Configuration
 	Database struct {
 		Driver   string
@@ -19,12 +23,68 @@ type Config struct {
 		Password string // For PostgreSQL
 		Name     string // For PostgreSQL
 		SSLMode  string // For PostgreSQL
+		// ConnectAttempts is how often startup tries to reach the database
+		ConnectAttempts int
+		// ConnectBackoff is the first wait between attempts in seconds; it
+		// doubles after each failure
+		ConnectBackoff int
 	}
 
 	// Admin Server Configuration
 	AdminServer struct {
-		Host string
-		Port int
+		Host     string
+		Port     int
+		Timezone string // IANA zone used to display timestamps, e.g. "Europe/Berlin"
+		CORS     struct {
+			AllowedOrigins []string
+			AllowedMethods []string
+			AllowedHeaders []string
+		}
+		// OIDC enables single sign-on alongside password login when
+		// Issuer and ClientID are set
+		OIDC struct {
+			Issuer       string
+			ClientID     string
+			ClientSecret string
+			RedirectURL  string
+			DefaultRole  string
+			Scopes       []string
+		}
+		// Signup enables a public self-service signup page
+		Signup struct {
+			Enabled     bool
+			DefaultRole string
+			RateLimit   int // Signup submissions per client IP per hour; 0 disables the limit
+		}
+
+		// APIRateLimit caps rate-limited API requests per user per minute;
+		// 0 disables the limit
+		APIRateLimit int
+
+		// TemplateDir optionally holds *.html files overriding the built-in
+		// page templates of the same name
+		TemplateDir string
+
+		// DeleteGraceHours keeps deleted mappings and their logs restorable
+		// for this many hours before purging them; 0 deletes immediately
+		DeleteGraceHours int
+
+		// RecentErrorsMinutes is how far back the dashboard banner looks for
+		// failed emails on the user's mappings; 0 disables the banner
+		RecentErrorsMinutes int
+
+		// BasePath mounts the admin UI under a subpath, e.g. "/email-admin",
+		// for deployments behind a reverse proxy; empty serves it at the root
+		BasePath string
+
+		// LoginRedirects maps a role to the admin route users with that role
+		// land on after logging in, e.g. {"admin": "/users"}; unlisted roles
+		// land on "/"
+		LoginRedirects map[string]string
+
+		// AllowedEndpointHosts limits mapping endpoints to these hosts
+		// ("hooks.example.com" or "*.example.com"); empty allows any host
+		AllowedEndpointHosts []string
 	}
 
 	// Mail Server Configuration
@@ -38,6 +98,114 @@ type Config struct {
 		RetryDelay    int
 		SMTPHost      string
 		SMTPPort      int
+		Synchronous   bool
+		// RetryJitter randomizes retry delays: "none", "full" or "equal";
+		// empty adds up to 20% on top of each delay
+		RetryJitter string
+		// RetryMaxElapsed stops retrying an email this many seconds after
+		// its first attempt, whatever the attempt count; 0 means no limit
+		RetryMaxElapsed int
+		// AcceptedDomains lists the recipient domains the mail server handles;
+		// when empty only Domain is accepted
+		AcceptedDomains []string
+		// MaxInFlight caps concurrently processed emails; 0 means no limit
+		MaxInFlight int
+		// DrainTimeout is how many seconds shutdown waits for in-flight
+		// emails to be delivered before abandoning them
+		DrainTimeout int
+		// WebhookSigningKey verifies inbound webhook signatures
+		WebhookSigningKey string
+		// WebhookInsecureSkipVerify disables webhook signature verification;
+		// local development only
+		WebhookInsecureSkipVerify bool
+		// QueueAlertThreshold is the pending-delivery count that triggers an
+		// alert; 0 disables alerting
+		QueueAlertThreshold int
+		// QueueAlertWebhook optionally receives queue alert notifications
+		QueueAlertWebhook string
+		// QueueAlertInterval is how often queue depth is sampled, in seconds
+		QueueAlertInterval int
+		// MaxResponseBytes caps how much of an endpoint response is read
+		MaxResponseBytes int64
+		// DeadLetterMaxBytes caps the payload kept with a dead letter
+		DeadLetterMaxBytes int64
+		// MaxIdleConnsPerHost is how many keep-alive connections to each
+		// endpoint host are kept open between requests
+		MaxIdleConnsPerHost int
+		// IdleConnTimeout closes idle endpoint connections after this many seconds
+		IdleConnTimeout int
+		// SpoolDir buffers emails on disk while the database is unavailable
+		SpoolDir string
+		// SpoolReplayInterval is how often spooled emails are retried, in seconds
+		SpoolReplayInterval int
+		// SynthesizeHeaders generates a missing Message-ID or Date header
+		SynthesizeHeaders bool
+		// LookupError handles mapping lookup errors without a spool:
+		// "" drops the email, "retry" retries the lookup with backoff
+		LookupError string
+		// InvalidSender handles empty or malformed envelope senders:
+		// "" (accept), "reject" (550 at MAIL FROM) or "drop"
+		InvalidSender string
+		// MaxLineLength rejects messages with longer lines, in bytes
+		// excluding CRLF; 0 disables the check
+		MaxLineLength int
+		// MaxConnections caps concurrent SMTP connections; 0 means no limit
+		MaxConnections int
+		// RejectInactive answers 550 at RCPT for recipients whose mapping
+		// is inactive instead of accepting and dropping the mail
+		RejectInactive bool
+		// UnknownRecipient handles email for addresses without a mapping:
+		// "" (drop), "reject" (550 at RCPT) or "bounce" (drop and reply to
+		// the sender through Mailgun). BounceSubject and BounceTemplate are
+		// text/templates over .From, .To and .Subject; empty uses defaults.
+		UnknownRecipient string
+		BounceSubject    string
+		BounceTemplate   string
+		// MaxTags caps the tags taken from an email's subject and
+		// MaxTagLength their length; 0 means no limit
+		MaxTags      int
+		MaxTagLength int
+		// IncludeTLSInfo adds the receiving session's TLS status, version
+		// and cipher to the payload
+		IncludeTLSInfo bool
+		// MetricsAddr serves Prometheus metrics at /metrics; empty disables
+		MetricsAddr string
+		// MetricsPerMappingLabels labels metrics by mapping and endpoint;
+		// disable for large deployments to bound cardinality
+		MetricsPerMappingLabels bool
+		// TLS enables STARTTLS on the SMTP server
+		TLS struct {
+			CertFile            string
+			KeyFile             string
+			ClientCAFile        string
+			MapClientCertToUser bool
+			MinVersion          string
+			CipherSuites        []string
+			RequireForAuth      bool // Refuse AUTH before STARTTLS
+		}
+	}
+
+	// Vanity Address Configuration
+	Vanity struct {
+		Enabled      bool
+		MinLength    int
+		Blocklist    []string
+		BlockPattern string
+	}
+
+	// Attachment Configuration: attachments larger than InlineMaxBytes are
+	// uploaded to S3-compatible storage when a bucket is configured
+	Attachments struct {
+		InlineMaxBytes int
+		URLExpiry      int // Lifetime of presigned URLs in hours
+		S3             struct {
+			Endpoint  string
+			Region    string
+			Bucket    string
+			AccessKey string
+			SecretKey string
+			PathStyle bool
+		}
 	}
 
 	// Mailgun Configuration (optional)
@@ -46,11 +214,25 @@ type Config struct {
 		Domain      string
 		FromAddress string
 		SiteDomain  string
+		// Validate checks the API key at startup: "async" (warn on
+		// failure), "sync" (fail startup) or "off"
+		Validate string
+		// ValidateTimeout bounds the credential check, in seconds
+		ValidateTimeout int
 	}
 }
 
-// LoadConfig loads the configuration from environment variables and config files
+// LoadConfig loads the configuration from environment variables and config files.
+// The profile is taken from EMAILTOAPI_PROFILE or the top-level "profile" key.
 func LoadConfig() (*Config, error) {
+	return LoadConfigProfile("")
+}
+
+// LoadConfigProfile loads the configuration, applying the overrides under
+// profiles.<profile> on top of the top-level values. An empty profile falls
+// back to EMAILTOAPI_PROFILE or the "profile" key; if none is set, only the
+// top-level configuration is used.
+func LoadConfigProfile(profile string) (*Config, error) {
 	v := viper.New()
 
 	// Set default values
@@ -79,6 +261,20 @@ func LoadConfig() (*Config, error) {
 	// Map legacy env vars for backward compatibility
 	mapLegacyEnvVars(v)
 
+	// Apply profile overrides; environment variables still take precedence
+	if profile == "" {
+		profile = v.GetString("profile")
+	}
+	if profile != "" {
+		overrides := v.Sub("profiles." + profile)
+		if overrides == nil {
+			return nil, fmt.Errorf("unknown config profile: %s", profile)
+		}
+		if err := v.MergeConfigMap(overrides.AllSettings()); err != nil {
+			return nil, fmt.Errorf("failed to apply config profile %s: %w", profile, err)
+		}
+	}
+
 	var cfg Config
 	if err := v.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
@@ -87,7 +283,30 @@ func LoadConfig() (*Config, error) {
 	return &cfg, nil
 }
 
+// AcceptedDomains returns the recipient domains the mail server handles
+func (c *Config) AcceptedDomains() []string {
+	if len(c.MailServer.AcceptedDomains) > 0 {
+		return c.MailServer.AcceptedDomains
+	}
+	if c.MailServer.Domain != "" {
+		return []string{c.MailServer.Domain}
+	}
+	return nil
+}
+
+// WebhookSigningKey returns the key inbound webhooks are verified with.
+// Mailgun inbound routes fall back to the Mailgun API key, which Mailgun
+// signs with unless a separate webhook signing key is set up.
+func (c *Config) WebhookSigningKey() string {
+	if c.MailServer.WebhookSigningKey == "" && c.MailServer.ReceiveMethod == "mailgun-webhook" {
+		return c.Mailgun.APIKey
+	}
+	return c.MailServer.WebhookSigningKey
+}
+
 func setDefaults(v *viper.Viper) {
+	v.SetDefault("instancelabel", "")
+
 	// Database defaults
 	v.SetDefault("database.driver", "sqlite")
 	v.SetDefault("database.path", "emailtoapi.db")
@@ -96,10 +315,32 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("database.user", "postgres")
 	v.SetDefault("database.name", "emailtoapi")
 	v.SetDefault("database.sslmode", "disable")
+	v.SetDefault("database.connectattempts", 5)
+	v.SetDefault("database.connectbackoff", 1)
 
 	// Admin server defaults
 	v.SetDefault("adminserver.host", "0.0.0.0")
 	v.SetDefault("adminserver.port", 8080)
+	v.SetDefault("adminserver.timezone", "UTC")
+	v.SetDefault("adminserver.templatedir", "")
+	v.SetDefault("adminserver.deletegracehours", 0)
+	v.SetDefault("adminserver.recenterrorsminutes", 60)
+	v.SetDefault("adminserver.basepath", "")
+	v.SetDefault("adminserver.loginredirects", map[string]string{})
+	v.SetDefault("adminserver.allowedendpointhosts", []string{})
+	v.SetDefault("adminserver.apiratelimit", 60)
+	v.SetDefault("adminserver.cors.allowedorigins", []string{}) // same-origin only
+	v.SetDefault("adminserver.cors.allowedmethods", []string{"GET", "POST", "PUT", "DELETE"})
+	v.SetDefault("adminserver.cors.allowedheaders", []string{"Content-Type"})
+	v.SetDefault("adminserver.oidc.issuer", "")
+	v.SetDefault("adminserver.oidc.clientid", "")
+	v.SetDefault("adminserver.oidc.clientsecret", "")
+	v.SetDefault("adminserver.oidc.redirecturl", "")
+	v.SetDefault("adminserver.oidc.defaultrole", "user")
+	v.SetDefault("adminserver.oidc.scopes", []string{"openid", "email"})
+	v.SetDefault("adminserver.signup.enabled", false)
+	v.SetDefault("adminserver.signup.defaultrole", "user")
+	v.SetDefault("adminserver.signup.ratelimit", 5)
 
 	// Mail server defaults
 	v.SetDefault("mailserver.host", "0.0.0.0")
@@ -108,11 +349,64 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("mailserver.maxemailsize", 10*1024*1024) // 10MB
 	v.SetDefault("mailserver.maxretries", 10)
 	v.SetDefault("mailserver.retrydelay", 5)
+	v.SetDefault("mailserver.retryjitter", "")
+	v.SetDefault("mailserver.retrymaxelapsed", 0)
 	v.SetDefault("mailserver.smtphost", "0.0.0.0")
 	v.SetDefault("mailserver.smtpport", 2525)
+	v.SetDefault("mailserver.synchronous", false)
+	v.SetDefault("mailserver.maxinflight", 0)
+	v.SetDefault("mailserver.draintimeout", 30)
+	v.SetDefault("mailserver.webhooksigningkey", "")
+	v.SetDefault("mailserver.webhookinsecureskipverify", false)
+	v.SetDefault("mailserver.queuealertthreshold", 0)
+	v.SetDefault("mailserver.queuealertwebhook", "")
+	v.SetDefault("mailserver.queuealertinterval", 60)
+	v.SetDefault("mailserver.maxresponsebytes", 64*1024)     // 64KB
+	v.SetDefault("mailserver.deadlettermaxbytes", 1024*1024) // 1MB
+	v.SetDefault("mailserver.maxidleconnsperhost", 10)
+	v.SetDefault("mailserver.idleconntimeout", 90)
+	v.SetDefault("mailserver.spooldir", "")
+	v.SetDefault("mailserver.spoolreplayinterval", 30)
+	v.SetDefault("mailserver.synthesizeheaders", false)
+	v.SetDefault("mailserver.lookuperror", "")
+	v.SetDefault("mailserver.invalidsender", "")
+	v.SetDefault("mailserver.maxlinelength", 998)
+	v.SetDefault("mailserver.maxconnections", 0)
+	v.SetDefault("mailserver.rejectinactive", false)
+	v.SetDefault("mailserver.unknownrecipient", "")
+	v.SetDefault("mailserver.bouncesubject", "")
+	v.SetDefault("mailserver.bouncetemplate", "")
+	v.SetDefault("mailserver.includetlsinfo", false)
+	v.SetDefault("mailserver.maxtags", 20)
+	v.SetDefault("mailserver.maxtaglength", 64)
+	v.SetDefault("mailserver.metricsaddr", "")
+	v.SetDefault("mailserver.metricspermappinglabels", true)
+	v.SetDefault("mailserver.tls.certfile", "")
+	v.SetDefault("mailserver.tls.keyfile", "")
+	v.SetDefault("mailserver.tls.clientcafile", "")
+	v.SetDefault("mailserver.tls.mapclientcerttouser", false)
+	v.SetDefault("mailserver.tls.minversion", "1.2")
+	v.SetDefault("mailserver.tls.ciphersuites", []string{})
+	v.SetDefault("mailserver.tls.requireforauth", false)
+
+	// Attachment defaults
+	v.SetDefault("attachments.inlinemaxbytes", 256*1024) // 256KB
+	v.SetDefault("attachments.urlexpiry", 168)           // 7 days
+	v.SetDefault("attachments.s3.endpoint", "")
+	v.SetDefault("attachments.s3.region", "us-east-1")
+	v.SetDefault("attachments.s3.bucket", "")
+	v.SetDefault("attachments.s3.accesskey", "")
+	v.SetDefault("attachments.s3.secretkey", "")
+	v.SetDefault("attachments.s3.pathstyle", false)
+
+	// Vanity address defaults
+	v.SetDefault("vanity.enabled", false)
+	v.SetDefault("vanity.minlength", 6)
 
 	// Mailgun defaults
 	v.SetDefault("mailgun.site_domain", "")
+	v.SetDefault("mailgun.validate", "async")
+	v.SetDefault("mailgun.validatetimeout", 5)
 }
 
 // mapLegacyEnvVars maps old environment variable names to new configuration paths
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testConfig = `
+mailserver:
+  domain: example.com
+  smtpport: 2525
+profiles:
+  prod:
+    mailserver:
+      domain: prod.example.com
+`
+
+func writeTestConfig(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(testConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	t.Chdir(dir)
+}
+
+func TestLoadConfig_ProdProfile(t *testing.T) {
+	writeTestConfig(t)
+	t.Setenv("EMAILTOAPI_PROFILE", "prod")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.MailServer.Domain != "prod.example.com" {
+		t.Errorf("Expected prod domain override, got %q", cfg.MailServer.Domain)
+	}
+	if cfg.MailServer.SMTPPort != 2525 {
+		t.Errorf("Expected top-level smtp port to be kept, got %d", cfg.MailServer.SMTPPort)
+	}
+}
+
+func TestLoadConfig_NoProfile(t *testing.T) {
+	writeTestConfig(t)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.MailServer.Domain != "example.com" {
+		t.Errorf("Expected top-level domain, got %q", cfg.MailServer.Domain)
+	}
+}
+
+func TestLoadConfigProfile_Unknown(t *testing.T) {
+	writeTestConfig(t)
+
+	if _, err := LoadConfigProfile("staging"); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
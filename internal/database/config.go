@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // Config holds database configuration
@@ -12,6 +13,25 @@ type Config struct {
 	DSN        string
 	MigrateURL string
 	Domain     string // Domain for generated email addresses
+	// AcceptedDomains are the domains the mail server receives for; generated
+	// addresses must use one of them
+	AcceptedDomains []string
+	Vanity          VanityConfig
+	// AllowedEndpointHosts restricts mapping endpoints to these host patterns;
+	// empty allows any host
+	AllowedEndpointHosts []string
+	// DeleteGracePeriod keeps deleted mappings and their logs as tombstones
+	// for this long so they can be restored; zero deletes immediately
+	DeleteGracePeriod time.Duration
+	// ConnectAttempts is how often New pings the database before giving up;
+	// zero means a single attempt
+	ConnectAttempts int
+	// ConnectBackoff is the wait after the first failed ping; it doubles
+	// after each further failure
+	ConnectBackoff time.Duration
+	// MigrationsSource is where Migrate reads migration files from;
+	// defaults to "file://migrations"
+	MigrationsSource string
 }
 
 // LoadConfig loads database configuration from environment variables
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// copyBatchSize is how many rows are read and written at a time
+const copyBatchSize = 500
+
+// ErrTargetNotEmpty is returned by CopyData when the target already has data
+var ErrTargetNotEmpty = errors.New("target database is not empty")
+
+// CopyStats counts the rows copied by CopyData
+type CopyStats struct {
+	Users    int64
+	Mappings int64
+	Logs     int64
+}
+
+// CopyData copies users, email mappings and email logs from src into dst,
+// keeping their IDs so relationships survive. dst must already have the
+// schema and no users or mappings. Everything is copied in one transaction,
+// so a failed copy leaves dst empty.
+func CopyData(src, dst *DB) (CopyStats, error) {
+	var stats CopyStats
+
+	for _, model := range []interface{}{&User{}, &EmailMapping{}} {
+		var count int64
+		if err := dst.Unscoped().Model(model).Count(&count).Error; err != nil {
+			return stats, fmt.Errorf("failed to check target: %w", err)
+		}
+		if count > 0 {
+			return stats, ErrTargetNotEmpty
+		}
+	}
+
+	err := dst.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if stats.Users, err = copyTable[User](src.DB, tx, "users"); err != nil {
+			return err
+		}
+		if stats.Mappings, err = copyTable[EmailMapping](src.DB, tx, "email_mappings"); err != nil {
+			return err
+		}
+		if stats.Logs, err = copyTable[EmailLog](src.DB, tx, "email_logs"); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return CopyStats{}, err
+	}
+	return stats, nil
+}
+
+// copyTable copies every row of a model in primary key order, then moves
+// the table's ID sequence past the copied IDs on databases that have one
+func copyTable[T any](src, dst *gorm.DB, table string) (int64, error) {
+	var copied int64
+	var rows []T
+	// Unscoped so deleted rows awaiting purge are copied too
+	result := src.Unscoped().FindInBatches(&rows, copyBatchSize, func(batch *gorm.DB, _ int) error {
+		zeros, err := zeroDefaults(dst, rows)
+		if err != nil {
+			return fmt.Errorf("failed to inspect %s: %w", table, err)
+		}
+		// Associations are copied as their own tables
+		if err := dst.Omit(clause.Associations).Create(&rows).Error; err != nil {
+			return fmt.Errorf("failed to copy %s: %w", table, err)
+		}
+		if err := restoreZeroDefaults[T](dst, zeros); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", table, err)
+		}
+		copied += batch.RowsAffected
+		return nil
+	})
+	if result.Error != nil {
+		return copied, result.Error
+	}
+
+	if dst.Dialector.Name() == "postgres" && copied > 0 {
+		if err := dst.Exec(fmt.Sprintf(
+			"SELECT setval(pg_get_serial_sequence('%s', 'id'), (SELECT MAX(id) FROM %s))", table, table,
+		)).Error; err != nil {
+			return copied, fmt.Errorf("failed to reset %s id sequence: %w", table, err)
+		}
+	}
+	return copied, nil
+}
+
+// zeroDefaults finds the fields of rows that are zero but have a non-zero
+// default, which Create would replace with the default (such as IsActive
+// false on an inactive user). It returns the zero value and the IDs of the
+// affected rows per column.
+func zeroDefaults[T any](dst *gorm.DB, rows []T) (map[string]zeroColumn, error) {
+	stmt := &gorm.Statement{DB: dst}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	columns := make(map[string]zeroColumn)
+	for _, field := range stmt.Schema.Fields {
+		if field.DefaultValueInterface == nil || reflect.ValueOf(field.DefaultValueInterface).IsZero() {
+			continue
+		}
+		for i := range rows {
+			row := reflect.ValueOf(&rows[i]).Elem()
+			if _, isZero := field.ValueOf(ctx, row); isZero {
+				id, _ := stmt.Schema.PrioritizedPrimaryField.ValueOf(ctx, row)
+				column := columns[field.DBName]
+				column.zero = reflect.Zero(field.FieldType).Interface()
+				column.ids = append(column.ids, id)
+				columns[field.DBName] = column
+			}
+		}
+	}
+	return columns, nil
+}
+
+// zeroColumn is a column to reset to its zero value for some rows
+type zeroColumn struct {
+	zero interface{}
+	ids  []interface{}
+}
+
+// restoreZeroDefaults writes back the zero values found by zeroDefaults;
+// UpdateColumn leaves UpdatedAt as copied
+func restoreZeroDefaults[T any](dst *gorm.DB, columns map[string]zeroColumn) error {
+	for name, column := range columns {
+		if err := dst.Unscoped().Model(new(T)).Where("id IN ?", column.ids).UpdateColumn(name, column.zero).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCopyData_RoundTrip(t *testing.T) {
+	src := newTestDB(t, &Config{Domain: "example.com"})
+	dst := newTestDB(t, &Config{Domain: "example.com"})
+
+	// Leave a gap in user IDs so preserved IDs are distinguishable from
+	// freshly assigned ones
+	if _, err := src.CreateUser("deleted@example.com", "user"); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	owner, err := src.CreateUser("owner@example.com", "admin")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	disabled, err := src.CreateUser("disabled@example.com", "user")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if _, err := src.ToggleUserStatus(disabled.ID); err != nil {
+		t.Fatalf("Failed to disable user: %v", err)
+	}
+	if err := src.Delete(&User{}, 1).Error; err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	mapping, err := src.CreateEmailMapping(owner.ID, "https://example.com/hook", "orders", map[string]string{"X-Token": "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+	if err := src.UpdateMappingOptions(mapping.GeneratedEmail, owner.ID, MappingOptions{StaticTags: []string{"prod"}}); err != nil {
+		t.Fatalf("Failed to set mapping options: %v", err)
+	}
+	if err := src.LogEmailProcessing(mapping.GeneratedEmail, "hello", "success", "", nil, owner.ID, "req-1", ""); err != nil {
+		t.Fatalf("Failed to log email: %v", err)
+	}
+
+	stats, err := CopyData(src, dst)
+	if err != nil {
+		t.Fatalf("CopyData failed: %v", err)
+	}
+	if stats != (CopyStats{Users: 2, Mappings: 1, Logs: 1}) {
+		t.Errorf("Unexpected copy stats %+v", stats)
+	}
+
+	gotOwner, err := dst.GetUserByID(owner.ID)
+	if err != nil || gotOwner.Email != "owner@example.com" || gotOwner.Role != "admin" {
+		t.Errorf("Expected owner copied with ID %d, got %+v (err %v)", owner.ID, gotOwner, err)
+	}
+	var gotDisabled User
+	if err := dst.First(&gotDisabled, disabled.ID).Error; err != nil || gotDisabled.IsActive {
+		t.Errorf("Expected disabled user to stay disabled, got %+v (err %v)", gotDisabled, err)
+	}
+
+	gotMapping, err := dst.GetEmailMapping(mapping.GeneratedEmail)
+	if err != nil || gotMapping == nil {
+		t.Fatalf("Expected mapping to be copied, got %v", err)
+	}
+	if gotMapping.ID != mapping.ID || gotMapping.UserID != owner.ID || gotMapping.Headers["X-Token"] != "secret" ||
+		len(gotMapping.StaticTags) != 1 {
+		t.Errorf("Mapping not copied faithfully: %+v", gotMapping)
+	}
+
+	var logs []EmailLog
+	dst.Find(&logs)
+	if len(logs) != 1 || logs[0].MappingID != mapping.ID || logs[0].RequestID != "req-1" {
+		t.Errorf("Log not copied faithfully: %+v", logs)
+	}
+
+	// A second copy into the now populated target is refused
+	if _, err := CopyData(src, dst); !errors.Is(err, ErrTargetNotEmpty) {
+		t.Errorf("Expected ErrTargetNotEmpty, got %v", err)
+	}
+}
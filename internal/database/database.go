@@ -1,18 +1,21 @@
 package database
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
@@ -39,10 +42,19 @@ func New(config *Config) (*DB, error) {
 		return nil, fmt.Errorf("unsupported database driver: %s", config.Driver)
 	}
 
-	db, err := gorm.Open(dialector, &gorm.Config{})
+	// Ping ourselves so an unreachable database can be retried
+	db, err := gorm.Open(dialector, &gorm.Config{DisableAutomaticPing: true})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if err := pingWithRetry(sqlDB.PingContext, config.ConnectAttempts, config.ConnectBackoff); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
 
 	return &DB{
 		DB:     db,
@@ -50,15 +62,50 @@ func New(config *Config) (*DB, error) {
 	}, nil
 }
 
+// pingTimeout bounds a single connection attempt
+const pingTimeout = 5 * time.Second
+
+// pingWithRetry pings until it succeeds or attempts are used up, waiting
+// backoff after the first failure and doubling the wait after each next one
+func pingWithRetry(ping func(context.Context) error, attempts int, backoff time.Duration) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err = ping(ctx)
+		cancel()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Connected to database after %d attempts", attempt)
+			}
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+		log.Printf("Database not reachable (attempt %d/%d), retrying in %s: %v", attempt, attempts, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return fmt.Errorf("database unreachable after %d attempt(s): %w", attempts, err)
+}
+
 // Migrate runs database migrations
 func (db *DB) Migrate() error {
-	m, err := migrate.New("file://migrations", db.config.MigrateURL)
+	m, err := db.migrator()
 	if err != nil {
-		return fmt.Errorf("failed to create migrate instance: %w", err)
+		return err
 	}
 	defer m.Close()
 
 	if err := m.Up(); err != nil {
+		var dirty migrate.ErrDirty
+		if errors.As(err, &dirty) {
+			return &DirtyMigrationError{Version: dirty.Version}
+		}
 		if !errors.Is(err, migrate.ErrNoChange) {
 			return fmt.Errorf("failed to run migrations: %w", err)
 		}
@@ -68,6 +115,97 @@ func (db *DB) Migrate() error {
 	return nil
 }
 
+// DirtyMigrationError reports that a previous migration failed partway,
+// leaving the schema at an unknown state between two versions
+type DirtyMigrationError struct {
+	Version int
+}
+
+func (e *DirtyMigrationError) Error() string {
+	return fmt.Sprintf("database schema is dirty at migration version %d: a previous run of that migration failed partway. "+
+		"Check which of its changes were applied and finish or undo them by hand, then force the version that matches "+
+		"the schema (%d if the migration is now fully applied, or the previous version if it was undone) and restart",
+		e.Version, e.Version)
+}
+
+// ForceMigrationVersion records version as the current, clean migration
+// version without running any migrations. It repairs a dirty state once
+// the schema has been fixed by hand.
+func (db *DB) ForceMigrationVersion(version int) error {
+	m, err := db.migrator()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Force(version); err != nil {
+		return fmt.Errorf("failed to force migration version %d: %w", version, err)
+	}
+	log.Printf("Forced migration version to %d", version)
+	return nil
+}
+
+// MigrationStatus describes how far the schema has been migrated
+type MigrationStatus struct {
+	Version uint // Applied version, 0 if no migration has run
+	Latest  uint // Newest available migration
+	Dirty   bool // A migration failed partway at Version
+}
+
+// Pending reports whether migrations are waiting to be applied
+func (s MigrationStatus) Pending() bool {
+	return s.Version < s.Latest
+}
+
+// MigrationStatus reports the applied and newest available migration
+// versions without running any migrations
+func (db *DB) MigrationStatus() (MigrationStatus, error) {
+	m, err := db.migrator()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+	defer m.Close()
+
+	var status MigrationStatus
+	status.Version, status.Dirty, err = m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return MigrationStatus{}, fmt.Errorf("failed to get migration version: %w", err)
+	}
+
+	src, err := source.Open(db.migrationsSource())
+	if err != nil {
+		return MigrationStatus{}, fmt.Errorf("failed to open migrations: %w", err)
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	for err == nil {
+		status.Latest = version
+		version, err = src.Next(version)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return MigrationStatus{}, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	return status, nil
+}
+
+// migrationsSource returns where migration files are read from
+func (db *DB) migrationsSource() string {
+	if db.config.MigrationsSource == "" {
+		return "file://migrations"
+	}
+	return db.config.MigrationsSource
+}
+
+// migrator opens the migration files and the database to migrate
+func (db *DB) migrator() (*migrate.Migrate, error) {
+	m, err := migrate.New(db.migrationsSource(), db.config.MigrateURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+	return m, nil
+}
+
 // Close closes the database connection
 func (db *DB) Close() error {
 	sqlDB, err := db.DB.DB()
@@ -168,8 +306,37 @@ func (db *DB) SetPassword(token, password string) error {
 	})
 }
 
+// validateDomain checks that generated addresses will use a domain the mail server accepts
+func (db *DB) validateDomain() error {
+	if db.config.Domain == "" {
+		return fmt.Errorf("no domain configured for generated email addresses")
+	}
+	if len(db.config.AcceptedDomains) == 0 {
+		return nil
+	}
+	for _, domain := range db.config.AcceptedDomains {
+		if strings.EqualFold(domain, db.config.Domain) {
+			return nil
+		}
+	}
+	log.Printf("Refusing to create mapping: domain %s is not accepted by the mail server (accepted: %v)",
+		db.config.Domain, db.config.AcceptedDomains)
+	return fmt.Errorf("domain %s is not handled by the mail server", db.config.Domain)
+}
+
 // CreateEmailMapping creates a new email mapping for a user
 func (db *DB) CreateEmailMapping(userID uint, endpoint, description string, headers map[string]string) (*EmailMapping, error) {
+	if err := db.validateDomain(); err != nil {
+		return nil, err
+	}
+	endpoint, err := normalizeEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.validateEndpoint(endpoint); err != nil {
+		return nil, err
+	}
+
 	// Try up to 3 times to generate a unique email address
 	var generatedEmail string
 	for attempts := 0; attempts < 3; attempts++ {
@@ -183,7 +350,8 @@ func (db *DB) CreateEmailMapping(userID uint, endpoint, description string, head
 
 		// Check if this email already exists
 		var exists bool
-		if err := db.Model(&EmailMapping{}).Select("1").Where("generated_email = ?", generatedEmail).Scan(&exists).Error; err != nil {
+		// Unscoped: deleted mappings keep their address until purged
+		if err := db.Unscoped().Model(&EmailMapping{}).Select("1").Where("generated_email = ?", generatedEmail).Scan(&exists).Error; err != nil {
 			return nil, fmt.Errorf("failed to check email uniqueness: %w", err)
 		}
 		if !exists {
@@ -194,6 +362,11 @@ func (db *DB) CreateEmailMapping(userID uint, endpoint, description string, head
 		}
 	}
 
+	headers, err = db.withDefaultHeaders(userID, headers)
+	if err != nil {
+		return nil, err
+	}
+
 	mapping := &EmailMapping{
 		UserID:         userID,
 		GeneratedEmail: generatedEmail,
@@ -223,13 +396,42 @@ func (db *DB) GetEmailMapping(emailAddress string) (*EmailMapping, error) {
 	return &mapping, nil
 }
 
+// IsMappingInactive reports whether a mapping exists for the address but
+// has been deactivated
+func (db *DB) IsMappingInactive(emailAddress string) (bool, error) {
+	var count int64
+	err := db.Model(&EmailMapping{}).Where("generated_email = ? AND is_active = ?", emailAddress, false).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check email mapping: %w", err)
+	}
+	return count > 0, nil
+}
+
+// HasEmailMapping reports whether a mapping exists for the address, active
+// or not
+func (db *DB) HasEmailMapping(emailAddress string) (bool, error) {
+	var count int64
+	err := db.Model(&EmailMapping{}).Where("generated_email = ?", emailAddress).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check email mapping: %w", err)
+	}
+	return count > 0, nil
+}
+
 // LogEmailProcessing logs the email processing attempt
-func (db *DB) LogEmailProcessing(emailAddress, subject, status, errorMsg string, headers map[string]string, userID uint) error {
+func (db *DB) LogEmailProcessing(emailAddress, subject, status, errorMsg string, headers map[string]string, userID uint, requestID, clientIP string) error {
 	var mapping EmailMapping
 	if err := db.Where("generated_email = ? AND user_id = ?", emailAddress, userID).First(&mapping).Error; err != nil {
 		return fmt.Errorf("failed to get mapping: %w", err)
 	}
+	if !mapping.ShouldLog(status) {
+		return nil
+	}
 
+	// Drop paths pass nil headers; store those as {} rather than null
+	if headers == nil {
+		headers = map[string]string{}
+	}
 	headersJSON, err := json.Marshal(headers)
 	if err != nil {
 		return fmt.Errorf("failed to marshal headers: %w", err)
@@ -238,10 +440,12 @@ func (db *DB) LogEmailProcessing(emailAddress, subject, status, errorMsg string,
 	log := &EmailLog{
 		MappingID:    mapping.ID,
 		FromAddress:  emailAddress,
-		Subject:      subject,
+		Subject:      displaySubject(subject),
 		Status:       status,
 		ErrorMessage: errorMsg,
 		Headers:      string(headersJSON),
+		RequestID:    requestID,
+		ClientIP:     clientIP,
 	}
 
 	if err := db.Create(log).Error; err != nil {
@@ -251,14 +455,32 @@ func (db *DB) LogEmailProcessing(emailAddress, subject, status, errorMsg string,
 	return nil
 }
 
+// NoSubject is recorded in place of an empty or missing subject
+const NoSubject = "(no subject)"
+
+// displaySubject returns subject, or NoSubject if it is blank
+func displaySubject(subject string) string {
+	if strings.TrimSpace(subject) == "" {
+		return NoSubject
+	}
+	return subject
+}
+
 // UpdateEmailMapping updates an existing email-to-API mapping
 func (db *DB) UpdateEmailMapping(emailAddress string, endpointURL string, headers map[string]string, userID uint) error {
+	endpointURL, err := normalizeEndpoint(endpointURL)
+	if err != nil {
+		return err
+	}
+	if err := db.validateEndpoint(endpointURL); err != nil {
+		return err
+	}
+
+	// Update through the struct so headers go through their JSON serializer
 	result := db.Model(&EmailMapping{}).
 		Where("generated_email = ? AND user_id = ?", emailAddress, userID).
-		Updates(map[string]interface{}{
-			"endpoint_url": endpointURL,
-			"headers":      headers,
-		})
+		Select("endpoint_url", "headers").
+		Updates(&EmailMapping{EndpointURL: endpointURL, Headers: headers})
 
 	if result.Error != nil {
 		return fmt.Errorf("failed to update email mapping: %w", result.Error)
@@ -271,6 +493,50 @@ func (db *DB) UpdateEmailMapping(emailAddress string, endpointURL string, header
 	return nil
 }
 
+// UpdateMappingOptions replaces the optional processing settings of a mapping
+func (db *DB) UpdateMappingOptions(emailAddress string, userID uint, opts MappingOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if opts.BounceEndpoint != "" {
+		endpoint, err := normalizeEndpoint(opts.BounceEndpoint)
+		if err != nil {
+			return fmt.Errorf("invalid bounce endpoint: %w", err)
+		}
+		if err := db.validateEndpoint(endpoint); err != nil {
+			return fmt.Errorf("invalid bounce endpoint: %w", err)
+		}
+		opts.BounceEndpoint = endpoint
+	}
+
+	var mapping EmailMapping
+	if err := db.Where("generated_email = ? AND user_id = ?", emailAddress, userID).First(&mapping).Error; err != nil {
+		return fmt.Errorf("failed to get mapping: %w", err)
+	}
+
+	mapping.MappingOptions = opts
+	if err := db.Save(&mapping).Error; err != nil {
+		return fmt.Errorf("failed to update mapping options: %w", err)
+	}
+
+	return nil
+}
+
+// SetMappingSigningSecret sets the secret the mapping's payloads are signed
+// with; an empty secret turns signing off
+func (db *DB) SetMappingSigningSecret(emailAddress string, userID uint, secret string) error {
+	result := db.Model(&EmailMapping{}).
+		Where("generated_email = ? AND user_id = ?", emailAddress, userID).
+		Update("signing_secret", secret)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update signing secret: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no mapping found for email: %s", emailAddress)
+	}
+	return nil
+}
+
 // DeleteEmailMapping permanently deletes an email mapping and its associated logs
 func (db *DB) DeleteEmailMapping(emailAddress string, userID uint) error {
 	log.Printf("Attempting to delete email mapping for %s (userID: %d)", emailAddress, userID)
@@ -288,6 +554,10 @@ func (db *DB) DeleteEmailMapping(emailAddress string, userID uint) error {
 	
 	log.Printf("Found mapping ID %d for email %s (userID: %d)", mapping.ID, emailAddress, userID)
 
+	if db.config.DeleteGracePeriod > 0 {
+		return db.tombstoneMapping(&mapping)
+	}
+
 	// Execute the deletion using raw SQL to directly handle foreign key constraints
 	// Use transaction for consistency
 	return db.Transaction(func(tx *gorm.DB) error {
@@ -344,7 +614,7 @@ func (db *DB) GetUsers() ([]User, error) {
 	var users []User
 	err := db.DB.Raw(`
 		SELECT id, email, password_hash, role, 
-			   created_at, updated_at, last_login, is_active 
+			   created_at, updated_at, last_login, last_login_ip, last_login_ua, is_active 
 		FROM users 
 		ORDER BY created_at DESC
 	`).Scan(&users).Error
@@ -354,14 +624,46 @@ func (db *DB) GetUsers() ([]User, error) {
 	return users, nil
 }
 
-// UpdateLastLogin updates a user's last login timestamp
-func (db *DB) UpdateLastLogin(userID uint) error {
-	if err := db.Model(&User{}).Where("id = ?", userID).Update("last_login", time.Now()).Error; err != nil {
+// maxUserAgentLength bounds the stored user agent of the last login
+const maxUserAgentLength = 512
+
+// UpdateLastLogin records when and from where a user last logged in
+func (db *DB) UpdateLastLogin(userID uint, ip, userAgent string) error {
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	err := db.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"last_login":    time.Now(),
+		"last_login_ip": ip,
+		"last_login_ua": userAgent,
+	}).Error
+	if err != nil {
 		return fmt.Errorf("failed to update last login: %w", err)
 	}
 	return nil
 }
 
+// UpdateUserDisplayPreferences sets the time zone and locale timestamps are
+// shown to the user in; empty values use the admin server's defaults
+func (db *DB) UpdateUserDisplayPreferences(userID uint, timezone, locale string) error {
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q: %w", timezone, err)
+		}
+	}
+	result := db.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"timezone": timezone,
+		"locale":   locale,
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update display preferences: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no user found with ID: %d", userID)
+	}
+	return nil
+}
+
 // GetUserByID retrieves a user by their ID
 func (db *DB) GetUserByID(userID uint) (*User, error) {
 	var user User
@@ -375,6 +677,19 @@ func (db *DB) GetUserByID(userID uint) (*User, error) {
 	return &user, nil
 }
 
+// HasValidRegistrationToken reports whether a user has an unused,
+// unexpired registration token
+func (db *DB) HasValidRegistrationToken(userID uint) (bool, error) {
+	var count int64
+	err := db.Model(&RegistrationToken{}).
+		Where("user_id = ? AND used_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check registration tokens: %w", err)
+	}
+	return count > 0, nil
+}
+
 // ValidateRegistrationToken checks if a registration token is valid
 func (db *DB) ValidateRegistrationToken(token string) (bool, error) {
 	var rt RegistrationToken
@@ -489,3 +804,40 @@ func (db *DB) GetLogsWithUsers() ([]EmailLog, error) {
 	}
 	return logs, nil
 }
+
+// CountErrorsSince counts failed processing attempts on the user's mappings
+// logged at or after since
+func (db *DB) CountErrorsSince(userID uint, since time.Time) (int64, error) {
+	var count int64
+	err := db.Model(&EmailLog{}).
+		Joins("JOIN email_mappings ON email_mappings.id = email_logs.mapping_id").
+		Where("email_mappings.user_id = ? AND email_logs.status = ? AND email_logs.processed_at >= ?", userID, "error", since).
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count errors: %w", err)
+	}
+	return count, nil
+}
+
+// CloneEmailMapping creates a new mapping with a freshly generated address
+// and the same endpoint, description, headers, options and signing secret as
+// an existing one
+func (db *DB) CloneEmailMapping(emailAddress string, userID uint) (*EmailMapping, error) {
+	var source EmailMapping
+	if err := db.Where("generated_email = ? AND user_id = ?", emailAddress, userID).First(&source).Error; err != nil {
+		return nil, fmt.Errorf("failed to get mapping: %w", err)
+	}
+
+	clone, err := db.CreateEmailMapping(userID, source.EndpointURL, source.Description, source.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	clone.MappingOptions = source.MappingOptions
+	clone.SigningSecret = source.SigningSecret
+	if err := db.Save(clone).Error; err != nil {
+		return nil, fmt.Errorf("failed to copy mapping options: %w", err)
+	}
+
+	return clone, nil
+}
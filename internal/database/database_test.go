@@ -0,0 +1,460 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDB creates a file-backed SQLite database with the schema migrated
+func newTestDB(t *testing.T, config *Config) *DB {
+	t.Helper()
+
+	config.Driver = "sqlite"
+	config.DSN = filepath.Join(t.TempDir(), "test.db")
+	db, err := New(config)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.AutoMigrate(&User{}, &EmailMapping{}, &EmailLog{}, &Delivery{}, &Setting{}); err != nil {
+		t.Fatalf("Failed to create test tables: %v", err)
+	}
+
+	return db
+}
+
+func TestCreateEmailMapping_UnhandledDomain(t *testing.T) {
+	db := newTestDB(t, &Config{
+		Domain:          "admin.example.com",
+		AcceptedDomains: []string{"mail.example.com"},
+	})
+
+	_, err := db.CreateEmailMapping(1, "https://example.com/hook", "", nil)
+	if err == nil || !strings.Contains(err.Error(), "not handled by the mail server") {
+		t.Fatalf("Expected unhandled domain error, got %v", err)
+	}
+}
+
+func TestCreateEmailMapping_HandledDomain(t *testing.T) {
+	db := newTestDB(t, &Config{
+		Domain:          "mail.example.com",
+		AcceptedDomains: []string{"MAIL.example.com"},
+	})
+
+	mapping, err := db.CreateEmailMapping(1, "https://example.com/hook", "", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(mapping.GeneratedEmail, "@mail.example.com") {
+		t.Errorf("Expected address under mail.example.com, got %s", mapping.GeneratedEmail)
+	}
+}
+
+func TestCreateEmailMapping_EndpointAllowlist(t *testing.T) {
+	db := newTestDB(t, &Config{
+		Domain:               "example.com",
+		AllowedEndpointHosts: []string{"hooks.example.com", "*.example.org"},
+	})
+
+	tests := []struct {
+		endpoint string
+		allowed  bool
+	}{
+		{"https://hooks.example.com/email", true},
+		{"https://HOOKS.example.com:8443/email", true},
+		{"https://api.example.org/email", true},
+		{"https://deep.api.example.org/email", true},
+		{"https://example.org/email", false},
+		{"https://evil.example.net/email", false},
+		{"https://hooks.example.com.evil.net/email", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			_, err := db.CreateEmailMapping(1, tt.endpoint, "", nil)
+			if tt.allowed && err != nil {
+				t.Errorf("Expected endpoint to be allowed, got %v", err)
+			}
+			if !tt.allowed && (err == nil || !strings.Contains(err.Error(), "not in the allowed list")) {
+				t.Errorf("Expected endpoint to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUpdateEmailMapping_EndpointAllowlist(t *testing.T) {
+	db := newTestDB(t, &Config{
+		Domain:               "example.com",
+		AllowedEndpointHosts: []string{"hooks.example.com"},
+	})
+
+	mapping, err := db.CreateEmailMapping(1, "https://hooks.example.com/email", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+
+	if err := db.UpdateEmailMapping(mapping.GeneratedEmail, "https://other.example.net/email", nil, 1); err == nil {
+		t.Error("Expected update to a disallowed host to fail")
+	}
+	if err := db.UpdateEmailMapping(mapping.GeneratedEmail, "https://hooks.example.com/v2", nil, 1); err != nil {
+		t.Errorf("Expected update to an allowed host to succeed, got %v", err)
+	}
+}
+
+func TestLogEmailProcessing_NilHeaders(t *testing.T) {
+	db := newTestDB(t, &Config{Domain: "example.com"})
+	user, err := db.CreateUser("owner@example.com", "user")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	mapping, err := db.CreateEmailMapping(user.ID, "https://example.com/hook", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+
+	if err := db.LogEmailProcessing(mapping.GeneratedEmail, "hello", "dropped", "", nil, user.ID, "req-1", ""); err != nil {
+		t.Fatalf("Failed to log email: %v", err)
+	}
+
+	var entry EmailLog
+	if err := db.First(&entry).Error; err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if entry.Headers != "{}" {
+		t.Errorf("Expected nil headers stored as {}, got %q", entry.Headers)
+	}
+}
+
+func TestCreateEmailMapping_InheritsDefaultHeaders(t *testing.T) {
+	db := newTestDB(t, &Config{Domain: "example.com"})
+	user, err := db.CreateUser("owner@example.com", "user")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	defaults := map[string]string{"Authorization": "Bearer default", "X-Team": "ops"}
+	if err := db.UpdateUserDefaultHeaders(user.ID, defaults); err != nil {
+		t.Fatalf("Failed to set default headers: %v", err)
+	}
+
+	mapping, err := db.CreateEmailMapping(user.ID, "https://example.com/hook", "", map[string]string{"authorization": "Bearer mine"})
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+
+	stored, err := db.GetEmailMapping(mapping.GeneratedEmail)
+	if err != nil {
+		t.Fatalf("Failed to get mapping: %v", err)
+	}
+	// Mapping headers override defaults regardless of case
+	want := map[string]string{"authorization": "Bearer mine", "X-Team": "ops"}
+	if len(stored.Headers) != len(want) {
+		t.Fatalf("Expected headers %v, got %v", want, stored.Headers)
+	}
+	for name, value := range want {
+		if stored.Headers[name] != value {
+			t.Errorf("Expected header %s=%q, got %v", name, value, stored.Headers)
+		}
+	}
+
+	// Changing the defaults leaves existing mappings alone
+	if err := db.UpdateUserDefaultHeaders(user.ID, nil); err != nil {
+		t.Fatalf("Failed to clear default headers: %v", err)
+	}
+	if stored, _ := db.GetEmailMapping(mapping.GeneratedEmail); stored.Headers["X-Team"] != "ops" {
+		t.Errorf("Expected existing mapping to keep its headers, got %v", stored.Headers)
+	}
+}
+
+func TestCreateEmailMapping_NormalizesEndpoint(t *testing.T) {
+	db := newTestDB(t, &Config{Domain: "example.com"})
+
+	tests := []struct {
+		name     string
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{"valid", "https://hooks.example.com/email?key=1", "https://hooks.example.com/email?key=1", false},
+		{"whitespace padded", "  https://hooks.example.com/email \n", "https://hooks.example.com/email", false},
+		{"schemeless", "hooks.example.com/email", "https://hooks.example.com/email", false},
+		{"uppercase scheme and host", "HTTP://Hooks.Example.com/Email", "http://hooks.example.com/Email", false},
+		{"bare trailing slash", "https://hooks.example.com/", "https://hooks.example.com", false},
+		{"path trailing slash kept", "https://hooks.example.com/email/", "https://hooks.example.com/email/", false},
+		{"fragment dropped", "https://hooks.example.com/email#top", "https://hooks.example.com/email", false},
+		{"unsupported scheme", "ftp://hooks.example.com/email", "", true},
+		{"missing host", "https:///email", "", true},
+		{"empty", "   ", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping, err := db.CreateEmailMapping(1, tt.endpoint, "", nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected %q to be rejected, got %s", tt.endpoint, mapping.EndpointURL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected %q to be accepted, got %v", tt.endpoint, err)
+			}
+			stored, err := db.GetEmailMapping(mapping.GeneratedEmail)
+			if err != nil || stored == nil {
+				t.Fatalf("Failed to get mapping: %v", err)
+			}
+			if stored.EndpointURL != tt.want {
+				t.Errorf("Expected endpoint stored as %q, got %q", tt.want, stored.EndpointURL)
+			}
+		})
+	}
+
+	mapping, err := db.CreateEmailMapping(1, "https://hooks.example.com/email", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+	if err := db.UpdateEmailMapping(mapping.GeneratedEmail, " hooks.example.com/v2 ", nil, 1); err != nil {
+		t.Fatalf("Failed to update mapping: %v", err)
+	}
+	if stored, _ := db.GetEmailMapping(mapping.GeneratedEmail); stored.EndpointURL != "https://hooks.example.com/v2" {
+		t.Errorf("Expected updated endpoint normalized, got %q", stored.EndpointURL)
+	}
+}
+
+func TestNew_UnreachableDatabaseRetries(t *testing.T) {
+	// Grab a free port and close it so connections are refused
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	start := time.Now()
+	_, err = New(&Config{
+		Driver:          "postgres",
+		DSN:             "host=127.0.0.1 port=" + strconv.Itoa(port) + " user=test dbname=test sslmode=disable connect_timeout=1",
+		ConnectAttempts: 3,
+		ConnectBackoff:  50 * time.Millisecond,
+	})
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("Expected an error for an unreachable database")
+	}
+	if !strings.Contains(err.Error(), "after 3 attempt(s)") {
+		t.Errorf("Expected the error to report 3 attempts, got %v", err)
+	}
+	// Two waits: 50ms, then 100ms
+	if elapsed < 150*time.Millisecond {
+		t.Errorf("Expected retries to back off for at least 150ms, took %s", elapsed)
+	}
+	if elapsed > 10*time.Second {
+		t.Errorf("Expected to give up quickly, took %s", elapsed)
+	}
+}
+
+func TestPingWithRetry_SucceedsOnceUp(t *testing.T) {
+	calls := 0
+	ping := func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	if err := pingWithRetry(ping, 5, time.Millisecond); err != nil {
+		t.Fatalf("Expected success once the database is up, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 pings, got %d", calls)
+	}
+}
+
+func TestUpdateMappingOptions_InvalidSuccessPattern(t *testing.T) {
+	db := newTestDB(t, &Config{Domain: "example.com"})
+	mapping, err := db.CreateEmailMapping(1, "https://example.com/hook", "test", nil)
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+
+	err = db.UpdateMappingOptions(mapping.GeneratedEmail, 1, MappingOptions{SuccessPattern: "ok("})
+	if err == nil || !strings.Contains(err.Error(), "invalid success pattern") {
+		t.Errorf("Expected invalid success pattern error, got %v", err)
+	}
+}
+
+func TestUpdateMappingOptions_SchemaWithBatchOrDigest(t *testing.T) {
+	db := newTestDB(t, &Config{Domain: "example.com"})
+	mapping, err := db.CreateEmailMapping(1, "https://example.com/hook", "test", nil)
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+
+	schema := `{"type": "object"}`
+	for _, opts := range []MappingOptions{
+		{PayloadSchema: schema, BatchSize: 10},
+		{PayloadSchema: schema, DigestSchedule: DigestDaily},
+	} {
+		err := db.UpdateMappingOptions(mapping.GeneratedEmail, 1, opts)
+		if err == nil || !strings.Contains(err.Error(), "batches or digests") {
+			t.Errorf("Expected %+v to be rejected, got %v", opts, err)
+		}
+	}
+
+	// Chat targets get one message per email, so the schema still applies
+	opts := MappingOptions{PayloadSchema: schema, BatchSize: 10, ChatFormat: ChatFormatSlack}
+	if err := db.UpdateMappingOptions(mapping.GeneratedEmail, 1, opts); err != nil {
+		t.Errorf("Expected a chat mapping with a schema to be accepted, got %v", err)
+	}
+}
+
+func TestUpdateMappingOptions_BodyTemplateWithBatchOrDigest(t *testing.T) {
+	db := newTestDB(t, &Config{Domain: "example.com"})
+	mapping, err := db.CreateEmailMapping(1, "https://example.com/hook", "test", nil)
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+
+	tmpl := `{"title": {{json .Subject}}}`
+	for _, opts := range []MappingOptions{
+		{BodyTemplate: tmpl, BatchSize: 10},
+		{BodyTemplate: tmpl, DigestSchedule: DigestWeekly},
+	} {
+		err := db.UpdateMappingOptions(mapping.GeneratedEmail, 1, opts)
+		if err == nil || !strings.Contains(err.Error(), "batches or digests") {
+			t.Errorf("Expected %+v to be rejected, got %v", opts, err)
+		}
+	}
+
+	// The method applies to batches and digests
+	opts := MappingOptions{HTTPMethod: MethodPut, BatchSize: 10}
+	if err := db.UpdateMappingOptions(mapping.GeneratedEmail, 1, opts); err != nil {
+		t.Errorf("Expected a batched mapping with a method to be accepted, got %v", err)
+	}
+}
+
+func TestGetUsers_IncludesLastLogin(t *testing.T) {
+	db := newTestDB(t, &Config{Domain: "example.com"})
+	user, err := db.CreateUser("alice@example.com", "user")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := db.UpdateLastLogin(user.ID, "203.0.113.7", "TestBrowser/1.0"); err != nil {
+		t.Fatalf("Failed to record last login: %v", err)
+	}
+
+	users, err := db.GetUsers()
+	if err != nil {
+		t.Fatalf("GetUsers() error = %v", err)
+	}
+	if len(users) != 1 {
+		t.Fatalf("Expected one user, got %d", len(users))
+	}
+	got := users[0]
+	if got.LastLogin == nil || got.LastLoginIP != "203.0.113.7" || got.LastLoginUA != "TestBrowser/1.0" {
+		t.Errorf("Expected the last login time, IP and user agent, got %v, %q, %q", got.LastLogin, got.LastLoginIP, got.LastLoginUA)
+	}
+}
+
+func TestMigrate_DirtyState(t *testing.T) {
+	dir := t.TempDir()
+	for name, sql := range map[string]string{
+		"001_create_widgets.up.sql":   "CREATE TABLE widgets (id INTEGER PRIMARY KEY);",
+		"001_create_widgets.down.sql": "DROP TABLE widgets;",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(sql), 0644); err != nil {
+			t.Fatalf("Failed to write migration: %v", err)
+		}
+	}
+
+	dsn := filepath.Join(t.TempDir(), "migrate.db")
+	db, err := New(&Config{
+		Driver:           "sqlite",
+		DSN:              dsn,
+		MigrateURL:       "sqlite3://" + dsn,
+		MigrationsSource: "file://" + dir,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	// Simulate a migration that failed partway
+	if err := db.Exec("UPDATE schema_migrations SET dirty = true").Error; err != nil {
+		t.Fatalf("Failed to mark schema dirty: %v", err)
+	}
+
+	err = db.Migrate()
+	var dirty *DirtyMigrationError
+	if !errors.As(err, &dirty) {
+		t.Fatalf("Migrate() error = %v, want DirtyMigrationError", err)
+	}
+	if dirty.Version != 1 || !strings.Contains(err.Error(), "dirty at migration version 1") {
+		t.Errorf("Unexpected dirty migration error: %v", err)
+	}
+
+	if err := db.ForceMigrationVersion(1); err != nil {
+		t.Fatalf("ForceMigrationVersion() error = %v", err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Errorf("Migrate() after repair error = %v", err)
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	dir := t.TempDir()
+	for name, sql := range map[string]string{
+		"001_create_widgets.up.sql":   "CREATE TABLE widgets (id INTEGER PRIMARY KEY);",
+		"001_create_widgets.down.sql": "DROP TABLE widgets;",
+		"002_add_size.up.sql":         "ALTER TABLE widgets ADD COLUMN size INTEGER;",
+		"002_add_size.down.sql":       "ALTER TABLE widgets DROP COLUMN size;",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(sql), 0644); err != nil {
+			t.Fatalf("Failed to write migration: %v", err)
+		}
+	}
+
+	dsn := filepath.Join(t.TempDir(), "migrate.db")
+	db, err := New(&Config{
+		Driver:           "sqlite",
+		DSN:              dsn,
+		MigrateURL:       "sqlite3://" + dsn,
+		MigrationsSource: "file://" + dir,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus() error = %v", err)
+	}
+	if status != (MigrationStatus{Version: 0, Latest: 2}) || !status.Pending() {
+		t.Errorf("Before migrating got %+v, want version 0 of 2 pending", status)
+	}
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	status, err = db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus() error = %v", err)
+	}
+	if status != (MigrationStatus{Version: 2, Latest: 2}) || status.Pending() {
+		t.Errorf("After migrating got %+v, want version 2 of 2", status)
+	}
+}
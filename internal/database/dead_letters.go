@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// CreateDeadLetter stores an email that failed after every retry
+func (db *DB) CreateDeadLetter(letter *DeadLetter) error {
+	letter.Subject = displaySubject(letter.Subject)
+	if letter.Status == "" {
+		letter.Status = DeadLetterFailed
+	}
+	if err := db.Create(letter).Error; err != nil {
+		return fmt.Errorf("failed to create dead letter: %w", err)
+	}
+	return nil
+}
+
+// ListDeadLetters returns up to limit dead letters with their mappings,
+// newest first; a limit of zero returns all of them. Dead letters of
+// deleted mappings are left out.
+func (db *DB) ListDeadLetters(limit int) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	query := db.Preload("Mapping").
+		Where("mapping_id IN (?)", db.Model(&EmailMapping{}).Select("id")).
+		Order("created_at DESC, id DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&letters).Error; err != nil {
+		return nil, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+	return letters, nil
+}
+
+// GetDeadLetter retrieves a dead letter by ID
+func (db *DB) GetDeadLetter(id uint) (*DeadLetter, error) {
+	var letter DeadLetter
+	if err := db.First(&letter, id).Error; err != nil {
+		return nil, fmt.Errorf("failed to get dead letter: %w", err)
+	}
+	return &letter, nil
+}
+
+// QueueDeadLetterReplay marks a failed dead letter for the mail server to
+// send again. Dead letters whose payload was too large to keep can't be
+// replayed.
+func (db *DB) QueueDeadLetterReplay(id uint) error {
+	result := db.Model(&DeadLetter{}).
+		Where("id = ? AND status = ? AND payload <> ''", id, DeadLetterFailed).
+		Update("status", DeadLetterQueued)
+	if result.Error != nil {
+		return fmt.Errorf("failed to queue dead letter: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("dead letter %d can't be replayed", id)
+	}
+	return nil
+}
+
+// ClaimQueuedDeadLetters returns up to limit queued dead letters with their
+// mappings, oldest first, marking them replaying so each is only sent once
+func (db *DB) ClaimQueuedDeadLetters(limit int) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	err := db.Preload("Mapping").
+		Where("status = ?", DeadLetterQueued).
+		Order("created_at ASC, id ASC").
+		Limit(limit).
+		Find(&letters).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queued dead letters: %w", err)
+	}
+
+	claimed := letters[:0]
+	for _, letter := range letters {
+		result := db.Model(&DeadLetter{}).
+			Where("id = ? AND status = ?", letter.ID, DeadLetterQueued).
+			Update("status", DeadLetterReplaying)
+		if result.Error != nil {
+			return claimed, fmt.Errorf("failed to claim dead letter %d: %w", letter.ID, result.Error)
+		}
+		if result.RowsAffected == 1 {
+			letter.Status = DeadLetterReplaying
+			claimed = append(claimed, letter)
+		}
+	}
+	return claimed, nil
+}
+
+// RecordDeadLetterReplayFailure makes a dead letter failed again after an
+// unsuccessful replay
+func (db *DB) RecordDeadLetterReplayFailure(id uint, lastError string) error {
+	err := db.Model(&DeadLetter{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":     DeadLetterFailed,
+			"last_error": lastError,
+			"replays":    gorm.Expr("replays + 1"),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record dead letter replay: %w", err)
+	}
+	return nil
+}
+
+// DeleteDeadLetter removes a dead letter once it has been delivered
+func (db *DB) DeleteDeadLetter(id uint) error {
+	if err := db.Delete(&DeadLetter{}, id).Error; err != nil {
+		return fmt.Errorf("failed to delete dead letter: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// UpdateUserDefaultHeaders replaces the headers merged into the user's new
+// mappings; existing mappings are not changed
+func (db *DB) UpdateUserDefaultHeaders(userID uint, headers map[string]string) error {
+	// Update through the struct so headers go through their JSON serializer
+	result := db.Model(&User{ID: userID}).Select("default_headers").Updates(&User{DefaultHeaders: headers})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update default headers: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no user found with ID: %d", userID)
+	}
+	return nil
+}
+
+// withDefaultHeaders merges the user's default headers into a new mapping's
+// headers. Header names are compared case-insensitively, and the mapping's
+// own headers win.
+func (db *DB) withDefaultHeaders(userID uint, headers map[string]string) (map[string]string, error) {
+	// Find rather than First: an unknown user simply has no defaults
+	var user User
+	if err := db.Select("default_headers").Where("id = ?", userID).Limit(1).Find(&user).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if len(user.DefaultHeaders) == 0 {
+		return headers, nil
+	}
+
+	merged := make(map[string]string, len(user.DefaultHeaders)+len(headers))
+	for name, value := range user.DefaultHeaders {
+		merged[name] = value
+	}
+	for name, value := range headers {
+		for defaultName := range user.DefaultHeaders {
+			if http.CanonicalHeaderKey(defaultName) == http.CanonicalHeaderKey(name) {
+				delete(merged, defaultName)
+			}
+		}
+		merged[name] = value
+	}
+	return merged, nil
+}
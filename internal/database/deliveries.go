@@ -0,0 +1,107 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// CreateDelivery records a new queued delivery for a mapping
+func (db *DB) CreateDelivery(mappingID uint, requestID, subject string) (*Delivery, error) {
+	delivery := &Delivery{
+		MappingID:     mappingID,
+		RequestID:     requestID,
+		Subject:       displaySubject(subject),
+		Status:        DeliveryQueued,
+		NextAttemptAt: time.Now(),
+	}
+	if err := db.Create(delivery).Error; err != nil {
+		return nil, fmt.Errorf("failed to create delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+// GetDelivery retrieves a delivery by ID
+func (db *DB) GetDelivery(id uint) (*Delivery, error) {
+	var delivery Delivery
+	if err := db.First(&delivery, id).Error; err != nil {
+		return nil, fmt.Errorf("failed to get delivery: %w", err)
+	}
+	return &delivery, nil
+}
+
+// GetPendingDeliveries returns queued and retrying deliveries, soonest first
+func (db *DB) GetPendingDeliveries() ([]Delivery, error) {
+	var deliveries []Delivery
+	err := db.Preload("Mapping").
+		Where("status IN ?", []string{DeliveryQueued, DeliveryRetrying}).
+		Order("next_attempt_at ASC").
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// RecordDeliveryAttempt marks a failed attempt and schedules the next one
+func (db *DB) RecordDeliveryAttempt(id uint, attempts int, lastError string, nextAttemptAt time.Time) error {
+	err := db.Model(&Delivery{}).
+		Where("id = ? AND status <> ?", id, DeliveryCanceled).
+		Updates(map[string]interface{}{
+			"status":          DeliveryRetrying,
+			"attempts":        attempts,
+			"last_error":      lastError,
+			"next_attempt_at": nextAttemptAt,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record delivery attempt: %w", err)
+	}
+	return nil
+}
+
+// RetryDeliveryNow schedules a pending delivery's next attempt immediately
+func (db *DB) RetryDeliveryNow(id uint) error {
+	result := db.Model(&Delivery{}).
+		Where("id = ? AND status IN ?", id, []string{DeliveryQueued, DeliveryRetrying}).
+		Update("next_attempt_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to retry delivery: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("delivery %d is not pending", id)
+	}
+	return nil
+}
+
+// CancelDelivery stops any further attempts for a pending delivery
+func (db *DB) CancelDelivery(id uint) error {
+	result := db.Model(&Delivery{}).
+		Where("id = ? AND status IN ?", id, []string{DeliveryQueued, DeliveryRetrying}).
+		Update("status", DeliveryCanceled)
+	if result.Error != nil {
+		return fmt.Errorf("failed to cancel delivery: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("delivery %d is not pending", id)
+	}
+	return nil
+}
+
+// DeleteDelivery removes a delivery once it has finished
+func (db *DB) DeleteDelivery(id uint) error {
+	if err := db.Delete(&Delivery{}, id).Error; err != nil {
+		return fmt.Errorf("failed to delete delivery: %w", err)
+	}
+	return nil
+}
+
+// CountPendingDeliveries returns the number of queued and retrying deliveries
+func (db *DB) CountPendingDeliveries() (int64, error) {
+	var count int64
+	err := db.Model(&Delivery{}).
+		Where("status IN ?", []string{DeliveryQueued, DeliveryRetrying}).
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pending deliveries: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"fmt"
+)
+
+// AddDigestEntry holds an email for its mapping's next digest
+func (db *DB) AddDigestEntry(mappingID uint, requestID, sender, subject, clientIP string) error {
+	entry := &DigestEntry{
+		MappingID: mappingID,
+		RequestID: requestID,
+		Sender:    sender,
+		Subject:   displaySubject(subject),
+		ClientIP:  clientIP,
+	}
+	if err := db.Create(entry).Error; err != nil {
+		return fmt.Errorf("failed to add digest entry: %w", err)
+	}
+	return nil
+}
+
+// GetDigestEntries returns every held email with its mapping, grouped by
+// mapping and oldest first. Entries of a deleted mapping have a zero Mapping.
+func (db *DB) GetDigestEntries() ([]DigestEntry, error) {
+	var entries []DigestEntry
+	err := db.Preload("Mapping").
+		Order("mapping_id ASC, created_at ASC, id ASC").
+		Find(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get digest entries: %w", err)
+	}
+	return entries, nil
+}
+
+// DeleteDigestEntries removes held emails once their digest was sent
+func (db *DB) DeleteDigestEntries(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := db.Delete(&DigestEntry{}, ids).Error; err != nil {
+		return fmt.Errorf("failed to delete digest entries: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrEmailTaken is returned when a requested email already belongs to a user
+var ErrEmailTaken = errors.New("email address is already in use")
+
+// emailTaken reports whether another user already has the address
+func emailTaken(tx *gorm.DB, email string, userID uint) (bool, error) {
+	var count int64
+	err := tx.Model(&User{}).Where("LOWER(email) = ? AND id <> ?", strings.ToLower(email), userID).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check email uniqueness: %w", err)
+	}
+	return count > 0, nil
+}
+
+// CreateEmailChangeToken starts a change of a user's email to newEmail. The
+// change is applied by ConfirmEmailChange once the new address confirms it.
+func (db *DB) CreateEmailChangeToken(userID uint, newEmail string) (*EmailChangeToken, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(newEmail))
+	if err != nil || addr.Name != "" {
+		return nil, fmt.Errorf("invalid email address")
+	}
+	newEmail = addr.Address
+
+	taken, err := emailTaken(db.DB, newEmail, userID)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, ErrEmailTaken
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	ct := &EmailChangeToken{
+		UserID:    userID,
+		Token:     base64.URLEncoding.EncodeToString(tokenBytes),
+		NewEmail:  newEmail,
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+	}
+	if err := db.Create(ct).Error; err != nil {
+		return nil, fmt.Errorf("failed to create email change token: %w", err)
+	}
+
+	return ct, nil
+}
+
+// ConfirmEmailChange applies the email change for a token and returns the
+// updated user. Uniqueness is checked again since the address may have been
+// taken after the change was requested.
+func (db *DB) ConfirmEmailChange(token string) (*User, error) {
+	var user User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var ct EmailChangeToken
+		if err := tx.Where("token = ?", token).First(&ct).Error; err != nil {
+			return fmt.Errorf("invalid token")
+		}
+		if ct.UsedAt != nil {
+			return fmt.Errorf("token already used")
+		}
+		if time.Now().After(ct.ExpiresAt) {
+			return fmt.Errorf("token expired")
+		}
+
+		taken, err := emailTaken(tx, ct.NewEmail, ct.UserID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrEmailTaken
+		}
+
+		if err := tx.Model(&User{}).Where("id = ?", ct.UserID).Update("email", ct.NewEmail).Error; err != nil {
+			return fmt.Errorf("failed to update email: %w", err)
+		}
+		if err := tx.Model(&ct).Update("used_at", time.Now()).Error; err != nil {
+			return fmt.Errorf("failed to update token: %w", err)
+		}
+
+		return tx.First(&user, ct.UserID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// normalizeEndpoint returns the canonical form of a pasted endpoint URL:
+// surrounding whitespace is trimmed, a missing scheme defaults to https,
+// scheme and host are lowercased, a bare "/" path and any fragment are
+// dropped. Only http and https URLs with a host are accepted.
+func normalizeEndpoint(endpoint string) (string, error) {
+	endpoint = strings.TrimSpace(endpoint)
+	if endpoint == "" {
+		return "", fmt.Errorf("endpoint URL is required")
+	}
+	if !strings.Contains(endpoint, "://") {
+		log.Printf("Warning: endpoint URL %q has no scheme, defaulting to https", endpoint)
+		endpoint = "https://" + endpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint URL %q: %w", endpoint, err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid endpoint URL %q: scheme must be http or https", endpoint)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("invalid endpoint URL %q: missing host", endpoint)
+	}
+	u.Host = strings.ToLower(u.Host)
+	if u.Path == "/" && u.RawQuery == "" {
+		u.Path = ""
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String(), nil
+}
+
+// validateEndpoint checks that an endpoint URL's host is in the configured
+// allowlist. Patterns are exact hosts ("hooks.example.com") or a leading
+// wildcard ("*.example.com") matching any subdomain. An empty allowlist
+// allows every host.
+func (db *DB) validateEndpoint(endpoint string) error {
+	if len(db.config.AllowedEndpointHosts) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("invalid endpoint URL %q", endpoint)
+	}
+	host := strings.ToLower(u.Hostname())
+
+	for _, pattern := range db.config.AllowedEndpointHosts {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return nil
+			}
+			continue
+		}
+		if host == pattern {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("endpoint host %s is not in the allowed list", host)
+}
@@ -34,16 +34,20 @@ func (db *DB) AdminDeleteEmailMapping(emailAddress string) error {
 		return err
 	}
 	
+	if db.config.DeleteGracePeriod > 0 {
+		return db.tombstoneMapping(mapping)
+	}
+
 	// Use a transaction to ensure all related records are deleted
 	return db.Transaction(func(tx *gorm.DB) error {
 		// First delete associated logs
-		if result := tx.Where("mapping_id = ?", mapping.ID).Delete(&EmailLog{}); result.Error != nil {
+		if result := tx.Unscoped().Where("mapping_id = ?", mapping.ID).Delete(&EmailLog{}); result.Error != nil {
 			log.Printf("Error deleting logs: %v", result.Error)
 			return fmt.Errorf("failed to delete associated email logs: %w", result.Error)
 		}
 		
 		// Then delete the mapping itself
-		if result := tx.Delete(mapping); result.Error != nil {
+		if result := tx.Unscoped().Delete(mapping); result.Error != nil {
 			log.Printf("Error deleting mapping: %v", result.Error)
 			return fmt.Errorf("failed to delete email mapping: %w", result.Error)
 		}
@@ -1,7 +1,16 @@
 package database
 
 import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
+
+	"github.com/looprock/email-to-api/internal/jsonschema"
+	"gorm.io/gorm"
 )
 
 // User represents a user in the system
@@ -14,6 +23,18 @@ type User struct {
 	CreatedAt    time.Time `gorm:"not null;autoCreateTime"`
 	UpdatedAt    time.Time `gorm:"not null;autoUpdateTime"`
 	LastLogin    *time.Time
+	// Source IP and user agent of the last login
+	LastLoginIP string `gorm:"column:last_login_ip;not null;default:''"`
+	LastLoginUA string `gorm:"column:last_login_ua;not null;default:''"`
+
+	// Timezone (an IANA zone) and Locale select how timestamps are shown to
+	// the user; empty uses the admin server's defaults
+	Timezone string `gorm:"not null;default:''"`
+	Locale   string `gorm:"not null;default:''"`
+
+	// DefaultHeaders are merged into the headers of each new mapping the
+	// user creates; the mapping's own headers take precedence
+	DefaultHeaders map[string]string `gorm:"serializer:json"`
 }
 
 // RegistrationToken represents a token used for user registration
@@ -27,6 +48,284 @@ type RegistrationToken struct {
 	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
 }
 
+// EmailChangeToken is a pending change of a user's email, applied once the
+// new address confirms it
+type EmailChangeToken struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement"`
+	Token     string    `gorm:"uniqueIndex;not null"`
+	UserID    uint      `gorm:"not null"`
+	NewEmail  string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"not null;autoCreateTime"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
+
+// MappingOptions holds optional per-mapping processing settings
+type MappingOptions struct {
+	// DropAutoSubmitted filters auto-generated mail (Auto-Submitted other than "no")
+	DropAutoSubmitted bool `gorm:"not null;default:false"`
+
+	// Batching: when BatchSize > 0, emails are buffered and POSTed together as a
+	// JSON array once BatchSize emails, BatchMaxBytes or BatchWindow (seconds) is reached
+	BatchSize     int `gorm:"not null;default:0"`
+	BatchWindow   int `gorm:"not null;default:0"`
+	BatchMaxBytes int `gorm:"not null;default:0"`
+
+	// FieldNaming selects the payload key style: "" or "snake_case" (default) or "camelCase".
+	// FieldNameMap renames individual keys (by their snake_case name) and takes precedence.
+	FieldNaming  string            `gorm:"not null;default:''"`
+	FieldNameMap map[string]string `gorm:"serializer:json"`
+
+	// StripQuoted adds a copy of the plain body without quoted replies and
+	// signature to the payload as clean_body
+	StripQuoted bool `gorm:"not null;default:false"`
+
+	// FlattenHeaders adds the headers to the payload as headers_flat, one
+	// string per header name with repeated values deduplicated and joined
+	FlattenHeaders bool `gorm:"not null;default:false"`
+
+	// LogLevel controls which processing results are written to email_logs:
+	// "" or "all", "errors" (failures only) or "none"
+	LogLevel string `gorm:"not null;default:''"`
+
+	// StaticTags are added to every email's tags alongside the subject tags
+	StaticTags []string `gorm:"serializer:json"`
+
+	// StatusActions overrides how endpoint response codes are treated, keyed
+	// by status code (e.g. "202": "success", "409": "drop"). Unlisted codes
+	// are retried when >= 400 and succeed otherwise.
+	StatusActions map[string]string `gorm:"serializer:json"`
+
+	// InlineImages rewrites cid: references in the HTML body to inline
+	// images: "" leaves them, "data_uri" or "index" (attachment:N)
+	InlineImages string `gorm:"not null;default:''"`
+
+	// PayloadVersion pins the payload schema version sent to the endpoint;
+	// empty sends the current version
+	PayloadVersion string `gorm:"not null;default:''"`
+
+	// ChatFormat posts a chat message instead of the JSON payload: "" sends
+	// the payload, "slack" a Slack message and "teams" a Teams card
+	ChatFormat string `gorm:"not null;default:''"`
+
+	// SuccessPattern is a regular expression a 2xx response body must match
+	// for the delivery to succeed; a non-matching body is retried. Empty
+	// accepts any body.
+	SuccessPattern string `gorm:"not null;default:''"`
+
+	// BounceAction handles delivery status notifications (bounces): ""
+	// forwards them like other mail, "drop" discards them and "route" posts
+	// them to BounceEndpoint instead of the mapping's endpoint
+	BounceAction   string `gorm:"not null;default:''"`
+	BounceEndpoint string `gorm:"not null;default:''"`
+
+	// StripHeaders lists header names left out of the forwarded headers,
+	// matched case-insensitively; a trailing "*" matches a prefix, e.g.
+	// "X-Spam-*"
+	StripHeaders []string `gorm:"serializer:json"`
+
+	// PayloadFields limits the forwarded data object to these fields, by
+	// their snake_case name (e.g. "from", "subject", "tags"); empty sends
+	// every field
+	PayloadFields []string `gorm:"serializer:json"`
+
+	// DigestSchedule holds emails and posts one summary of them per period
+	// instead of forwarding each: "" forwards immediately, "daily" or
+	// "weekly" (Mondays). Digests go out at DigestHour:00 UTC.
+	DigestSchedule string `gorm:"not null;default:''"`
+	DigestHour     int    `gorm:"not null;default:0"`
+
+	// TagCase controls tag casing: "" or "lower" lowercases tags, "preserve"
+	// keeps them as written, so tags differing only in case are distinct
+	TagCase string `gorm:"not null;default:''"`
+
+	// MaxInFlight caps how many requests to the endpoint run at once, for
+	// endpoints that can't take many; 0 means no limit
+	MaxInFlight int `gorm:"not null;default:0"`
+
+	// PayloadSchema is a JSON Schema the payload must satisfy before it is
+	// sent; a payload that doesn't is logged as an error and not retried.
+	// Empty sends every payload.
+	PayloadSchema string `gorm:"type:text;not null;default:''"`
+
+	// HTTPMethod is the method requests to the endpoint use: "" or "POST"
+	// (default), "PUT" or "PATCH"
+	HTTPMethod string `gorm:"not null;default:''"`
+
+	// BodyTemplate is a text/template over the email's payload data that
+	// replaces the JSON payload as the request body; empty sends the payload.
+	// It can't be combined with batches or digests.
+	BodyTemplate string `gorm:"type:text;not null;default:''"`
+}
+
+// sendsTogether reports whether emails are delivered in batches or digests
+// rather than one request each; chat targets always get one message per email
+func (o MappingOptions) sendsTogether() bool {
+	return o.ChatFormat == "" && (o.BatchSize > 0 || o.DigestSchedule != "")
+}
+
+// HTTP methods a mapping's requests can use
+const (
+	MethodPost  = "POST"
+	MethodPut   = "PUT"
+	MethodPatch = "PATCH"
+)
+
+// Method returns the HTTP method for requests to the endpoint
+func (o MappingOptions) Method() string {
+	if o.HTTPMethod == "" {
+		return MethodPost
+	}
+	return o.HTTPMethod
+}
+
+// bodyTemplateFuncs are the functions body templates can call besides the
+// text/template builtins
+var bodyTemplateFuncs = template.FuncMap{
+	// json encodes a value as JSON, so strings are quoted and escaped
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join": strings.Join,
+}
+
+// ParseBodyTemplate parses a mapping's BodyTemplate
+func ParseBodyTemplate(text string) (*template.Template, error) {
+	return template.New("body").Funcs(bodyTemplateFuncs).Parse(text)
+}
+
+// Tag casing modes
+const (
+	TagCaseLower    = "lower"
+	TagCasePreserve = "preserve"
+)
+
+// Digest schedules
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// Bounce handling actions
+const (
+	BounceActionDrop  = "drop"
+	BounceActionRoute = "route"
+)
+
+// Validate checks options that can't be stored as given
+func (o MappingOptions) Validate() error {
+	if o.SuccessPattern != "" {
+		if _, err := regexp.Compile(o.SuccessPattern); err != nil {
+			return fmt.Errorf("invalid success pattern: %w", err)
+		}
+	}
+	if o.BounceAction == BounceActionRoute && o.BounceEndpoint == "" {
+		return fmt.Errorf("routing bounces requires a bounce endpoint")
+	}
+	switch o.DigestSchedule {
+	case "", DigestDaily, DigestWeekly:
+	default:
+		return fmt.Errorf("unknown digest schedule %q", o.DigestSchedule)
+	}
+	if o.DigestHour < 0 || o.DigestHour > 23 {
+		return fmt.Errorf("digest hour must be between 0 and 23")
+	}
+	switch o.TagCase {
+	case "", TagCaseLower, TagCasePreserve:
+	default:
+		return fmt.Errorf("unknown tag case %q", o.TagCase)
+	}
+	if o.MaxInFlight < 0 {
+		return fmt.Errorf("max in flight must not be negative")
+	}
+	if o.PayloadSchema != "" {
+		if _, err := jsonschema.Compile(o.PayloadSchema); err != nil {
+			return fmt.Errorf("invalid payload schema: %w", err)
+		}
+		// A schema describes one payload, not a batch array or a digest
+		if o.sendsTogether() {
+			return fmt.Errorf("a payload schema can't be used with batches or digests")
+		}
+	}
+	switch o.HTTPMethod {
+	case "", MethodPost, MethodPut, MethodPatch:
+	default:
+		return fmt.Errorf("unsupported HTTP method %q", o.HTTPMethod)
+	}
+	if o.BodyTemplate != "" {
+		if _, err := ParseBodyTemplate(o.BodyTemplate); err != nil {
+			return fmt.Errorf("invalid body template: %w", err)
+		}
+		// Batches and digests are posted as JSON; HTTPMethod still applies
+		if o.sendsTogether() {
+			return fmt.Errorf("a body template can't be used with batches or digests")
+		}
+	}
+	return nil
+}
+
+// Chat message formats
+const (
+	ChatFormatSlack = "slack"
+	ChatFormatTeams = "teams"
+)
+
+// Inline image rewriting modes
+const (
+	InlineImagesDataURI = "data_uri"
+	InlineImagesIndex   = "index"
+)
+
+// Mapping log levels
+const (
+	LogLevelAll    = "all"
+	LogLevelErrors = "errors"
+	LogLevelNone   = "none"
+)
+
+// ShouldLog reports whether a processing result with the given status is
+// logged under the mapping's LogLevel
+func (o MappingOptions) ShouldLog(status string) bool {
+	switch o.LogLevel {
+	case LogLevelNone:
+		return false
+	case LogLevelErrors:
+		return status == "error"
+	default:
+		return true
+	}
+}
+
+// Endpoint response actions
+const (
+	StatusActionSuccess    = "success"
+	StatusActionRetry      = "retry"
+	StatusActionDrop       = "drop"
+	StatusActionDeadLetter = "dead-letter"
+)
+
+// ValidStatusAction reports whether action is a known endpoint response action
+func ValidStatusAction(action string) bool {
+	switch action {
+	case StatusActionSuccess, StatusActionRetry, StatusActionDrop, StatusActionDeadLetter:
+		return true
+	}
+	return false
+}
+
+// StatusAction returns the action for an endpoint response status code
+func (o MappingOptions) StatusAction(code int) string {
+	if action, ok := o.StatusActions[strconv.Itoa(code)]; ok {
+		return action
+	}
+	if code >= 400 {
+		return StatusActionRetry
+	}
+	return StatusActionSuccess
+}
+
 // EmailMapping represents an email forwarding mapping
 type EmailMapping struct {
 	ID             uint   `gorm:"primaryKey;autoIncrement"`
@@ -39,6 +338,10 @@ type EmailMapping struct {
 	CreatedAt      time.Time         `gorm:"not null;autoCreateTime"`
 	UpdatedAt      time.Time         `gorm:"not null;autoUpdateTime"`
 	User           User              `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	DeletedAt      gorm.DeletedAt    `gorm:"index"`                        // Set while a deleted mapping awaits purging
+	SigningSecret  string            `gorm:"not null;default:''" json:"-"` // Key for the X-Signature header, if set; never shown
+
+	MappingOptions
 }
 
 // EmailLog represents a log of processed emails
@@ -49,7 +352,76 @@ type EmailLog struct {
 	FromAddress  string `gorm:"not null"`
 	Status       string `gorm:"not null"`
 	ErrorMessage string
-	Headers      string       `gorm:"type:text"`
-	ProcessedAt  time.Time    `gorm:"not null;autoCreateTime"`
-	Mapping      EmailMapping `gorm:"foreignKey:MappingID;constraint:OnDelete:CASCADE"`
+	Headers      string         `gorm:"type:text"`
+	RequestID    string         `gorm:"index"`
+	ClientIP     string         `gorm:"column:client_ip;not null;default:'';index"` // IP address of the sending client, if known
+	Payload      string         `gorm:"type:text;not null;default:''"`              // Payload of a failed delivery, kept for bulk retries
+	ProcessedAt  time.Time      `gorm:"not null;autoCreateTime"`
+	Mapping      EmailMapping   `gorm:"foreignKey:MappingID;constraint:OnDelete:CASCADE"`
+	DeletedAt    gorm.DeletedAt `gorm:"index"` // Set with the mapping's, see EmailMapping.DeletedAt
 }
+
+// Delivery tracks an email whose delivery is queued or waiting to be retried.
+// Rows are removed once the delivery succeeds or gives up.
+type Delivery struct {
+	ID            uint   `gorm:"primaryKey;autoIncrement"`
+	MappingID     uint   `gorm:"not null;index"`
+	RequestID     string `gorm:"index"`
+	Subject       string
+	Status        string    `gorm:"not null;default:'queued'"` // queued, retrying or canceled
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"not null"`
+	LastError     string
+	CreatedAt     time.Time    `gorm:"not null;autoCreateTime"`
+	UpdatedAt     time.Time    `gorm:"not null;autoUpdateTime"`
+	Mapping       EmailMapping `gorm:"foreignKey:MappingID;constraint:OnDelete:CASCADE"`
+}
+
+// DigestEntry is an email held for its mapping's next digest
+type DigestEntry struct {
+	ID        uint         `gorm:"primaryKey;autoIncrement"`
+	MappingID uint         `gorm:"not null;index"`
+	RequestID string       `gorm:"index"`
+	Sender    string       `gorm:"not null;default:''"`
+	Subject   string       `gorm:"not null;default:''"`
+	ClientIP  string       `gorm:"column:client_ip;not null;default:''"`
+	CreatedAt time.Time    `gorm:"not null;autoCreateTime"`
+	Mapping   EmailMapping `gorm:"foreignKey:MappingID;constraint:OnDelete:CASCADE"`
+}
+
+// DeadLetter keeps an email whose delivery failed after every retry, with
+// its payload and the endpoint and headers it was sent to, so it can be
+// inspected and replayed. Rows are removed once a replay succeeds.
+type DeadLetter struct {
+	ID          uint              `gorm:"primaryKey;autoIncrement"`
+	MappingID   uint              `gorm:"not null;index"`
+	RequestID   string            `gorm:"index"`
+	Subject     string            `gorm:"not null;default:''"`
+	FromAddress string            `gorm:"not null;default:''"`
+	EndpointURL string            `gorm:"not null;default:''"`
+	Headers     map[string]string `gorm:"serializer:json"`
+	Payload     string            `gorm:"type:text;not null;default:''"` // Empty when over the size cap
+	PayloadSize int               `gorm:"not null;default:0"`
+	LastError   string            `gorm:"type:text;not null;default:''"`
+	Status      string            `gorm:"not null;default:'failed'"` // failed, queued or replaying
+	Replays     int               `gorm:"not null;default:0"`
+	CreatedAt   time.Time         `gorm:"not null;autoCreateTime"`
+	UpdatedAt   time.Time         `gorm:"not null;autoUpdateTime"`
+	Mapping     EmailMapping      `gorm:"foreignKey:MappingID;constraint:OnDelete:CASCADE"`
+}
+
+// Dead letter statuses. A queued dead letter waits for the mail server to
+// replay it; once claimed it is replaying until the replay succeeds, which
+// removes it, or fails, which makes it failed again.
+const (
+	DeadLetterFailed    = "failed"
+	DeadLetterQueued    = "queued"
+	DeadLetterReplaying = "replaying"
+)
+
+// Delivery statuses
+const (
+	DeliveryQueued   = "queued"
+	DeliveryRetrying = "retrying"
+	DeliveryCanceled = "canceled"
+)
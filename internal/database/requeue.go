@@ -0,0 +1,111 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Log statuses used by bulk retries. A requeued log waits for the mail
+// server to send its payload again; once claimed it becomes retried and
+// the outcome is logged as a new entry with the same request ID.
+const (
+	LogStatusRequeued = "requeued"
+	LogStatusRetried  = "retried"
+)
+
+// RequeueFilter scopes a bulk retry. Zero values match everything.
+type RequeueFilter struct {
+	MappingID uint
+	Since     time.Time // Inclusive
+	Until     time.Time // Exclusive
+}
+
+// RequeueResult summarizes a bulk retry
+type RequeueResult struct {
+	Requeued int64 `json:"requeued"`
+	// Skipped counts matching failures that have no stored payload, such
+	// as those logged before payloads were kept, and can't be retried
+	Skipped int64 `json:"skipped"`
+}
+
+// SaveFailedPayload stores the payload of a failed delivery on its error
+// log so it can be retried later
+func (db *DB) SaveFailedPayload(requestID, payload string) error {
+	err := db.Model(&EmailLog{}).
+		Where("request_id = ? AND status = ?", requestID, "error").
+		Update("payload", payload).Error
+	if err != nil {
+		return fmt.Errorf("failed to save payload: %w", err)
+	}
+	return nil
+}
+
+// RequeueFailedLogs marks the failed logs matching filter for another
+// delivery attempt
+func (db *DB) RequeueFailedLogs(filter RequeueFilter) (RequeueResult, error) {
+	// Builds the filtered query; called twice since counting consumes it
+	failed := func() *gorm.DB {
+		query := db.Model(&EmailLog{}).Where("status = ?", "error")
+		if filter.MappingID != 0 {
+			query = query.Where("mapping_id = ?", filter.MappingID)
+		}
+		if !filter.Since.IsZero() {
+			query = query.Where("processed_at >= ?", filter.Since)
+		}
+		if !filter.Until.IsZero() {
+			query = query.Where("processed_at < ?", filter.Until)
+		}
+		return query
+	}
+
+	var result RequeueResult
+	if err := failed().Where("payload = ''").Count(&result.Skipped).Error; err != nil {
+		return RequeueResult{}, fmt.Errorf("failed to count failed logs: %w", err)
+	}
+	updated := failed().Where("payload <> ''").Update("status", LogStatusRequeued)
+	if updated.Error != nil {
+		return RequeueResult{}, fmt.Errorf("failed to requeue failed logs: %w", updated.Error)
+	}
+	result.Requeued = updated.RowsAffected
+	return result, nil
+}
+
+// ClaimRequeuedLogs returns up to limit requeued logs with their mappings,
+// oldest first, marking them retried so each is only sent once
+func (db *DB) ClaimRequeuedLogs(limit int) ([]EmailLog, error) {
+	var logs []EmailLog
+	err := db.Preload("Mapping").
+		Where("status = ?", LogStatusRequeued).
+		Order("processed_at ASC, id ASC").
+		Limit(limit).
+		Find(&logs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get requeued logs: %w", err)
+	}
+
+	claimed := logs[:0]
+	for _, log := range logs {
+		result := db.Model(&EmailLog{}).
+			Where("id = ? AND status = ?", log.ID, LogStatusRequeued).
+			Update("status", LogStatusRetried)
+		if result.Error != nil {
+			return claimed, fmt.Errorf("failed to claim requeued log %d: %w", log.ID, result.Error)
+		}
+		if result.RowsAffected == 1 {
+			log.Status = LogStatusRetried
+			claimed = append(claimed, log)
+		}
+	}
+	return claimed, nil
+}
+
+// CountRequeuedLogs returns the number of logs still waiting to be retried
+func (db *DB) CountRequeuedLogs() (int64, error) {
+	var count int64
+	if err := db.Model(&EmailLog{}).Where("status = ?", LogStatusRequeued).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count requeued logs: %w", err)
+	}
+	return count, nil
+}
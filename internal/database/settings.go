@@ -0,0 +1,82 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// Setting is a runtime-adjustable setting stored as a key/value pair
+type Setting struct {
+	Key       string `gorm:"primaryKey;size:100"`
+	Value     string `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+// Runtime setting keys
+const (
+	SettingMaxEmailSize    = "max_email_size"
+	SettingRetryAttempts   = "retry_attempts"
+	SettingMaintenanceMode = "maintenance_mode"
+)
+
+// RuntimeSettings are the settings that can be changed from the admin
+// interface without a restart. Zero values mean the configuration file's
+// value applies.
+type RuntimeSettings struct {
+	MaxEmailSize    int64
+	RetryAttempts   int
+	MaintenanceMode bool
+}
+
+// GetRuntimeSettings loads the runtime settings; keys never saved keep
+// their zero value
+func (db *DB) GetRuntimeSettings() (RuntimeSettings, error) {
+	var rows []Setting
+	if err := db.Find(&rows).Error; err != nil {
+		return RuntimeSettings{}, fmt.Errorf("failed to get settings: %w", err)
+	}
+
+	var settings RuntimeSettings
+	for _, row := range rows {
+		var err error
+		switch row.Key {
+		case SettingMaxEmailSize:
+			settings.MaxEmailSize, err = strconv.ParseInt(row.Value, 10, 64)
+		case SettingRetryAttempts:
+			settings.RetryAttempts, err = strconv.Atoi(row.Value)
+		case SettingMaintenanceMode:
+			settings.MaintenanceMode, err = strconv.ParseBool(row.Value)
+		}
+		if err != nil {
+			return RuntimeSettings{}, fmt.Errorf("invalid value %q for setting %s: %w", row.Value, row.Key, err)
+		}
+	}
+	return settings, nil
+}
+
+// SaveRuntimeSettings stores all runtime settings
+func (db *DB) SaveRuntimeSettings(settings RuntimeSettings) error {
+	if settings.MaxEmailSize < 0 {
+		return fmt.Errorf("max email size cannot be negative")
+	}
+	if settings.RetryAttempts < 0 {
+		return fmt.Errorf("retry attempts cannot be negative")
+	}
+
+	rows := []Setting{
+		{Key: SettingMaxEmailSize, Value: strconv.FormatInt(settings.MaxEmailSize, 10)},
+		{Key: SettingRetryAttempts, Value: strconv.Itoa(settings.RetryAttempts)},
+		{Key: SettingMaintenanceMode, Value: strconv.FormatBool(settings.MaintenanceMode)},
+	}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(&rows).Error
+	if err != nil {
+		return fmt.Errorf("failed to save settings: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// tombstoneMapping soft-deletes a mapping and its logs so they disappear
+// from the UI and processing but can be restored until purged. Pending
+// deliveries are dropped right away so nothing is sent to the endpoint.
+func (db *DB) tombstoneMapping(mapping *EmailMapping) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("mapping_id = ?", mapping.ID).Delete(&Delivery{}).Error; err != nil {
+			return fmt.Errorf("failed to delete pending deliveries: %w", err)
+		}
+		if err := tx.Where("mapping_id = ?", mapping.ID).Delete(&EmailLog{}).Error; err != nil {
+			return fmt.Errorf("failed to delete associated email logs: %w", err)
+		}
+		if err := tx.Delete(mapping).Error; err != nil {
+			return fmt.Errorf("failed to delete email mapping: %w", err)
+		}
+		log.Printf("Deleted mapping ID %d for email %s; restorable for %s",
+			mapping.ID, mapping.GeneratedEmail, db.config.DeleteGracePeriod)
+		return nil
+	})
+}
+
+// GetDeletedMappings returns deleted mappings still within their grace
+// period, most recently deleted first
+func (db *DB) GetDeletedMappings() ([]EmailMapping, error) {
+	var mappings []EmailMapping
+	err := db.Unscoped().Preload("User").
+		Where("deleted_at IS NOT NULL").
+		Order("deleted_at DESC").
+		Find(&mappings).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deleted mappings: %w", err)
+	}
+	return mappings, nil
+}
+
+// RestoreEmailMapping brings back a deleted mapping and its logs
+func (db *DB) RestoreEmailMapping(mappingID uint) (*EmailMapping, error) {
+	var mapping EmailMapping
+	if err := db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", mappingID).First(&mapping).Error; err != nil {
+		return nil, fmt.Errorf("no deleted mapping found with ID %d: %w", mappingID, err)
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&EmailMapping{}).Where("id = ?", mappingID).Update("deleted_at", nil).Error; err != nil {
+			return fmt.Errorf("failed to restore email mapping: %w", err)
+		}
+		if err := tx.Unscoped().Model(&EmailLog{}).Where("mapping_id = ?", mappingID).Update("deleted_at", nil).Error; err != nil {
+			return fmt.Errorf("failed to restore email logs: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	mapping.DeletedAt = gorm.DeletedAt{}
+	return &mapping, nil
+}
+
+// PurgeDeleted permanently removes mappings and logs deleted before cutoff,
+// returning how many mappings were purged
+func (db *DB) PurgeDeleted(cutoff time.Time) (int64, error) {
+	var purged int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Logs first, as they reference their mapping
+		if err := tx.Unscoped().Where("deleted_at < ?", cutoff).Delete(&EmailLog{}).Error; err != nil {
+			return fmt.Errorf("failed to purge email logs: %w", err)
+		}
+		result := tx.Unscoped().Where("deleted_at < ?", cutoff).Delete(&EmailMapping{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to purge email mappings: %w", result.Error)
+		}
+		purged = result.RowsAffected
+		return nil
+	})
+	return purged, err
+}
+
+// RunPurge purges tombstones older than the delete grace period every
+// interval until ctx is canceled
+func (db *DB) RunPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := db.PurgeDeleted(time.Now().Add(-db.config.DeleteGracePeriod))
+		if err != nil {
+			log.Printf("Failed to purge deleted mappings: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d deleted mapping(s) past their grace period", purged)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
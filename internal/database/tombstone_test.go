@@ -0,0 +1,89 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeleteEmailMapping_GracePeriod(t *testing.T) {
+	db := newTestDB(t, &Config{Domain: "example.com", DeleteGracePeriod: 24 * time.Hour})
+	user, err := db.CreateUser("owner@example.com", "user")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	mapping, err := db.CreateEmailMapping(user.ID, "https://example.com/hook", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+	if err := db.LogEmailProcessing(mapping.GeneratedEmail, "hello", "success", "", nil, user.ID, "req-1", ""); err != nil {
+		t.Fatalf("Failed to log email: %v", err)
+	}
+
+	countLogs := func() (visible, stored int64) {
+		db.Model(&EmailLog{}).Count(&visible)
+		db.Unscoped().Model(&EmailLog{}).Count(&stored)
+		return visible, stored
+	}
+
+	if err := db.DeleteEmailMapping(mapping.GeneratedEmail, user.ID); err != nil {
+		t.Fatalf("Failed to delete mapping: %v", err)
+	}
+	if got, _ := db.GetEmailMapping(mapping.GeneratedEmail); got != nil {
+		t.Error("Expected deleted mapping to stop receiving mail")
+	}
+	if visible, stored := countLogs(); visible != 0 || stored != 1 {
+		t.Errorf("Expected the log hidden but retained, got %d visible and %d stored", visible, stored)
+	}
+
+	// Within the grace period nothing is purged and the mapping can be restored
+	if purged, err := db.PurgeDeleted(time.Now().Add(-24 * time.Hour)); err != nil || purged != 0 {
+		t.Fatalf("Expected nothing purged within the grace period, got %d (err %v)", purged, err)
+	}
+	if _, err := db.RestoreEmailMapping(mapping.ID); err != nil {
+		t.Fatalf("Failed to restore mapping: %v", err)
+	}
+	if got, _ := db.GetEmailMapping(mapping.GeneratedEmail); got == nil {
+		t.Error("Expected restored mapping to be active again")
+	}
+	if visible, _ := countLogs(); visible != 1 {
+		t.Errorf("Expected restored mapping's log to be visible, got %d", visible)
+	}
+
+	// Once the grace period has passed, the tombstones are removed for good
+	if err := db.DeleteEmailMapping(mapping.GeneratedEmail, user.ID); err != nil {
+		t.Fatalf("Failed to delete mapping: %v", err)
+	}
+	if purged, err := db.PurgeDeleted(time.Now().Add(time.Minute)); err != nil || purged != 1 {
+		t.Fatalf("Expected the mapping purged after the grace period, got %d (err %v)", purged, err)
+	}
+	if _, stored := countLogs(); stored != 0 {
+		t.Errorf("Expected purged logs to be removed, got %d", stored)
+	}
+	if deleted, _ := db.GetDeletedMappings(); len(deleted) != 0 {
+		t.Errorf("Expected no deleted mappings left, got %d", len(deleted))
+	}
+}
+
+func TestDeleteEmailMapping_NoGracePeriod(t *testing.T) {
+	db := newTestDB(t, &Config{Domain: "example.com"})
+	user, err := db.CreateUser("owner@example.com", "user")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	mapping, err := db.CreateEmailMapping(user.ID, "https://example.com/hook", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+	if err := db.LogEmailProcessing(mapping.GeneratedEmail, "hello", "success", "", nil, user.ID, "req-1", ""); err != nil {
+		t.Fatalf("Failed to log email: %v", err)
+	}
+
+	if err := db.DeleteEmailMapping(mapping.GeneratedEmail, user.ID); err != nil {
+		t.Fatalf("Failed to delete mapping: %v", err)
+	}
+	var stored int64
+	db.Unscoped().Model(&EmailLog{}).Count(&stored)
+	if stored != 0 {
+		t.Errorf("Expected logs deleted immediately without a grace period, got %d", stored)
+	}
+}
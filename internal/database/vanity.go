@@ -0,0 +1,101 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// vanityNamePattern limits custom local parts to characters that are safe in
+// an address without quoting
+var vanityNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// VanityConfig controls user-chosen (vanity) local parts for generated addresses
+type VanityConfig struct {
+	Enabled      bool
+	MinLength    int      // Minimum length of the local part
+	Blocklist    []string // Disallowed substrings, matched case-insensitively
+	BlockPattern string   // Optional regex of disallowed local parts
+}
+
+// Validate checks a requested vanity local part against the configured rules
+func (c VanityConfig) Validate(localPart string) error {
+	if !c.Enabled {
+		return fmt.Errorf("custom addresses are not enabled")
+	}
+
+	name := strings.ToLower(strings.TrimSpace(localPart))
+	if len(name) < c.MinLength {
+		return fmt.Errorf("custom address must be at least %d characters", c.MinLength)
+	}
+	if !vanityNamePattern.MatchString(name) {
+		return fmt.Errorf("custom address may only contain letters, digits, '.', '_' and '-'")
+	}
+
+	for _, term := range c.Blocklist {
+		term = strings.ToLower(strings.TrimSpace(term))
+		if term != "" && strings.Contains(name, term) {
+			return fmt.Errorf("custom address contains a disallowed term")
+		}
+	}
+
+	if c.BlockPattern != "" {
+		re, err := regexp.Compile(c.BlockPattern)
+		if err != nil {
+			return fmt.Errorf("invalid vanity block pattern: %w", err)
+		}
+		if re.MatchString(name) {
+			return fmt.Errorf("custom address is not allowed")
+		}
+	}
+
+	return nil
+}
+
+// CreateVanityEmailMapping creates a new email mapping using a user-chosen local part
+func (db *DB) CreateVanityEmailMapping(userID uint, localPart, endpoint, description string, headers map[string]string) (*EmailMapping, error) {
+	if err := db.validateDomain(); err != nil {
+		return nil, err
+	}
+	if err := db.config.Vanity.Validate(localPart); err != nil {
+		return nil, err
+	}
+	endpoint, err := normalizeEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.validateEndpoint(endpoint); err != nil {
+		return nil, err
+	}
+
+	generatedEmail := fmt.Sprintf("%s@%s", strings.ToLower(strings.TrimSpace(localPart)), db.config.Domain)
+
+	var count int64
+	// Unscoped: deleted mappings keep their address until purged
+	if err := db.Unscoped().Model(&EmailMapping{}).Where("generated_email = ?", generatedEmail).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to check email uniqueness: %w", err)
+	}
+	if count > 0 {
+		return nil, fmt.Errorf("address %s is already taken", generatedEmail)
+	}
+
+	headers, err = db.withDefaultHeaders(userID, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := &EmailMapping{
+		UserID:         userID,
+		GeneratedEmail: generatedEmail,
+		EndpointURL:    endpoint,
+		Description:    description,
+		Headers:        headers,
+		IsActive:       true,
+	}
+
+	if err := db.Create(mapping).Error; err != nil {
+		return nil, fmt.Errorf("failed to create mapping: %w", err)
+	}
+
+	return mapping, nil
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCreateVanityEmailMapping(t *testing.T) {
+	db := newTestDB(t, &Config{
+		Domain: "example.com",
+		Vanity: VanityConfig{
+			Enabled:   true,
+			MinLength: 5,
+			Blocklist: []string{"admin", "Postmaster"},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		vanity  string
+		wantErr string
+	}{
+		{name: "allowed", vanity: "billing-alerts"},
+		{name: "blocked term", vanity: "the-admin-desk", wantErr: "disallowed term"},
+		{name: "blocked term case-insensitive", vanity: "POSTMASTER1", wantErr: "disallowed term"},
+		{name: "too short", vanity: "abc", wantErr: "at least 5 characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping, err := db.CreateVanityEmailMapping(1, tt.vanity, "https://example.com/hook", "", nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if mapping.GeneratedEmail != tt.vanity+"@example.com" {
+				t.Errorf("Expected address %s@example.com, got %s", tt.vanity, mapping.GeneratedEmail)
+			}
+		})
+	}
+}
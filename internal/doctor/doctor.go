@@ -0,0 +1,174 @@
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/config"
+	"github.com/looprock/email-to-api/internal/database"
+	"github.com/looprock/email-to-api/internal/email"
+)
+
+// Check statuses
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+	StatusSkip = "skip"
+)
+
+// Result is the outcome of one check
+type Result struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+// Run checks the configuration and the services it points at without
+// starting any server: the database connection, the migration state, the
+// Mailgun credentials and the SMTP listen address. It writes a report to w
+// and returns false if any check failed.
+func Run(cfg *config.Config, dbConfig *database.Config, w io.Writer) bool {
+	results := Check(cfg, dbConfig)
+
+	ok := true
+	for _, result := range results {
+		fmt.Fprintf(w, "[%-4s] %s", result.Status, result.Name)
+		if result.Detail != "" {
+			fmt.Fprintf(w, ": %s", result.Detail)
+		}
+		fmt.Fprintln(w)
+		if result.Status == StatusFail {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// Check runs every check and returns the results in order
+func Check(cfg *config.Config, dbConfig *database.Config) []Result {
+	results := checkConfig(cfg)
+
+	// Only try the database once so an outage is reported rather than waited out
+	once := *dbConfig
+	once.ConnectAttempts = 1
+	db, err := database.New(&once)
+	if err != nil {
+		results = append(results,
+			Result{Name: "database", Status: StatusFail, Detail: err.Error()},
+			Result{Name: "migrations", Status: StatusSkip, Detail: "database unreachable"})
+	} else {
+		defer db.Close()
+		results = append(results,
+			Result{Name: "database", Status: StatusOK, Detail: dbConfig.Driver},
+			checkMigrations(db))
+	}
+
+	return append(results, checkMailgun(cfg), checkSMTPListen(cfg))
+}
+
+// checkConfig validates values that would otherwise only fail once the
+// servers are running
+func checkConfig(cfg *config.Config) []Result {
+	var errs []error
+	switch cfg.Database.Driver {
+	case "sqlite", "sqlite3", "postgres":
+	default:
+		errs = append(errs, fmt.Errorf("unsupported database.driver %q", cfg.Database.Driver))
+	}
+	switch cfg.MailServer.ReceiveMethod {
+	case "smtp", "webhook", "mailgun-webhook":
+	default:
+		errs = append(errs, fmt.Errorf("unknown mailserver.receivemethod %q", cfg.MailServer.ReceiveMethod))
+	}
+	if cfg.MailServer.ReceiveMethod != "smtp" && cfg.WebhookSigningKey() == "" && !cfg.MailServer.WebhookInsecureSkipVerify {
+		errs = append(errs, errors.New("mailserver.webhooksigningkey is required to receive email by webhook"))
+	}
+	if len(cfg.AcceptedDomains()) == 0 {
+		errs = append(errs, errors.New("mailserver.domain or mailserver.accepteddomains is required"))
+	}
+	if cfg.MailServer.MaxEmailSize <= 0 {
+		errs = append(errs, fmt.Errorf("mailserver.maxemailsize must be positive, got %d", cfg.MailServer.MaxEmailSize))
+	}
+	if cfg.MailServer.ReceiveMethod == "smtp" && (cfg.MailServer.SMTPPort <= 0 || cfg.MailServer.SMTPPort > 65535) {
+		errs = append(errs, fmt.Errorf("mailserver.smtpport %d is out of range", cfg.MailServer.SMTPPort))
+	}
+	for _, option := range []struct {
+		key, value string
+		allowed    []string
+	}{
+		{"mailserver.retryjitter", cfg.MailServer.RetryJitter, []string{"", email.JitterNone, email.JitterFull, email.JitterEqual}},
+		{"mailserver.lookuperror", cfg.MailServer.LookupError, []string{"", email.LookupErrorRetry}},
+		{"mailserver.invalidsender", cfg.MailServer.InvalidSender, []string{"", email.InvalidSenderReject, email.InvalidSenderDrop}},
+		{"mailserver.unknownrecipient", cfg.MailServer.UnknownRecipient, []string{"", email.UnknownRecipientReject, email.UnknownRecipientBounce}},
+		{"mailgun.validate", cfg.Mailgun.Validate, []string{"", email.MailgunValidateAsync, email.MailgunValidateSync, email.MailgunValidateOff}},
+	} {
+		if !slices.Contains(option.allowed, option.value) {
+			errs = append(errs, fmt.Errorf("invalid %s %q", option.key, option.value))
+		}
+	}
+
+	if _, err := email.NewBounceTemplate(cfg.MailServer.BounceSubject, cfg.MailServer.BounceTemplate); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		results := make([]Result, 0, len(errs))
+		for _, err := range errs {
+			results = append(results, Result{Name: "config", Status: StatusFail, Detail: err.Error()})
+		}
+		return results
+	}
+	return []Result{{Name: "config", Status: StatusOK}}
+}
+
+// checkMigrations reports a dirty schema or migrations not yet applied
+func checkMigrations(db *database.DB) Result {
+	status, err := db.MigrationStatus()
+	switch {
+	case err != nil:
+		return Result{Name: "migrations", Status: StatusFail, Detail: err.Error()}
+	case status.Dirty:
+		return Result{Name: "migrations", Status: StatusFail,
+			Detail: fmt.Sprintf("schema is dirty at version %d; repair it and run the mail server with -force-migration", status.Version)}
+	case status.Pending():
+		return Result{Name: "migrations", Status: StatusWarn,
+			Detail: fmt.Sprintf("at version %d of %d; the mail server applies the rest at startup", status.Version, status.Latest)}
+	}
+	return Result{Name: "migrations", Status: StatusOK, Detail: fmt.Sprintf("at version %d", status.Version)}
+}
+
+// checkMailgun verifies the Mailgun credentials when Mailgun is configured
+func checkMailgun(cfg *config.Config) Result {
+	sender, err := email.NewMailgunSender(cfg.Mailgun.SiteDomain, email.MailgunValidation{
+		Mode:    email.MailgunValidateSync,
+		Timeout: time.Duration(cfg.Mailgun.ValidateTimeout) * time.Second,
+	})
+	switch {
+	case err != nil:
+		return Result{Name: "mailgun", Status: StatusFail, Detail: err.Error()}
+	case sender == nil:
+		return Result{Name: "mailgun", Status: StatusSkip, Detail: "not configured"}
+	}
+	return Result{Name: "mailgun", Status: StatusOK}
+}
+
+// checkSMTPListen confirms the SMTP listen address is free to bind
+func checkSMTPListen(cfg *config.Config) Result {
+	if cfg.MailServer.ReceiveMethod != "smtp" {
+		return Result{Name: "smtp listen", Status: StatusSkip, Detail: "receive method is " + cfg.MailServer.ReceiveMethod}
+	}
+
+	addr := net.JoinHostPort(cfg.MailServer.SMTPHost, strconv.Itoa(cfg.MailServer.SMTPPort))
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return Result{Name: "smtp listen", Status: StatusFail, Detail: err.Error()}
+	}
+	ln.Close()
+	return Result{Name: "smtp listen", Status: StatusOK, Detail: addr}
+}
@@ -0,0 +1,112 @@
+package doctor
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/config"
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// newTestConfig returns a valid configuration using a fresh SQLite database
+// with one migration available
+func newTestConfig(t *testing.T) (*config.Config, *database.Config) {
+	t.Helper()
+	t.Setenv("MAILGUN_API_KEY", "")
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "001_create_widgets.up.sql"), []byte("CREATE TABLE widgets (id INTEGER PRIMARY KEY);"), 0644); err != nil {
+		t.Fatalf("Failed to write migration: %v", err)
+	}
+
+	var cfg config.Config
+	cfg.Database.Driver = "sqlite"
+	cfg.MailServer.Domain = "example.com"
+	cfg.MailServer.ReceiveMethod = "smtp"
+	cfg.MailServer.MaxEmailSize = 1024 * 1024
+	cfg.MailServer.SMTPHost = "127.0.0.1"
+	cfg.MailServer.SMTPPort = freePort(t)
+
+	dsn := filepath.Join(t.TempDir(), "doctor.db")
+	return &cfg, &database.Config{
+		Driver:           "sqlite",
+		DSN:              dsn,
+		MigrateURL:       "sqlite3://" + dsn,
+		MigrationsSource: "file://" + dir,
+	}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func statuses(results []Result) map[string]string {
+	byName := make(map[string]string)
+	for _, result := range results {
+		// Keep the worst status for checks reporting several results
+		if byName[result.Name] != StatusFail {
+			byName[result.Name] = result.Status
+		}
+	}
+	return byName
+}
+
+func TestCheck_ValidConfig(t *testing.T) {
+	cfg, dbConfig := newTestConfig(t)
+
+	got := statuses(Check(cfg, dbConfig))
+	want := map[string]string{
+		"config":      StatusOK,
+		"database":    StatusOK,
+		"migrations":  StatusWarn, // The migration hasn't been applied yet
+		"mailgun":     StatusSkip,
+		"smtp listen": StatusOK,
+	}
+	for name, status := range want {
+		if got[name] != status {
+			t.Errorf("Check %q = %q, want %q", name, got[name], status)
+		}
+	}
+}
+
+func TestRun_BadConfig(t *testing.T) {
+	cfg, dbConfig := newTestConfig(t)
+	cfg.Database.Driver = "oracle"
+	cfg.MailServer.RetryJitter = "sometimes"
+	dbConfig.Driver = "oracle"
+
+	// Occupy the SMTP port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	cfg.MailServer.SMTPPort = ln.Addr().(*net.TCPAddr).Port
+
+	var report bytes.Buffer
+	if Run(cfg, dbConfig, &report) {
+		t.Fatalf("Expected Run to report failure, got:\n%s", report.String())
+	}
+
+	for _, want := range []string{
+		`[fail] config: unsupported database.driver "oracle"`,
+		`[fail] config: invalid mailserver.retryjitter "sometimes"`,
+		"[fail] database: unsupported database driver: oracle",
+		"[skip] migrations: database unreachable",
+		"[fail] smtp listen:",
+	} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("Report missing %q:\n%s", want, report.String())
+		}
+	}
+}
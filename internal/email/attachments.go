@@ -0,0 +1,92 @@
+package email
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+)
+
+// defaultAttachmentURLExpiry is how long presigned attachment URLs stay valid
+const defaultAttachmentURLExpiry = 7 * 24 * time.Hour
+
+// unsafeKeyChars matches characters replaced in object keys
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// AttachmentData is an attachment in the forwarded payload. Small attachments
+// (or all of them when no object store is configured) are inlined as base64
+// Content; larger ones are uploaded and referenced by a presigned URL.
+type AttachmentData struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	ContentID   string `json:"content_id,omitempty"`
+	Size        int    `json:"size"`
+	Content     string `json:"content,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+// AttachmentConfig controls how attachments are included in payloads
+type AttachmentConfig struct {
+	// Store receives attachments larger than InlineMaxBytes; nil inlines everything
+	Store          ObjectStore
+	InlineMaxBytes int
+	URLExpiry      time.Duration
+}
+
+// prepareAttachments converts an email's attachments for the payload,
+// uploading large ones to the object store. Uploads that fail fall back to
+// inline content so no attachment is lost.
+func (p *Processor) prepareAttachments(logger *log.Logger, email Email) []AttachmentData {
+	if len(email.Attachments) == 0 {
+		return nil
+	}
+
+	cfg := p.config.Attachments
+	result := make([]AttachmentData, 0, len(email.Attachments))
+	for i, att := range email.Attachments {
+		data := AttachmentData{
+			Filename:    att.Filename,
+			ContentType: att.ContentType,
+			ContentID:   att.ContentID,
+			Size:        len(att.Data),
+		}
+
+		if cfg.Store != nil && len(att.Data) > cfg.InlineMaxBytes {
+			key := fmt.Sprintf("%s/%d-%s", email.RequestID, i, unsafeKeyChars.ReplaceAllString(att.Filename, "_"))
+			url, err := p.uploadAttachment(key, att)
+			if err == nil {
+				data.URL = url
+				result = append(result, data)
+				continue
+			}
+			logger.Printf("Failed to upload attachment %q, inlining it instead: %v", att.Filename, err)
+		}
+
+		data.Content = base64.StdEncoding.EncodeToString(att.Data)
+		result = append(result, data)
+	}
+	return result
+}
+
+// uploadAttachment stores an attachment and returns its presigned URL
+func (p *Processor) uploadAttachment(key string, att Attachment) (string, error) {
+	cfg := p.config.Attachments
+	expiry := cfg.URLExpiry
+	if expiry <= 0 {
+		expiry = defaultAttachmentURLExpiry
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	contentType := att.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if err := cfg.Store.Put(ctx, key, contentType, att.Data); err != nil {
+		return "", err
+	}
+	return cfg.Store.PresignGet(key, expiry)
+}
@@ -0,0 +1,131 @@
+package email
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// fakeStore is an in-memory ObjectStore
+type fakeStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *fakeStore) Put(ctx context.Context, key, contentType string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *fakeStore) PresignGet(key string, expiry time.Duration) (string, error) {
+	return "https://storage.example.com/" + key + "?expires=" + expiry.String(), nil
+}
+
+func TestProcessor_AttachmentsUploadedToObjectStore(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	store := &fakeStore{objects: make(map[string][]byte)}
+	processor := New(db, ProcessorConfig{
+		MaxSize:       1024 * 1024,
+		RetryAttempts: 1,
+		Synchronous:   true,
+		Attachments:   AttachmentConfig{Store: store, InlineMaxBytes: 16},
+	})
+
+	large := []byte(strings.Repeat("x", 1024))
+	err := processor.Process(Email{
+		From:      "sender@example.com",
+		To:        mapping.GeneratedEmail,
+		RequestID: "req123",
+		Attachments: []Attachment{
+			{Filename: "note.txt", ContentType: "text/plain", Data: []byte("small")},
+			{Filename: "big report.pdf", ContentType: "application/pdf", Data: large},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	if len(data.Data.Attachments) != 2 {
+		t.Fatalf("Expected 2 attachments in payload, got %d", len(data.Data.Attachments))
+	}
+
+	small := data.Data.Attachments[0]
+	if small.URL != "" || small.Content != base64.StdEncoding.EncodeToString([]byte("small")) {
+		t.Errorf("Expected small attachment inline, got %+v", small)
+	}
+
+	uploaded := data.Data.Attachments[1]
+	if uploaded.Content != "" || uploaded.Size != len(large) {
+		t.Errorf("Expected large attachment not to be inlined, got size %d", uploaded.Size)
+	}
+	if uploaded.URL != "https://storage.example.com/req123/1-big_report.pdf?expires=168h0m0s" {
+		t.Errorf("Unexpected attachment URL %q", uploaded.URL)
+	}
+	if got := store.objects["req123/1-big_report.pdf"]; string(got) != string(large) {
+		t.Errorf("Expected attachment to be uploaded, got %d bytes", len(got))
+	}
+}
+
+func TestS3Store_PutAndPresign(t *testing.T) {
+	var gotPath, gotAuth, gotBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	store, err := NewS3Store(S3Config{
+		Endpoint:  ts.URL,
+		Region:    "eu-west-1",
+		Bucket:    "mail",
+		AccessKey: "AKID",
+		SecretKey: "secret",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+
+	if err := store.Put(context.Background(), "req/a file.txt", "text/plain", []byte("hello")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if gotPath != "/mail/req/a file.txt" || gotBody != "hello" {
+		t.Errorf("Unexpected upload path %q body %q", gotPath, gotBody)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AKID/20240501/eu-west-1/s3/aws4_request") {
+		t.Errorf("Expected SigV4 authorization, got %q", gotAuth)
+	}
+
+	url, err := store.PresignGet("req/a file.txt", time.Hour)
+	if err != nil {
+		t.Fatalf("PresignGet failed: %v", err)
+	}
+	for _, want := range []string{ts.URL + "/mail/req/a%20file.txt?", "X-Amz-Expires=3600", "X-Amz-Signature="} {
+		if !strings.Contains(url, want) {
+			t.Errorf("Expected presigned URL to contain %q, got %s", want, url)
+		}
+	}
+}
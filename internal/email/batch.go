@@ -0,0 +1,152 @@
+package email
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// defaultBatchWindow is used when a batched mapping has no window configured
+const defaultBatchWindow = 60 * time.Second
+
+// batchItem is a single email waiting in a batch
+type batchItem struct {
+	payload   ProcessedData
+	requestID string
+	size      int
+}
+
+// pendingBatch collects emails for one mapping until it is flushed
+type pendingBatch struct {
+	mapping database.EmailMapping
+	items   []batchItem
+	bytes   int
+	timer   *time.Timer
+}
+
+// addToBatch buffers an email for a batched mapping, delivering the batch once
+// it reaches the mapping's size or byte limit
+func (p *Processor) addToBatch(mapping *database.EmailMapping, payload ProcessedData, requestID string) {
+	logger := requestLogger(requestID)
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		logger.Printf("Failed to measure batched payload: %v", err)
+	}
+	item := batchItem{payload: payload, requestID: requestID, size: len(data)}
+
+	var ready []*pendingBatch
+
+	p.batchMu.Lock()
+	b := p.batches[mapping.ID]
+
+	// Flush first if this email would push the batch over its byte limit
+	if b != nil && mapping.BatchMaxBytes > 0 && b.bytes+item.size > mapping.BatchMaxBytes {
+		logger.Printf("Batch for mapping %d would exceed %d bytes, flushing early", mapping.ID, mapping.BatchMaxBytes)
+		ready = append(ready, p.takeBatchLocked(b))
+		b = nil
+	}
+
+	if b == nil {
+		window := time.Duration(mapping.BatchWindow) * time.Second
+		if window <= 0 {
+			window = defaultBatchWindow
+		}
+		b = &pendingBatch{mapping: *mapping}
+		batch := b
+		b.timer = time.AfterFunc(window, func() { p.flushBatch(batch) })
+		p.batches[mapping.ID] = b
+	}
+
+	b.items = append(b.items, item)
+	b.bytes += item.size
+	p.batched.Add(1)
+	logger.Printf("Added email to batch for mapping %d (%d/%d)", mapping.ID, len(b.items), mapping.BatchSize)
+
+	if len(b.items) >= mapping.BatchSize {
+		ready = append(ready, p.takeBatchLocked(b))
+	}
+	p.batchMu.Unlock()
+
+	for _, batch := range ready {
+		p.deliverBatch(batch)
+	}
+}
+
+// takeBatchLocked removes a batch from the pending set; batchMu must be held
+func (p *Processor) takeBatchLocked(b *pendingBatch) *pendingBatch {
+	b.timer.Stop()
+	if p.batches[b.mapping.ID] == b {
+		delete(p.batches, b.mapping.ID)
+	}
+	return b
+}
+
+// flushBatch delivers a batch when its window ends, unless it was already taken
+func (p *Processor) flushBatch(b *pendingBatch) {
+	p.batchMu.Lock()
+	if p.batches[b.mapping.ID] != b {
+		p.batchMu.Unlock()
+		return
+	}
+	p.takeBatchLocked(b)
+	p.batchMu.Unlock()
+
+	p.deliverBatch(b)
+}
+
+// deliverBatch posts all emails in a batch as one JSON array, retrying the
+// whole batch together, and logs the outcome for each email
+func (p *Processor) deliverBatch(b *pendingBatch) {
+	defer p.batched.Add(-int64(len(b.items)))
+
+	batchID := newRequestID()
+	logger := requestLogger(batchID)
+	mapping := b.mapping
+
+	payloads := make([]ProcessedData, len(b.items))
+	for i, item := range b.items {
+		payloads[i] = item.payload
+	}
+
+	logger.Printf("Delivering batch of %d emails (%d bytes) to endpoint %q", len(payloads), b.bytes, mapping.EndpointURL)
+
+	var lastErr error
+	data, err := marshalPayload(&mapping, payloads)
+	if err != nil {
+		lastErr = fmt.Errorf("failed to marshal batch: %w", err)
+	} else {
+		lastErr = p.sendWithRetry(logger, mapping.EndpointURL, nil, func() error {
+			return p.postJSON(&mapping, data, batchID, batchID)
+		})
+	}
+
+	status, errorMsg := "success", ""
+	if lastErr != nil {
+		status, errorMsg = "error", fmt.Sprintf("batch %s failed: %v", batchID, lastErr)
+		if statusAction(lastErr) == database.StatusActionDrop {
+			status = "dropped"
+		}
+		logger.Printf("Batch delivery failed: %v", lastErr)
+	}
+
+	for _, item := range b.items {
+		if err := p.logProcessing(
+			&mapping,
+			item.payload.Data.To,
+			item.payload.Data.Subject,
+			status,
+			errorMsg,
+			item.requestID,
+			item.payload.Data.ReceivedFrom,
+		); err != nil {
+			logger.Printf("Failed to log batched email: %v", err)
+		}
+		if status == "error" {
+			email := Email{RequestID: item.requestID, From: item.payload.Data.From, Subject: item.payload.Data.Subject}
+			p.saveFailedItem(logger, &mapping, email, item.payload, lastErr)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package email
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestProcessor_BatchDelivery(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]ProcessedData
+	var method string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		var batch []ProcessedData
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("Expected a JSON array body: %v", err)
+		}
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{BatchSize: 3, BatchWindow: 60, HTTPMethod: database.MethodPut})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	for i := 0; i < 3; i++ {
+		if err := processor.Process(Email{
+			From:    "sender@example.com",
+			To:      mapping.GeneratedEmail,
+			Subject: fmt.Sprintf("email %d", i),
+		}); err != nil {
+			t.Fatalf("Failed to process email: %v", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 1 {
+		t.Fatalf("Expected 1 batched request, got %d", len(batches))
+	}
+	if len(batches[0]) != 3 {
+		t.Fatalf("Expected 3 emails in the batch, got %d", len(batches[0]))
+	}
+	if batches[0][2].Data.Subject != "email 2" {
+		t.Errorf("Expected batch to preserve order, got subject %q", batches[0][2].Data.Subject)
+	}
+	if method != "PUT" {
+		t.Errorf("Expected the batch to use the mapping's method, got %s", method)
+	}
+
+	var logged int64
+	db.Model(&database.EmailLog{}).Where("status = ?", "success").Count(&logged)
+	if logged != 3 {
+		t.Errorf("Expected 3 success log entries, got %d", logged)
+	}
+}
+
+func TestProcessor_BatchMaxBytesFlushesEarly(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []ProcessedData
+		json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		sizes = append(sizes, len(batch))
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	// Each payload is several hundred bytes, so only one fits per batch
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{BatchSize: 2, BatchWindow: 60, BatchMaxBytes: 100})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	for i := 0; i < 2; i++ {
+		if err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "big"}); err != nil {
+			t.Fatalf("Failed to process email: %v", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sizes) != 1 || sizes[0] != 1 {
+		t.Errorf("Expected the first email to be flushed alone when the byte limit was hit, got batches %v", sizes)
+	}
+}
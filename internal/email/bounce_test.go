@@ -0,0 +1,95 @@
+package email
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// sampleDSN is a minimal delivery status notification (RFC 3464)
+const sampleDSN = "From: MAILER-DAEMON@mx.example.net\r\n" +
+	"Subject: Undelivered Mail Returned to Sender\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Your message could not be delivered.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example.net\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; nobody@example.org\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"--b1--\r\n"
+
+func TestIsDeliveryReport(t *testing.T) {
+	if !isDeliveryReport(ParseMessage([]byte(sampleDSN)).ContentType) {
+		t.Error("Expected the sample DSN to be recognized")
+	}
+	if isDeliveryReport("multipart/report; report-type=disposition-notification") {
+		t.Error("Expected a read receipt not to be treated as a bounce")
+	}
+	if isDeliveryReport("text/plain") {
+		t.Error("Expected plain text not to be treated as a bounce")
+	}
+}
+
+func TestProcessor_BounceHandling(t *testing.T) {
+	tests := []struct {
+		name         string
+		action       string
+		wantEndpoint int
+		wantBounce   int
+		wantLog      string
+	}{
+		{name: "forward", action: "", wantEndpoint: 1, wantLog: "success"},
+		{name: "drop", action: database.BounceActionDrop, wantLog: "bounce"},
+		{name: "route", action: database.BounceActionRoute, wantBounce: 1, wantLog: "success"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var endpointHits, bounceHits int
+			endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				endpointHits++
+			}))
+			defer endpoint.Close()
+			bounces := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				bounceHits++
+			}))
+			defer bounces.Close()
+
+			db := newTestDB(t)
+			mapping := createTestMapping(t, db, endpoint.URL, database.MappingOptions{
+				BounceAction:   tt.action,
+				BounceEndpoint: bounces.URL,
+			})
+			processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+			email := ParseMessage([]byte(sampleDSN))
+			email.From = ""
+			email.To = mapping.GeneratedEmail
+			if err := processor.Process(email); err != nil {
+				t.Fatalf("Failed to process bounce: %v", err)
+			}
+
+			if endpointHits != tt.wantEndpoint || bounceHits != tt.wantBounce {
+				t.Errorf("Expected %d endpoint and %d bounce endpoint requests, got %d and %d",
+					tt.wantEndpoint, tt.wantBounce, endpointHits, bounceHits)
+			}
+			var entry database.EmailLog
+			if err := db.Last(&entry).Error; err != nil {
+				t.Fatalf("Failed to read log: %v", err)
+			}
+			if entry.Status != tt.wantLog {
+				t.Errorf("Expected log status %q, got %q", tt.wantLog, entry.Status)
+			}
+		})
+	}
+}
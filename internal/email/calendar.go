@@ -0,0 +1,153 @@
+package email
+
+import (
+	"strings"
+	"time"
+)
+
+// CalendarEvent holds the key fields of the first event in a calendar
+// invite (an iCalendar text/calendar part)
+type CalendarEvent struct {
+	// Method is the iTIP method, e.g. REQUEST for an invite or CANCEL
+	Method  string `json:"method,omitempty"`
+	UID     string `json:"uid,omitempty"`
+	Summary string `json:"summary,omitempty"`
+	// Start and End are RFC 3339 timestamps, or YYYY-MM-DD for all-day
+	// events. Floating times carry no offset; values with an unknown time
+	// zone are left as written.
+	Start         string `json:"start,omitempty"`
+	End           string `json:"end,omitempty"`
+	Location      string `json:"location,omitempty"`
+	Organizer     string `json:"organizer,omitempty"`
+	OrganizerName string `json:"organizer_name,omitempty"`
+}
+
+// isCalendarType reports whether a media type carries iCalendar data
+func isCalendarType(mediaType string) bool {
+	return mediaType == "text/calendar" || mediaType == "application/ics"
+}
+
+// parseCalendar extracts the first VEVENT from iCalendar data, returning nil
+// if there is none
+func parseCalendar(data string) *CalendarEvent {
+	var event CalendarEvent
+	var method string
+	inEvent, found := false, false
+
+	for _, line := range unfoldCalendarLines(data) {
+		name, params, value := splitCalendarLine(line)
+		switch {
+		case name == "METHOD" && !inEvent:
+			method = value
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT") && !found:
+			inEvent, found = true, true
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			inEvent = false
+		case !inEvent:
+			// Properties of the calendar or of other components
+		case name == "UID":
+			event.UID = value
+		case name == "SUMMARY":
+			event.Summary = unescapeCalendarText(value)
+		case name == "LOCATION":
+			event.Location = unescapeCalendarText(value)
+		case name == "DTSTART":
+			event.Start = calendarTime(value, params)
+		case name == "DTEND":
+			event.End = calendarTime(value, params)
+		case name == "ORGANIZER":
+			event.Organizer = strings.TrimPrefix(strings.TrimPrefix(value, "mailto:"), "MAILTO:")
+			event.OrganizerName = strings.Trim(params["CN"], `"`)
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	event.Method = method
+	return &event
+}
+
+// unfoldCalendarLines splits iCalendar data into content lines, joining
+// continuation lines (starting with a space or tab) onto the previous one
+func unfoldCalendarLines(data string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// splitCalendarLine splits a content line such as
+// "DTSTART;TZID=Europe/Paris:20261020T150000" into its upper-cased name,
+// parameters and value
+func splitCalendarLine(line string) (string, map[string]string, string) {
+	// The value starts at the first colon outside a quoted parameter value
+	colon, quoted := -1, false
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return strings.ToUpper(line), nil, ""
+	}
+
+	fields := strings.Split(line[:colon], ";")
+	params := make(map[string]string, len(fields)-1)
+	for _, param := range fields[1:] {
+		if key, value, ok := strings.Cut(param, "="); ok {
+			params[strings.ToUpper(key)] = value
+		}
+	}
+	return strings.ToUpper(fields[0]), params, line[colon+1:]
+}
+
+// calendarTime converts an iCalendar DATE or DATE-TIME value to RFC 3339,
+// or to YYYY-MM-DD for dates. Values that can't be parsed are returned as is.
+func calendarTime(value string, params map[string]string) string {
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		if t, err := time.Parse("20060102", value); err == nil {
+			return t.Format(time.DateOnly)
+		}
+		return value
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		if t, err := time.Parse("20060102T150405Z", value); err == nil {
+			return t.Format(time.RFC3339)
+		}
+		return value
+	}
+
+	tzid := strings.Trim(params["TZID"], `"`)
+	if tzid == "" {
+		// Floating time, the same wall clock time in every zone
+		if t, err := time.Parse("20060102T150405", value); err == nil {
+			return t.Format("2006-01-02T15:04:05")
+		}
+		return value
+	}
+	loc, err := time.LoadLocation(tzid)
+	if err != nil {
+		return value
+	}
+	if t, err := time.ParseInLocation("20060102T150405", value, loc); err == nil {
+		return t.Format(time.RFC3339)
+	}
+	return value
+}
+
+// unescapeCalendarText undoes iCalendar TEXT escaping
+func unescapeCalendarText(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,89 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+// inviteMessage is a meeting invite as sent by common calendar clients:
+// a plain text description alongside a text/calendar part
+var inviteMessage = strings.Join([]string{
+	"From: alice@example.com",
+	"Subject: Invitation: Quarterly review",
+	"MIME-Version: 1.0",
+	`Content-Type: multipart/alternative; boundary="inv"`,
+	"",
+	"--inv",
+	"Content-Type: text/plain; charset=utf-8",
+	"",
+	"You have been invited to Quarterly review",
+	"--inv",
+	`Content-Type: text/calendar; charset=utf-8; method=REQUEST`,
+	"",
+	"BEGIN:VCALENDAR",
+	"VERSION:2.0",
+	"METHOD:REQUEST",
+	"BEGIN:VTIMEZONE",
+	"TZID:Europe/Paris",
+	"END:VTIMEZONE",
+	"BEGIN:VEVENT",
+	"UID:review-42@example.com",
+	"SUMMARY:Quarterly review\\, Q3",
+	"DTSTART;TZID=Europe/Paris:20261020T150000",
+	"DTEND;TZID=Europe/Paris:20261020T160000",
+	"LOCATION:Room 4",
+	`ORGANIZER;CN="Alice Example":mailto:alice@examp`,
+	" le.com",
+	"END:VEVENT",
+	"END:VCALENDAR",
+	"--inv--",
+	"",
+}, "\r\n")
+
+func TestParseMessage_CalendarInvite(t *testing.T) {
+	email := ParseMessage([]byte(inviteMessage))
+
+	if email.Calendar == nil {
+		t.Fatal("Expected the calendar invite to be parsed")
+	}
+	want := CalendarEvent{
+		Method:        "REQUEST",
+		UID:           "review-42@example.com",
+		Summary:       "Quarterly review, Q3",
+		Start:         "2026-10-20T15:00:00+02:00",
+		End:           "2026-10-20T16:00:00+02:00",
+		Location:      "Room 4",
+		Organizer:     "alice@example.com",
+		OrganizerName: "Alice Example",
+	}
+	if *email.Calendar != want {
+		t.Errorf("Calendar = %+v, want %+v", *email.Calendar, want)
+	}
+	if email.PlainBody != "You have been invited to Quarterly review" {
+		t.Errorf("Expected plain body to be kept, got %q", email.PlainBody)
+	}
+}
+
+func TestCalendarTime(t *testing.T) {
+	tests := []struct {
+		value  string
+		params map[string]string
+		want   string
+	}{
+		{value: "20261020T130000Z", want: "2026-10-20T13:00:00Z"},
+		{value: "20261020", params: map[string]string{"VALUE": "DATE"}, want: "2026-10-20"},
+		{value: "20261020T150000", want: "2026-10-20T15:00:00"},
+		{value: "20261020T150000", params: map[string]string{"TZID": "Nowhere/Unknown"}, want: "20261020T150000"},
+	}
+	for _, tt := range tests {
+		if got := calendarTime(tt.value, tt.params); got != tt.want {
+			t.Errorf("calendarTime(%q, %v) = %q, want %q", tt.value, tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestParseMessage_NoCalendar(t *testing.T) {
+	if email := ParseMessage([]byte(inlineImageMessage)); email.Calendar != nil {
+		t.Errorf("Expected no calendar, got %+v", email.Calendar)
+	}
+}
@@ -0,0 +1,176 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// Chat message limits; Slack truncates or rejects longer header and
+// section texts
+const (
+	chatSubjectLimit = 150
+	chatSnippetLimit = 500
+)
+
+// slackMessage is a Slack incoming webhook message using Block Kit
+type slackMessage struct {
+	Text   string       `json:"text"` // Notification fallback
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// teamsMessage is a Teams incoming webhook message carrying an Adaptive Card
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string    `json:"contentType"`
+	Content     teamsCard `json:"content"`
+}
+
+type teamsCard struct {
+	Schema  string         `json:"$schema"`
+	Type    string         `json:"type"`
+	Version string         `json:"version"`
+	Body    []teamsElement `json:"body"`
+}
+
+type teamsElement struct {
+	Type   string      `json:"type"`
+	Text   string      `json:"text,omitempty"`
+	Weight string      `json:"weight,omitempty"`
+	Size   string      `json:"size,omitempty"`
+	Wrap   bool        `json:"wrap,omitempty"`
+	Facts  []teamsFact `json:"facts,omitempty"`
+}
+
+type teamsFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// encodePayload returns the request body for the mapping: a chat message
+// for chat targets, the rendered body template if the mapping has one,
+// otherwise the JSON payload with the mapping's naming
+func encodePayload(mapping *database.EmailMapping, payload ProcessedData) ([]byte, error) {
+	switch mapping.ChatFormat {
+	case database.ChatFormatSlack:
+		return json.Marshal(slackPayload(payload.Data))
+	case database.ChatFormatTeams:
+		return json.Marshal(teamsPayload(payload.Data))
+	}
+	if mapping.BodyTemplate != "" {
+		return renderBodyTemplate(mapping.BodyTemplate, payload.Data)
+	}
+	return marshalPayload(mapping, payload)
+}
+
+// renderBodyTemplate executes a mapping's body template over the email data
+func renderBodyTemplate(text string, data EmailData) ([]byte, error) {
+	tmpl, err := database.ParseBodyTemplate(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("failed to render body template: %w", err)
+	}
+	return body.Bytes(), nil
+}
+
+// slackPayload formats an email as a Slack message with the subject as a
+// header, sender and recipient fields and a snippet of the body
+func slackPayload(data EmailData) slackMessage {
+	subject := chatSubject(data)
+	return slackMessage{
+		Text: "New email from " + data.From + ": " + subject,
+		Blocks: []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: truncateRunes(subject, chatSubjectLimit)}},
+			{Type: "section", Fields: []slackText{
+				{Type: "mrkdwn", Text: "*From:*\n" + slackEscape(data.From)},
+				{Type: "mrkdwn", Text: "*To:*\n" + slackEscape(data.To)},
+			}},
+			{Type: "section", Text: &slackText{Type: "plain_text", Text: chatSnippet(data)}},
+		},
+	}
+}
+
+// teamsPayload formats an email as a Teams Adaptive Card with the same
+// content as slackPayload
+func teamsPayload(data EmailData) teamsMessage {
+	return teamsMessage{
+		Type: "message",
+		Attachments: []teamsAttachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content: teamsCard{
+				Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+				Type:    "AdaptiveCard",
+				Version: "1.4",
+				Body: []teamsElement{
+					{Type: "TextBlock", Text: chatSubject(data), Weight: "Bolder", Size: "Medium", Wrap: true},
+					{Type: "FactSet", Facts: []teamsFact{
+						{Title: "From", Value: data.From},
+						{Title: "To", Value: data.To},
+					}},
+					{Type: "TextBlock", Text: chatSnippet(data), Wrap: true},
+				},
+			},
+		}},
+	}
+}
+
+// chatSubject is the subject shown in chat messages
+func chatSubject(data EmailData) string {
+	if subject := strings.TrimSpace(data.Subject); subject != "" {
+		return subject
+	}
+	return "(no subject)"
+}
+
+// chatSnippet is the start of the most readable body available, with
+// whitespace runs collapsed
+func chatSnippet(data EmailData) string {
+	body := data.CleanBody
+	if body == "" {
+		body = data.PlainBody
+	}
+	if body == "" {
+		body = data.Body
+	}
+	body = strings.Join(strings.Fields(body), " ")
+	if body == "" {
+		return "(empty message)"
+	}
+	return truncateRunes(body, chatSnippetLimit)
+}
+
+// truncateRunes shortens s to at most n runes, marking the cut with "…"
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// slackEscape escapes the characters Slack's mrkdwn treats as control
+// sequences
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
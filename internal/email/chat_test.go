@@ -0,0 +1,103 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestProcessor_SlackChatFormat(t *testing.T) {
+	var msg map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&msg)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{ChatFormat: database.ChatFormatSlack, BatchSize: 10})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	body := "Disk usage on <db-1> is at 95%.\r\n\r\nPlease check."
+	err := processor.Process(Email{From: "alerts@example.com", To: mapping.GeneratedEmail, Subject: "Disk alert", Body: body, PlainBody: body})
+	if err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+	if msg == nil {
+		t.Fatal("Expected the chat mapping to be delivered immediately despite batching")
+	}
+
+	if text, _ := msg["text"].(string); !strings.Contains(text, "Disk alert") {
+		t.Errorf("Expected fallback text to contain the subject, got %q", text)
+	}
+	blocks, _ := msg["blocks"].([]any)
+	if len(blocks) != 3 {
+		t.Fatalf("Expected 3 blocks, got %d: %v", len(blocks), msg["blocks"])
+	}
+
+	header := blocks[0].(map[string]any)
+	if header["type"] != "header" {
+		t.Errorf("Expected first block to be a header, got %v", header["type"])
+	}
+	if text := header["text"].(map[string]any); text["type"] != "plain_text" || text["text"] != "Disk alert" {
+		t.Errorf("Unexpected header text: %v", text)
+	}
+
+	fields, _ := blocks[1].(map[string]any)["fields"].([]any)
+	if len(fields) != 2 {
+		t.Fatalf("Expected 2 fields, got %v", blocks[1])
+	}
+	from := fields[0].(map[string]any)
+	if from["type"] != "mrkdwn" || from["text"] != "*From:*\nalerts@example.com" {
+		t.Errorf("Unexpected from field: %v", from)
+	}
+	if to := fields[1].(map[string]any); to["text"] != "*To:*\n"+mapping.GeneratedEmail {
+		t.Errorf("Unexpected to field: %v", to)
+	}
+
+	snippet := blocks[2].(map[string]any)["text"].(map[string]any)
+	if want := "Disk usage on <db-1> is at 95%. Please check."; snippet["text"] != want {
+		t.Errorf("Expected snippet %q, got %q", want, snippet["text"])
+	}
+}
+
+func TestTeamsPayload(t *testing.T) {
+	data, err := json.Marshal(teamsPayload(EmailData{From: "a@example.com", To: "b@example.com", Body: strings.Repeat("x", 600)}))
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+
+	var msg struct {
+		Type        string `json:"type"`
+		Attachments []struct {
+			ContentType string `json:"contentType"`
+			Content     struct {
+				Type string `json:"type"`
+				Body []struct {
+					Type string `json:"type"`
+					Text string `json:"text"`
+				} `json:"body"`
+			} `json:"content"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if msg.Type != "message" || len(msg.Attachments) != 1 {
+		t.Fatalf("Unexpected message: %s", data)
+	}
+	card := msg.Attachments[0]
+	if card.ContentType != "application/vnd.microsoft.card.adaptive" || card.Content.Type != "AdaptiveCard" {
+		t.Errorf("Unexpected attachment: %s", data)
+	}
+	if len(card.Content.Body) != 3 || card.Content.Body[0].Text != "(no subject)" {
+		t.Fatalf("Unexpected card body: %s", data)
+	}
+	if n := len([]rune(card.Content.Body[2].Text)); n != chatSnippetLimit {
+		t.Errorf("Expected snippet of %d runes, got %d", chatSnippetLimit, n)
+	}
+}
@@ -0,0 +1,54 @@
+package email
+
+import (
+	"regexp"
+	"strings"
+)
+
+// replyHeaderPattern matches attribution lines such as
+// "On Mon, 1 Jan 2024 at 10:00, Jane <jane@example.com> wrote:"
+var replyHeaderPattern = regexp.MustCompile(`^On\s.*\swrote:$`)
+
+// stripQuoted removes quoted reply chains and a trailing signature from a
+// plain-text body. Quoted lines ("> ...") are dropped, and everything from a
+// reply attribution line, an "Original Message" marker or a "-- " signature
+// delimiter onwards is cut.
+func stripQuoted(body string) string {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+
+	var kept []string
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimSpace(line)
+
+		if isReplyBoundary(trimmed) {
+			break
+		}
+		// Attribution lines are often wrapped before "wrote:"
+		if strings.HasPrefix(trimmed, "On ") && i+1 < len(lines) &&
+			replyHeaderPattern.MatchString(trimmed+" "+strings.TrimSpace(lines[i+1])) {
+			break
+		}
+		// Signature delimiter per RFC 3676 ("-- "), commonly sent without the space
+		if lines[i] == "-- " || lines[i] == "--" {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+
+		kept = append(kept, line)
+	}
+
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// isReplyBoundary reports whether a line starts the quoted part of a reply
+func isReplyBoundary(line string) bool {
+	if replyHeaderPattern.MatchString(line) {
+		return true
+	}
+	lower := strings.ToLower(line)
+	return strings.HasPrefix(lower, "-----original message-----") ||
+		strings.HasPrefix(lower, "----- original message -----")
+}
@@ -0,0 +1,48 @@
+package email
+
+import "testing"
+
+func TestStripQuoted(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "quoted chain with attribution",
+			body: "Sounds good, see you then.\r\n\r\n" +
+				"On Mon, 1 Jan 2024 at 10:00, Jane <jane@example.com> wrote:\r\n" +
+				"> Are we still on for lunch?\r\n" +
+				">> Earlier message\r\n",
+			want: "Sounds good, see you then.",
+		},
+		{
+			name: "wrapped attribution line",
+			body: "Yes.\n\nOn Mon, 1 Jan 2024 at 10:00 AM Jane Doe <jane@example.com>\nwrote:\n> Question?\n",
+			want: "Yes.",
+		},
+		{
+			name: "inline quotes and signature",
+			body: "> Can you send the report?\nAttached.\n> And the numbers?\nBelow.\n\n-- \nJohn Smith\nACME Corp\n",
+			want: "Attached.\nBelow.",
+		},
+		{
+			name: "original message marker",
+			body: "Thanks!\n\n-----Original Message-----\nFrom: Jane\nSubject: hi\n",
+			want: "Thanks!",
+		},
+		{
+			name: "nothing to strip",
+			body: "Just a message.\nOn second thought, never mind.",
+			want: "Just a message.\nOn second thought, never mind.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripQuoted(tt.body); got != tt.want {
+				t.Errorf("stripQuoted() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
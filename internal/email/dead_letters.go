@@ -0,0 +1,119 @@
+package email
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// deadLetterBatchSize caps how many dead letters are replayed per pass
+const deadLetterBatchSize = 100
+
+// saveDeadLetter keeps an email that failed after every retry with its
+// payload and the endpoint and headers it was sent to. Payloads over
+// DeadLetterMaxBytes are left out.
+func (p *Processor) saveDeadLetter(logger *log.Logger, mapping *database.EmailMapping, email Email, payload []byte, lastErr error) {
+	letter := &database.DeadLetter{
+		MappingID:   mapping.ID,
+		RequestID:   email.RequestID,
+		Subject:     email.Subject,
+		FromAddress: email.From,
+		EndpointURL: mapping.EndpointURL,
+		Headers:     mapping.Headers,
+		PayloadSize: len(payload),
+		LastError:   lastErr.Error(),
+	}
+	if int64(len(payload)) <= p.config.DeadLetterMaxBytes {
+		letter.Payload = string(payload)
+	} else {
+		logger.Printf("Not keeping the %d byte payload with the dead letter: over the %d byte limit", len(payload), p.config.DeadLetterMaxBytes)
+	}
+	if err := p.db.CreateDeadLetter(letter); err != nil {
+		logger.Printf("Warning: Failed to store dead letter: %v", err)
+	}
+}
+
+// saveFailedItem keeps one email of a batch or digest that failed after
+// every retry, for bulk retry and as a dead letter. Either way it is resent
+// on its own rather than as part of a batch or digest.
+func (p *Processor) saveFailedItem(logger *log.Logger, mapping *database.EmailMapping, email Email, payload ProcessedData, lastErr error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		logger.Printf("Warning: Failed to keep payload of request %s: %v", email.RequestID, err)
+		return
+	}
+	p.saveFailedPayload(logger, email.RequestID, string(data))
+	p.saveDeadLetter(logger, mapping, email, data, lastErr)
+}
+
+// RetryDeadLetters replays the dead letters queued from the admin
+// interface, logging each outcome under the original request ID. A dead
+// letter is removed once delivered and marked failed again otherwise.
+func (p *Processor) RetryDeadLetters() {
+	for {
+		letters, err := p.db.ClaimQueuedDeadLetters(deadLetterBatchSize)
+		if err != nil {
+			log.Printf("Failed to claim queued dead letters: %v", err)
+		}
+		for _, letter := range letters {
+			p.replayDeadLetter(letter)
+		}
+		if err != nil || len(letters) < deadLetterBatchSize {
+			return
+		}
+	}
+}
+
+// replayDeadLetter sends one dead letter's payload to its mapping's endpoint
+func (p *Processor) replayDeadLetter(letter database.DeadLetter) {
+	logger := requestLogger(letter.RequestID)
+	mapping := &letter.Mapping
+	if mapping.ID == 0 {
+		logger.Printf("Not replaying dead letter %d: mapping %d no longer exists", letter.ID, letter.MappingID)
+		if err := p.db.RecordDeadLetterReplayFailure(letter.ID, "mapping no longer exists"); err != nil {
+			logger.Printf("Warning: %v", err)
+		}
+		return
+	}
+
+	var payload ProcessedData
+	if err := json.Unmarshal([]byte(letter.Payload), &payload); err != nil {
+		logger.Printf("Not replaying dead letter %d: invalid stored payload: %v", letter.ID, err)
+		if err := p.db.RecordDeadLetterReplayFailure(letter.ID, "invalid stored payload: "+err.Error()); err != nil {
+			logger.Printf("Warning: %v", err)
+		}
+		return
+	}
+
+	// Sent with the mapping's current endpoint and headers, so fixing a
+	// misconfigured mapping and replaying delivers the email
+	logger.Printf("Replaying dead letter %d to endpoint %q", letter.ID, mapping.EndpointURL)
+	key := idempotencyKey(mapping, Email{MessageID: payload.Data.MessageID, RequestID: letter.RequestID})
+	lastErr := p.sendWithRetry(logger, mapping.EndpointURL, nil, func() error {
+		return p.sendToAPI(mapping, payload, letter.RequestID, key)
+	})
+
+	status, errorMsg := "success", ""
+	if lastErr != nil {
+		status, errorMsg = "error", lastErr.Error()
+		if statusAction(lastErr) == database.StatusActionDrop {
+			status = "dropped"
+		}
+	}
+	if err := p.logProcessing(mapping, mapping.GeneratedEmail, payload.Data.Subject, status, errorMsg, letter.RequestID, ""); err != nil {
+		logger.Printf("Failed to log replayed dead letter: %v", err)
+	}
+
+	if status == "error" {
+		logger.Printf("Replay of dead letter %d failed: %v", letter.ID, lastErr)
+		if err := p.db.RecordDeadLetterReplayFailure(letter.ID, errorMsg); err != nil {
+			logger.Printf("Warning: %v", err)
+		}
+		return
+	}
+	logger.Printf("Replayed dead letter %d to endpoint %q", letter.ID, mapping.EndpointURL)
+	if err := p.db.DeleteDeadLetter(letter.ID); err != nil {
+		logger.Printf("Warning: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestProcessor_DeadLetterReplay(t *testing.T) {
+	var up atomic.Bool
+	var subject atomic.Value
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			http.Error(w, "backend down", http.StatusInternalServerError)
+			return
+		}
+		var payload ProcessedData
+		json.NewDecoder(r.Body).Decode(&payload)
+		subject.Store(payload.Data.Subject)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{
+		MaxSize:       1024 * 1024,
+		RetryAttempts: 2,
+		Backoff:       testBackoff,
+	})
+
+	email := Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "dead letter", RequestID: "req-1"}
+	// Delivered as in asynchronous mode; a synchronous sender retries instead
+	if err := processor.processAsync(email, false); err == nil {
+		t.Fatal("Expected delivery to fail")
+	}
+
+	letters, err := db.ListDeadLetters(0)
+	if err != nil {
+		t.Fatalf("Failed to list dead letters: %v", err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("Expected one dead letter, got %d", len(letters))
+	}
+	letter := letters[0]
+	if letter.EndpointURL != ts.URL || letter.RequestID != "req-1" || letter.Status != database.DeadLetterFailed {
+		t.Errorf("Unexpected dead letter: %+v", letter)
+	}
+	if !strings.Contains(letter.Payload, `"subject":"dead letter"`) || letter.PayloadSize != len(letter.Payload) {
+		t.Errorf("Expected the payload to be kept, got %q (%d bytes)", letter.Payload, letter.PayloadSize)
+	}
+	if !strings.Contains(letter.LastError, "500") {
+		t.Errorf("Expected the last error to be kept, got %q", letter.LastError)
+	}
+
+	// Nothing is sent until the dead letter is queued
+	up.Store(true)
+	processor.RetryDeadLetters()
+	if subject.Load() != nil {
+		t.Fatal("Expected the dead letter to wait until it's queued")
+	}
+
+	if err := db.QueueDeadLetterReplay(letter.ID); err != nil {
+		t.Fatalf("Failed to queue replay: %v", err)
+	}
+	processor.RetryDeadLetters()
+
+	if got, _ := subject.Load().(string); got != "dead letter" {
+		t.Errorf("Expected the stored payload to be resent, got subject %q", got)
+	}
+	if letters, _ := db.ListDeadLetters(0); len(letters) != 0 {
+		t.Errorf("Expected the delivered dead letter to be removed, got %+v", letters)
+	}
+
+	var logs []database.EmailLog
+	if err := db.Where("request_id = ?", "req-1").Order("id").Find(&logs).Error; err != nil {
+		t.Fatalf("Failed to load logs: %v", err)
+	}
+	if len(logs) != 2 || logs[0].Status != "error" || logs[1].Status != "success" {
+		t.Fatalf("Expected an error log followed by a success, got %+v", logs)
+	}
+}
+
+func TestProcessor_DeadLetterMaxBytes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{
+		MaxSize:            1024 * 1024,
+		RetryAttempts:      1,
+		Backoff:            testBackoff,
+		DeadLetterMaxBytes: 10,
+	})
+
+	email := Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "too big", RequestID: "req-1"}
+	// Delivered as in asynchronous mode; a synchronous sender retries instead
+	if err := processor.processAsync(email, false); err == nil {
+		t.Fatal("Expected delivery to fail")
+	}
+
+	letters, err := db.ListDeadLetters(0)
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("Expected one dead letter, got %d (%v)", len(letters), err)
+	}
+	if letters[0].Payload != "" || letters[0].PayloadSize <= 10 {
+		t.Errorf("Expected the payload to be left out but its size kept, got %+v", letters[0])
+	}
+	if err := db.QueueDeadLetterReplay(letters[0].ID); err == nil {
+		t.Error("Expected a dead letter without its payload not to be replayable")
+	}
+}
+
+func TestProcessor_DeadLettersForBatchAndDigest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "backend down", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	batched := createTestMapping(t, db, ts.URL, database.MappingOptions{BatchSize: 2, BatchWindow: 60})
+	digested := createTestMapping(t, db, ts.URL, database.MappingOptions{DigestSchedule: database.DigestDaily})
+	processor := New(db, ProcessorConfig{
+		MaxSize:       1024 * 1024,
+		RetryAttempts: 2,
+		Synchronous:   true,
+		Backoff:       testBackoff,
+	})
+
+	for _, email := range []Email{
+		{From: "sender@example.com", To: batched.GeneratedEmail, Subject: "batched 1", RequestID: "batch-1"},
+		{From: "sender@example.com", To: batched.GeneratedEmail, Subject: "batched 2", RequestID: "batch-2"},
+		{From: "sender@example.com", To: digested.GeneratedEmail, Subject: "digested", RequestID: "digest-1"},
+	} {
+		if err := processor.Process(email); err != nil {
+			t.Fatalf("Failed to process email: %v", err)
+		}
+	}
+	processor.SendDueDigests(time.Now().Add(25 * time.Hour))
+
+	letters, err := db.ListDeadLetters(0)
+	if err != nil {
+		t.Fatalf("Failed to list dead letters: %v", err)
+	}
+	byRequest := make(map[string]database.DeadLetter)
+	for _, letter := range letters {
+		byRequest[letter.RequestID] = letter
+	}
+	if len(letters) != 3 || len(byRequest) != 3 {
+		t.Fatalf("Expected a dead letter per email, got %+v", letters)
+	}
+	for id, subject := range map[string]string{"batch-1": "batched 1", "batch-2": "batched 2", "digest-1": "digested"} {
+		letter := byRequest[id]
+		var payload ProcessedData
+		if err := json.Unmarshal([]byte(letter.Payload), &payload); err != nil || payload.Data.Subject != subject {
+			t.Errorf("Expected %s to keep its own payload, got %q (%v)", id, letter.Payload, err)
+		}
+		if !strings.Contains(letter.LastError, "500") {
+			t.Errorf("Expected %s to keep the last error, got %q", id, letter.LastError)
+		}
+
+		var log database.EmailLog
+		if err := db.Where("request_id = ?", id).First(&log).Error; err != nil || log.Payload == "" {
+			t.Errorf("Expected %s's payload to be kept for bulk retry, got %+v (%v)", id, log, err)
+		}
+	}
+}
+
+func TestProcessor_DeadLetterReplayWithoutMapping(t *testing.T) {
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, "https://example.com/hook", database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	letter := &database.DeadLetter{MappingID: mapping.ID, RequestID: "req-1", Payload: `{"data": {}}`, LastError: "500"}
+	if err := db.CreateDeadLetter(letter); err != nil {
+		t.Fatalf("Failed to create dead letter: %v", err)
+	}
+	if err := db.QueueDeadLetterReplay(letter.ID); err != nil {
+		t.Fatalf("Failed to queue replay: %v", err)
+	}
+	if err := db.DeleteEmailMapping(mapping.GeneratedEmail, 1); err != nil {
+		t.Fatalf("Failed to delete mapping: %v", err)
+	}
+	processor.RetryDeadLetters()
+
+	got, err := db.GetDeadLetter(letter.ID)
+	if err != nil {
+		t.Fatalf("Failed to get dead letter: %v", err)
+	}
+	if got.Status != database.DeadLetterFailed || got.Replays != 1 {
+		t.Errorf("Expected the replay to be recorded as failed, got status %q after %d replays", got.Status, got.Replays)
+	}
+}
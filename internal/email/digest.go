@@ -0,0 +1,192 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// DigestPayload is posted to a digest mapping's endpoint once per period,
+// summarizing the emails received since the previous digest
+type DigestPayload struct {
+	Type     string        `json:"type"` // Always "digest"
+	Schedule string        `json:"schedule"`
+	To       string        `json:"to"`
+	Count    int           `json:"count"`
+	Emails   []DigestEmail `json:"emails"`
+	Source   string        `json:"source"`
+	Origin   string        `json:"origin,omitempty"` // Configured instance label
+}
+
+// DigestEmail summarizes one email in a digest
+type DigestEmail struct {
+	From       string    `json:"from"`
+	Subject    string    `json:"subject"`
+	ReceivedAt time.Time `json:"received_at"`
+	RequestID  string    `json:"request_id"`
+}
+
+// addToDigest holds an email until its mapping's next digest is due
+func (p *Processor) addToDigest(logger *log.Logger, mapping *database.EmailMapping, email Email) error {
+	if err := p.db.AddDigestEntry(mapping.ID, email.RequestID, email.From, email.Subject, clientIP(email.ReceivedFrom)); err != nil {
+		return err
+	}
+	logger.Printf("Held email for the %s digest of mapping %d, due %s", mapping.DigestSchedule, mapping.ID,
+		nextDigestTime(mapping.DigestSchedule, mapping.DigestHour, time.Now()).Format(time.RFC3339))
+	return nil
+}
+
+// nextDigestTime returns the first digest time of a schedule after t.
+// Daily digests go out every day at hour:00 UTC, weekly ones on Mondays.
+func nextDigestTime(schedule string, hour int, t time.Time) time.Time {
+	t = t.UTC()
+	next := time.Date(t.Year(), t.Month(), t.Day(), hour, 0, 0, 0, time.UTC)
+	days := 1
+	if schedule == database.DigestWeekly {
+		next = next.AddDate(0, 0, (int(time.Monday)-int(next.Weekday())+7)%7)
+		days = 7
+	}
+	for !next.After(t) {
+		next = next.AddDate(0, 0, days)
+	}
+	return next
+}
+
+// SendDueDigests posts a digest for every mapping whose oldest held email
+// was received before the digest time that has now passed. Held emails of
+// a mapping whose digest was turned off are sent right away.
+func (p *Processor) SendDueDigests(now time.Time) {
+	entries, err := p.db.GetDigestEntries()
+	if err != nil {
+		log.Printf("Failed to load digest entries: %v", err)
+		return
+	}
+
+	// Entries are grouped by mapping, oldest first
+	for start := 0; start < len(entries); {
+		end := start
+		for end < len(entries) && entries[end].MappingID == entries[start].MappingID {
+			end++
+		}
+		group := entries[start:end]
+		start = end
+
+		mapping := group[0].Mapping
+		if mapping.ID == 0 {
+			// The mapping was deleted; there is nowhere to send the digest
+			if err := p.db.DeleteDigestEntries(digestEntryIDs(group)); err != nil {
+				log.Printf("Failed to clear digest of deleted mapping %d: %v", group[0].MappingID, err)
+			}
+			continue
+		}
+		if mapping.DigestSchedule != "" &&
+			now.Before(nextDigestTime(mapping.DigestSchedule, mapping.DigestHour, group[0].CreatedAt)) {
+			continue
+		}
+		p.deliverDigest(&mapping, group)
+	}
+}
+
+// deliverDigest posts one digest for the held emails, retrying it as a
+// whole, and logs the outcome for each email. The emails are released
+// whatever the outcome, as with batches.
+func (p *Processor) deliverDigest(mapping *database.EmailMapping, entries []database.DigestEntry) {
+	digestID := newRequestID()
+	logger := requestLogger(digestID)
+
+	payload := DigestPayload{
+		Type:     "digest",
+		Schedule: mapping.DigestSchedule,
+		To:       mapping.GeneratedEmail,
+		Count:    len(entries),
+		Emails:   make([]DigestEmail, len(entries)),
+		Source:   "email",
+		Origin:   p.config.InstanceLabel,
+	}
+	for i, entry := range entries {
+		payload.Emails[i] = DigestEmail{
+			From:       entry.Sender,
+			Subject:    entry.Subject,
+			ReceivedAt: entry.CreatedAt.UTC(),
+			RequestID:  entry.RequestID,
+		}
+	}
+
+	logger.Printf("Delivering digest of %d emails to endpoint %q", len(entries), mapping.EndpointURL)
+
+	var lastErr error
+	data, err := json.Marshal(payload)
+	if err != nil {
+		lastErr = fmt.Errorf("failed to marshal digest: %w", err)
+	} else {
+		lastErr = p.sendWithRetry(logger, mapping.EndpointURL, nil, func() error {
+			return p.postJSON(mapping, data, digestID, digestID)
+		})
+	}
+
+	status, errorMsg := "success", ""
+	if lastErr != nil {
+		status, errorMsg = "error", fmt.Sprintf("digest %s failed: %v", digestID, lastErr)
+		if statusAction(lastErr) == database.StatusActionDrop {
+			status = "dropped"
+		}
+		logger.Printf("Digest delivery failed: %v", lastErr)
+	}
+
+	if err := p.db.DeleteDigestEntries(digestEntryIDs(entries)); err != nil {
+		logger.Printf("Failed to clear delivered digest: %v", err)
+	}
+	for _, entry := range entries {
+		if err := p.logProcessing(mapping, mapping.GeneratedEmail, entry.Subject, status, errorMsg, entry.RequestID, entry.ClientIP); err != nil {
+			logger.Printf("Failed to log digested email: %v", err)
+		}
+		if status == "error" {
+			p.saveFailedItem(logger, mapping, Email{RequestID: entry.RequestID, From: entry.Sender, Subject: entry.Subject},
+				digestEntryPayload(logger, mapping, entry, p.config.InstanceLabel), lastErr)
+		}
+	}
+}
+
+// digestEntryPayload is the payload kept for an email of a failed digest.
+// Only the digest's summary of the email is held, so that is all it has.
+func digestEntryPayload(logger *log.Logger, mapping *database.EmailMapping, entry database.DigestEntry, origin string) ProcessedData {
+	payload := ProcessedData{
+		Data: EmailData{
+			From:       entry.Sender,
+			To:         mapping.GeneratedEmail,
+			EnvelopeTo: mapping.GeneratedEmail,
+			Subject:    entry.Subject,
+			ReceivedAt: entry.CreatedAt.UTC(),
+		},
+		Source: "email",
+		Origin: origin,
+	}
+	return pinPayloadVersion(logger, payload, mapping.PayloadVersion)
+}
+
+// RunDigests sends due digests every interval until ctx is done
+func (p *Processor) RunDigests(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.SendDueDigests(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func digestEntryIDs(entries []database.DigestEntry) []uint {
+	ids := make([]uint, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return ids
+}
@@ -0,0 +1,100 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestNextDigestTime(t *testing.T) {
+	// 2026-10-14 is a Wednesday
+	at := time.Date(2026, 10, 14, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		schedule string
+		hour     int
+		want     time.Time
+	}{
+		{database.DigestDaily, 12, time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)},
+		{database.DigestDaily, 9, time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)},
+		{database.DigestWeekly, 9, time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := nextDigestTime(tt.schedule, tt.hour, at); !got.Equal(tt.want) {
+			t.Errorf("nextDigestTime(%q, %d) = %s, want %s", tt.schedule, tt.hour, got, tt.want)
+		}
+	}
+
+	// A Monday digest time that has passed moves to the next week
+	monday := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	if got := nextDigestTime(database.DigestWeekly, 9, monday); !got.Equal(monday.AddDate(0, 0, 7)) {
+		t.Errorf("nextDigestTime at the digest time = %s, want the following Monday", got)
+	}
+}
+
+func TestProcessor_DigestCollectsEmails(t *testing.T) {
+	var received []DigestPayload
+	var method string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		var payload DigestPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		received = append(received, payload)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{DigestSchedule: database.DigestDaily, DigestHour: 9, HTTPMethod: database.MethodPatch})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	for _, subject := range []string{"first", "second", "third"} {
+		if err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: subject, Body: "body"}); err != nil {
+			t.Fatalf("Failed to process email: %v", err)
+		}
+	}
+	if len(received) != 0 {
+		t.Fatalf("Expected emails to be held for the digest, got %d posts", len(received))
+	}
+
+	// Nothing is sent before the digest time
+	processor.SendDueDigests(time.Now())
+	if len(received) != 0 {
+		t.Fatalf("Expected no digest before it is due, got %d", len(received))
+	}
+
+	processor.SendDueDigests(time.Now().Add(25 * time.Hour))
+	if len(received) != 1 {
+		t.Fatalf("Expected one digest, got %d", len(received))
+	}
+	digest := received[0]
+	if digest.Type != "digest" || digest.Schedule != database.DigestDaily || digest.To != mapping.GeneratedEmail {
+		t.Errorf("Unexpected digest: %+v", digest)
+	}
+	if method != "PATCH" {
+		t.Errorf("Expected the digest to use the mapping's method, got %s", method)
+	}
+	if digest.Count != 3 || len(digest.Emails) != 3 {
+		t.Fatalf("Expected 3 emails in the digest, got %d (%d listed)", digest.Count, len(digest.Emails))
+	}
+	for i, subject := range []string{"first", "second", "third"} {
+		if digest.Emails[i].Subject != subject || digest.Emails[i].From != "sender@example.com" {
+			t.Errorf("Email %d = %+v, want subject %q", i, digest.Emails[i], subject)
+		}
+	}
+
+	var success int64
+	db.Model(&database.EmailLog{}).Where("status = ?", "success").Count(&success)
+	if success != 3 {
+		t.Errorf("Expected 3 success logs, got %d", success)
+	}
+
+	// The held emails are released, so the next run sends nothing
+	processor.SendDueDigests(time.Now().Add(50 * time.Hour))
+	if len(received) != 1 {
+		t.Errorf("Expected the digest to be sent once, got %d", len(received))
+	}
+}
@@ -19,8 +19,27 @@ type Sender struct {
 	siteDomain  string
 }
 
-// NewMailgunSender creates a new Mailgun email sender
-func NewMailgunSender(siteDomain string) (*Sender, error) {
+// Mailgun credential validation modes
+const (
+	MailgunValidateAsync = "async" // Check in the background, warn on failure
+	MailgunValidateSync  = "sync"  // Check before returning, fail on error
+	MailgunValidateOff   = "off"   // Don't check
+)
+
+// defaultMailgunValidateTimeout bounds the credential check when no timeout
+// is configured
+const defaultMailgunValidateTimeout = 5 * time.Second
+
+// MailgunValidation controls the credential check NewMailgunSender runs
+type MailgunValidation struct {
+	Mode    string // One of the MailgunValidate modes; "" means async
+	Timeout time.Duration
+}
+
+// NewMailgunSender creates a new Mailgun email sender. By default the API
+// key is checked in the background, so a Mailgun outage only logs a warning
+// rather than failing startup.
+func NewMailgunSender(siteDomain string, validation MailgunValidation) (*Sender, error) {
 	apiKey := os.Getenv("MAILGUN_API_KEY")
 	if apiKey == "" {
 		return nil, nil // Mailgun not configured, return nil without error
@@ -48,16 +67,8 @@ func NewMailgunSender(siteDomain string) (*Sender, error) {
 	log.Printf("Initializing Mailgun with domain: %s, from address: %s", domain, fromAddress)
 	mg := mailgun.NewMailgun(domain, apiKey)
 
-	// Test the API key by getting sending stats
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := mg.GetStats(ctx, []string{"accepted", "delivered"}, &mailgun.GetStatOptions{})
-	if err != nil {
-		if strings.Contains(err.Error(), "401") {
-			return nil, fmt.Errorf("authentication failed - please verify your API key and domain settings in the Mailgun dashboard")
-		}
-		return nil, fmt.Errorf("failed to validate Mailgun credentials: %w", err)
+	if err := validateMailgun(mg, validation); err != nil {
+		return nil, err
 	}
 
 	return &Sender{
@@ -68,6 +79,48 @@ func NewMailgunSender(siteDomain string) (*Sender, error) {
 	}, nil
 }
 
+// validateMailgun checks the API key according to validation.Mode. Only a
+// synchronous check returns an error; an asynchronous one logs a warning.
+func validateMailgun(mg mailgun.Mailgun, validation MailgunValidation) error {
+	timeout := validation.Timeout
+	if timeout <= 0 {
+		timeout = defaultMailgunValidateTimeout
+	}
+
+	switch validation.Mode {
+	case MailgunValidateOff:
+		return nil
+	case MailgunValidateSync:
+		return checkMailgunCredentials(mg, timeout)
+	case "", MailgunValidateAsync:
+		go func() {
+			if err := checkMailgunCredentials(mg, timeout); err != nil {
+				log.Printf("Warning: %v; account emails may fail to send", err)
+				return
+			}
+			log.Println("Mailgun credentials validated")
+		}()
+		return nil
+	default:
+		return fmt.Errorf("unknown Mailgun validation mode %q", validation.Mode)
+	}
+}
+
+// checkMailgunCredentials tests the API key by getting sending stats
+func checkMailgunCredentials(mg mailgun.Mailgun, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := mg.GetStats(ctx, []string{"accepted", "delivered"}, &mailgun.GetStatOptions{})
+	if err != nil {
+		if strings.Contains(err.Error(), "401") {
+			return fmt.Errorf("authentication failed - please verify your API key and domain settings in the Mailgun dashboard")
+		}
+		return fmt.Errorf("failed to validate Mailgun credentials: %w", err)
+	}
+	return nil
+}
+
 // SendRegistrationEmail sends a registration email with the provided token
 func (s *Sender) SendRegistrationEmail(email, token string) error {
 	subject := "Complete Your Registration"
@@ -101,3 +154,52 @@ Email API Management System`, s.siteDomain, token)
 
 	return nil
 }
+
+// SendEmailChangeEmail sends a link confirming a change of account email to the new address
+func (s *Sender) SendEmailChangeEmail(email, token string) error {
+	subject := "Confirm Your New Email Address"
+	body := fmt.Sprintf(`Hello!
+
+A request was made to change the email address of your Email API Management System account to this address. To confirm the change, please click the link below:
+
+http://%s/profile/confirm-email?token=%s
+
+This link will expire in 24 hours.
+
+If you did not request this change, please ignore this email.
+
+Best regards,
+Email API Management System`, s.siteDomain, token)
+
+	log.Printf("Attempting to send email change confirmation to %s using domain %s", email, s.domain)
+	message := mailgun.NewMessage(s.fromAddress, subject, body, email)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, id, err := s.mg.Send(ctx, message)
+	if err != nil {
+		if strings.Contains(err.Error(), "401") {
+			return fmt.Errorf("unauthorized: please verify your Mailgun API key and domain settings")
+		}
+		return fmt.Errorf("failed to send email change confirmation: %w", err)
+	}
+	log.Printf("Successfully sent email change confirmation to %s with message ID: %s", email, id)
+
+	return nil
+}
+
+// SendBounce sends the auto-reply for an email to an unknown recipient
+func (s *Sender) SendBounce(to, subject, body string) error {
+	message := mailgun.NewMessage(s.fromAddress, subject, body, to)
+	// Mark the reply as automatic so other auto-responders don't answer it
+	message.AddHeader("Auto-Submitted", "auto-replied")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, _, err := s.mg.Send(ctx, message); err != nil {
+		return fmt.Errorf("failed to send bounce: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package email
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mailgunMaxMemory is how much of a Mailgun inbound post is held in memory
+// while parsing; larger attachments are buffered on disk
+const mailgunMaxMemory = 32 * 1024 * 1024
+
+// MailgunWebhookHandler receives email forwarded by a Mailgun inbound route
+// (a multipart/form-data post with recipient, sender, subject, body-plain,
+// body-html, message-headers and attachment-N fields) and hands it to
+// Process like the SMTP server does. The timestamp, token and signature
+// fields are verified with the configured WebhookAuth before the email is
+// looked at, and unsigned or stale posts are refused with 406.
+func (p *Processor) MailgunWebhookHandler() http.Handler {
+	return p.BusyGuard(http.HandlerFunc(p.handleMailgunWebhook))
+}
+
+func (p *Processor) handleMailgunWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, p.maxSize()+webhookBodyOverhead)
+	if err := r.ParseMultipartForm(mailgunMaxMemory); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Email too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Invalid form: %v", err), http.StatusBadRequest)
+		return
+	}
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+
+	if err := p.config.WebhookAuth.Verify(r.FormValue("timestamp"), r.FormValue("token"), r.FormValue("signature")); err != nil {
+		log.Printf("Rejecting Mailgun webhook from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "Invalid signature", http.StatusNotAcceptable)
+		return
+	}
+
+	email, err := parseMailgunForm(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid email: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !p.acceptsDomain(email.To) {
+		// Mailgun retries anything but 200 and 406, so refuse for good
+		log.Printf("Rejecting Mailgun webhook for %s: domain not handled by this server", email.To)
+		http.Error(w, "Recipient domain not handled by this server", http.StatusNotAcceptable)
+		return
+	}
+
+	email.RequestID = newRequestID()
+	email.ReceivedFrom = r.RemoteAddr
+	email.ReceivedAt = time.Now()
+
+	p.processWebhookEmail(w, email)
+}
+
+// parseMailgunForm converts a parsed Mailgun inbound post into an Email.
+// Metadata such as Cc, Message-ID and Date comes from message-headers, which
+// are parsed like the headers of a message received over SMTP.
+func parseMailgunForm(r *http.Request) (Email, error) {
+	recipient := strings.TrimSpace(r.FormValue("recipient"))
+	if recipient == "" {
+		return Email{}, errors.New("missing recipient")
+	}
+
+	var pairs [][2]string
+	if raw := r.FormValue("message-headers"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &pairs); err != nil {
+			return Email{}, fmt.Errorf("invalid message-headers: %w", err)
+		}
+	}
+	var header strings.Builder
+	for _, pair := range pairs {
+		value := strings.NewReplacer("\r", " ", "\n", " ").Replace(pair[1])
+		header.WriteString(pair[0] + ": " + value + "\r\n")
+	}
+	header.WriteString("\r\n")
+
+	email := ParseMessage([]byte(header.String()))
+	// Mailgun spells these Message-Id and In-Reply-To
+	email.MessageID = getHeaderFold(email.Headers, "Message-ID")
+	email.InReplyTo = getHeaderFold(email.Headers, "In-Reply-To")
+	email.From = r.FormValue("sender")
+	email.To = recipient
+	if subject := r.FormValue("subject"); subject != "" {
+		email.Subject = subject
+	}
+	email.PlainBody = r.FormValue("body-plain")
+	email.HTMLBody = r.FormValue("body-html")
+	email.Body = email.PlainBody
+
+	attachments, err := mailgunAttachments(r)
+	if err != nil {
+		return Email{}, err
+	}
+	email.Attachments = append(email.Attachments, attachments...)
+	return email, nil
+}
+
+// mailgunAttachments reads the attachment-1 to attachment-N file parts of a
+// Mailgun post. Parts named in content-id-map are inline images referenced
+// from the HTML body.
+func mailgunAttachments(r *http.Request) ([]Attachment, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+
+	contentIDs := make(map[string]string) // Field name to Content-ID
+	if raw := r.FormValue("content-id-map"); raw != "" {
+		var byID map[string]string
+		if err := json.Unmarshal([]byte(raw), &byID); err != nil {
+			return nil, fmt.Errorf("invalid content-id-map: %w", err)
+		}
+		for id, field := range byID {
+			contentIDs[field] = strings.Trim(id, "<> ")
+		}
+	}
+
+	count, _ := strconv.Atoi(r.FormValue("attachment-count"))
+	var attachments []Attachment
+	for i := 1; i <= count; i++ {
+		field := fmt.Sprintf("attachment-%d", i)
+		files := r.MultipartForm.File[field]
+		if len(files) == 0 {
+			continue
+		}
+		f, err := files[0].Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", field, err)
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", field, err)
+		}
+		contentType := files[0].Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		attachments = append(attachments, Attachment{
+			Filename:    files[0].Filename,
+			ContentType: contentType,
+			ContentID:   contentIDs[field],
+			Data:        data,
+		})
+	}
+	return attachments, nil
+}
+
+// StartMailgunWebhookServer starts the receiver for Mailgun inbound routes
+// and serves until the processor starts shutting down
+func StartMailgunWebhookServer(processor *Processor, host string, port int) error {
+	if processor.config.WebhookAuth == nil {
+		return errors.New("Mailgun webhook receiver needs webhook authentication configured")
+	}
+	return serveWebhook(processor, host, port, processor.MailgunWebhookHandler())
+}
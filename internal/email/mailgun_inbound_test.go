@@ -0,0 +1,132 @@
+package email
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// mailgunPost builds a Mailgun inbound route post, signed with key at
+// timestamp unless key is empty
+func mailgunPost(t *testing.T, key string, timestamp time.Time, fields map[string]string, files map[string]string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if key != "" {
+		ts, token := strconv.FormatInt(timestamp.Unix(), 10), "0123456789abcdef"
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(ts + token))
+		fields["timestamp"], fields["token"], fields["signature"] = ts, token, hex.EncodeToString(mac.Sum(nil))
+	}
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	for name, content := range files {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", `form-data; name="`+name+`"; filename="`+name+`.txt"`)
+		h.Set("Content-Type", "text/plain")
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			t.Fatalf("Failed to create part: %v", err)
+		}
+		part.Write([]byte(content))
+	}
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestMailgunWebhookHandler_ProcessesInboundRoute(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{
+		MaxSize:         1024 * 1024,
+		RetryAttempts:   1,
+		Synchronous:     true,
+		AcceptedDomains: []string{"example.com"},
+		WebhookAuth:     NewWebhookAuth("key", false),
+	})
+
+	headers, _ := json.Marshal([][2]string{
+		{"Message-Id", "<abc@mail.example.org>"},
+		{"Cc", "Carol <carol@example.org>"},
+		{"Subject", "header subject"},
+	})
+	req := mailgunPost(t, "key", time.Now(), map[string]string{
+		"recipient":        mapping.GeneratedEmail,
+		"sender":           "bounce@mail.example.org",
+		"subject":          "build failed",
+		"body-plain":       "see log",
+		"body-html":        "<p>see log</p>",
+		"message-headers":  string(headers),
+		"attachment-count": "1",
+	}, map[string]string{"attachment-1": "log output"})
+	rec := httptest.NewRecorder()
+	processor.MailgunWebhookHandler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if data.Data.Subject != "build failed" || data.Data.From != "bounce@mail.example.org" || data.Data.To != mapping.GeneratedEmail {
+		t.Errorf("Unexpected envelope and subject: %+v", data.Data)
+	}
+	if data.Data.PlainBody != "see log" || data.Data.HTMLBody != "<p>see log</p>" {
+		t.Errorf("Expected plain and HTML bodies, got %q and %q", data.Data.PlainBody, data.Data.HTMLBody)
+	}
+	if data.Data.MessageID != "<abc@mail.example.org>" || len(data.Data.Cc) != 1 {
+		t.Errorf("Expected metadata from message-headers, got message ID %q and cc %v", data.Data.MessageID, data.Data.Cc)
+	}
+	if len(data.Data.Attachments) != 1 || data.Data.Attachments[0].Filename != "attachment-1.txt" {
+		t.Errorf("Expected the attachment to be forwarded, got %+v", data.Data.Attachments)
+	}
+}
+
+func TestMailgunWebhookHandler_RejectsUnverified(t *testing.T) {
+	processor := New(newTestDB(t), ProcessorConfig{
+		MaxSize:         1024 * 1024,
+		AcceptedDomains: []string{"example.com"},
+		WebhookAuth:     NewWebhookAuth("key", false),
+	})
+	fields := func() map[string]string {
+		return map[string]string{"recipient": "someone@example.com", "sender": "a@example.org"}
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"unsigned", mailgunPost(t, "", time.Now(), fields(), nil)},
+		{"wrong key", mailgunPost(t, "other", time.Now(), fields(), nil)},
+		{"stale", mailgunPost(t, "key", time.Now().Add(-time.Hour), fields(), nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			processor.MailgunWebhookHandler().ServeHTTP(rec, tt.req)
+			if rec.Code != http.StatusNotAcceptable {
+				t.Errorf("Expected 406, got %d", rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// fakeMailgun answers the credential check after delay with err, or blocks
+// until the check times out when delay is negative
+type fakeMailgun struct {
+	mailgun.Mailgun
+	delay   time.Duration
+	err     error
+	checked chan struct{}
+}
+
+func (f *fakeMailgun) GetStats(ctx context.Context, events []string, opts *mailgun.GetStatOptions) ([]mailgun.Stats, error) {
+	defer close(f.checked)
+	if f.delay < 0 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	time.Sleep(f.delay)
+	return nil, f.err
+}
+
+func TestValidateMailgun(t *testing.T) {
+	tests := []struct {
+		name       string
+		client     *fakeMailgun
+		validation MailgunValidation
+		wantErr    bool
+	}{
+		{"async with failing Mailgun", &fakeMailgun{err: errors.New("503 Service Unavailable")}, MailgunValidation{}, false},
+		{"async with hanging Mailgun", &fakeMailgun{delay: -1}, MailgunValidation{Mode: MailgunValidateAsync, Timeout: 300 * time.Millisecond}, false},
+		{"sync with failing Mailgun", &fakeMailgun{err: errors.New("401 Unauthorized")}, MailgunValidation{Mode: MailgunValidateSync}, true},
+		{"sync with hanging Mailgun", &fakeMailgun{delay: -1}, MailgunValidation{Mode: MailgunValidateSync, Timeout: 50 * time.Millisecond}, true},
+		{"sync with healthy Mailgun", &fakeMailgun{}, MailgunValidation{Mode: MailgunValidateSync}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.client.checked = make(chan struct{})
+
+			start := time.Now()
+			err := validateMailgun(tt.client, tt.validation)
+			if tt.wantErr != (err != nil) {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.validation.Mode != MailgunValidateSync && time.Since(start) > 100*time.Millisecond {
+				t.Errorf("Expected async validation not to block, took %s", time.Since(start))
+			}
+
+			select {
+			case <-tt.client.checked:
+			case <-time.After(time.Second):
+				t.Fatal("Expected credentials to be checked")
+			}
+		})
+	}
+
+	if err := validateMailgun(&fakeMailgun{}, MailgunValidation{Mode: MailgunValidateOff}); err != nil {
+		t.Errorf("Expected no check when validation is off, got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package email
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the endpoint latency
+// histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics collects processing counters and endpoint latencies and serves them
+// in the Prometheus text format. A nil *Metrics records nothing.
+type Metrics struct {
+	// perMappingLabels adds the mapping and endpoint labels; without them
+	// series are aggregated so large deployments keep cardinality bounded
+	perMappingLabels bool
+
+	mu        sync.Mutex
+	processed map[processedKey]uint64
+	latency   map[string]*histogram // keyed by endpoint
+}
+
+type processedKey struct {
+	mapping string
+	status  string
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewMetrics creates a metrics collector
+func NewMetrics(perMappingLabels bool) *Metrics {
+	return &Metrics{
+		perMappingLabels: perMappingLabels,
+		processed:        make(map[processedKey]uint64),
+		latency:          make(map[string]*histogram),
+	}
+}
+
+// ObserveProcessed counts an email reaching a final status for a mapping
+func (m *Metrics) ObserveProcessed(mapping, status string) {
+	if m == nil {
+		return
+	}
+	if !m.perMappingLabels {
+		mapping = ""
+	}
+	m.mu.Lock()
+	m.processed[processedKey{mapping, status}]++
+	m.mu.Unlock()
+}
+
+// Processed returns the count recorded for a mapping and status
+func (m *Metrics) Processed(mapping, status string) uint64 {
+	if m == nil {
+		return 0
+	}
+	if !m.perMappingLabels {
+		mapping = ""
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.processed[processedKey{mapping, status}]
+}
+
+// ObserveLatency records how long a request to an endpoint took. Endpoints
+// are labelled by host so per-request URL details don't add series.
+func (m *Metrics) ObserveLatency(endpoint string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	if !m.perMappingLabels {
+		endpoint = ""
+	} else if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		endpoint = u.Host
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.latency[endpoint]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latency[endpoint] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// labelEscaper escapes label values per the Prometheus text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats name/value pairs, skipping empty values
+func labels(pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1])))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]processedKey, 0, len(m.processed))
+	for k := range m.processed {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].mapping != keys[j].mapping {
+			return keys[i].mapping < keys[j].mapping
+		}
+		return keys[i].status < keys[j].status
+	})
+	fmt.Fprintln(w, "# HELP emails_processed_total Emails that reached a final status.")
+	fmt.Fprintln(w, "# TYPE emails_processed_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "emails_processed_total{%s} %d\n", labels("mapping", k.mapping, "status", k.status), m.processed[k])
+	}
+
+	endpoints := make([]string, 0, len(m.latency))
+	for e := range m.latency {
+		endpoints = append(endpoints, e)
+	}
+	sort.Strings(endpoints)
+	fmt.Fprintln(w, "# HELP endpoint_request_duration_seconds Latency of requests to mapping endpoints.")
+	fmt.Fprintln(w, "# TYPE endpoint_request_duration_seconds histogram")
+	for _, e := range endpoints {
+		h := m.latency[e]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "endpoint_request_duration_seconds_bucket{%s} %d\n",
+				labels("endpoint", e, "le", fmt.Sprint(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "endpoint_request_duration_seconds_bucket{%s} %d\n", labels("endpoint", e, "le", "+Inf"), h.count)
+		suffix := ""
+		if l := labels("endpoint", e); l != "" {
+			suffix = "{" + l + "}"
+		}
+		fmt.Fprintf(w, "endpoint_request_duration_seconds_sum%s %g\n", suffix, h.sum)
+		fmt.Fprintf(w, "endpoint_request_duration_seconds_count%s %d\n", suffix, h.count)
+	}
+}
@@ -0,0 +1,83 @@
+package email
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestMetrics_CountsByMappingAndStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	ok := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	failing := createTestMapping(t, db, ts.URL+"/fail", database.MappingOptions{})
+
+	metrics := NewMetrics(true)
+	processor := New(db, ProcessorConfig{
+		MaxSize:       1024 * 1024,
+		RetryAttempts: 1,
+		Synchronous:   true,
+		Backoff:       testBackoff,
+		Metrics:       metrics,
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := processor.Process(Email{From: "sender@example.com", To: ok.GeneratedEmail}); err != nil {
+			t.Fatalf("Failed to process email: %v", err)
+		}
+	}
+	processor.Process(Email{From: "sender@example.com", To: failing.GeneratedEmail})
+	processor.Process(Email{From: "sender@example.com", To: "unknown@example.com"})
+
+	if got := metrics.Processed(ok.GeneratedEmail, "success"); got != 2 {
+		t.Errorf("Expected 2 successes for %s, got %d", ok.GeneratedEmail, got)
+	}
+	if got := metrics.Processed(failing.GeneratedEmail, "error"); got != 1 {
+		t.Errorf("Expected 1 error for %s, got %d", failing.GeneratedEmail, got)
+	}
+	// Unknown recipients are counted without a mapping label
+	if got := metrics.Processed("", "dropped"); got != 1 {
+		t.Errorf("Expected 1 unlabelled drop, got %d", got)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		fmt.Sprintf(`emails_processed_total{mapping="%s",status="success"} 2`, ok.GeneratedEmail),
+		`emails_processed_total{status="dropped"} 1`,
+		fmt.Sprintf(`endpoint_request_duration_seconds_count{endpoint="%s"} 3`, strings.TrimPrefix(ts.URL, "http://")),
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics output to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetrics_PerMappingLabelsDisabled(t *testing.T) {
+	metrics := NewMetrics(false)
+	metrics.ObserveProcessed("a@example.com", "success")
+	metrics.ObserveProcessed("b@example.com", "success")
+
+	if got := metrics.Processed("", "success"); got != 2 {
+		t.Errorf("Expected counts aggregated across mappings, got %d", got)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(rec.Body.String(), "mapping=") {
+		t.Errorf("Expected no mapping label, got:\n%s", rec.Body.String())
+	}
+}
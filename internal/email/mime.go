@@ -0,0 +1,190 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// maxMIMEDepth bounds how deeply nested multiparts are followed
+const maxMIMEDepth = 10
+
+// mimeParts collects the parts of a message we forward
+type mimeParts struct {
+	plain       string
+	html        string
+	attachments []Attachment // attached files and inline parts referenced by Content-ID
+	calendar    string       // first iCalendar part, e.g. a meeting invite
+}
+
+// parseMultipart walks a multipart body, following multipart/related,
+// multipart/alternative and multipart/mixed parts nested in each other, and
+// returns the first plain, HTML and calendar parts and the attachments. It
+// returns false when the content type isn't multipart.
+func parseMultipart(contentType string, body []byte) (mimeParts, bool) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return mimeParts{}, false
+	}
+
+	var parts mimeParts
+	if err := parts.walk(multipart.NewReader(bytes.NewReader(body), params["boundary"]), 0); err != nil {
+		log.Printf("Error parsing multipart body: %v", err)
+	}
+	return parts, true
+}
+
+// parseSinglePart decodes the body of a message that isn't multipart and
+// sorts it like a part of one: a text or HTML body, a calendar, or an
+// attachment when the message is just a file
+func parseSinglePart(headers map[string][]string, body []byte) mimeParts {
+	if len(body) == 0 {
+		return mimeParts{}
+	}
+	header := make(textproto.MIMEHeader)
+	for _, name := range []string{"Content-Type", "Content-Disposition", "Content-Id"} {
+		if value := getHeaderFold(headers, name); value != "" {
+			header.Set(name, value)
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransferEncoding(getHeaderFold(headers, "Content-Transfer-Encoding"), bytes.NewReader(body)))
+	if err != nil {
+		log.Printf("Error decoding message body, keeping it as received: %v", err)
+		data = body
+	}
+
+	var parts mimeParts
+	parts.add(header, data)
+	return parts
+}
+
+func (m *mimeParts) walk(r *multipart.Reader, depth int) error {
+	if depth > maxMIMEDepth {
+		return fmt.Errorf("multipart nested more than %d levels", maxMIMEDepth)
+	}
+
+	for {
+		part, err := r.NextRawPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mediaType, params, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if strings.HasPrefix(mediaType, "multipart/") {
+			if err := m.walk(multipart.NewReader(part, params["boundary"]), depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// 7bit, 8bit and binary parts are read as they are
+		data, err := io.ReadAll(decodeTransferEncoding(part.Header.Get("Content-Transfer-Encoding"), part))
+		if err != nil {
+			return fmt.Errorf("failed to read %s part: %w", mediaType, err)
+		}
+		m.add(part.Header, data)
+	}
+}
+
+// add sorts a decoded part into the bodies, the calendar or the attachments.
+// Parts are attachments when they carry a Content-ID or an attachment
+// disposition, or when they aren't text; text parts beyond the first plain
+// and HTML ones are kept only if they have a filename, so a footer added
+// by a mailing list doesn't turn into a file.
+func (m *mimeParts) add(header textproto.MIMEHeader, data []byte) {
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+	disposition, _, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	contentID := strings.Trim(header.Get("Content-Id"), "<> ")
+	filename := partFilename(header, params)
+
+	if isCalendarType(mediaType) && m.calendar == "" {
+		m.calendar = string(data)
+		if disposition != "attachment" {
+			return
+		}
+	} else if contentID == "" && disposition != "attachment" {
+		switch {
+		case mediaType == "text/plain" && m.plain == "":
+			m.plain = string(data)
+			return
+		case mediaType == "text/html" && m.html == "":
+			m.html = string(data)
+			return
+		case strings.HasPrefix(mediaType, "text/") && filename == "":
+			return
+		}
+	}
+
+	m.attachments = append(m.attachments, Attachment{
+		Filename:    filename,
+		ContentType: mediaType,
+		ContentID:   contentID,
+		Data:        data,
+	})
+}
+
+// decodeTransferEncoding wraps r to undo a part's Content-Transfer-Encoding
+func decodeTransferEncoding(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r) // ignores line breaks
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// partFilename returns a part's filename from Content-Disposition, falling
+// back to the Content-Type name parameter
+func partFilename(header textproto.MIMEHeader, typeParams map[string]string) string {
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	return typeParams["name"]
+}
+
+// cidReference matches cid: URLs in HTML attribute values and CSS
+var cidReference = regexp.MustCompile(`cid:[^"'\s)>]+`)
+
+// rewriteCIDs replaces cid: references in html with data URIs or attachment
+// indices, as selected by mode. References to unknown Content-IDs are kept.
+func rewriteCIDs(html string, attachments []Attachment, mode string) string {
+	if mode != database.InlineImagesDataURI && mode != database.InlineImagesIndex {
+		return html
+	}
+
+	return cidReference.ReplaceAllStringFunc(html, func(ref string) string {
+		id, err := url.PathUnescape(strings.TrimPrefix(ref, "cid:"))
+		if err != nil {
+			return ref
+		}
+		for i, att := range attachments {
+			if att.ContentID != id {
+				continue
+			}
+			if mode == database.InlineImagesIndex {
+				return fmt.Sprintf("attachment:%d", i)
+			}
+			return fmt.Sprintf("data:%s;base64,%s", att.ContentType, base64.StdEncoding.EncodeToString(att.Data))
+		}
+		return ref
+	})
+}
@@ -0,0 +1,211 @@
+package email
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// inlineImageMessage is an HTML email with a logo referenced by Content-ID,
+// nested the way mail clients send it: alternative > related > html + image
+var inlineImageMessage = strings.Join([]string{
+	"From: sender@example.com",
+	"Subject: newsletter",
+	"MIME-Version: 1.0",
+	`Content-Type: multipart/alternative; boundary="alt"`,
+	"",
+	"--alt",
+	"Content-Type: text/plain; charset=utf-8",
+	"",
+	"Plain version",
+	"--alt",
+	`Content-Type: multipart/related; boundary="rel"`,
+	"",
+	"--rel",
+	"Content-Type: text/html; charset=utf-8",
+	"Content-Transfer-Encoding: quoted-printable",
+	"",
+	`<p>Hi</p><img src=3D"cid:logo@example.com">`,
+	"--rel",
+	`Content-Type: image/png; name="logo.png"`,
+	"Content-Transfer-Encoding: base64",
+	"Content-ID: <logo@example.com>",
+	"Content-Disposition: inline",
+	"",
+	base64.StdEncoding.EncodeToString([]byte("PNGDATA")),
+	"--rel--",
+	"--alt--",
+	"",
+}, "\r\n")
+
+func TestProcessor_InlineImages(t *testing.T) {
+	tests := []struct {
+		mode     string
+		wantHTML string
+	}{
+		{mode: "", wantHTML: `<img src="cid:logo@example.com">`},
+		{mode: database.InlineImagesIndex, wantHTML: `<img src="attachment:0">`},
+		{mode: database.InlineImagesDataURI, wantHTML: `<img src="data:image/png;base64,` + base64.StdEncoding.EncodeToString([]byte("PNGDATA")) + `">`},
+	}
+
+	for _, tt := range tests {
+		t.Run("mode="+tt.mode, func(t *testing.T) {
+			var data ProcessedData
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&data)
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			db := newTestDB(t)
+			mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{InlineImages: tt.mode})
+			processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+			email := ParseMessage([]byte(inlineImageMessage))
+			email.From, email.To = "sender@example.com", mapping.GeneratedEmail
+			if err := processor.Process(email); err != nil {
+				t.Fatalf("Failed to process email: %v", err)
+			}
+
+			if data.Data.PlainBody != "Plain version" {
+				t.Errorf("Expected plain body from the text/plain part, got %q", data.Data.PlainBody)
+			}
+			if !strings.Contains(data.Data.HTMLBody, tt.wantHTML) {
+				t.Errorf("Expected HTML body to contain %q, got %q", tt.wantHTML, data.Data.HTMLBody)
+			}
+			if len(data.Data.Attachments) != 1 {
+				t.Fatalf("Expected 1 inline attachment, got %d", len(data.Data.Attachments))
+			}
+			att := data.Data.Attachments[0]
+			if att.ContentID != "logo@example.com" || att.Filename != "logo.png" || att.ContentType != "image/png" {
+				t.Errorf("Unexpected inline attachment %+v", att)
+			}
+			if decoded, _ := base64.StdEncoding.DecodeString(att.Content); string(decoded) != "PNGDATA" {
+				t.Errorf("Expected decoded image data, got %q", decoded)
+			}
+		})
+	}
+}
+
+// mixedMessage is an email with attachments as mail clients send it:
+// mixed > alternative (plain + HTML) + a named file + an unnamed file
+var mixedMessage = strings.Join([]string{
+	"From: sender@example.com",
+	"Subject: report",
+	"MIME-Version: 1.0",
+	`Content-Type: multipart/mixed; boundary="mix"`,
+	"",
+	"This is a multi-part message in MIME format.",
+	"--mix",
+	`Content-Type: multipart/alternative; boundary="alt"`,
+	"",
+	"--alt",
+	"Content-Type: text/plain; charset=utf-8",
+	"Content-Transfer-Encoding: 8bit",
+	"",
+	"Grüße, see the report",
+	"--alt",
+	"Content-Type: text/html; charset=utf-8",
+	"Content-Transfer-Encoding: base64",
+	"",
+	base64.StdEncoding.EncodeToString([]byte("<p>See the report</p>")),
+	"--alt--",
+	"--mix",
+	`Content-Type: application/pdf; name="report.pdf"`,
+	"Content-Transfer-Encoding: base64",
+	`Content-Disposition: attachment; filename="report.pdf"`,
+	"",
+	base64.StdEncoding.EncodeToString([]byte("PDFDATA")),
+	"--mix",
+	"Content-Type: text/plain",
+	"Content-Transfer-Encoding: quoted-printable",
+	"Content-Disposition: attachment",
+	"",
+	"line one=",
+	" continued",
+	"--mix--",
+	"",
+}, "\r\n")
+
+func TestParseMessage_MultipartMixed(t *testing.T) {
+	email := ParseMessage([]byte(mixedMessage))
+
+	if email.PlainBody != "Grüße, see the report" {
+		t.Errorf("Expected the 8bit plain part, got %q", email.PlainBody)
+	}
+	if email.HTMLBody != "<p>See the report</p>" {
+		t.Errorf("Expected the decoded HTML part, got %q", email.HTMLBody)
+	}
+
+	want := []Attachment{
+		{Filename: "report.pdf", ContentType: "application/pdf", Data: []byte("PDFDATA")},
+		{ContentType: "text/plain", Data: []byte("line one continued")},
+	}
+	if len(email.Attachments) != len(want) {
+		t.Fatalf("Expected %d attachments, got %+v", len(want), email.Attachments)
+	}
+	for i, att := range email.Attachments {
+		if att.Filename != want[i].Filename || att.ContentType != want[i].ContentType || string(att.Data) != string(want[i].Data) {
+			t.Errorf("Attachment %d = %+v, want %+v", i, att, want[i])
+		}
+	}
+}
+
+func TestParseMessage_SinglePart(t *testing.T) {
+	tests := []struct {
+		name       string
+		message    string
+		wantPlain  string
+		wantHTML   string
+		wantAttach string
+	}{
+		{
+			name:      "plain",
+			message:   "Subject: hi\r\n\r\nHello",
+			wantPlain: "Hello",
+		},
+		{
+			name:      "quoted-printable",
+			message:   "Subject: hi\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nCaf=C3=A9",
+			wantPlain: "Café",
+		},
+		{
+			name:     "html",
+			message:  "Subject: hi\r\nContent-Type: text/html\r\n\r\n<p>Hello</p>",
+			wantHTML: "<p>Hello</p>",
+		},
+		{
+			name:       "file",
+			message:    "Subject: hi\r\nContent-Type: application/pdf\r\nContent-Transfer-Encoding: base64\r\n\r\n" + base64.StdEncoding.EncodeToString([]byte("PDFDATA")),
+			wantAttach: "PDFDATA",
+		},
+		{
+			name:      "bare line feeds",
+			message:   "Subject: hi\nContent-Transfer-Encoding: quoted-printable\n\nHello=\nworld",
+			wantPlain: "Helloworld",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := ParseMessage([]byte(tt.message))
+			if email.Subject != "hi" {
+				t.Errorf("Expected subject %q, got %q", "hi", email.Subject)
+			}
+			if email.PlainBody != tt.wantPlain || email.HTMLBody != tt.wantHTML {
+				t.Errorf("Expected plain %q and HTML %q, got %q and %q", tt.wantPlain, tt.wantHTML, email.PlainBody, email.HTMLBody)
+			}
+			var attached string
+			if len(email.Attachments) == 1 {
+				attached = string(email.Attachments[0].Data)
+			}
+			if attached != tt.wantAttach || len(email.Attachments) > 1 {
+				t.Errorf("Expected attachment %q, got %+v", tt.wantAttach, email.Attachments)
+			}
+		})
+	}
+}
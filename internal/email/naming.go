@@ -0,0 +1,139 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// marshalPayload encodes a payload using the mapping's field allowlist and
+// field naming settings. Only the payload's field names are renamed; the keys
+// of its maps, such as header names, are kept as they are.
+func marshalPayload(mapping *database.EmailMapping, payload any) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	camel := strings.EqualFold(mapping.FieldNaming, "camelCase")
+	if !camel && len(mapping.FieldNameMap) == 0 && len(mapping.PayloadFields) == 0 {
+		return data, nil
+	}
+
+	// Decode generically, keeping numbers exact
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	if len(mapping.PayloadFields) > 0 {
+		value = projectFields(value, mapping.PayloadFields)
+	}
+	if !camel && len(mapping.FieldNameMap) == 0 {
+		return json.Marshal(value)
+	}
+
+	rename := func(key string) string {
+		if mapped, ok := mapping.FieldNameMap[key]; ok {
+			return mapped
+		}
+		if camel {
+			return snakeToCamel(key)
+		}
+		return key
+	}
+
+	return json.Marshal(renameKeys(value, reflect.TypeOf(payload), rename))
+}
+
+// projectFields keeps only the listed fields in each payload's data object;
+// value is a single payload or a batch of them. The envelope (version,
+// source, origin) is always kept.
+func projectFields(value any, fields []string) any {
+	switch v := value.(type) {
+	case map[string]any:
+		data, ok := v["data"].(map[string]any)
+		if !ok {
+			return v
+		}
+		projected := make(map[string]any, len(fields))
+		for _, field := range fields {
+			if child, ok := data[field]; ok {
+				projected[field] = child
+			}
+		}
+		v["data"] = projected
+		return v
+	case []any:
+		for i, child := range v {
+			v[i] = projectFields(child, fields)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// renameKeys recursively renames the object keys of a decoded value that
+// are fields of t, the type it was encoded from. Objects encoded from maps,
+// such as headers, keep their keys.
+func renameKeys(value any, t reflect.Type, rename func(string) string) any {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		if t == nil || t.Kind() != reflect.Struct {
+			return v
+		}
+		fields := jsonFields(t)
+		renamed := make(map[string]any, len(v))
+		for key, child := range v {
+			renamed[rename(key)] = renameKeys(child, fields[key], rename)
+		}
+		return renamed
+	case []any:
+		if t == nil || (t.Kind() != reflect.Slice && t.Kind() != reflect.Array) {
+			return v
+		}
+		for i, child := range v {
+			v[i] = renameKeys(child, t.Elem(), rename)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// jsonFields returns the types of a struct's fields by their JSON name
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// snakeToCamel converts a snake_case key such as "message_id" to "messageId"
+func snakeToCamel(key string) string {
+	parts := strings.Split(key, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,195 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ObjectStore stores attachment data and hands out time-limited download URLs
+type ObjectStore interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	PresignGet(key string, expiry time.Duration) (string, error)
+}
+
+// S3Config configures an S3-compatible object store
+type S3Config struct {
+	Endpoint  string // e.g. https://s3.us-east-1.amazonaws.com or a MinIO URL
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool // Address the bucket in the path instead of the host name
+}
+
+// S3Store is an ObjectStore backed by an S3-compatible service, using
+// AWS Signature Version 4
+type S3Store struct {
+	config S3Config
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewS3Store creates an S3-compatible object store
+func NewS3Store(config S3Config) (*S3Store, error) {
+	if config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("S3 bucket, access key and secret key are required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+	}
+	if _, err := url.Parse(config.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+
+	return &S3Store{
+		config: config,
+		client: &http.Client{Timeout: 60 * time.Second},
+		now:    time.Now,
+	}, nil
+}
+
+// objectURL returns the URL of an object
+func (s *S3Store) objectURL(key string) *url.URL {
+	u, _ := url.Parse(s.config.Endpoint)
+	if s.config.PathStyle {
+		u.Path = "/" + s.config.Bucket + "/" + key
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	return u
+}
+
+// Put uploads an object
+func (s *S3Store) Put(ctx context.Context, key, contentType string, data []byte) error {
+	u := s.objectURL(key)
+	req, err := http.NewRequestWithContext(ctx, "PUT", u.String(), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	now := s.now().UTC()
+	payloadHash := sha256Hex(data)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "content-type:" + contentType + "\n" +
+		"host:" + u.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + now.Format("20060102T150405Z") + "\n"
+	canonicalRequest := strings.Join([]string{"PUT", u.EscapedPath(), "", canonicalHeaders, signedHeaders, payloadHash}, "\n")
+
+	scope, signature := s.sign(now, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// PresignGet returns a URL that downloads the object until expiry passes
+func (s *S3Store) PresignGet(key string, expiry time.Duration) (string, error) {
+	u := s.objectURL(key)
+	now := s.now().UTC()
+	scope := now.Format("20060102") + "/" + s.config.Region + "/s3/aws4_request"
+
+	query := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    s.config.AccessKey + "/" + scope,
+		"X-Amz-Date":          now.Format("20060102T150405Z"),
+		"X-Amz-Expires":       fmt.Sprintf("%d", int(expiry.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+	canonicalQuery := s3CanonicalQuery(query)
+	canonicalRequest := strings.Join([]string{"GET", u.EscapedPath(), canonicalQuery, "host:" + u.Host + "\n", "host", "UNSIGNED-PAYLOAD"}, "\n")
+
+	_, signature := s.sign(now, canonicalRequest)
+	u.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+// sign returns the credential scope and SigV4 signature of a canonical request
+func (s *S3Store) sign(now time.Time, canonicalRequest string) (string, string) {
+	date := now.Format("20060102")
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return scope, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Escape percent-encodes everything except RFC 3986 unreserved characters
+func s3Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3EscapePath escapes each segment of an object path
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3CanonicalQuery encodes query parameters sorted by name
+func s3CanonicalQuery(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = s3Escape(k) + "=" + s3Escape(params[k])
+	}
+	return strings.Join(pairs, "&")
+}
@@ -2,41 +2,240 @@ package email
 
 import (
 	"bytes"
+	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"math/rand"
+	"mime"
+	"net"
 	"net/http"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/looprock/email-to-api/internal/database"
+	"github.com/looprock/email-to-api/internal/jsonschema"
 )
 
 // Processor handles email processing and forwarding
 type Processor struct {
 	db     *database.DB
 	config ProcessorConfig
+
+	// client posts to mapping endpoints, keeping connections alive so
+	// requests to the same host reuse them
+	client *http.Client
+
+	// Pending batches keyed by mapping ID
+	batchMu sync.Mutex
+	batches map[uint]*pendingBatch
+
+	// Request slots of mappings with a MaxInFlight limit, keyed by mapping ID
+	slotsMu   sync.Mutex
+	sendSlots map[uint]chan struct{}
+
+	// Number of emails currently being processed
+	inFlight atomic.Int64
+
+	// Emails buffered on disk while the database is unavailable
+	spool *spool
+
+	// Runtime settings from the database, reloaded every settingsRefresh
+	settingsMu     sync.Mutex
+	settings       database.RuntimeSettings
+	settingsLoaded time.Time
+
+	// Emails waiting in batches, counted until their batch is delivered
+	batched atomic.Int64
+
+	// Set by Shutdown to refuse new emails, which also closes closed so
+	// receivers stop listening; halted is canceled once the drain timeout
+	// runs out to abandon remaining deliveries
+	closing atomic.Bool
+	closed  chan struct{}
+	halted  context.Context
+	halt    context.CancelFunc
 }
 
+// ErrOverloaded is returned by Process when MaxInFlight emails are already
+// being processed; callers should ask the sender to retry later
+var ErrOverloaded = errors.New("too many emails in flight")
+
+// ErrMaintenance is returned by Process while maintenance mode is enabled;
+// callers should ask the sender to retry later
+var ErrMaintenance = errors.New("maintenance mode enabled")
+
+// ErrTooLarge is returned by Process for an email over the maximum size
+var ErrTooLarge = errors.New("email size exceeds maximum allowed size")
+
+// ErrLookupFailed is returned by Process when the mapping lookup kept
+// failing; callers should ask the sender to retry later
+var ErrLookupFailed = errors.New("mapping lookup failed")
+
+// ErrDeliveryFailed is returned by Process in synchronous mode when delivery
+// to the endpoint failed in a way a later attempt may not; callers should
+// ask the sender to retry later. The email isn't kept as a dead letter, as
+// the sender's retry would deliver it a second time.
+var ErrDeliveryFailed = errors.New("delivery failed")
+
 // BackoffConfig holds configuration for exponential backoff
 type BackoffConfig struct {
 	InitialDelay  time.Duration
 	MaxDelay      time.Duration
 	Multiplier    float64
 	Randomization float64
+	// MinDelay is the shortest delay calculateBackoff returns; delays,
+	// jitter included, are clamped between MinDelay and MaxDelay
+	MinDelay time.Duration
+	// Jitter selects how the capped delay is randomized: JitterNone,
+	// JitterFull or JitterEqual. Empty adds up to Randomization of the
+	// delay on top of it.
+	Jitter string
+	// MaxElapsed stops retrying once the next attempt would start this long
+	// after the first, whatever the attempt count; zero means no limit
+	MaxElapsed time.Duration
+	// NetworkInitialDelay replaces InitialDelay after a DNS failure or a
+	// refused connection, which tend to clear quickly; zero uses
+	// defaultNetworkInitialDelay, or InitialDelay if that is shorter
+	NetworkInitialDelay time.Duration
 }
 
+// Backoff jitter strategies, as described in
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+const (
+	JitterNone  = "none"  // wait exactly the capped delay
+	JitterFull  = "full"  // wait between zero and the capped delay
+	JitterEqual = "equal" // wait between half and all of the capped delay
+)
+
 // ProcessorConfig holds configuration for the email processor
 type ProcessorConfig struct {
 	MaxSize       int64
 	RetryAttempts int
 	RetryDelay    int
 	Backoff       BackoffConfig
+	// Synchronous forwards emails inline so delivery failures are returned
+	// from Process (and surface as SMTP errors) instead of being logged only.
+	// A failure a retry may fix becomes a 451 for the sender to retry rather
+	// than a dead letter.
+	Synchronous bool
+	// AcceptedDomains restricts which recipient domains are accepted at RCPT;
+	// empty accepts any domain
+	AcceptedDomains []string
+	// MaxInFlight caps the number of emails processed concurrently; beyond it
+	// new mail is refused with a temporary failure. Zero means no limit.
+	MaxInFlight int
+	// InstanceLabel is sent as "origin" so consumers can tell deployments apart
+	InstanceLabel string
+	// Attachments controls inlining vs. uploading attachments to object storage
+	Attachments AttachmentConfig
+	// MaxResponseBytes caps how much of an endpoint's response body is read
+	MaxResponseBytes int64
+	// DeadLetterMaxBytes caps the payload kept with a dead letter; larger
+	// payloads are left out, so the email can be inspected but not replayed
+	DeadLetterMaxBytes int64
+	// MaxIdleConnsPerHost is how many idle keep-alive connections are kept
+	// per endpoint host, and IdleConnTimeout how long they are kept; zero
+	// uses defaultMaxIdleConnsPerHost and defaultIdleConnTimeout
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	// SpoolDir buffers emails on disk when the database is unavailable;
+	// empty disables spooling
+	SpoolDir string
+	// SynthesizeHeaders gives emails without a Message-ID or Date header
+	// generated ones, so endpoints can rely on both being present
+	SynthesizeHeaders bool
+	// LookupError handles a failed mapping lookup when no spool is
+	// configured: "" logs the error and drops the email, LookupErrorRetry
+	// retries the lookup with backoff and, if it keeps failing, asks the
+	// sender to retry later when processing synchronously
+	LookupError string
+	// InvalidSender handles mail whose envelope sender is empty or not a
+	// valid address: "" accepts it, InvalidSenderReject refuses it at MAIL
+	// FROM and InvalidSenderDrop accepts and discards it
+	InvalidSender string
+	// MaxLineLength rejects messages containing a line longer than this many
+	// bytes (excluding CRLF); zero means no limit beyond the SMTP server's own
+	MaxLineLength int
+	// MaxConnections caps concurrent SMTP connections; further connections
+	// are answered with a 421 and closed. Zero means no limit.
+	MaxConnections int
+	// RejectInactive refuses recipients whose mapping exists but is inactive
+	// with a 550 at RCPT instead of accepting and dropping their mail
+	RejectInactive bool
+	// MaxTags caps the number of tags taken from the subject and
+	// MaxTagLength their length in characters; longer tags are truncated.
+	// Zero means no limit.
+	MaxTags      int
+	MaxTagLength int
+	// IncludeTLSInfo adds whether the email arrived over TLS, and with which
+	// protocol version and cipher, to the payload
+	IncludeTLSInfo bool
+	// Metrics optionally records processing outcomes and endpoint latency
+	Metrics *Metrics
+	// WebhookAuth verifies requests to the webhook receiver, which refuses
+	// to start without it
+	WebhookAuth *WebhookAuth
+	// UnknownRecipient handles email for addresses without a mapping: ""
+	// drops it, UnknownRecipientReject refuses the recipient at RCPT and
+	// UnknownRecipientBounce drops it and replies to the sender through
+	// BounceNotifier, rendered with BounceTemplate (nil uses the defaults)
+	UnknownRecipient string
+	BounceNotifier   BounceNotifier
+	BounceTemplate   *BounceTemplate
 }
 
+// LookupErrorRetry retries failed mapping lookups instead of dropping
+const LookupErrorRetry = "retry"
+
+// Invalid sender handling modes
+const (
+	InvalidSenderReject = "reject"
+	InvalidSenderDrop   = "drop"
+)
+
+// Log statuses of emails refused for exceeding the maximum email size. The
+// same limit is applied by the SMTP server to the whole message and by the
+// processor to the body, and the status tells which of them refused it.
+const (
+	// StatusRejectedAtSMTPSize is logged when the SMTP server refuses a
+	// message during the transaction; the sender gets a 552
+	StatusRejectedAtSMTPSize = "rejected-at-smtp-size"
+	// StatusDroppedOversize is logged when the processor drops an accepted
+	// email whose body is too large
+	StatusDroppedOversize = "dropped-oversize"
+)
+
+// defaultNetworkInitialDelay is the first retry delay after a DNS failure or
+// a refused connection when none is configured
+const defaultNetworkInitialDelay = 250 * time.Millisecond
+
+// Endpoint connection pool defaults
+const (
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// defaultMaxResponseBytes is the response body cap when none is configured
+const defaultMaxResponseBytes = 64 * 1024
+
+// defaultDeadLetterMaxBytes is the dead letter payload cap when none is configured
+const defaultDeadLetterMaxBytes = 1024 * 1024
+
 // New creates a new email processor
 func New(db *database.DB, config ProcessorConfig) *Processor {
 	// Set default backoff values if not configured
@@ -46,30 +245,82 @@ func New(db *database.DB, config ProcessorConfig) *Processor {
 	if config.Backoff.MaxDelay == 0 {
 		config.Backoff.MaxDelay = 30 * time.Second
 	}
-	if config.Backoff.Multiplier == 0 {
+	if config.Backoff.Multiplier <= 1 {
+		// A multiplier of 1 or less would never grow the delay
+		if config.Backoff.Multiplier != 0 {
+			log.Printf("Warning: retry backoff multiplier %v must be greater than 1, using 2", config.Backoff.Multiplier)
+		}
 		config.Backoff.Multiplier = 2.0
 	}
+	if config.Backoff.MinDelay > config.Backoff.MaxDelay {
+		log.Printf("Warning: retry backoff floor %v exceeds the maximum delay %v, using the maximum", config.Backoff.MinDelay, config.Backoff.MaxDelay)
+		config.Backoff.MinDelay = config.Backoff.MaxDelay
+	}
+	if config.Backoff.NetworkInitialDelay == 0 {
+		config.Backoff.NetworkInitialDelay = min(defaultNetworkInitialDelay, config.Backoff.InitialDelay)
+	}
 	if config.Backoff.Randomization == 0 {
 		config.Backoff.Randomization = 0.2 // 20% randomization
 	}
+	switch config.Backoff.Jitter {
+	case "", JitterNone, JitterFull, JitterEqual:
+	default:
+		log.Printf("Warning: unknown retry jitter %q, using the default", config.Backoff.Jitter)
+		config.Backoff.Jitter = ""
+	}
+	if config.MaxResponseBytes <= 0 {
+		config.MaxResponseBytes = defaultMaxResponseBytes
+	}
+	if config.DeadLetterMaxBytes <= 0 {
+		config.DeadLetterMaxBytes = defaultDeadLetterMaxBytes
+	}
 
-	return &Processor{
-		db:     db,
-		config: config,
+	if config.MaxIdleConnsPerHost <= 0 {
+		config.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if config.IdleConnTimeout <= 0 {
+		config.IdleConnTimeout = defaultIdleConnTimeout
+	}
+
+	p := &Processor{
+		db:        db,
+		config:    config,
+		client:    newEndpointClient(config),
+		batches:   make(map[uint]*pendingBatch),
+		sendSlots: make(map[uint]chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	p.halted, p.halt = context.WithCancel(context.Background())
+	if config.SpoolDir != "" {
+		p.spool = &spool{dir: config.SpoolDir}
 	}
+	return p
+}
+
+// newEndpointClient returns the client for posting to mapping endpoints:
+// the default transport with a larger idle connection pool per host
+func newEndpointClient(config ProcessorConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 0 // Limited per host instead
+	transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = config.IdleConnTimeout
+	return &http.Client{Transport: transport}
 }
 
 // Email represents a processed email
 type Email struct {
-	// Basic email fields
-	From    string
-	To      string
-	Subject string
-	Body    string
+	// Basic email fields. To is the envelope recipient this email is being
+	// forwarded for; HeaderTo lists every address in the To header.
+	From     string
+	To       string
+	HeaderTo []string
+	Subject  string
+	Body     string
 
 	// Additional recipients
-	Cc  []string
-	Bcc []string
+	Cc      []string
+	Bcc     []string
+	ReplyTo []string
 
 	// Message metadata
 	MessageID  string
@@ -83,20 +334,34 @@ type Email struct {
 	HTMLBody                string
 	PlainBody               string
 	Attachments             []Attachment
+	// Calendar is the first event of an attached calendar invite
+	Calendar *CalendarEvent
 
 	// Connection info
 	ReceivedFrom    string
 	ReceivedAt      time.Time
 	AuthenticatedAs string
+	// TLS state of the receiving SMTP session; version and cipher are empty
+	// for plaintext sessions
+	ReceivedTLS bool
+	TLSVersion  string
+	TLSCipher   string
+
+	// RequestID correlates this email across logs, the database and the endpoint
+	RequestID string
 
 	// All headers in raw form
 	Headers map[string][]string
+	// FoldedHeaders holds the headers that were folded over several lines,
+	// with their line breaks, as received
+	FoldedHeaders map[string][]string
 }
 
 // Attachment represents an email attachment
 type Attachment struct {
 	Filename    string
 	ContentType string
+	ContentID   string // Set for inline parts referenced from the HTML as cid:
 	Data        []byte
 }
 
@@ -107,10 +372,18 @@ type EmailData struct {
 	To      string `json:"to"`
 	Subject string `json:"subject"`
 	Body    string `json:"body"`
+	// EnvelopeTo is the mapped recipient (same as To); HeaderTo is the full
+	// recipient list from the To header, which may name other addresses
+	EnvelopeTo string   `json:"envelope_to,omitempty"`
+	HeaderTo   []string `json:"header_to,omitempty"`
+	// CleanBody is the plain body without quoted replies and signature,
+	// set when the mapping enables StripQuoted
+	CleanBody string `json:"clean_body,omitempty"`
 
 	// Additional recipients
-	Cc  []string `json:"cc,omitempty"`
-	Bcc []string `json:"bcc,omitempty"`
+	Cc      []string `json:"cc,omitempty"`
+	Bcc     []string `json:"bcc,omitempty"`
+	ReplyTo []string `json:"reply_to,omitempty"`
 
 	// Message metadata
 	MessageID  string    `json:"message_id,omitempty"`
@@ -124,155 +397,666 @@ type EmailData struct {
 	HTMLBody                string `json:"html_body,omitempty"`
 	PlainBody               string `json:"plain_body,omitempty"`
 
+	// Attachments, inlined or as presigned object storage URLs
+	Attachments []AttachmentData `json:"attachments,omitempty"`
+
+	// Calendar invite details, when the email carries one
+	Calendar *CalendarEvent `json:"calendar,omitempty"`
+
 	// Connection info
 	ReceivedFrom    string    `json:"received_from,omitempty"`
 	ReceivedAt      time.Time `json:"received_at"`
 	AuthenticatedAs string    `json:"authenticated_as,omitempty"`
+	// TLS state of the receiving session, set when IncludeTLSInfo is enabled
+	ReceivedTLS *bool  `json:"received_tls,omitempty"`
+	TLSVersion  string `json:"tls_version,omitempty"`
+	TLSCipher   string `json:"tls_cipher,omitempty"`
 
 	// All headers
 	Headers map[string][]string `json:"headers,omitempty"`
+	// HeadersFlat holds one string per header, set when the mapping
+	// enables FlattenHeaders
+	HeadersFlat map[string]string `json:"headers_flat,omitempty"`
+	// FoldedHeaders holds the headers that were folded, as received
+	FoldedHeaders map[string][]string `json:"folded_headers,omitempty"`
 
-	// Tags extracted from subject (lowercased)
+	// Mailing list and auto-response headers
+	ListUnsubscribe []string `json:"list_unsubscribe,omitempty"`
+	AutoSubmitted   string   `json:"auto_submitted,omitempty"`
+	Precedence      string   `json:"precedence,omitempty"`
+
+	// Tags extracted from subject, lowercased unless the mapping preserves case
 	Tags []string `json:"tags"`
 }
 
 // ProcessedData represents the JSON payload to be sent to the API
 type ProcessedData struct {
-	Data   EmailData `json:"data"`
-	Source string    `json:"source"`
+	Version string    `json:"version"` // Payload schema version, see PayloadVersion
+	Data    EmailData `json:"data"`
+	Source  string    `json:"source"`
+	Origin  string    `json:"origin,omitempty"` // Configured instance label
+}
+
+// acceptsDomain reports whether the recipient's domain is handled by this server
+func (p *Processor) acceptsDomain(address string) bool {
+	if len(p.config.AcceptedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return false
+	}
+	domain := address[at+1:]
+	for _, accepted := range p.config.AcceptedDomains {
+		if strings.EqualFold(accepted, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// validSender reports whether an envelope sender is a non-empty, well-formed address
+func validSender(from string) bool {
+	if strings.TrimSpace(from) == "" {
+		return false
+	}
+	_, err := mail.ParseAddress(from)
+	return err == nil
+}
+
+// isAutoSubmitted reports whether an Auto-Submitted value marks automatic mail (RFC 3834)
+func isAutoSubmitted(value string) bool {
+	keyword, _, _ := strings.Cut(value, ";")
+	keyword = strings.ToLower(strings.TrimSpace(keyword))
+	return keyword != "" && keyword != "no"
+}
+
+// stripHeaders returns headers without the named ones; a name ending in "*"
+// matches every header with that prefix. Names are case-insensitive.
+func stripHeaders(headers map[string][]string, names []string) map[string][]string {
+	if len(names) == 0 || len(headers) == 0 {
+		return headers
+	}
+
+	kept := make(map[string][]string, len(headers))
+	for key, values := range headers {
+		if !headerMatches(key, names) {
+			kept[key] = values
+		}
+	}
+	return kept
+}
+
+// flattenHeaders returns one value per header: names differing only in case
+// are merged, repeated values dropped and the rest joined with ", "
+func flattenHeaders(headers map[string][]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	// Sorted so merged names always list their values in the same order
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	merged := make(map[string][]string, len(headers))
+	for _, key := range keys {
+		name := textproto.CanonicalMIMEHeaderKey(key)
+		merged[name] = append(merged[name], headers[key]...)
+	}
+
+	flat := make(map[string]string, len(merged))
+	for key, values := range merged {
+		var unique []string
+		seen := make(map[string]bool, len(values))
+		for _, value := range values {
+			if !seen[value] {
+				seen[value] = true
+				unique = append(unique, value)
+			}
+		}
+		flat[key] = strings.Join(unique, ", ")
+	}
+	return flat
+}
+
+// headerMatches reports whether key matches any of the header name patterns
+func headerMatches(key string, names []string) bool {
+	key = strings.ToLower(key)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isDeliveryReport reports whether a Content-Type marks a delivery status
+// notification, i.e. a bounce (RFC 3464)
+func isDeliveryReport(contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "multipart/report" && strings.EqualFold(params["report-type"], "delivery-status")
+}
+
+// parseListUnsubscribe extracts the URIs from a List-Unsubscribe header (RFC 2369)
+func parseListUnsubscribe(value string) []string {
+	var uris []string
+	for _, part := range strings.Split(value, ",") {
+		uri := strings.Trim(strings.TrimSpace(part), "<>")
+		if uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
+// newRequestID generates a random identifier for tracing an email through the pipeline
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestLogger returns a logger that tags every line with the request ID
+func requestLogger(requestID string) *log.Logger {
+	return log.New(log.Writer(), fmt.Sprintf("%srequest_id=%s ", log.Prefix(), requestID), log.Flags())
 }
 
 // calculateBackoff calculates the next backoff duration with jitter
 func (p *Processor) calculateBackoff(attempt int) time.Duration {
-	// Calculate base delay using exponential backoff
-	multiplier := math.Pow(p.config.Backoff.Multiplier, float64(attempt))
-	delay := time.Duration(float64(p.config.Backoff.InitialDelay) * multiplier)
+	return p.backoffFrom(p.config.Backoff.InitialDelay, attempt)
+}
 
-	// Apply maximum delay cap
-	if delay > p.config.Backoff.MaxDelay {
-		delay = p.config.Backoff.MaxDelay
+// calculateNetworkBackoff is calculateBackoff for transient network errors,
+// growing from the shorter NetworkInitialDelay
+func (p *Processor) calculateNetworkBackoff(attempt int) time.Duration {
+	return p.backoffFrom(p.config.Backoff.NetworkInitialDelay, attempt)
+}
+
+// backoffFrom calculates the backoff of an attempt growing from initial
+func (p *Processor) backoffFrom(initial time.Duration, attempt int) time.Duration {
+	cfg := p.config.Backoff
+
+	// Calculate base delay using exponential backoff, capped before
+	// converting so large attempts can't overflow
+	exact := float64(initial) * math.Pow(cfg.Multiplier, float64(attempt))
+	delay := cfg.MaxDelay
+	if exact < float64(cfg.MaxDelay) {
+		delay = time.Duration(exact)
 	}
 
-	// Add randomization/jitter
-	jitterRange := float64(delay) * p.config.Backoff.Randomization
-	jitter := time.Duration(rand.Float64() * jitterRange)
-	delay = delay + jitter
+	switch cfg.Jitter {
+	case JitterNone:
+	case JitterFull:
+		delay = time.Duration(rand.Int63n(int64(delay) + 1))
+	case JitterEqual:
+		half := delay / 2
+		delay = half + time.Duration(rand.Int63n(int64(delay-half)+1))
+	default:
+		// Add randomization/jitter
+		jitterRange := float64(delay) * cfg.Randomization
+		delay += time.Duration(rand.Float64() * jitterRange)
+	}
 
-	return delay
+	return min(max(delay, cfg.MinDelay), cfg.MaxDelay)
 }
 
 // Process handles the email processing workflow
 func (p *Processor) Process(email Email) error {
-	log.Printf("Processing email from %s to %s with subject: %q", email.From, email.To, email.Subject)
+	if email.RequestID == "" {
+		email.RequestID = newRequestID()
+	}
+	logger := requestLogger(email.RequestID)
+	logger.Printf("Processing email from %s to %s with subject: %q", email.From, email.To, email.Subject)
+
+	if p.runtimeSettings().MaintenanceMode {
+		logger.Printf("Refusing email: maintenance mode enabled")
+		return ErrMaintenance
+	}
 
 	// Check email size immediately
-	if int64(len(email.Body)) > p.config.MaxSize {
-		log.Printf("Email size %d bytes exceeds maximum allowed size of %d bytes", len(email.Body), p.config.MaxSize)
+	maxSize := p.maxSize()
+	if int64(len(email.Body)) > maxSize {
+		logger.Printf("Dropping email: body size %d bytes exceeds maximum allowed size of %d bytes", len(email.Body), maxSize)
 		// Log the dropped email due to size
-		if err := p.db.LogEmailProcessing(
+		if err := p.logProcessing(
+			nil,
 			email.To,
 			email.Subject,
-			"dropped",
-			fmt.Sprintf("email size %d bytes exceeds maximum allowed size of %d bytes", len(email.Body), p.config.MaxSize),
-			nil,
-			uint(1), // default user ID
+			StatusDroppedOversize,
+			fmt.Sprintf("body size %d bytes exceeds maximum allowed size of %d bytes", len(email.Body), maxSize),
+			email.RequestID,
+			email.ReceivedFrom,
 		); err != nil {
-			log.Printf("Failed to log dropped email: %v", err)
+			logger.Printf("Failed to log dropped email: %v", err)
+		}
+		return ErrTooLarge
+	}
+	logger.Printf("Email size check passed: %d bytes", len(email.Body))
+
+	if !p.acquire() {
+		logger.Printf("Refusing email: %d emails already in flight", p.inFlight.Load())
+		return ErrOverloaded
+	}
+
+	if p.config.Synchronous {
+		defer p.release()
+		if err := p.processAsync(email, true); err != nil {
+			logger.Printf("Synchronous processing failed: %v", err)
+			return err
 		}
-		return fmt.Errorf("email size exceeds maximum allowed size")
+		return nil
 	}
-	log.Printf("Email size check passed: %d bytes", len(email.Body))
 
 	// Start async processing
 	go func() {
-		if err := p.processAsync(email); err != nil {
-			log.Printf("Async processing failed: %v", err)
+		defer p.release()
+		if err := p.processAsync(email, false); err != nil {
+			logger.Printf("Async processing failed: %v", err)
 		}
 	}()
 
 	return nil
 }
 
+// Overloaded reports whether the in-flight limit has been reached
+func (p *Processor) Overloaded() bool {
+	return p.config.MaxInFlight > 0 && p.inFlight.Load() >= int64(p.config.MaxInFlight)
+}
+
+// acquire reserves an in-flight slot, returning false when none is free
+func (p *Processor) acquire() bool {
+	if p.closing.Load() {
+		return false
+	}
+	n := p.inFlight.Add(1)
+	if p.config.MaxInFlight > 0 && n > int64(p.config.MaxInFlight) {
+		p.inFlight.Add(-1)
+		return false
+	}
+	return true
+}
+
+// release frees an in-flight slot taken by acquire
+func (p *Processor) release() {
+	p.inFlight.Add(-1)
+}
+
+// logProcessing records an email's final status in the processing log and
+// the metrics. mapping is nil when the email couldn't be matched to one; the
+// log entry then goes to the default user and the metrics leave out the
+// mapping label so unknown recipients can't add series.
+func (p *Processor) logProcessing(mapping *database.EmailMapping, emailAddress, subject, status, errorMsg, requestID, receivedFrom string) error {
+	var headers map[string]string
+	userID, label := uint(1), ""
+	if mapping != nil {
+		headers, userID, label = mapping.Headers, mapping.UserID, mapping.GeneratedEmail
+	}
+	p.config.Metrics.ObserveProcessed(label, status)
+	return p.db.LogEmailProcessing(emailAddress, subject, status, errorMsg, headers, userID, requestID, clientIP(receivedFrom))
+}
+
+// clientIP returns the IP address of a "host:port" peer address, or the
+// address as given if it has no port
+func clientIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// synthesizeHeaders fills in a missing Message-ID, derived from the request
+// ID and the recipient's domain, and a missing Date, set to when the email
+// was received. Both are added to the forwarded headers as well.
+func synthesizeHeaders(logger *log.Logger, email *Email) {
+	messageID := email.MessageID == "" && getHeaderFold(email.Headers, "Message-ID") == ""
+	date := getHeaderFold(email.Headers, "Date") == ""
+	if !messageID && !date {
+		return
+	}
+
+	// Copy the headers so the caller's map is left alone
+	headers := make(map[string][]string, len(email.Headers)+2)
+	for name, values := range email.Headers {
+		headers[name] = values
+	}
+	if messageID {
+		domain := "localhost"
+		if at := strings.LastIndex(email.To, "@"); at >= 0 && at < len(email.To)-1 {
+			domain = email.To[at+1:]
+		}
+		email.MessageID = fmt.Sprintf("<%s@%s>", email.RequestID, domain)
+		headers["Message-Id"] = []string{email.MessageID}
+		logger.Printf("Synthesized Message-ID %s", email.MessageID)
+	}
+	if date {
+		email.Date = email.ReceivedAt
+		if email.Date.IsZero() {
+			email.Date = time.Now()
+		}
+		headers["Date"] = []string{email.Date.Format(time.RFC1123Z)}
+		logger.Printf("Synthesized Date %s", headers["Date"][0])
+	}
+	email.Headers = headers
+}
+
 // processAsync handles the asynchronous email processing workflow
-func (p *Processor) processAsync(email Email) error {
+func (p *Processor) processAsync(email Email, senderRetries bool) error {
+	logger := requestLogger(email.RequestID)
+
+	if p.config.SynthesizeHeaders {
+		synthesizeHeaders(logger, &email)
+	}
+
 	// Get API endpoint mapping for the recipient
-	mapping, err := p.db.GetEmailMapping(email.To)
+	mapping, err := p.lookupMapping(logger, email.To)
+	if err != nil && p.spool != nil {
+		// The database is unreachable; keep the email on disk for replay
+		// rather than dropping it
+		logger.Printf("Error getting email mapping for address %q, spooling email: %v", email.To, err)
+		if spoolErr := p.spool.write(email); spoolErr != nil {
+			return fmt.Errorf("failed to get email mapping: %w (spooling failed: %v)", err, spoolErr)
+		}
+		return nil
+	}
 	if err != nil {
-		log.Printf("Error getting email mapping for address %q: %v", email.To, err)
+		logger.Printf("Error getting email mapping for address %q: %v", email.To, err)
 		// Log the error in getting mapping
-		if logErr := p.db.LogEmailProcessing(
+		if logErr := p.logProcessing(
+			nil,
 			email.To,
 			email.Subject,
 			"error",
 			fmt.Sprintf("failed to get email mapping: %v", err),
-			nil,
-			uint(1), // Use default user ID only for logging errors when we can't find the mapping
+			email.RequestID,
+			email.ReceivedFrom,
 		); logErr != nil {
-			log.Printf("Failed to log error: %v", logErr)
+			logger.Printf("Failed to log error: %v", logErr)
+		}
+		if p.config.LookupError == LookupErrorRetry {
+			return fmt.Errorf("%w: %v", ErrLookupFailed, err)
 		}
 		return fmt.Errorf("failed to get email mapping: %w", err)
 	}
 	if mapping == nil {
-		log.Printf("No mapping found for email address %q - dropping email from %q with subject %q",
+		logger.Printf("No mapping found for email address %q - dropping email from %q with subject %q",
 			email.To, email.From, email.Subject)
 		// Log the dropped email
-		if err := p.db.LogEmailProcessing(
+		if err := p.logProcessing(
+			nil,
 			email.To,
 			email.Subject,
 			"dropped",
 			"no mapping found",
-			nil,
-			uint(1), // Use default user ID only for logging errors when we can't find the mapping
+			email.RequestID,
+			email.ReceivedFrom,
 		); err != nil {
-			log.Printf("Failed to log dropped email: %v", err)
+			logger.Printf("Failed to log dropped email: %v", err)
+		}
+		if p.config.UnknownRecipient == UnknownRecipientBounce {
+			p.bounceUnknownRecipient(logger, email)
 		}
 		return nil
 	}
 
 	if !mapping.IsActive {
-		log.Printf("Mapping found for %q but it is inactive - dropping email from %q with subject %q",
+		logger.Printf("Mapping found for %q but it is inactive - dropping email from %q with subject %q",
 			email.To, email.From, email.Subject)
 		// Log the dropped email
-		if err := p.db.LogEmailProcessing(
+		if err := p.logProcessing(
+			mapping,
 			email.To,
 			email.Subject,
 			"dropped",
 			"mapping is inactive",
-			mapping.Headers,
-			mapping.UserID,
+			email.RequestID,
+			email.ReceivedFrom,
+		); err != nil {
+			logger.Printf("Failed to log dropped email: %v", err)
+		}
+		return nil
+	}
+
+	logger.Printf("Found active mapping for %q to endpoint %q", email.To, mapping.EndpointURL)
+
+	if p.config.InvalidSender == InvalidSenderDrop && !validSender(email.From) {
+		logger.Printf("Dropping email with invalid sender %q", email.From)
+		if err := p.logProcessing(
+			mapping,
+			email.To,
+			email.Subject,
+			"dropped",
+			fmt.Sprintf("invalid sender %q", email.From),
+			email.RequestID,
+			email.ReceivedFrom,
+		); err != nil {
+			logger.Printf("Failed to log dropped email: %v", err)
+		}
+		return nil
+	}
+
+	autoSubmitted := getHeaderFold(email.Headers, "Auto-Submitted")
+	if mapping.DropAutoSubmitted && isAutoSubmitted(autoSubmitted) {
+		logger.Printf("Filtering auto-submitted email (Auto-Submitted: %s) from %q", autoSubmitted, email.From)
+		if err := p.logProcessing(
+			mapping,
+			email.To,
+			email.Subject,
+			"filtered",
+			fmt.Sprintf("auto-submitted email (%s)", autoSubmitted),
+			email.RequestID,
+			email.ReceivedFrom,
+		); err != nil {
+			logger.Printf("Failed to log filtered email: %v", err)
+		}
+		return nil
+	}
+
+	if isDeliveryReport(email.ContentType) {
+		switch mapping.BounceAction {
+		case database.BounceActionDrop:
+			logger.Printf("Dropping bounce from %q", email.From)
+			if err := p.logProcessing(
+				mapping,
+				email.To,
+				email.Subject,
+				"bounce",
+				"delivery status notification dropped",
+				email.RequestID,
+				email.ReceivedFrom,
+			); err != nil {
+				logger.Printf("Failed to log bounce: %v", err)
+			}
+			return nil
+		case database.BounceActionRoute:
+			logger.Printf("Routing bounce from %q to bounce endpoint %q", email.From, mapping.BounceEndpoint)
+			routed := *mapping
+			routed.EndpointURL = mapping.BounceEndpoint
+			routed.BatchSize = 0 // Bounces are delivered one by one
+			routed.DigestSchedule = ""
+			mapping = &routed
+		}
+	}
+
+	// Digest mappings hold emails for a summary posted once per period
+	if mapping.DigestSchedule != "" && mapping.ChatFormat == "" {
+		if err := p.addToDigest(logger, mapping, email); err != nil {
+			logger.Printf("Failed to hold email for digest: %v", err)
+			if logErr := p.logProcessing(
+				mapping,
+				email.To,
+				email.Subject,
+				"error",
+				err.Error(),
+				email.RequestID,
+				email.ReceivedFrom,
+			); logErr != nil {
+				logger.Printf("Failed to log error: %v", logErr)
+			}
+			return err
+		}
+		return nil
+	}
+
+	processedEmail := p.buildPayload(logger, mapping, email)
+
+	// Batched mappings are delivered together once the batch fills or its
+	// window ends; chat targets get one message per email
+	if mapping.BatchSize > 0 && mapping.ChatFormat == "" {
+		p.addToBatch(mapping, processedEmail, email.RequestID)
+		return nil
+	}
+
+	// Log the payload for debugging
+	payloadJSON, _ := json.Marshal(processedEmail)
+	logger.Printf("Sending payload to API: %s", string(payloadJSON))
+
+	// Track the delivery so operators can see, retry or cancel it while pending
+	delivery, err := p.db.CreateDelivery(mapping.ID, email.RequestID, email.Subject)
+	if err != nil {
+		logger.Printf("Warning: Failed to track delivery: %v", err)
+	}
+
+	// Send to API with retries and exponential backoff
+	lastErr := p.sendWithRetry(logger, mapping.EndpointURL, delivery, func() error {
+		return p.sendToAPI(mapping, processedEmail, email.RequestID, idempotencyKey(mapping, email))
+	})
+	if delivery != nil {
+		if err := p.db.DeleteDelivery(delivery.ID); err != nil {
+			logger.Printf("Warning: Failed to clear delivery %d: %v", delivery.ID, err)
+		}
+	}
+	if lastErr == nil {
+		logger.Printf("Successfully sent email to endpoint %q", mapping.EndpointURL)
+
+		// Log successful processing
+		if err := p.logProcessing(
+			mapping,
+			email.To,
+			email.Subject,
+			"success",
+			"",
+			email.RequestID,
+			email.ReceivedFrom,
+		); err != nil {
+			logger.Printf("Warning: Failed to log successful processing: %v", err)
+			return fmt.Errorf("failed to log success: %w", err)
+		}
+		logger.Printf("Successfully logged email processing in database")
+
+		return nil
+	}
+
+	// The endpoint's status code maps to drop: discard without failing
+	if statusAction(lastErr) == database.StatusActionDrop {
+		logger.Printf("Dropping email: %v", lastErr)
+		if err := p.logProcessing(
+			mapping,
+			email.To,
+			email.Subject,
+			"dropped",
+			lastErr.Error(),
+			email.RequestID,
+			email.ReceivedFrom,
 		); err != nil {
-			log.Printf("Failed to log dropped email: %v", err)
+			logger.Printf("Failed to log dropped email: %v", err)
 		}
 		return nil
 	}
 
-	log.Printf("Found active mapping for %q to endpoint %q", email.To, mapping.EndpointURL)
+	// Log failed processing
+	if err := p.logProcessing(
+		mapping,
+		email.To,
+		email.Subject,
+		"error",
+		lastErr.Error(),
+		email.RequestID,
+		email.ReceivedFrom,
+	); err != nil {
+		logger.Printf("Warning: Failed to log error processing: %v", err)
+		return fmt.Errorf("failed to log error: %w", err)
+	}
+
+	// The sender is told to retry, so keeping the email as well would
+	// deliver it twice; failures that would recur are kept and refused
+	if senderRetries && !isPermanent(lastErr) && !errors.Is(lastErr, errDeliveryCanceled) {
+		return fmt.Errorf("%w after %d attempts: %v", ErrDeliveryFailed, p.retryAttempts(), lastErr)
+	}
+	p.saveFailedPayload(logger, email.RequestID, string(payloadJSON))
+	if !errors.Is(lastErr, errDeliveryCanceled) {
+		p.saveDeadLetter(logger, mapping, email, payloadJSON, lastErr)
+	}
+
+	return fmt.Errorf("failed to process email after %d attempts: %w",
+		p.retryAttempts(), lastErr)
+}
+
+// lookupMapping gets the active mapping for address. Lookup errors are
+// retried with backoff when LookupError is LookupErrorRetry; a missing
+// mapping is not an error and returns nil.
+func (p *Processor) lookupMapping(logger *log.Logger, address string) (*database.EmailMapping, error) {
+	attempts := 1
+	if p.config.LookupError == LookupErrorRetry && p.spool == nil {
+		attempts = max(p.retryAttempts(), 1)
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		var mapping *database.EmailMapping
+		if mapping, err = p.db.GetEmailMapping(address); err == nil {
+			return mapping, nil
+		}
+		if attempt == attempts-1 {
+			break
+		}
+		backoff := p.calculateBackoff(attempt)
+		logger.Printf("Mapping lookup for %q failed: %v. Retrying in %v...", address, err, backoff)
+		if sleepErr := p.sleep(backoff); sleepErr != nil {
+			return nil, err
+		}
+	}
+	return nil, err
+}
 
-	// Process the subject into array of tags
-	tags := strings.Fields(email.Subject)
+// buildPayload converts an email into the payload posted to the mapping's endpoint
+func (p *Processor) buildPayload(logger *log.Logger, mapping *database.EmailMapping, email Email) ProcessedData {
+	autoSubmitted := getHeaderFold(email.Headers, "Auto-Submitted")
+
+	// Process the subject into array of tags, followed by the mapping's static tags
+	preserveCase := mapping.TagCase == database.TagCasePreserve
+	tags := mergeTags(preserveCase, p.limitTags(logger, strings.Fields(email.Subject), preserveCase), mapping.StaticTags)
 	if len(tags) == 0 {
 		// Ensure we always have at least one tag
 		tags = []string{"untagged"}
-		log.Printf("No tags found in subject, using default tag: %q", tags[0])
+		logger.Printf("No tags found in subject, using default tag: %q", tags[0])
 	} else {
-		// Convert tags to lowercase
-		for i, tag := range tags {
-			tags[i] = strings.ToLower(tag)
-		}
-		log.Printf("Extracted %d tags from subject: %v", len(tags), tags)
+		logger.Printf("Extracted %d tags from subject and mapping: %v", len(tags), tags)
 	}
 
 	// Convert Email to EmailData
 	emailData := EmailData{
 		// Basic fields
-		From:    email.From,
-		To:      email.To,
-		Subject: email.Subject,
-		Body:    email.Body,
+		From:       email.From,
+		To:         email.To,
+		Subject:    email.Subject,
+		Body:       email.Body,
+		EnvelopeTo: email.To,
+		HeaderTo:   email.HeaderTo,
 
 		// Additional recipients
-		Cc:  email.Cc,
-		Bcc: email.Bcc,
+		Cc:      email.Cc,
+		Bcc:     email.Bcc,
+		ReplyTo: email.ReplyTo,
 
 		// Message metadata
 		MessageID:  email.MessageID,
@@ -283,88 +1067,307 @@ func (p *Processor) processAsync(email Email) error {
 		// Content details
 		ContentType:             email.ContentType,
 		ContentTransferEncoding: email.ContentTransferEncoding,
-		HTMLBody:                email.HTMLBody,
+		HTMLBody:                rewriteCIDs(email.HTMLBody, email.Attachments, mapping.InlineImages),
 		PlainBody:               email.PlainBody,
+		Attachments:             p.prepareAttachments(logger, email),
+		Calendar:                email.Calendar,
 
 		// Connection info
 		ReceivedFrom:    email.ReceivedFrom,
 		ReceivedAt:      email.ReceivedAt,
 		AuthenticatedAs: email.AuthenticatedAs,
 
-		// All headers
-		Headers: email.Headers,
+		// All headers, less the ones the mapping strips
+		Headers:       stripHeaders(email.Headers, mapping.StripHeaders),
+		FoldedHeaders: stripHeaders(email.FoldedHeaders, mapping.StripHeaders),
+
+		// Mailing list and auto-response headers
+		ListUnsubscribe: parseListUnsubscribe(getHeaderFold(email.Headers, "List-Unsubscribe")),
+		AutoSubmitted:   autoSubmitted,
+		Precedence:      getHeaderFold(email.Headers, "Precedence"),
 
 		// Tags
 		Tags: tags,
 	}
 
-	processedEmail := ProcessedData{
+	if p.config.IncludeTLSInfo {
+		receivedTLS := email.ReceivedTLS
+		emailData.ReceivedTLS = &receivedTLS
+		emailData.TLSVersion = email.TLSVersion
+		emailData.TLSCipher = email.TLSCipher
+	}
+
+	if mapping.FlattenHeaders {
+		emailData.HeadersFlat = flattenHeaders(emailData.Headers)
+	}
+
+	if mapping.StripQuoted {
+		plain := email.PlainBody
+		if plain == "" {
+			plain = email.Body
+		}
+		emailData.CleanBody = stripQuoted(plain)
+	}
+
+	payload := ProcessedData{
 		Data:   emailData,
 		Source: "email",
+		Origin: p.config.InstanceLabel,
 	}
+	return pinPayloadVersion(logger, payload, mapping.PayloadVersion)
+}
 
-	// Log the payload for debugging
-	payloadJSON, _ := json.Marshal(processedEmail)
-	log.Printf("Sending payload to API: %s", string(payloadJSON))
+// mergeTags combines tag lists in order, dropping duplicates. Tags are
+// lowercased unless preserveCase is set.
+func mergeTags(preserveCase bool, lists ...[]string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		for _, tag := range list {
+			tag = strings.TrimSpace(tag)
+			if !preserveCase {
+				tag = strings.ToLower(tag)
+			}
+			if tag == "" || seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
 
-	// Send to API with retries and exponential backoff
+// limitTags applies MaxTagLength and MaxTags to the tags taken from the
+// subject, truncating long tags and dropping the ones past the limit.
+// Static tags are configured per mapping and aren't limited.
+func (p *Processor) limitTags(logger *log.Logger, words []string, preserveCase bool) []string {
+	if maxLen := p.config.MaxTagLength; maxLen > 0 {
+		truncated := 0
+		for i, word := range words {
+			if runes := []rune(word); len(runes) > maxLen {
+				words[i] = string(runes[:maxLen])
+				truncated++
+			}
+		}
+		if truncated > 0 {
+			logger.Printf("Truncated %d subject tag(s) to %d characters", truncated, maxLen)
+		}
+	}
+
+	tags := mergeTags(preserveCase, words)
+	if maxTags := p.config.MaxTags; maxTags > 0 && len(tags) > maxTags {
+		logger.Printf("Dropping %d subject tag(s) beyond the limit of %d", len(tags)-maxTags, maxTags)
+		tags = tags[:maxTags]
+	}
+	return tags
+}
+
+// Preview returns the exact JSON body that would be posted to the mapping's
+// endpoint for email, without sending it
+func (p *Processor) Preview(mapping *database.EmailMapping, email Email) ([]byte, error) {
+	if email.RequestID == "" {
+		email.RequestID = newRequestID()
+	}
+	return encodePayload(mapping, p.buildPayload(requestLogger(email.RequestID), mapping, email))
+}
+
+// sendWithRetry calls send until it succeeds or the retry attempts are
+// exhausted, backing off between attempts. It returns the last error.
+// When delivery is non-nil each failed attempt is recorded on it, and the
+// wait can be cut short or the delivery canceled from the admin interface.
+func (p *Processor) sendWithRetry(logger *log.Logger, endpoint string, delivery *database.Delivery, send func() error) error {
+	attempts := max(p.retryAttempts(), 1)
+	start := time.Now()
 	var lastErr error
-	for attempt := 0; attempt < p.config.RetryAttempts; attempt++ {
-		log.Printf("Attempt %d/%d: Sending to endpoint %q", attempt+1, p.config.RetryAttempts, mapping.EndpointURL)
-		if err := p.sendToAPI(mapping.EndpointURL, mapping.Headers, processedEmail); err != nil {
+	for attempt := 0; attempt < attempts; attempt++ {
+		logger.Printf("Attempt %d/%d: Sending to endpoint %q", attempt+1, attempts, endpoint)
+		if err := send(); err != nil {
 			lastErr = err
+			if attempt == attempts-1 {
+				break
+			}
+			if isPermanent(err) {
+				logger.Printf("Attempt %d failed permanently: %v", attempt+1, err)
+				break
+			}
 			backoff := p.calculateBackoff(attempt)
-			log.Printf("Attempt %d failed: %v. Retrying in %v...", attempt+1, err, backoff)
-			time.Sleep(backoff)
+			failure := "failed"
+			if kind := networkErrorKind(err); kind != "" {
+				backoff = p.calculateNetworkBackoff(attempt)
+				failure = fmt.Sprintf("failed (transient %s)", kind)
+			}
+			if limit := p.config.Backoff.MaxElapsed; limit > 0 && time.Since(start)+backoff > limit {
+				logger.Printf("Attempt %d %s: %v. Giving up, retrying would exceed %v", attempt+1, failure, err, limit)
+				break
+			}
+			logger.Printf("Attempt %d %s: %v. Retrying in %v...", attempt+1, failure, err, backoff)
+			if delivery == nil {
+				if err := p.sleep(backoff); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := p.waitForRetry(logger, delivery.ID, attempt+1, err, backoff); err != nil {
+				return err
+			}
 			continue
 		}
+		return nil
+	}
+	return lastErr
+}
 
-		log.Printf("Successfully sent email to endpoint %q", mapping.EndpointURL)
+// networkErrorKind describes the transient network failure behind err, a
+// failed DNS lookup or a refused connection, or returns "" for other errors.
+// These usually clear within moments, e.g. while a resolver or the endpoint
+// restarts, so they are retried sooner.
+func networkErrorKind(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "DNS failure"
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return "connection refused"
+	}
+	return ""
+}
 
-		// Log successful processing
-		if err := p.db.LogEmailProcessing(
-			email.To,
-			email.Subject,
-			"success",
-			"",
-			mapping.Headers,
-			mapping.UserID, // Use the mapping's UserID for logging
-		); err != nil {
-			log.Printf("Warning: Failed to log successful processing: %v", err)
-			return fmt.Errorf("failed to log success: %w", err)
+// deliveryPollInterval is how often a waiting delivery checks for a manual
+// retry or cancel
+var deliveryPollInterval = time.Second
+
+// errDeliveryCanceled is returned when a pending delivery is canceled
+var errDeliveryCanceled = errors.New("delivery canceled")
+
+// waitForRetry records a failed attempt and waits until the delivery's next
+// attempt is due, returning errDeliveryCanceled if it is canceled meanwhile
+func (p *Processor) waitForRetry(logger *log.Logger, id uint, attempts int, lastErr error, backoff time.Duration) error {
+	deadline := time.Now().Add(backoff)
+	if err := p.db.RecordDeliveryAttempt(id, attempts, lastErr.Error(), deadline); err != nil {
+		logger.Printf("Warning: %v", err)
+	}
+
+	for {
+		delivery, err := p.db.GetDelivery(id)
+		if err == nil {
+			if delivery.Status == database.DeliveryCanceled {
+				logger.Printf("Delivery %d canceled", id)
+				return errDeliveryCanceled
+			}
+			deadline = delivery.NextAttemptAt
 		}
-		log.Printf("Successfully logged email processing in database")
 
-		return nil
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		if err := p.sleep(min(remaining, deliveryPollInterval)); err != nil {
+			return err
+		}
 	}
+}
 
-	// Log failed processing
-	if err := p.db.LogEmailProcessing(
-		email.To,
-		email.Subject,
-		"error",
-		lastErr.Error(),
-		mapping.Headers,
-		mapping.UserID, // Use the mapping's UserID for logging
-	); err != nil {
-		log.Printf("Warning: Failed to log error processing: %v", err)
-		return fmt.Errorf("failed to log error: %w", err)
+// sendToAPI sends the processed data to the mapping's API endpoint
+func (p *Processor) sendToAPI(mapping *database.EmailMapping, payload ProcessedData, requestID, idempotencyKey string) error {
+	data, err := encodePayload(mapping, payload)
+	if err != nil {
+		return &permanentError{err: fmt.Errorf("failed to encode payload: %w", err)}
+	}
+	if err := checkPayloadSchema(mapping, data); err != nil {
+		return &permanentError{err: err}
 	}
 
-	return fmt.Errorf("failed to process email after %d attempts: %w",
-		p.config.RetryAttempts, lastErr)
+	return p.postJSON(mapping, data, requestID, idempotencyKey)
+}
+
+// idempotencyKey identifies an email's delivery to a mapping so the endpoint
+// can dedupe retries. It is derived from the Message-ID when there is one,
+// so a message redelivered by the sender gets the same key, and falls back
+// to the email's request ID.
+func idempotencyKey(mapping *database.EmailMapping, email Email) string {
+	if email.MessageID == "" {
+		return email.RequestID
+	}
+	sum := sha256.Sum256([]byte(email.MessageID + "\x00" + mapping.GeneratedEmail))
+	return hex.EncodeToString(sum[:16])
+}
+
+// statusError reports an endpoint response that the mapping's status
+// actions don't treat as success
+type statusError struct {
+	Action     string
+	StatusCode int
+	Body       string
+}
+
+func (e *statusError) Error() string {
+	switch e.Action {
+	case database.StatusActionDrop:
+		return fmt.Sprintf("endpoint asked to drop the email with status: %d, body: %s", e.StatusCode, e.Body)
+	case database.StatusActionDeadLetter:
+		return fmt.Sprintf("endpoint rejected the email permanently with status: %d, body: %s", e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("API request failed with status: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// statusAction returns the status action behind err, or "" if err wasn't
+// caused by an endpoint response
+func statusAction(err error) string {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Action
+	}
+	return ""
+}
+
+// permanentError marks a failure that would recur on every attempt, such as
+// a payload failing its mapping's schema, so it isn't retried
+type permanentError struct {
+	err error
 }
 
-// sendToAPI sends the processed data to the specified API endpoint
-func (p *Processor) sendToAPI(endpoint string, headers map[string]string, payload ProcessedData) error {
-	data, err := json.Marshal(payload)
+func (e *permanentError) Error() string { return e.err.Error() }
+
+func (e *permanentError) Unwrap() error { return e.err }
+
+// checkPayloadSchema validates an encoded payload against the mapping's
+// PayloadSchema, if it has one
+func checkPayloadSchema(mapping *database.EmailMapping, data []byte) error {
+	if mapping.PayloadSchema == "" {
+		return nil
+	}
+	schema, err := jsonschema.Compile(mapping.PayloadSchema)
 	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
+		return fmt.Errorf("invalid payload schema: %w", err)
 	}
+	if err := schema.Validate(data); err != nil {
+		return fmt.Errorf("payload does not match the mapping's schema: %w", err)
+	}
+	return nil
+}
+
+// isPermanent reports whether err should not be retried
+func isPermanent(err error) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return true
+	}
+	action := statusAction(err)
+	return action == database.StatusActionDrop || action == database.StatusActionDeadLetter
+}
+
+// postJSON sends an already-encoded body to the mapping's endpoint with the
+// mapping's HTTP method. idempotencyKey is sent unchanged on every attempt
+// for the same delivery.
+func (p *Processor) postJSON(mapping *database.EmailMapping, data []byte, requestID, idempotencyKey string) error {
+	logger := requestLogger(requestID)
+	endpoint, headers := mapping.EndpointURL, mapping.Headers
 
-	log.Printf("Sending request to %s with payload: %s", endpoint, string(data))
+	logger.Printf("Sending %s request to %s with payload: %s", mapping.Method(), endpoint, string(data))
 
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(data))
+	// Abandoned on shutdown once the drain timeout runs out
+	req, err := http.NewRequestWithContext(p.halted, mapping.Method(), endpoint, bytes.NewBuffer(data))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -372,31 +1375,105 @@ func (p *Processor) sendToAPI(endpoint string, headers map[string]string, payloa
 	// Set default Content-Type if not specified in headers
 	if _, hasContentType := headers["Content-Type"]; !hasContentType {
 		req.Header.Set("Content-Type", "application/json")
-		log.Printf("Using default Content-Type: application/json")
+		logger.Printf("Using default Content-Type: application/json")
 	}
 
+	// Tag the request so it can be correlated with our logs
+	req.Header.Set("X-Request-ID", requestID)
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+
 	// Add custom headers
 	for key, value := range headers {
 		req.Header.Set(key, value)
-		log.Printf("Added custom header: %s: %s", key, value)
+		logger.Printf("Added custom header: %s: %s", key, value)
+	}
+
+	release, err := p.acquireSendSlot(mapping)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
 	}
+	defer release()
 
-	log.Printf("Request headers: %v", req.Header)
+	// Signed last so a custom header can't replace the signature, and once
+	// the slot is free so waiting for it doesn't age the timestamp
+	if mapping.SigningSecret != "" {
+		req.Header.Set("X-Signature", signPayload(mapping.SigningSecret, time.Now(), data))
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	logger.Printf("Request headers: %v", req.Header)
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	p.config.Metrics.ObserveLatency(endpoint, time.Since(start))
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// Read and log response body for debugging
-	respBody, _ := io.ReadAll(resp.Body)
-	log.Printf("Response status: %d, body: %s", resp.StatusCode, string(respBody))
+	// Read and log response body for debugging, capped so a huge response
+	// can't exhaust memory
+	respBody := readResponseBody(resp.Body, p.config.MaxResponseBytes)
+	logger.Printf("Response status: %d, body: %s", resp.StatusCode, respBody)
+
+	if action := mapping.StatusAction(resp.StatusCode); action != database.StatusActionSuccess {
+		return &statusError{Action: action, StatusCode: resp.StatusCode, Body: respBody}
+	}
 
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("API request failed with status: %d, body: %s", resp.StatusCode, string(respBody))
+	// Some endpoints report failures in a 2xx body; retry unless it matches
+	if mapping.SuccessPattern != "" && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		re, err := regexp.Compile(mapping.SuccessPattern)
+		if err != nil {
+			return fmt.Errorf("invalid success pattern: %w", err)
+		}
+		if !re.MatchString(respBody) {
+			return fmt.Errorf("response body does not match success pattern, status: %d, body: %s", resp.StatusCode, respBody)
+		}
 	}
 
-	log.Printf("API request successful (status %d)", resp.StatusCode)
+	logger.Printf("API request successful (status %d)", resp.StatusCode)
 	return nil
 }
+
+// signPayload returns the X-Signature header value for a request body:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">". The
+// timestamp is part of what is signed so receivers can refuse stale requests.
+func signPayload(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmacSHA256([]byte(secret), ts+"."+string(body))
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac)
+}
+
+// acquireSendSlot waits until fewer than the mapping's MaxInFlight requests
+// to its endpoint are running, whichever path they come from, and returns
+// the function that frees the slot again. When the limit is changed, requests
+// already running finish under the old one.
+func (p *Processor) acquireSendSlot(mapping *database.EmailMapping) (func(), error) {
+	if mapping.MaxInFlight <= 0 {
+		return func() {}, nil
+	}
+
+	p.slotsMu.Lock()
+	slots, ok := p.sendSlots[mapping.ID]
+	if !ok || cap(slots) != mapping.MaxInFlight {
+		slots = make(chan struct{}, mapping.MaxInFlight)
+		p.sendSlots[mapping.ID] = slots
+	}
+	p.slotsMu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-p.halted.Done():
+		return nil, p.halted.Err()
+	}
+}
+
+// readResponseBody reads at most limit bytes of a response body, marking the
+// result when the body was longer
+func readResponseBody(r io.Reader, limit int64) string {
+	body, _ := io.ReadAll(io.LimitReader(r, limit+1))
+	if int64(len(body)) > limit {
+		return string(body[:limit]) + "... (truncated)"
+	}
+	return string(body)
+}
@@ -1,12 +1,33 @@
 package email
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/looprock/email-to-api/internal/database"
+	"gorm.io/gorm"
 )
 
 func TestProcessor_Process(t *testing.T) {
@@ -54,36 +75,14 @@ func TestProcessor_Process(t *testing.T) {
 	db, err := database.New(&database.Config{
 		Driver: "sqlite",
 		DSN:    ":memory:",
+		Domain: "example.com",
 	})
 	if err != nil {
 		t.Fatalf("Failed to create test database: %v", err)
 	}
 
 	// Create test tables
-	err = db.DB.Exec(`
-		CREATE TABLE email_mappings (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id INTEGER NOT NULL,
-			generated_email TEXT NOT NULL UNIQUE,
-			endpoint_url TEXT NOT NULL,
-			description TEXT,
-			headers TEXT,
-			is_active BOOLEAN DEFAULT TRUE,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		);
-		CREATE TABLE email_logs (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			mapping_id INTEGER,
-			from_address TEXT NOT NULL,
-			subject TEXT,
-			body TEXT,
-			processed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			status TEXT NOT NULL,
-			error_message TEXT,
-			headers TEXT
-		);
-	`).Error
+	err = db.AutoMigrate(&database.User{}, &database.EmailMapping{}, &database.EmailLog{}, &database.Delivery{})
 	if err != nil {
 		t.Fatalf("Failed to create test tables: %v", err)
 	}
@@ -113,3 +112,1458 @@ func TestProcessor_Process(t *testing.T) {
 		t.Errorf("Failed to process email: %v", err)
 	}
 }
+
+// newTestDB creates a file-backed SQLite database with the schema migrated
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+
+	db, err := database.New(&database.Config{
+		Driver: "sqlite",
+		DSN:    filepath.Join(t.TempDir(), "test.db"),
+		Domain: "example.com",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.AutoMigrate(&database.User{}, &database.EmailMapping{}, &database.EmailLog{}, &database.Delivery{}, &database.Setting{}, &database.DigestEntry{}, &database.DeadLetter{}); err != nil {
+		t.Fatalf("Failed to create test tables: %v", err)
+	}
+
+	return db
+}
+
+// testBackoff keeps retry delays short in tests
+var testBackoff = BackoffConfig{
+	InitialDelay: time.Millisecond,
+	MaxDelay:     5 * time.Millisecond,
+}
+
+func TestCalculateBackoff_Jitter(t *testing.T) {
+	// The second retry waits 20ms before jitter, below the 30ms cap
+	const base = 20 * time.Millisecond
+	tests := []struct {
+		jitter   string
+		min, max time.Duration
+	}{
+		{jitter: JitterNone, min: base, max: base},
+		{jitter: JitterFull, min: 0, max: base},
+		{jitter: JitterEqual, min: base / 2, max: base},
+		{jitter: "", min: base, max: base + base/5},
+	}
+	for _, tt := range tests {
+		name := tt.jitter
+		if name == "" {
+			name = "default"
+		}
+		t.Run(name, func(t *testing.T) {
+			p := New(nil, ProcessorConfig{Backoff: BackoffConfig{
+				InitialDelay: 10 * time.Millisecond,
+				MaxDelay:     30 * time.Millisecond,
+				Multiplier:   2,
+				Jitter:       tt.jitter,
+			}})
+
+			lowest, highest := time.Duration(math.MaxInt64), time.Duration(0)
+			for i := 0; i < 1000; i++ {
+				delay := p.calculateBackoff(1)
+				lowest, highest = min(lowest, delay), max(highest, delay)
+			}
+			if lowest < tt.min || highest > tt.max {
+				t.Errorf("Delays ranged %v..%v, want within %v..%v", lowest, highest, tt.min, tt.max)
+			}
+			// Randomized strategies should actually spread the delays
+			if tt.jitter != JitterNone && highest == lowest {
+				t.Errorf("Expected randomized delays, all were %v", lowest)
+			}
+		})
+	}
+}
+
+func TestProcessor_DropsOversizedEmail(t *testing.T) {
+	called := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024, RetryAttempts: 1, Synchronous: true})
+
+	err := processor.Process(Email{
+		From:    "sender@example.com",
+		To:      mapping.GeneratedEmail,
+		Subject: "too big",
+		Body:    strings.Repeat("x", 2000),
+	})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Process() error = %v, want ErrTooLarge", err)
+	}
+	if called {
+		t.Error("Expected oversized email not to be forwarded")
+	}
+
+	var entry database.EmailLog
+	if err := db.Where("mapping_id = ?", mapping.ID).First(&entry).Error; err != nil {
+		t.Fatalf("Failed to load log entry: %v", err)
+	}
+	if entry.Status != StatusDroppedOversize {
+		t.Errorf("Expected %q log, got %q", StatusDroppedOversize, entry.Status)
+	}
+}
+
+func TestProcessor_MappingMaxInFlight(t *testing.T) {
+	var running, peak atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{MaxInFlight: 2})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "load"}); err != nil {
+				t.Errorf("Process() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := peak.Load(); got != 2 {
+		t.Errorf("Expected at most 2 concurrent requests, reaching the limit, got a peak of %d", got)
+	}
+}
+
+func TestProcessor_SignsPayload(t *testing.T) {
+	var body []byte
+	var signature string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get("X-Signature")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	unsigned := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	if err := processor.Process(Email{From: "sender@example.com", To: unsigned.GeneratedEmail, Subject: "plain"}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if signature != "" {
+		t.Errorf("Expected no signature without a secret, got %q", signature)
+	}
+
+	// A custom header must not be able to replace the signature
+	signed, err := db.CreateEmailMapping(1, ts.URL, "Signed", map[string]string{"X-Signature": "forged"})
+	if err != nil {
+		t.Fatalf("Failed to create mapping: %v", err)
+	}
+	if err := db.SetMappingSigningSecret(signed.GeneratedEmail, 1, "s3cret"); err != nil {
+		t.Fatalf("Failed to set signing secret: %v", err)
+	}
+	before := time.Now().Unix()
+	if err := processor.Process(Email{From: "sender@example.com", To: signed.GeneratedEmail, Subject: "signed"}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	ts1, v1, ok := strings.Cut(signature, ",v1=")
+	if !ok || !strings.HasPrefix(ts1, "t=") {
+		t.Fatalf("Expected a t=...,v1=... signature, got %q", signature)
+	}
+	timestamp, err := strconv.ParseInt(strings.TrimPrefix(ts1, "t="), 10, 64)
+	if err != nil || timestamp < before || timestamp > time.Now().Unix() {
+		t.Errorf("Expected the current time in the signature, got %q", ts1)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(strings.TrimPrefix(ts1, "t=") + "." + string(body)))
+	if want := hex.EncodeToString(mac.Sum(nil)); v1 != want {
+		t.Errorf("Signature %q does not verify, want %q", v1, want)
+	}
+}
+
+func TestProcessor_SignsAfterWaitingForSendSlot(t *testing.T) {
+	var mu sync.Mutex
+	var signatures []string
+	first := make(chan struct{})
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		signatures = append(signatures, r.Header.Get("X-Signature"))
+		n := len(signatures)
+		mu.Unlock()
+		if n == 1 {
+			close(first)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{MaxInFlight: 1})
+	if err := db.SetMappingSigningSecret(mapping.GeneratedEmail, 1, "s3cret"); err != nil {
+		t.Fatalf("Failed to set signing secret: %v", err)
+	}
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	var wg sync.WaitGroup
+	send := func() {
+		defer wg.Done()
+		if err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "queued"}); err != nil {
+			t.Errorf("Process() error = %v", err)
+		}
+	}
+	wg.Add(2)
+	go send()
+	<-first
+	go send()
+
+	// Hold the only slot across a second boundary before freeing it
+	time.Sleep(1100 * time.Millisecond)
+	freed := time.Now().Unix()
+	close(release)
+	wg.Wait()
+
+	if len(signatures) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(signatures))
+	}
+	ts1, _, _ := strings.Cut(strings.TrimPrefix(signatures[1], "t="), ",")
+	timestamp, err := strconv.ParseInt(ts1, 10, 64)
+	if err != nil || timestamp < freed {
+		t.Errorf("Expected the waiting request to be signed once the slot was free (t >= %d), got %q", freed, signatures[1])
+	}
+}
+
+func TestProcessor_RetryMaxElapsed(t *testing.T) {
+	var attempts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{
+		MaxSize:       1024 * 1024,
+		RetryAttempts: 100,
+		Synchronous:   true,
+		Backoff: BackoffConfig{
+			InitialDelay: 20 * time.Millisecond,
+			MaxDelay:     20 * time.Millisecond,
+			Jitter:       JitterNone,
+			MaxElapsed:   100 * time.Millisecond,
+		},
+	})
+
+	start := time.Now()
+	err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "test subject"})
+	if err == nil {
+		t.Fatal("Expected delivery to fail")
+	}
+
+	// Attempts at roughly 0, 20, 40, 60 and 80ms; the next would start past 100ms
+	if got := attempts.Load(); got < 2 || got > 5 {
+		t.Errorf("Expected retries to stop at the elapsed ceiling after 2-5 attempts, got %d", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected retrying to stop near 100ms, took %v", elapsed)
+	}
+
+	var count int64
+	db.Model(&database.EmailLog{}).Where("status = ?", "error").Count(&count)
+	if count != 1 {
+		t.Errorf("Expected 1 error log, got %d", count)
+	}
+}
+
+func TestCalculateBackoff_Clamp(t *testing.T) {
+	backoff := BackoffConfig{
+		InitialDelay: 10 * time.Millisecond,
+		MaxDelay:     30 * time.Millisecond,
+		MinDelay:     15 * time.Millisecond,
+		Multiplier:   2,
+	}
+
+	// Full jitter on the first 10ms delay never reaches the floor, so every
+	// delay is raised to it
+	backoff.Jitter = JitterFull
+	p := New(nil, ProcessorConfig{Backoff: backoff})
+	for i := 0; i < 1000; i++ {
+		if delay := p.calculateBackoff(0); delay != backoff.MinDelay {
+			t.Fatalf("Full jitter delay = %v, want floor %v", delay, backoff.MinDelay)
+		}
+	}
+
+	// Jitter added on top of a capped delay doesn't exceed the cap, even
+	// for attempts large enough to overflow the exponential
+	backoff.Jitter = ""
+	p = New(nil, ProcessorConfig{Backoff: backoff})
+	for _, attempt := range []int{2, 10, 100, 10000} {
+		if delay := p.calculateBackoff(attempt); delay != backoff.MaxDelay {
+			t.Errorf("Attempt %d delay = %v, want cap %v", attempt, delay, backoff.MaxDelay)
+		}
+	}
+}
+
+// flakyTransport fails the first failures requests with err, then sends
+// requests normally
+type flakyTransport struct {
+	failures atomic.Int32
+	err      error
+}
+
+func (f *flakyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if f.failures.Add(-1) >= 0 {
+		return nil, f.err
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestProcessor_RetriesDNSFailureSooner(t *testing.T) {
+	var received atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	// A regular failure would wait a minute before the next attempt
+	processor := New(db, ProcessorConfig{
+		MaxSize:       1024 * 1024,
+		RetryAttempts: 3,
+		Synchronous:   true,
+		Backoff: BackoffConfig{
+			InitialDelay:        time.Minute,
+			MaxDelay:            time.Minute,
+			NetworkInitialDelay: time.Millisecond,
+			Jitter:              JitterNone,
+		},
+	})
+	transport := &flakyTransport{err: &net.DNSError{Err: "server misbehaving", Name: "endpoint.example", IsTemporary: true}}
+	transport.failures.Store(1)
+	processor.client.Transport = transport
+
+	done := make(chan error, 1)
+	go func() {
+		done <- processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "dns"})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the DNS failure to be retried with the short network backoff")
+	}
+	if got := received.Load(); got != 1 {
+		t.Errorf("Expected the endpoint to receive the email once, got %d", got)
+	}
+}
+
+func TestNetworkErrorKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"dns", &url.Error{Op: "Post", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", IsNotFound: true}}}, "DNS failure"},
+		{"refused", &url.Error{Op: "Post", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}, "connection refused"},
+		{"status", &statusError{Action: database.StatusActionRetry, StatusCode: 503}, ""},
+		{"timeout", context.DeadlineExceeded, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := networkErrorKind(fmt.Errorf("failed to send request: %w", tt.err)); got != tt.want {
+				t.Errorf("networkErrorKind() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateBackoff_DegenerateMultiplier(t *testing.T) {
+	for _, multiplier := range []float64{1, 0.5, -2} {
+		p := New(nil, ProcessorConfig{Backoff: BackoffConfig{
+			InitialDelay: time.Millisecond,
+			MaxDelay:     time.Second,
+			Multiplier:   multiplier,
+			Jitter:       JitterNone,
+		}})
+		first, third := p.calculateBackoff(0), p.calculateBackoff(2)
+		if third <= first {
+			t.Errorf("Multiplier %v: delays didn't grow (%v then %v)", multiplier, first, third)
+		}
+	}
+}
+
+func TestProcessor_ReusesEndpointConnections(t *testing.T) {
+	var conns atomic.Int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	for i := 0; i < 5; i++ {
+		if err := processor.Process(Email{
+			From:    "sender@example.com",
+			To:      mapping.GeneratedEmail,
+			Subject: "keep-alive",
+			Body:    "Test email body",
+		}); err != nil {
+			t.Fatalf("Failed to process email %d: %v", i, err)
+		}
+	}
+
+	if got := conns.Load(); got != 1 {
+		t.Errorf("Expected sequential requests to share 1 connection, opened %d", got)
+	}
+}
+
+func TestProcessor_ProcessSynchronousReturnsError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping, err := db.CreateEmailMapping(1, ts.URL, "Failing Mapping", nil)
+	if err != nil {
+		t.Fatalf("Failed to create test mapping: %v", err)
+	}
+
+	processor := New(db, ProcessorConfig{
+		MaxSize:       1024 * 1024,
+		RetryAttempts: 2,
+		Backoff:       testBackoff,
+		Synchronous:   true,
+	})
+
+	err = processor.Process(Email{
+		From:    "sender@example.com",
+		To:      mapping.GeneratedEmail,
+		Subject: "test subject",
+		Body:    "Test email body",
+	})
+	if err == nil {
+		t.Fatal("Expected an error from a failing endpoint in synchronous mode")
+	}
+
+	var count int64
+	db.Model(&database.EmailLog{}).Where("status = ?", "error").Count(&count)
+	if count != 1 {
+		t.Errorf("Expected 1 error log, got %d", count)
+	}
+}
+
+func TestProcessor_RequestIDCorrelation(t *testing.T) {
+	var sentID string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sentID = r.Header.Get("X-Request-ID")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping, err := db.CreateEmailMapping(1, ts.URL, "Test Mapping", nil)
+	if err != nil {
+		t.Fatalf("Failed to create test mapping: %v", err)
+	}
+
+	processor := New(db, ProcessorConfig{
+		MaxSize:       1024 * 1024,
+		RetryAttempts: 1,
+		Synchronous:   true,
+	})
+
+	if err := processor.Process(Email{
+		From:    "sender@example.com",
+		To:      mapping.GeneratedEmail,
+		Subject: "test subject",
+		Body:    "Test email body",
+	}); err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	var logEntry database.EmailLog
+	if err := db.Where("mapping_id = ?", mapping.ID).First(&logEntry).Error; err != nil {
+		t.Fatalf("Failed to load log entry: %v", err)
+	}
+
+	if sentID == "" {
+		t.Fatal("Expected X-Request-ID header to be sent")
+	}
+	if logEntry.RequestID != sentID {
+		t.Errorf("Expected log request ID %q to match header %q", logEntry.RequestID, sentID)
+	}
+}
+
+func TestProcessor_IdempotencyKeyStableAcrossRetries(t *testing.T) {
+	var keys []string
+	failures := 2
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 3, Backoff: testBackoff, Synchronous: true})
+
+	email := Email{
+		From:      "sender@example.com",
+		To:        mapping.GeneratedEmail,
+		Subject:   "test subject",
+		Body:      "Test email body",
+		MessageID: "<abc@example.com>",
+	}
+	if err := processor.Process(email); err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	if len(keys) != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", len(keys))
+	}
+	if keys[0] == "" {
+		t.Fatal("Expected Idempotency-Key header to be sent")
+	}
+	for i, key := range keys[1:] {
+		if key != keys[0] {
+			t.Errorf("Attempt %d sent key %q, want %q", i+2, key, keys[0])
+		}
+	}
+
+	// The sender redelivering the same message gets the same key
+	keys = nil
+	if err := processor.Process(email); err != nil {
+		t.Fatalf("Failed to process redelivered email: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != idempotencyKey(mapping, email) {
+		t.Errorf("Redelivery sent keys %v, want [%s]", keys, idempotencyKey(mapping, email))
+	}
+}
+
+// createTestMapping creates a mapping for user 1 with the given options applied
+func createTestMapping(t *testing.T, db *database.DB, endpoint string, opts database.MappingOptions) *database.EmailMapping {
+	t.Helper()
+
+	mapping, err := db.CreateEmailMapping(1, endpoint, "Test Mapping", nil)
+	if err != nil {
+		t.Fatalf("Failed to create test mapping: %v", err)
+	}
+	if err := db.UpdateMappingOptions(mapping.GeneratedEmail, 1, opts); err != nil {
+		t.Fatalf("Failed to set mapping options: %v", err)
+	}
+	mapping.MappingOptions = opts
+
+	return mapping
+}
+
+func TestProcessor_ListAndAutoResponseHeaders(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	err := processor.Process(Email{
+		From:    "list@example.com",
+		To:      mapping.GeneratedEmail,
+		Subject: "newsletter",
+		Headers: map[string][]string{
+			"List-Unsubscribe": {"<mailto:unsub@example.com>, <https://example.com/unsub>"},
+			"auto-submitted":   {"auto-generated"},
+			"Precedence":       {"bulk"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	if len(data.Data.ListUnsubscribe) != 2 || data.Data.ListUnsubscribe[1] != "https://example.com/unsub" {
+		t.Errorf("Expected parsed List-Unsubscribe URIs, got %v", data.Data.ListUnsubscribe)
+	}
+	if data.Data.AutoSubmitted != "auto-generated" {
+		t.Errorf("Expected Auto-Submitted = auto-generated, got %q", data.Data.AutoSubmitted)
+	}
+	if data.Data.Precedence != "bulk" {
+		t.Errorf("Expected Precedence = bulk, got %q", data.Data.Precedence)
+	}
+}
+
+func TestProcessor_DropAutoSubmitted(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{DropAutoSubmitted: true})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	for _, value := range []string{"auto-replied", "no"} {
+		err := processor.Process(Email{
+			From:    "someone@example.com",
+			To:      mapping.GeneratedEmail,
+			Subject: "out of office",
+			Headers: map[string][]string{"Auto-Submitted": {value}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to process email: %v", err)
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("Expected only the non-automatic email to be forwarded, got %d requests", requests)
+	}
+
+	var filtered int64
+	db.Model(&database.EmailLog{}).Where("status = ?", "filtered").Count(&filtered)
+	if filtered != 1 {
+		t.Errorf("Expected 1 filtered log entry, got %d", filtered)
+	}
+}
+
+func TestProcessor_CamelCaseFieldNaming(t *testing.T) {
+	var body map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{
+		FieldNaming:  "camelCase",
+		FieldNameMap: map[string]string{"from": "sender"},
+	})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	err := processor.Process(Email{
+		From:      "sender@example.com",
+		To:        mapping.GeneratedEmail,
+		Subject:   "camel",
+		MessageID: "<id@example.com>",
+		Headers:   map[string][]string{"X_Custom_Header": {"kept"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	data, ok := body["data"].(map[string]any)
+	if !ok {
+		t.Fatalf("Expected data object in payload, got %v", body)
+	}
+	if data["messageId"] != "<id@example.com>" {
+		t.Errorf("Expected messageId key, got payload %v", data)
+	}
+	if _, ok := data["message_id"]; ok {
+		t.Error("Expected no snake_case message_id key")
+	}
+	if data["sender"] != "sender@example.com" {
+		t.Errorf("Expected custom key map to rename from to sender, got %v", data)
+	}
+	headers, _ := data["headers"].(map[string]any)
+	if _, ok := headers["X_Custom_Header"]; !ok {
+		t.Errorf("Expected header names to be left untouched, got %v", headers)
+	}
+}
+
+func TestProcessor_CamelCaseKeepsHeaderNames(t *testing.T) {
+	var body map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{
+		FieldNaming:    "camelCase",
+		FieldNameMap:   map[string]string{"subject": "title"},
+		FlattenHeaders: true,
+	})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	err := processor.Process(Email{
+		From:          "sender@example.com",
+		To:            mapping.GeneratedEmail,
+		Subject:       "subject",
+		Headers:       map[string][]string{"Subject": {"subject"}, "X-Some_Header": {"a folded value"}},
+		FoldedHeaders: map[string][]string{"X-Some_Header": {"a\r\n folded value"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	data, ok := body["data"].(map[string]any)
+	if !ok {
+		t.Fatalf("Expected data object in payload, got %v", body)
+	}
+	if data["title"] != "subject" {
+		t.Errorf("Expected custom key map to rename subject to title, got %v", data)
+	}
+	for _, field := range []string{"headers", "headersFlat", "foldedHeaders"} {
+		headers, _ := data[field].(map[string]any)
+		kept := false
+		for name := range headers {
+			kept = kept || strings.EqualFold(name, "X-Some_Header")
+		}
+		if !kept {
+			t.Errorf("Expected header name X-Some_Header to be kept in %s, got %v", field, headers)
+		}
+		if _, ok := headers["title"]; ok {
+			t.Errorf("Expected the Subject header not to be renamed in %s, got %v", field, headers)
+		}
+	}
+}
+
+func TestProcessor_InstanceLabelOrigin(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{
+		MaxSize:       1024 * 1024,
+		RetryAttempts: 1,
+		Synchronous:   true,
+		InstanceLabel: "staging",
+	})
+
+	if err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail}); err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	if data.Origin != "staging" {
+		t.Errorf("Expected origin = staging, got %q", data.Origin)
+	}
+}
+
+func TestProcessor_StripQuoted(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{StripQuoted: true})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	body := "New reply\r\n\r\nOn Tue, Jane wrote:\r\n> old text\r\n\r\n-- \r\nJane\r\n"
+	err := processor.Process(Email{From: "jane@example.com", To: mapping.GeneratedEmail, Body: body, PlainBody: body})
+	if err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	if data.Data.Body != body {
+		t.Errorf("Expected raw body to be kept, got %q", data.Data.Body)
+	}
+	if data.Data.CleanBody != "New reply" {
+		t.Errorf("Expected clean body %q, got %q", "New reply", data.Data.CleanBody)
+	}
+}
+
+func TestProcessor_FlattenHeaders(t *testing.T) {
+	var raw struct {
+		Data struct {
+			Headers     map[string][]string `json:"headers"`
+			HeadersFlat map[string]string   `json:"headers_flat"`
+		} `json:"data"`
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&raw)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{FlattenHeaders: true})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	headers := map[string][]string{
+		"Subject":  {"hello"},
+		"Received": {"from a", "from b", "from a"},
+		"x-tag":    {"one"},
+		"X-Tag":    {"two"},
+	}
+	if err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "hello", Headers: headers}); err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	want := map[string]string{
+		"Subject":  "hello",
+		"Received": "from a, from b",
+		"X-Tag":    "two, one",
+	}
+	if !reflect.DeepEqual(raw.Data.HeadersFlat, want) {
+		t.Errorf("Expected flattened headers %v, got %v", want, raw.Data.HeadersFlat)
+	}
+	if len(raw.Data.Headers["Received"]) != 3 {
+		t.Errorf("Expected headers to be kept as received, got %v", raw.Data.Headers)
+	}
+}
+
+func TestProcessor_FoldedHeaders(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{StripHeaders: []string{"Received"}})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	email := ParseMessage([]byte("Received: from a\r\n by b\r\nSubject: a long\r\n subject\r\n\r\nbody"))
+	email.From = "sender@example.com"
+	email.To = mapping.GeneratedEmail
+	if err := processor.Process(email); err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	if data.Data.Subject != "a long subject" {
+		t.Errorf("Expected unfolded subject, got %q", data.Data.Subject)
+	}
+	want := map[string][]string{"Subject": {"a long\r\n subject"}}
+	if !reflect.DeepEqual(data.Data.FoldedHeaders, want) {
+		t.Errorf("Expected folded headers %v less the stripped ones, got %v", want, data.Data.FoldedHeaders)
+	}
+}
+
+func TestProcessor_ErrorsOnlyLogLevel(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	db := newTestDB(t)
+	opts := database.MappingOptions{LogLevel: database.LogLevelErrors}
+	succeeding := createTestMapping(t, db, ok.URL, opts)
+	erroring := createTestMapping(t, db, failing.URL, opts)
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true, Backoff: testBackoff})
+
+	if err := processor.Process(Email{From: "sender@example.com", To: succeeding.GeneratedEmail}); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if err := processor.Process(Email{From: "sender@example.com", To: erroring.GeneratedEmail}); err == nil {
+		t.Fatal("Expected delivery failure")
+	}
+
+	var successLogs, errorLogs int64
+	db.Model(&database.EmailLog{}).Where("mapping_id = ?", succeeding.ID).Count(&successLogs)
+	db.Model(&database.EmailLog{}).Where("mapping_id = ? AND status = ?", erroring.ID, "error").Count(&errorLogs)
+	if successLogs != 0 {
+		t.Errorf("Expected successful forward not to be logged, got %d log rows", successLogs)
+	}
+	if errorLogs != 1 {
+		t.Errorf("Expected 1 error log row, got %d", errorLogs)
+	}
+}
+
+func TestProcessor_ResponseBodyCapped(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(strings.Repeat("x", 8*1024*1024)))
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{
+		MaxSize:          1024 * 1024,
+		RetryAttempts:    1,
+		Synchronous:      true,
+		MaxResponseBytes: 1024,
+	})
+
+	err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail})
+	if err == nil {
+		t.Fatal("Expected delivery to fail")
+	}
+	if !strings.Contains(err.Error(), "(truncated)") {
+		t.Errorf("Expected truncated response body in error, got %.200s", err.Error())
+	}
+	if len(err.Error()) > 2048 {
+		t.Errorf("Expected response body read to be capped, error is %d bytes", len(err.Error()))
+	}
+}
+
+func TestProcessor_StaticTags(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{StaticTags: []string{"prod", "Billing"}})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "billing alert billing"})
+	if err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	want := []string{"billing", "alert", "prod"}
+	if strings.Join(data.Data.Tags, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected combined, de-duplicated tags %v, got %v", want, data.Data.Tags)
+	}
+}
+
+func TestProcessor_TagCasePreserve(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{
+		StaticTags: []string{"Billing"},
+		TagCase:    database.TagCasePreserve,
+	})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "URGENT Invoice invoice"})
+	if err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	// Tags differing only in case are kept apart
+	want := []string{"URGENT", "Invoice", "invoice", "Billing"}
+	if strings.Join(data.Data.Tags, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected tags with original casing %v, got %v", want, data.Data.Tags)
+	}
+}
+
+func TestProcessor_TagLimits(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{StaticTags: []string{"prod"}})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true, MaxTags: 3, MaxTagLength: 5})
+
+	subject := "Supercalifragilistic one two three four five six seven eight nine ten"
+	err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: subject})
+	if err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	// Three subject tags, truncated, then the static tag
+	want := []string{"super", "one", "two", "prod"}
+	if strings.Join(data.Data.Tags, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected capped tags %v, got %v", want, data.Data.Tags)
+	}
+}
+
+func TestProcessor_StripHeaders(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{StripHeaders: []string{"received", "X-Spam-*"}})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	email := ParseMessage([]byte("Received: from mx1.example.net\r\n" +
+		"Received: from mx2.example.net\r\n" +
+		"X-Spam-Score: 0.1\r\n" +
+		"X-Spam-Status: No\r\n" +
+		"X-Custom: keep\r\n" +
+		"Subject: strip\r\n" +
+		"\r\n" +
+		"body\r\n"))
+	email.From = "sender@example.com"
+	email.To = mapping.GeneratedEmail
+	if err := processor.Process(email); err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	for _, name := range []string{"Received", "X-Spam-Score", "X-Spam-Status"} {
+		if _, ok := data.Data.Headers[name]; ok {
+			t.Errorf("Expected header %s to be stripped", name)
+		}
+	}
+	for _, name := range []string{"X-Custom", "Subject"} {
+		if _, ok := data.Data.Headers[name]; !ok {
+			t.Errorf("Expected header %s to be kept, got %v", name, data.Data.Headers)
+		}
+	}
+}
+
+func TestProcessor_PayloadFields(t *testing.T) {
+	var raw struct {
+		Version string         `json:"version"`
+		Data    map[string]any `json:"data"`
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&raw)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{PayloadFields: []string{"from", "subject", "tags"}})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	err := processor.Process(Email{
+		From:      "sender@example.com",
+		To:        mapping.GeneratedEmail,
+		Subject:   "private report",
+		Body:      "secret body",
+		PlainBody: "secret body",
+		Headers:   map[string][]string{"Subject": {"private report"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	var keys []string
+	for key := range raw.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if want := "from,subject,tags"; strings.Join(keys, ",") != want {
+		t.Errorf("Expected only data fields %s, got %v", want, keys)
+	}
+	if raw.Data["subject"] != "private report" {
+		t.Errorf("Expected subject to be kept, got %v", raw.Data["subject"])
+	}
+	if raw.Version != PayloadVersion {
+		t.Errorf("Expected the envelope to be kept, got version %q", raw.Version)
+	}
+}
+
+func TestProcessor_StatusActions(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantErr      bool
+		wantAttempts int
+		wantLog      string
+	}{
+		{name: "202 success", status: http.StatusAccepted, wantAttempts: 1, wantLog: "success"},
+		{name: "409 drop", status: http.StatusConflict, wantAttempts: 1, wantLog: "dropped"},
+		{name: "410 dead-letter", status: http.StatusGone, wantErr: true, wantAttempts: 1, wantLog: "error"},
+		{name: "500 default retry", status: http.StatusInternalServerError, wantErr: true, wantAttempts: 3, wantLog: "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(tt.status)
+			}))
+			defer ts.Close()
+
+			db := newTestDB(t)
+			mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{
+				StatusActions: map[string]string{
+					"202": database.StatusActionSuccess,
+					"409": database.StatusActionDrop,
+					"410": database.StatusActionDeadLetter,
+				},
+			})
+			processor := New(db, ProcessorConfig{
+				MaxSize:       1024 * 1024,
+				RetryAttempts: 3,
+				Backoff:       testBackoff,
+				Synchronous:   true,
+			})
+
+			err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "status"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error = %v, got %v", tt.wantErr, err)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("Expected %d attempts, got %d", tt.wantAttempts, attempts)
+			}
+
+			var entry database.EmailLog
+			if err := db.Last(&entry).Error; err != nil {
+				t.Fatalf("Failed to read log: %v", err)
+			}
+			if entry.Status != tt.wantLog {
+				t.Errorf("Expected log status %q, got %q", tt.wantLog, entry.Status)
+			}
+		})
+	}
+}
+
+func TestProcessor_PayloadSchema(t *testing.T) {
+	// Requires the body, which a payload limited to from and subject lacks
+	schema := `{
+		"type": "object",
+		"required": ["version", "data"],
+		"properties": {
+			"data": {"type": "object", "required": ["from", "body"]}
+		}
+	}`
+
+	tests := []struct {
+		name         string
+		fields       []string
+		wantErr      bool
+		wantAttempts int
+		wantLog      string
+	}{
+		{name: "matching payload", wantAttempts: 1, wantLog: "success"},
+		{name: "payload missing a required field", fields: []string{"from", "subject"}, wantErr: true, wantLog: "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			db := newTestDB(t)
+			mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{PayloadSchema: schema, PayloadFields: tt.fields})
+			processor := New(db, ProcessorConfig{
+				MaxSize:       1024 * 1024,
+				RetryAttempts: 3,
+				Backoff:       testBackoff,
+				Synchronous:   true,
+			})
+
+			err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "schema", Body: "hello"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error = %v, got %v", tt.wantErr, err)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("Expected %d requests, got %d", tt.wantAttempts, attempts)
+			}
+
+			var entry database.EmailLog
+			if err := db.Last(&entry).Error; err != nil {
+				t.Fatalf("Failed to read log: %v", err)
+			}
+			if entry.Status != tt.wantLog {
+				t.Errorf("Expected log status %q, got %q", tt.wantLog, entry.Status)
+			}
+			if tt.wantErr && !strings.Contains(entry.ErrorMessage, `/data: missing required property "body"`) {
+				t.Errorf("Expected the schema violation to be logged, got %q", entry.ErrorMessage)
+			}
+		})
+	}
+}
+
+func TestProcessor_MethodAndBodyTemplate(t *testing.T) {
+	var method, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, body = r.Method, string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{
+		HTTPMethod:   database.MethodPut,
+		BodyTemplate: `{"title": {{json .Subject}}, "text": {{json .Body}}, "tags": {{json (join .Tags ",")}}}`,
+	})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	if err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "disk full", Body: `say "hi"`}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if method != "PUT" {
+		t.Errorf("Expected a PUT request, got %s", method)
+	}
+	if want := `{"title": "disk full", "text": "say \"hi\"", "tags": "disk,full"}`; body != want {
+		t.Errorf("Body = %s, want %s", body, want)
+	}
+}
+
+func TestProcessor_SuccessPattern(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantErr      bool
+		wantAttempts int
+		wantLog      string
+	}{
+		{name: "200 matching body", body: `{"ok": true}`, wantAttempts: 1, wantLog: "success"},
+		{name: "200 non-matching body", body: `{"ok": false, "error": "invalid token"}`, wantErr: true, wantAttempts: 3, wantLog: "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(tt.body))
+			}))
+			defer ts.Close()
+
+			db := newTestDB(t)
+			mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{SuccessPattern: `"ok":\s*true`})
+			processor := New(db, ProcessorConfig{
+				MaxSize:       1024 * 1024,
+				RetryAttempts: 3,
+				Backoff:       testBackoff,
+				Synchronous:   true,
+			})
+
+			err := processor.Process(Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "pattern"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error = %v, got %v", tt.wantErr, err)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("Expected %d attempts, got %d", tt.wantAttempts, attempts)
+			}
+
+			var entry database.EmailLog
+			if err := db.Last(&entry).Error; err != nil {
+				t.Fatalf("Failed to read log: %v", err)
+			}
+			if entry.Status != tt.wantLog {
+				t.Errorf("Expected log status %q, got %q", tt.wantLog, entry.Status)
+			}
+		})
+	}
+}
+
+func TestProcessor_PayloadVersion(t *testing.T) {
+	tests := []struct {
+		pinned      string
+		wantVersion string
+		wantFields  []string
+		wantAbsent  []string
+	}{
+		{pinned: "", wantVersion: PayloadVersion, wantFields: []string{"envelope_to", "reply_to"}},
+		{pinned: PayloadVersion2, wantVersion: "2", wantFields: []string{"envelope_to", "reply_to"}},
+		{pinned: PayloadVersion1, wantVersion: "1", wantFields: []string{"to", "tags"}, wantAbsent: []string{"envelope_to", "reply_to"}},
+	}
+
+	for _, tt := range tests {
+		t.Run("pinned="+tt.pinned, func(t *testing.T) {
+			var payload map[string]json.RawMessage
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&payload)
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			db := newTestDB(t)
+			mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{PayloadVersion: tt.pinned})
+			processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true, InstanceLabel: "eu-1"})
+
+			err := processor.Process(Email{
+				From:    "sender@example.com",
+				To:      mapping.GeneratedEmail,
+				Subject: "hello",
+				ReplyTo: []string{"reply@example.com"},
+			})
+			if err != nil {
+				t.Fatalf("Failed to process email: %v", err)
+			}
+
+			var version string
+			if err := json.Unmarshal(payload["version"], &version); err != nil || version != tt.wantVersion {
+				t.Errorf("Expected version %q, got %s", tt.wantVersion, payload["version"])
+			}
+			if _, ok := payload["origin"]; ok != (tt.wantVersion != PayloadVersion1) {
+				t.Errorf("Unexpected origin presence %v for version %s", ok, tt.wantVersion)
+			}
+
+			var data map[string]json.RawMessage
+			json.Unmarshal(payload["data"], &data)
+			for _, field := range tt.wantFields {
+				if _, ok := data[field]; !ok {
+					t.Errorf("Expected field %q in version %s payload", field, tt.wantVersion)
+				}
+			}
+			for _, field := range tt.wantAbsent {
+				if _, ok := data[field]; ok {
+					t.Errorf("Expected no field %q in version %s payload", field, tt.wantVersion)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessor_LookupError(t *testing.T) {
+	tests := []struct {
+		name        string
+		lookupError string
+		failures    int
+		wantErr     error
+		wantCalls   int
+		wantStatus  string
+	}{
+		{name: "drop", lookupError: "", failures: 1, wantErr: nil, wantCalls: 0, wantStatus: "error"},
+		{name: "retry recovers", lookupError: LookupErrorRetry, failures: 2, wantErr: nil, wantCalls: 1, wantStatus: "success"},
+		{name: "retry exhausted", lookupError: LookupErrorRetry, failures: 3, wantErr: ErrLookupFailed, wantCalls: 0, wantStatus: "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			db := newTestDB(t)
+			mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+			processor := New(db, ProcessorConfig{
+				MaxSize:       1024 * 1024,
+				RetryAttempts: 3,
+				Backoff:       testBackoff,
+				Synchronous:   true,
+				LookupError:   tt.lookupError,
+			})
+
+			// Simulate a database error on the next mapping lookups
+			failures := tt.failures
+			db.Callback().Query().Before("gorm:query").Register("test:lookup_error", func(tx *gorm.DB) {
+				if tx.Statement.Table == "email_mappings" && failures > 0 {
+					failures--
+					tx.AddError(errors.New("database is unavailable"))
+				}
+			})
+
+			err := processor.Process(Email{
+				From:    "sender@example.com",
+				To:      mapping.GeneratedEmail,
+				Subject: "test subject",
+				Body:    "Test email body",
+			})
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr == nil && errors.Is(err, ErrLookupFailed) {
+				t.Fatalf("Expected no retry-later error, got %v", err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("Expected %d endpoint calls, got %d", tt.wantCalls, calls)
+			}
+
+			var count int64
+			db.Model(&database.EmailLog{}).Where("status = ?", tt.wantStatus).Count(&count)
+			if count != 1 {
+				t.Errorf("Expected 1 %s log, got %d", tt.wantStatus, count)
+			}
+		})
+	}
+}
+
+func TestProcessor_SynthesizeHeaders(t *testing.T) {
+	var data ProcessedData
+	var requestID string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get("X-Request-ID")
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true, SynthesizeHeaders: true})
+
+	email := ParseMessage([]byte("Subject: no id or date\r\n\r\nbody\r\n"))
+	email.From = "sender@example.com"
+	email.To = mapping.GeneratedEmail
+	email.ReceivedAt = time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+	if err := processor.Process(email); err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	wantID := "<" + requestID + "@example.com>"
+	if requestID == "" || data.Data.MessageID != wantID {
+		t.Errorf("Expected synthesized Message-ID %q, got %q", wantID, data.Data.MessageID)
+	}
+	if got := data.Data.Headers["Message-Id"]; len(got) != 1 || got[0] != wantID {
+		t.Errorf("Expected Message-ID header %q, got %v", wantID, got)
+	}
+	if !data.Data.Date.Equal(email.ReceivedAt) {
+		t.Errorf("Expected Date to default to the received time %s, got %s", email.ReceivedAt, data.Data.Date)
+	}
+	if got := data.Data.Headers["Date"]; len(got) != 1 || got[0] != "Sat, 17 Oct 2026 09:30:00 +0000" {
+		t.Errorf("Expected Date header from the received time, got %v", got)
+	}
+
+	// Headers the message already has are left alone
+	email = ParseMessage([]byte("Message-ID: <orig@example.net>\r\nDate: Mon, 02 Jan 2006 15:04:05 -0700\r\nSubject: has both\r\n\r\nbody\r\n"))
+	email.From = "sender@example.com"
+	email.To = mapping.GeneratedEmail
+	if err := processor.Process(email); err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+	if data.Data.MessageID != "<orig@example.net>" || data.Data.Headers["Date"][0] != "Mon, 02 Jan 2006 15:04:05 -0700" {
+		t.Errorf("Expected existing Message-ID and Date to be kept, got %q and %v", data.Data.MessageID, data.Data.Headers["Date"])
+	}
+}
@@ -0,0 +1,135 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// QueueAlertConfig configures alerting on the pending-delivery queue
+type QueueAlertConfig struct {
+	// Threshold is the queue depth above which an alert fires; 0 disables alerting
+	Threshold int
+	// WebhookURL optionally receives a JSON notification when an alert fires
+	// or recovers
+	WebhookURL string
+	// Interval is how often the queue depth is sampled
+	Interval time.Duration
+}
+
+// queueAlert is the notification body posted to the alert webhook
+type queueAlert struct {
+	Event     string    `json:"event"`
+	Depth     int64     `json:"depth"`
+	Threshold int       `json:"threshold"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// QueueMonitor tracks pending-delivery queue depth and alerts once when it
+// crosses the threshold, then stays quiet until the queue recovers
+type QueueMonitor struct {
+	db     *database.DB
+	config QueueAlertConfig
+	client *http.Client
+
+	depth atomic.Int64 // most recently sampled queue depth
+
+	mu       sync.Mutex
+	alerting bool
+}
+
+// NewQueueMonitor creates a queue monitor
+func NewQueueMonitor(db *database.DB, config QueueAlertConfig) *QueueMonitor {
+	if config.Interval <= 0 {
+		config.Interval = time.Minute
+	}
+	return &QueueMonitor{
+		db:     db,
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Depth returns the most recently sampled queue depth
+func (m *QueueMonitor) Depth() int64 {
+	return m.depth.Load()
+}
+
+// Run samples the queue depth every interval until ctx is done
+func (m *QueueMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		m.Check()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check samples the queue depth and fires or clears the alert as needed
+func (m *QueueMonitor) Check() {
+	depth, err := m.db.CountPendingDeliveries()
+	if err != nil {
+		log.Printf("Failed to sample delivery queue depth: %v", err)
+		return
+	}
+	m.depth.Store(depth)
+
+	if m.config.Threshold <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	over := depth > int64(m.config.Threshold)
+	switch {
+	case over && !m.alerting:
+		m.alerting = true
+		log.Printf("ERROR: delivery queue depth %d exceeds threshold %d", depth, m.config.Threshold)
+		m.notify("queue_depth_exceeded", depth)
+	case !over && m.alerting:
+		m.alerting = false
+		log.Printf("Delivery queue depth %d back under threshold %d", depth, m.config.Threshold)
+		m.notify("queue_depth_recovered", depth)
+	}
+}
+
+// notify posts an alert event to the configured webhook, if any
+func (m *QueueMonitor) notify(event string, depth int64) {
+	if m.config.WebhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(queueAlert{
+		Event:     event,
+		Depth:     depth,
+		Threshold: m.config.Threshold,
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Failed to marshal queue alert: %v", err)
+		return
+	}
+
+	resp, err := m.client.Post(m.config.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to send queue alert: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		log.Printf("Queue alert webhook returned status %d", resp.StatusCode)
+	}
+}
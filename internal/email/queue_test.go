@@ -0,0 +1,72 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestQueueMonitor_AlertsOnceUntilRecovered(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert queueAlert
+		json.NewDecoder(r.Body).Decode(&alert)
+		mu.Lock()
+		events = append(events, alert.Event)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, "http://example.invalid", database.MappingOptions{})
+	monitor := NewQueueMonitor(db, QueueAlertConfig{Threshold: 1, WebhookURL: ts.URL})
+
+	enqueue := func() *database.Delivery {
+		t.Helper()
+		delivery, err := db.CreateDelivery(mapping.ID, "req", "subject")
+		if err != nil {
+			t.Fatalf("Failed to create delivery: %v", err)
+		}
+		return delivery
+	}
+
+	first := enqueue()
+	monitor.Check()
+	second := enqueue()
+	enqueue()
+	// Still over the threshold on every check; only the first one alerts
+	monitor.Check()
+	monitor.Check()
+
+	if got := monitor.Depth(); got != 3 {
+		t.Errorf("Expected queue depth 3, got %d", got)
+	}
+
+	for _, d := range []*database.Delivery{first, second} {
+		if err := db.DeleteDelivery(d.ID); err != nil {
+			t.Fatalf("Failed to delete delivery: %v", err)
+		}
+	}
+	monitor.Check()
+	// Crossing again after recovery alerts again
+	enqueue()
+	monitor.Check()
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"queue_depth_exceeded", "queue_depth_recovered", "queue_depth_exceeded"}
+	if len(events) != len(want) {
+		t.Fatalf("Expected events %v, got %v", want, events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("Expected event %d to be %s, got %s", i, want[i], events[i])
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// requeueBatchSize caps how many requeued emails are sent per pass
+const requeueBatchSize = 100
+
+// RetryRequeued sends the stored payloads of emails requeued from the admin
+// interface again, logging each outcome under the original request ID.
+// Emails that fail again keep their payload so they can be requeued later.
+func (p *Processor) RetryRequeued() {
+	for {
+		logs, err := p.db.ClaimRequeuedLogs(requeueBatchSize)
+		if err != nil {
+			log.Printf("Failed to claim requeued emails: %v", err)
+		}
+		for _, entry := range logs {
+			p.retryLogged(entry)
+		}
+		if err != nil || len(logs) < requeueBatchSize {
+			return
+		}
+	}
+}
+
+// retryLogged sends one requeued email's payload to its mapping's endpoint
+func (p *Processor) retryLogged(entry database.EmailLog) {
+	logger := requestLogger(entry.RequestID)
+	mapping := &entry.Mapping
+	if mapping.ID == 0 {
+		logger.Printf("Not retrying email: mapping %d no longer exists", entry.MappingID)
+		return
+	}
+
+	var payload ProcessedData
+	if err := json.Unmarshal([]byte(entry.Payload), &payload); err != nil {
+		logger.Printf("Not retrying email: invalid stored payload: %v", err)
+		return
+	}
+
+	logger.Printf("Retrying requeued email to endpoint %q", mapping.EndpointURL)
+	key := idempotencyKey(mapping, Email{MessageID: payload.Data.MessageID, RequestID: entry.RequestID})
+	lastErr := p.sendWithRetry(logger, mapping.EndpointURL, nil, func() error {
+		return p.sendToAPI(mapping, payload, entry.RequestID, key)
+	})
+
+	status, errorMsg := "success", ""
+	if lastErr != nil {
+		status, errorMsg = "error", lastErr.Error()
+		if statusAction(lastErr) == database.StatusActionDrop {
+			status = "dropped"
+		}
+		logger.Printf("Retry of requeued email failed: %v", lastErr)
+	} else {
+		logger.Printf("Successfully retried email to endpoint %q", mapping.EndpointURL)
+	}
+
+	if err := p.logProcessing(mapping, entry.FromAddress, payload.Data.Subject, status, errorMsg, entry.RequestID, entry.ClientIP); err != nil {
+		logger.Printf("Failed to log retried email: %v", err)
+		return
+	}
+	if status == "error" {
+		p.saveFailedPayload(logger, entry.RequestID, entry.Payload)
+	}
+}
+
+// RunRequeuedRetries sends requeued emails and replays queued dead letters
+// every interval until ctx is done
+func (p *Processor) RunRequeuedRetries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.RetryRequeued()
+		p.RetryDeadLetters()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// saveFailedPayload keeps a failed email's payload on its error log
+func (p *Processor) saveFailedPayload(logger *log.Logger, requestID, payload string) {
+	if err := p.db.SaveFailedPayload(requestID, payload); err != nil {
+		logger.Printf("Warning: Failed to keep payload for bulk retry: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestProcessor_RetryRequeued(t *testing.T) {
+	var up atomic.Bool
+	var subject atomic.Value
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload ProcessedData
+		json.NewDecoder(r.Body).Decode(&payload)
+		subject.Store(payload.Data.Subject)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{
+		MaxSize:       1024 * 1024,
+		RetryAttempts: 1,
+		Backoff:       testBackoff,
+	})
+
+	email := Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "try again", RequestID: "req-1", ReceivedFrom: "192.0.2.7:2525"}
+	// Delivered as in asynchronous mode; a synchronous sender retries instead
+	if err := processor.processAsync(email, false); err == nil {
+		t.Fatal("Expected delivery to fail")
+	}
+
+	result, err := db.RequeueFailedLogs(database.RequeueFilter{MappingID: mapping.ID})
+	if err != nil {
+		t.Fatalf("Failed to requeue: %v", err)
+	}
+	if result.Requeued != 1 || result.Skipped != 0 {
+		t.Fatalf("Expected the failure to be requeued with its payload, got %+v", result)
+	}
+
+	up.Store(true)
+	processor.RetryRequeued()
+
+	if got, _ := subject.Load().(string); got != "try again" {
+		t.Errorf("Expected the stored payload to be resent, got subject %q", got)
+	}
+
+	var logs []database.EmailLog
+	if err := db.Where("request_id = ?", "req-1").Order("id").Find(&logs).Error; err != nil {
+		t.Fatalf("Failed to load logs: %v", err)
+	}
+	if len(logs) != 2 || logs[0].Status != database.LogStatusRetried || logs[1].Status != "success" {
+		t.Fatalf("Expected a retried log followed by a success, got %+v", logs)
+	}
+	if logs[1].ClientIP != "192.0.2.7" {
+		t.Errorf("Expected the retry to keep the client IP, got %q", logs[1].ClientIP)
+	}
+}
@@ -0,0 +1,49 @@
+package email
+
+import (
+	"log"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// settingsRefresh is how long runtime settings are cached before being
+// reloaded from the database
+var settingsRefresh = 10 * time.Second
+
+// runtimeSettings returns the settings changed from the admin interface,
+// reloading them once the cached copy is older than settingsRefresh. If
+// the reload fails the last known settings are kept.
+func (p *Processor) runtimeSettings() database.RuntimeSettings {
+	p.settingsMu.Lock()
+	defer p.settingsMu.Unlock()
+
+	if !p.settingsLoaded.IsZero() && time.Since(p.settingsLoaded) < settingsRefresh {
+		return p.settings
+	}
+	settings, err := p.db.GetRuntimeSettings()
+	if err != nil {
+		log.Printf("Failed to load runtime settings, keeping previous values: %v", err)
+	} else {
+		p.settings = settings
+	}
+	p.settingsLoaded = time.Now()
+	return p.settings
+}
+
+// maxSize is the maximum email size, overridden by the runtime setting
+func (p *Processor) maxSize() int64 {
+	if size := p.runtimeSettings().MaxEmailSize; size > 0 {
+		return size
+	}
+	return p.config.MaxSize
+}
+
+// retryAttempts is the number of delivery attempts, overridden by the
+// runtime setting
+func (p *Processor) retryAttempts() int {
+	if attempts := p.runtimeSettings().RetryAttempts; attempts > 0 {
+		return attempts
+	}
+	return p.config.RetryAttempts
+}
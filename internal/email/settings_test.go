@@ -0,0 +1,68 @@
+package email
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestProcessor_RuntimeSettings(t *testing.T) {
+	orig := settingsRefresh
+	settingsRefresh = 0
+	t.Cleanup(func() { settingsRefresh = orig })
+
+	var received atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping, err := db.CreateEmailMapping(1, ts.URL, "Settings Mapping", nil)
+	if err != nil {
+		t.Fatalf("Failed to create test mapping: %v", err)
+	}
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	email := Email{
+		From:    "sender@example.com",
+		To:      mapping.GeneratedEmail,
+		Subject: "runtime settings",
+		Body:    strings.Repeat("x", 100),
+	}
+	if err := processor.Process(email); err != nil {
+		t.Fatalf("Process() before settings change error = %v", err)
+	}
+
+	// Lowering the max size applies to the next email without a restart
+	if err := db.SaveRuntimeSettings(database.RuntimeSettings{MaxEmailSize: 50}); err != nil {
+		t.Fatalf("SaveRuntimeSettings() error = %v", err)
+	}
+	if err := processor.Process(email); err == nil {
+		t.Error("Process() after lowering max size succeeded, want size error")
+	}
+
+	if err := db.SaveRuntimeSettings(database.RuntimeSettings{MaintenanceMode: true}); err != nil {
+		t.Fatalf("SaveRuntimeSettings() error = %v", err)
+	}
+	if err := processor.Process(email); !errors.Is(err, ErrMaintenance) {
+		t.Errorf("Process() in maintenance mode error = %v, want ErrMaintenance", err)
+	}
+
+	if err := db.SaveRuntimeSettings(database.RuntimeSettings{}); err != nil {
+		t.Fatalf("SaveRuntimeSettings() error = %v", err)
+	}
+	if err := processor.Process(email); err != nil {
+		t.Errorf("Process() after clearing settings error = %v", err)
+	}
+
+	if got := received.Load(); got != 2 {
+		t.Errorf("Endpoint received %d emails, want 2", got)
+	}
+}
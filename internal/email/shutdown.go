@@ -0,0 +1,72 @@
+package email
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// errShutdown is returned by deliveries abandoned when the drain timeout
+// ran out
+var errShutdown = errors.New("processor shut down before delivery completed")
+
+// drainPollInterval is how often Shutdown checks for remaining work
+var drainPollInterval = 10 * time.Millisecond
+
+// Shutdown stops accepting new emails, flushes pending batches and waits up
+// to timeout for in-flight emails to be delivered. Work still running after
+// that is abandoned: retries stop and open requests are canceled. It
+// returns the number of emails abandoned.
+func (p *Processor) Shutdown(timeout time.Duration) int {
+	if !p.closing.Swap(true) {
+		close(p.closed)
+	}
+	p.flushAllBatches()
+
+	deadline := time.Now().Add(timeout)
+	for p.busy() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+
+	abandoned := int(p.busy())
+	p.halt()
+	if abandoned > 0 {
+		log.Printf("Drain timeout of %s reached, abandoning %d in-flight email(s)", timeout, abandoned)
+	} else {
+		log.Printf("All in-flight emails drained")
+	}
+	return abandoned
+}
+
+// busy is the number of emails still being processed or waiting in batches
+func (p *Processor) busy() int64 {
+	return p.inFlight.Load() + p.batched.Load()
+}
+
+// flushAllBatches starts delivering every pending batch without waiting for
+// its window to end
+func (p *Processor) flushAllBatches() {
+	p.batchMu.Lock()
+	var ready []*pendingBatch
+	for _, b := range p.batches {
+		ready = append(ready, p.takeBatchLocked(b))
+	}
+	p.batchMu.Unlock()
+
+	for _, b := range ready {
+		go p.deliverBatch(b)
+	}
+}
+
+// sleep waits for d, returning errShutdown early if the processor is halted
+func (p *Processor) sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-p.halted.Done():
+		return errShutdown
+	}
+}
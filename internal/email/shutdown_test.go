@@ -0,0 +1,87 @@
+package email
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestProcessor_ShutdownDrainsInFlight(t *testing.T) {
+	delivered := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		delivered <- struct{}{}
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1})
+
+	if err := processor.Process(Email{From: "a@example.com", To: mapping.GeneratedEmail, Subject: "slow"}); err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+
+	if abandoned := processor.Shutdown(5 * time.Second); abandoned != 0 {
+		t.Errorf("Expected nothing abandoned, got %d", abandoned)
+	}
+	select {
+	case <-delivered:
+	default:
+		t.Error("Expected the delivery to complete before Shutdown returned")
+	}
+
+	if err := processor.Process(Email{From: "a@example.com", To: mapping.GeneratedEmail}); err != ErrOverloaded {
+		t.Errorf("Expected new emails to be refused after shutdown, got %v", err)
+	}
+}
+
+func TestProcessor_ShutdownAbandonsAfterTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 5})
+
+	if err := processor.Process(Email{From: "a@example.com", To: mapping.GeneratedEmail, Subject: "stuck"}); err != nil {
+		t.Fatalf("Failed to process email: %v", err)
+	}
+	// Let the request reach the endpoint
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if abandoned := processor.Shutdown(100 * time.Millisecond); abandoned != 1 {
+		t.Errorf("Expected 1 abandoned email, got %d", abandoned)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Shutdown to return after the drain timeout, took %s", elapsed)
+	}
+
+	// The abandoned email stops retrying and is logged as failed
+	deadline := time.Now().Add(2 * time.Second)
+	for processor.busy() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := processor.busy(); n != 0 {
+		t.Fatalf("Expected the abandoned email to stop, %d still busy", n)
+	}
+	logs, err := db.GetLogsWithUsers()
+	if err != nil {
+		t.Fatalf("Failed to get logs: %v", err)
+	}
+	if len(logs) != 1 || logs[0].Status != "error" {
+		t.Errorf("Expected one error log for the abandoned email, got %+v", logs)
+	}
+}
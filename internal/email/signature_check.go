@@ -0,0 +1,92 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// signatureCheckTimeout bounds a signature check, which an admin is waiting on
+const signatureCheckTimeout = 10 * time.Second
+
+// Results of a signature check
+const (
+	SignatureCheckPass        = "pass"        // The endpoint verified the signature
+	SignatureCheckFail        = "fail"        // The endpoint rejected the signature
+	SignatureCheckUnparseable = "unparseable" // The endpoint's answer had no verification result
+)
+
+// SignatureCheck is how an endpoint answered a signed test payload
+type SignatureCheck struct {
+	Result     string `json:"result"`
+	StatusCode int    `json:"status_code"`
+	Response   string `json:"response"`
+}
+
+// CheckSignature sends a test payload, signed with the mapping's secret, to
+// the mapping's endpoint and reports whether the endpoint verified it. The
+// request carries an X-Signature-Check header and the endpoint is expected to
+// answer with {"verified": true} or {"verified": false}, whatever the status.
+// The request isn't retried or logged as a delivery.
+func (p *Processor) CheckSignature(mapping *database.EmailMapping) (*SignatureCheck, error) {
+	if mapping.SigningSecret == "" {
+		return nil, errors.New("mapping has no signing secret")
+	}
+
+	_, domain, _ := strings.Cut(mapping.GeneratedEmail, "@")
+	data, err := p.Preview(mapping, Email{
+		From:         "signature-check@" + domain,
+		To:           mapping.GeneratedEmail,
+		Subject:      "Signature check",
+		Body:         "This is a signed test payload. It wasn't sent by email.",
+		ReceivedFrom: "signature-check",
+		ReceivedAt:   time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build test payload: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(p.halted, signatureCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, mapping.Method(), mapping.EndpointURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if _, hasContentType := mapping.Headers["Content-Type"]; !hasContentType {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range mapping.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("X-Signature-Check", "true")
+	req.Header.Set("X-Signature", signPayload(mapping.SigningSecret, time.Now(), data))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	check := &SignatureCheck{
+		Result:     SignatureCheckUnparseable,
+		StatusCode: resp.StatusCode,
+		Response:   readResponseBody(resp.Body, p.config.MaxResponseBytes),
+	}
+	var answer struct {
+		Verified *bool `json:"verified"`
+	}
+	if err := json.Unmarshal([]byte(check.Response), &answer); err == nil && answer.Verified != nil {
+		check.Result = SignatureCheckFail
+		if *answer.Verified {
+			check.Result = SignatureCheckPass
+		}
+	}
+	return check, nil
+}
@@ -2,20 +2,122 @@ package email
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net"
+	"net/mail"
+	"net/textproto"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/emersion/go-smtp"
 )
 
+// errOverloaded asks the sender to retry later when the processor is saturated
+var errOverloaded = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 2},
+	Message:      "Server busy, try again later",
+}
+
+// errMaintenance asks the sender to retry later while maintenance mode is on
+var errMaintenance = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 2},
+	Message:      "Service under maintenance, try again later",
+}
+
+// errLookupFailed asks the sender to retry later when the recipient's
+// mapping couldn't be looked up
+var errLookupFailed = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+	Message:      "Temporary lookup failure, try again later",
+}
+
+// errDeliveryFailed asks the sender to retry later when a synchronous
+// delivery to the endpoint failed
+var errDeliveryFailed = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 4, 0},
+	Message:      "Delivery failed, try again later",
+}
+
+// errInvalidSender refuses an empty or malformed envelope sender
+var errInvalidSender = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 1, 7},
+	Message:      "Invalid sender address",
+}
+
+// errMappingInactive refuses a recipient whose mapping is deactivated
+var errMappingInactive = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 2, 1},
+	Message:      "Mailbox disabled, not accepting messages",
+}
+
+// errUnknownRecipient refuses a recipient without a mapping
+var errUnknownRecipient = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+	Message:      "Recipient address unknown",
+}
+
+// errLineTooLong rejects a message containing a line over MaxLineLength
+var errLineTooLong = &smtp.SMTPError{
+	Code:         500,
+	EnhancedCode: smtp.EnhancedCode{5, 5, 6},
+	Message:      "Line too long",
+}
+
+// errMessageTooLarge rejects a message over the maximum email size, whether
+// announced with MAIL FROM's SIZE parameter or found while reading DATA
+var errMessageTooLarge = &smtp.SMTPError{
+	Code:         552,
+	EnhancedCode: smtp.EnhancedCode{5, 3, 4},
+	Message:      "Message exceeds maximum email size",
+}
+
+// lineLengthReader fails with errLineTooLong once a line exceeds limit bytes,
+// before the whole message has to be buffered and split
+type lineLengthReader struct {
+	r       io.Reader
+	limit   int
+	current int // bytes in the current line so far, excluding CR
+}
+
+func (l *lineLengthReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	for _, b := range p[:n] {
+		switch b {
+		case '\n':
+			l.current = 0
+		case '\r':
+		default:
+			l.current++
+			if l.current > l.limit {
+				return 0, errLineTooLong
+			}
+		}
+	}
+	return n, err
+}
+
 // The Backend implements SMTP server methods
 type Backend struct {
 	processor *Processor
+	// mapCertUsers only authenticates client certificates whose identity
+	// matches an active user
+	mapCertUsers bool
 }
 
 // NewBackend creates a new SMTP backend
@@ -23,25 +125,57 @@ func NewBackend(processor *Processor) *Backend {
 	return &Backend{processor: processor}
 }
 
-// NewSession implements smtp.Backend interface
+// NewSession implements smtp.Backend interface. After STARTTLS the client
+// sends EHLO again, so a verified client certificate is visible here.
 func (bkd *Backend) NewSession(c *smtp.Conn) (smtp.Session, error) {
 	remoteAddr := c.Conn().RemoteAddr().String()
 	log.Printf("New SMTP session started from %s", remoteAddr)
-	return &Session{
+	session := &Session{
 		processor:  bkd.processor,
 		remoteAddr: remoteAddr,
-	}, nil
+	}
+	if state, ok := c.TLSConnectionState(); ok {
+		session.username = bkd.certUsername(state)
+		session.tlsVersion = tls.VersionName(state.Version)
+		session.tlsCipher = tls.CipherSuiteName(state.CipherSuite)
+	}
+	return session, nil
 }
 
-// A Session is returned after EHLO
+// certUsername resolves the verified client certificate to the session's
+// authenticated identity
+func (bkd *Backend) certUsername(state tls.ConnectionState) string {
+	identity := clientCertIdentity(state)
+	if identity == "" {
+		return ""
+	}
+	if !bkd.mapCertUsers {
+		log.Printf("Authenticated by client certificate as %s", identity)
+		return identity
+	}
+
+	user, err := bkd.processor.db.GetUserByEmail(identity)
+	if err != nil || user == nil || !user.IsActive {
+		log.Printf("Client certificate identity %s does not match an active user", identity)
+		return ""
+	}
+	log.Printf("Authenticated by client certificate as user %s", user.Email)
+	return user.Email
+}
+
+// A Session is returned after EHLO. Connection state (remote address and
+// authenticated user) lives for the whole session; envelope state (from/to)
+// is cleared by Reset after every message, and message content is kept local
+// to Data so nothing can carry over between messages.
 type Session struct {
 	processor  *Processor
 	from       string
 	to         []string
-	subject    string
-	body       string
 	remoteAddr string
 	username   string
+	// Negotiated TLS version and cipher; empty for plaintext sessions
+	tlsVersion string
+	tlsCipher  string
 }
 
 func (s *Session) AuthPlain(username, password string) error {
@@ -53,88 +187,188 @@ func (s *Session) AuthPlain(username, password string) error {
 
 func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
 	log.Printf("MAIL FROM: %s", from)
+	if s.processor.config.InvalidSender == InvalidSenderReject && !validSender(from) {
+		log.Printf("Rejecting invalid sender %q", from)
+		return errInvalidSender
+	}
+	// The server enforces the size it advertised at startup; this catches a
+	// maximum lowered on the Settings page since
+	if opts != nil && opts.Size > 0 {
+		if maxSize := s.processor.maxSize(); opts.Size > maxSize {
+			log.Printf("Rejecting message from %q: announced size %d bytes exceeds maximum email size of %d bytes", from, opts.Size, maxSize)
+			return errMessageTooLarge
+		}
+	}
 	s.from = from
 	return nil
 }
 
 func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
 	log.Printf("RCPT TO: %s", to)
+	if !s.processor.acceptsDomain(to) {
+		log.Printf("Rejecting recipient %s: domain not handled by this server", to)
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 2},
+			Message:      "Recipient domain not handled by this server",
+		}
+	}
+	if s.processor.config.RejectInactive {
+		// On a lookup error accept the recipient; processing logs or
+		// spools the email as usual
+		inactive, err := s.processor.db.IsMappingInactive(to)
+		if err != nil {
+			log.Printf("Failed to check mapping for %s: %v", to, err)
+		} else if inactive {
+			log.Printf("Rejecting recipient %s: mapping is inactive", to)
+			return errMappingInactive
+		}
+	}
+	if s.processor.config.UnknownRecipient == UnknownRecipientReject {
+		// As above, a lookup error accepts the recipient
+		known, err := s.processor.db.HasEmailMapping(to)
+		if err != nil {
+			log.Printf("Failed to check mapping for %s: %v", to, err)
+		} else if !known {
+			log.Printf("Rejecting recipient %s: no mapping found", to)
+			return errUnknownRecipient
+		}
+	}
 	s.to = append(s.to, to)
 	return nil
 }
 
 func (s *Session) Data(r io.Reader) error {
-	log.Printf("Starting to receive email data")
-	// Read the email data
-	data, err := io.ReadAll(r)
+	requestID := newRequestID()
+	logger := requestLogger(requestID)
+
+	// Tell the sender to back off before reading a message we can't process
+	if s.processor.Overloaded() {
+		logger.Printf("Processor overloaded, deferring message")
+		return errOverloaded
+	}
+
+	logger.Printf("Starting to receive email data")
+	if limit := s.processor.config.MaxLineLength; limit > 0 {
+		r = &lineLengthReader{r: r, limit: limit}
+	}
+
+	// Read the email data, one byte past the maximum size to spot oversized
+	// messages without buffering all of them
+	maxSize := s.processor.maxSize()
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if errors.Is(err, errLineTooLong) {
+		logger.Printf("Rejecting email: line longer than %d bytes", s.processor.config.MaxLineLength)
+		return errLineTooLong
+	}
+	if errors.Is(err, smtp.ErrDataTooLarge) || int64(len(data)) > maxSize {
+		s.rejectOversize(logger, requestID, data, maxSize)
+		return errMessageTooLarge
+	}
 	if err != nil {
-		log.Printf("Error reading email data: %v", err)
+		logger.Printf("Error reading email data: %v", err)
 		return fmt.Errorf("failed to read email data: %w", err)
 	}
-	log.Printf("Received email data of length: %d bytes", len(data))
+	logger.Printf("Received email data of length: %d bytes", len(data))
 
-	// Parse the email data
-	emailStr := string(data)
-	lines := strings.Split(emailStr, "\r\n")
-
-	// Initialize headers map
-	headers := make(map[string][]string)
-	var currentHeader string
-	var bodyStart int
+	message := ParseMessage(data)
+	if message.Subject != "" {
+		logger.Printf("Found Subject header: %q", message.Subject)
+	}
 
-	// Parse headers
-	for i, line := range lines {
-		if line == "" {
-			bodyStart = i + 1
-			break
-		}
+	// Process for each recipient
+	for _, recipient := range s.to {
+		email := message
+		email.From = s.from
+		email.To = recipient
+
+		// Connection info
+		email.ReceivedFrom = s.remoteAddr
+		email.ReceivedAt = time.Now()
+		email.AuthenticatedAs = s.username
+		email.ReceivedTLS = s.tlsVersion != ""
+		email.TLSVersion = s.tlsVersion
+		email.TLSCipher = s.tlsCipher
+		email.RequestID = requestID
+
+		logger.Printf("Processing email to: %s", recipient)
+		logger.Printf("Email details: MessageID=%s, ContentType=%s, Date=%v",
+			email.MessageID, email.ContentType, email.Date)
 
-		// Handle header continuation lines
-		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
-			if currentHeader != "" {
-				lastVal := headers[currentHeader][len(headers[currentHeader])-1]
-				headers[currentHeader][len(headers[currentHeader])-1] = lastVal + "\n" + strings.TrimSpace(line)
+		// Process the email
+		if err := s.processor.Process(email); err != nil {
+			logger.Printf("Failed to process email for recipient %s: %v", recipient, err)
+			if errors.Is(err, ErrOverloaded) {
+				return errOverloaded
 			}
-			continue
-		}
-
-		// Parse header
-		if idx := strings.Index(line, ":"); idx > 0 {
-			currentHeader = strings.TrimSpace(line[:idx])
-			value := strings.TrimSpace(line[idx+1:])
-			headers[currentHeader] = append(headers[currentHeader], value)
-
-			// Capture subject specifically
-			if strings.EqualFold(currentHeader, "Subject") {
-				s.subject = value
-				log.Printf("Found Subject header: %q", value)
+			if errors.Is(err, ErrMaintenance) {
+				return errMaintenance
+			}
+			if errors.Is(err, ErrLookupFailed) {
+				return errLookupFailed
 			}
+			if errors.Is(err, ErrDeliveryFailed) {
+				return errDeliveryFailed
+			}
+			return fmt.Errorf("failed to process email for %s: %w", recipient, err)
 		}
+		logger.Printf("Successfully processed email for recipient: %s", recipient)
 	}
 
-	// Parse Content-Type and boundaries
-	contentType := ""
-	if ctHeaders := headers["Content-Type"]; len(ctHeaders) > 0 {
-		contentType = ctHeaders[0]
+	return nil
+}
+
+// rejectOversize logs a message refused during DATA for exceeding maxSize
+// once per recipient. Only the part read so far is available, which is
+// enough for the subject in all but the most unusual messages.
+func (s *Session) rejectOversize(logger *log.Logger, requestID string, data []byte, maxSize int64) {
+	logger.Printf("Rejecting email: message exceeds maximum email size of %d bytes", maxSize)
+	subject := ParseMessage(data).Subject
+	for _, recipient := range s.to {
+		if err := s.processor.logProcessing(
+			nil,
+			recipient,
+			subject,
+			StatusRejectedAtSMTPSize,
+			fmt.Sprintf("message exceeds maximum email size of %d bytes", maxSize),
+			requestID,
+			s.remoteAddr,
+		); err != nil {
+			logger.Printf("Failed to log rejected email: %v", err)
+		}
 	}
+}
 
-	// Parse message ID and references
-	messageID := ""
-	if msgIDs := headers["Message-ID"]; len(msgIDs) > 0 {
-		messageID = msgIDs[0]
+// ParseMessage parses a raw RFC 5322 message into an Email. Envelope and
+// connection fields (From, To, ReceivedFrom, ...) are left for the caller.
+func ParseMessage(data []byte) Email {
+	text := string(data)
+	if !strings.Contains(text, "\r\n") {
+		// Submitted with bare LF line endings, as some local tools do
+		text = strings.ReplaceAll(text, "\n", "\r\n")
 	}
 
-	inReplyTo := ""
-	if replies := headers["In-Reply-To"]; len(replies) > 0 {
-		inReplyTo = replies[0]
+	// Parse headers
+	msg, text := readMessage(text)
+	headers := map[string][]string(msg.Header)
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		log.Printf("Failed to read message body: %v", err)
 	}
+	folded := foldedHeaders(text[:len(text)-len(body)])
 
+	// Parse message ID and references
 	references := []string{}
 	if refs := headers["References"]; len(refs) > 0 {
 		references = strings.Fields(refs[0])
 	}
 
-	// Parse CC and BCC
+	// Parse To, CC, BCC and Reply-To
+	var headerTo []string
+	if toHeader := getHeaderFold(headers, "To"); toHeader != "" {
+		headerTo = parseAddressList(toHeader)
+	}
+
 	cc := []string{}
 	if ccHeaders := headers["Cc"]; len(ccHeaders) > 0 {
 		cc = parseAddressList(ccHeaders[0])
@@ -145,94 +379,234 @@ func (s *Session) Data(r io.Reader) error {
 		bcc = parseAddressList(bccHeaders[0])
 	}
 
+	var replyTo []string
+	if replyToHeader := getHeaderFold(headers, "Reply-To"); replyToHeader != "" {
+		replyTo = parseAddressList(replyToHeader)
+	}
+
 	// Parse Date
 	receivedTime := time.Now()
 	if dateHeaders := headers["Date"]; len(dateHeaders) > 0 {
-		if parsedTime, err := time.Parse(time.RFC1123Z, dateHeaders[0]); err == nil {
+		if parsedTime, err := mail.ParseDate(dateHeaders[0]); err == nil {
 			receivedTime = parsedTime
 		}
 	}
 
-	// Join the body lines back together
-	body := strings.Join(lines[bodyStart:], "\r\n")
+	// Multipart messages carry separate plain and HTML bodies, attachments
+	// and inline images referenced by Content-ID; a message that isn't is a
+	// single such part
+	parts, ok := parseMultipart(getHeaderFold(headers, "Content-Type"), body)
+	if !ok {
+		parts = parseSinglePart(headers, body)
+	}
+	var calendar *CalendarEvent
+	if parts.calendar != "" {
+		calendar = parseCalendar(parts.calendar)
+	}
 
-	// Process for each recipient
-	for _, recipient := range s.to {
-		email := Email{
-			// Basic fields
-			From:    s.from,
-			To:      recipient,
-			Subject: s.subject,
-			Body:    body,
-
-			// Additional recipients
-			Cc:  cc,
-			Bcc: bcc,
-
-			// Message metadata
-			MessageID:  messageID,
-			InReplyTo:  inReplyTo,
-			References: references,
-			Date:       receivedTime,
-
-			// Content details
-			ContentType:             contentType,
-			ContentTransferEncoding: getFirstHeader(headers, "Content-Transfer-Encoding"),
-			PlainBody:               body, // For now, treating all as plain
-
-			// Connection info
-			ReceivedFrom:    s.remoteAddr,
-			ReceivedAt:      time.Now(),
-			AuthenticatedAs: s.username,
-
-			// All headers
-			Headers: headers,
+	return Email{
+		HeaderTo: headerTo,
+		Subject:  decodeHeader(getHeaderFold(headers, "Subject")),
+		Body:     string(body),
+
+		// Additional recipients
+		Cc:      cc,
+		Bcc:     bcc,
+		ReplyTo: replyTo,
+
+		// Message metadata
+		MessageID:  getFirstHeader(headers, "Message-ID"),
+		InReplyTo:  getFirstHeader(headers, "In-Reply-To"),
+		References: references,
+		Date:       receivedTime,
+
+		// Content details
+		ContentType:             getFirstHeader(headers, "Content-Type"),
+		ContentTransferEncoding: getFirstHeader(headers, "Content-Transfer-Encoding"),
+		HTMLBody:                parts.html,
+		PlainBody:               parts.plain,
+		Attachments:             parts.attachments,
+		Calendar:                calendar,
+
+		// All headers
+		Headers:       headers,
+		FoldedHeaders: folded,
+	}
+}
+
+// malformedHeader holds the lines of a header section that aren't headers,
+// such as a continuation line before any header, so their content is kept
+const malformedHeader = "X-Malformed-Header"
+
+// readMessage reads a message with net/mail, which unfolds folded header
+// lines per RFC 5322 and canonicalizes header names. net/mail refuses a
+// header section with a line that isn't a header, so those lines are moved
+// into an X-Malformed-Header header and the message read again rather than
+// losing every header. It returns the message and the text it was read from.
+func readMessage(text string) (*mail.Message, string) {
+	if msg, err := mail.ReadMessage(strings.NewReader(text)); err == nil {
+		return msg, text
+	}
+
+	text = keepMalformedHeaders(text)
+	if msg, err := mail.ReadMessage(strings.NewReader(text)); err == nil {
+		return msg, text
+	}
+	// No header could be read, as in an empty message
+	return &mail.Message{Header: mail.Header{}, Body: strings.NewReader(text)}, text
+}
+
+// keepMalformedHeaders rewrites the lines of a message's header section that
+// net/mail can't read, a line without a colon along with its continuation
+// lines, as X-Malformed-Header headers. A continuation line before any header
+// is read as a header of its own when it has a colon.
+func keepMalformedHeaders(text string) string {
+	if strings.HasPrefix(text, "\r\n") {
+		return text // No header section
+	}
+	section, body, hasBody := strings.Cut(text, "\r\n\r\n")
+
+	var lines []string
+	for i, line := range strings.Split(section, "\r\n") {
+		folded := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+		switch {
+		case line == "":
+			// The end of a header section with no body
+		case folded && i > 0:
+			lines = append(lines, line)
+		case folded && strings.Contains(line, ":"):
+			log.Printf("Header continuation with no preceding header: %q", line)
+			lines = append(lines, strings.TrimLeft(line, " \t"))
+		case !folded && strings.Contains(line, ":"):
+			lines = append(lines, line)
+		default:
+			log.Printf("Keeping malformed header line as %s: %q", malformedHeader, line)
+			lines = append(lines, malformedHeader+": "+strings.TrimLeft(line, " \t"))
 		}
+	}
 
-		log.Printf("Processing email to: %s", recipient)
-		log.Printf("Email details: MessageID=%s, ContentType=%s, Date=%v",
-			email.MessageID, email.ContentType, email.Date)
+	header := strings.Join(lines, "\r\n")
+	if len(lines) > 0 {
+		header += "\r\n"
+	}
+	if hasBody {
+		header += "\r\n" + body
+	}
+	return header
+}
 
-		// Process the email
-		if err := s.processor.Process(email); err != nil {
-			log.Printf("Failed to process email for recipient %s: %v", recipient, err)
-			return fmt.Errorf("failed to process email for %s: %w", recipient, err)
+// foldedHeaders returns the values of the headers in a header section that
+// were folded over several lines as received, line breaks included, in the
+// same order as the parsed values. Headers that weren't folded are left out.
+func foldedHeaders(section string) map[string][]string {
+	type field struct {
+		name, value string
+		folded      bool
+	}
+	var fields []field
+	for _, line := range strings.Split(section, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			last := &fields[len(fields)-1]
+			last.value += "\r\n" + line
+			last.folded = true
+			continue
 		}
-		log.Printf("Successfully processed email for recipient: %s", recipient)
+		name, value, _ := strings.Cut(line, ":")
+		fields = append(fields, field{
+			name:  textproto.CanonicalMIMEHeaderKey(strings.TrimRight(name, " \t")),
+			value: strings.TrimLeft(value, " \t"),
+		})
 	}
 
-	return nil
+	folded := map[string]bool{}
+	for _, f := range fields {
+		if f.folded {
+			folded[f.name] = true
+		}
+	}
+	if len(folded) == 0 {
+		return nil
+	}
+	headers := make(map[string][]string, len(folded))
+	for _, f := range fields {
+		if folded[f.name] {
+			headers[f.name] = append(headers[f.name], f.value)
+		}
+	}
+	return headers
 }
 
-// Helper function to get first header value
+// getFirstHeader returns the first value of a header from a parsed message,
+// whose header names are canonical
 func getFirstHeader(headers map[string][]string, key string) string {
-	if values := headers[key]; len(values) > 0 {
+	if values := headers[textproto.CanonicalMIMEHeaderKey(key)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// getHeaderFold returns the first value of a header, matching the name
+// case-insensitively. The canonical name wins over other spellings, which are
+// tried in sorted order so the same headers always give the same value.
+func getHeaderFold(headers map[string][]string, key string) string {
+	if values := headers[textproto.CanonicalMIMEHeaderKey(key)]; len(values) > 0 {
 		return values[0]
 	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		if strings.EqualFold(name, key) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if values := headers[name]; len(values) > 0 {
+			return values[0]
+		}
+	}
 	return ""
 }
 
-// Helper function to parse address lists
+// headerDecoder decodes RFC 2047 encoded-words such as =?UTF-8?B?...?=
+var headerDecoder = new(mime.WordDecoder)
+
+// decodeHeader decodes the encoded-words in a header value to UTF-8. A value
+// that can't be decoded, e.g. because of an unsupported charset, is returned
+// as received; malformed encoded-words are kept as they are.
+func decodeHeader(value string) string {
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		log.Printf("Keeping header value %q undecoded: %v", value, err)
+		return value
+	}
+	return decoded
+}
+
+// Helper function to parse address lists; encoded-words in display names
+// are decoded
 func parseAddressList(addresses string) []string {
-	// Simple splitting by comma for now
+	// Simple splitting by comma for now, before decoding so commas in
+	// decoded names don't split addresses
 	parts := strings.Split(addresses, ",")
 	result := make([]string, 0, len(parts))
 	for _, part := range parts {
 		addr := strings.TrimSpace(part)
 		if addr != "" {
-			result = append(result, addr)
+			result = append(result, decodeHeader(addr))
 		}
 	}
 	return result
 }
 
+// Reset discards the envelope of the current message. Authentication is
+// connection-scoped and is kept across messages.
 func (s *Session) Reset() {
 	log.Printf("Resetting SMTP session")
 	s.from = ""
-	s.to = []string{}
-	s.subject = ""
-	s.body = ""
-	s.username = ""
+	s.to = nil
 }
 
 func (s *Session) Logout() error {
@@ -240,36 +614,94 @@ func (s *Session) Logout() error {
 	return nil
 }
 
-// loggingListener wraps a net.Listener to log connections
+// tooManyConnections is the greeting sent to connections over the limit
+const tooManyConnections = "421 4.7.0 Too many connections, try again later\r\n"
+
+// loggingListener wraps a net.Listener to log connections and, when
+// maxConns is set, to turn away connections beyond it
 type loggingListener struct {
 	net.Listener
+	maxConns int64
+	active   atomic.Int64
 }
 
 func (l *loggingListener) Accept() (net.Conn, error) {
-	conn, err := l.Listener.Accept()
-	if err != nil {
-		log.Printf("Failed to accept connection: %v", err)
-		return conn, err
-	}
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			log.Printf("Failed to accept connection: %v", err)
+			return conn, err
+		}
+
+		if n := l.active.Add(1); l.maxConns > 0 && n > l.maxConns {
+			l.active.Add(-1)
+			log.Printf("Rejecting connection from %s: %d connections already open", conn.RemoteAddr(), l.maxConns)
+			// Best effort: the client may already be gone
+			conn.SetWriteDeadline(time.Now().Add(time.Second))
+			io.WriteString(conn, tooManyConnections)
+			conn.Close()
+			continue
+		}
 
-	log.Printf("New TCP connection from: %s", conn.RemoteAddr())
-	return &loggingConn{Conn: conn}, nil
+		log.Printf("New TCP connection from: %s", conn.RemoteAddr())
+		return &loggingConn{Conn: conn, listener: l}, nil
+	}
 }
 
 // loggingConn wraps a net.Conn to log disconnections
 type loggingConn struct {
 	net.Conn
+	listener  *loggingListener
+	closeOnce sync.Once
 }
 
 func (c *loggingConn) Close() error {
-	log.Printf("TCP connection closed from: %s", c.RemoteAddr())
+	c.closeOnce.Do(func() {
+		log.Printf("TCP connection closed from: %s", c.RemoteAddr())
+		c.listener.active.Add(-1)
+	})
 	return c.Conn.Close()
 }
 
+// newSMTPServer creates an SMTP server for the processor with our settings applied
+func newSMTPServer(processor *Processor, host string, tlsConfig TLSConfig) (*smtp.Server, error) {
+	backend := NewBackend(processor)
+	backend.mapCertUsers = tlsConfig.MapClientCertToUser
+
+	s := smtp.NewServer(backend)
+	s.Domain = host
+	s.ReadTimeout = 30 * time.Second  // Increased timeout
+	s.WriteTimeout = 30 * time.Second // Increased timeout
+	// Advertised with SIZE and enforced while reading DATA; Session applies
+	// the current value again in case it changes on the Settings page
+	s.MaxMessageBytes = processor.maxSize()
+	s.MaxRecipients = 50
+	// Keep the transport's line limit, which drops the connection, above our
+	// own so over-long lines get a clean rejection from Data instead
+	if limit := processor.config.MaxLineLength; limit > 0 {
+		s.MaxLineLength = max(s.MaxLineLength, 2*limit)
+	}
+	s.AllowInsecureAuth = !tlsConfig.RequireForAuth
+	s.Debug = log.Writer() // Enable SMTP protocol debugging
+
+	config, err := tlsConfig.Load()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil && tlsConfig.RequireForAuth {
+		return nil, fmt.Errorf("requiring TLS for AUTH needs a certificate and key for STARTTLS")
+	}
+	s.TLSConfig = config
+
+	return s, nil
+}
+
 // StartSMTPServer starts the SMTP server
-func StartSMTPServer(processor *Processor, host string, port int) error {
-	be := NewBackend(processor)
-	s := smtp.NewServer(be)
+func StartSMTPServer(processor *Processor, host string, port int, tlsConfig TLSConfig) error {
+	s, err := newSMTPServer(processor, host, tlsConfig)
+	if err != nil {
+		return err
+	}
 
 	// Force dual-stack (IPv4 + IPv6) by setting specific listener options
 	addr := fmt.Sprintf("%s:%d", host, port)
@@ -297,13 +729,6 @@ func StartSMTPServer(processor *Processor, host string, port int) error {
 	}
 
 	s.Addr = addr
-	s.Domain = host
-	s.ReadTimeout = 30 * time.Second  // Increased timeout
-	s.WriteTimeout = 30 * time.Second // Increased timeout
-	s.MaxMessageBytes = 1024 * 1024
-	s.MaxRecipients = 50
-	s.AllowInsecureAuth = true
-	s.Debug = log.Writer() // Enable SMTP protocol debugging
 
 	log.Printf("Starting SMTP server at %s", s.Addr)
 	log.Printf("Server configuration:")
@@ -313,9 +738,12 @@ func StartSMTPServer(processor *Processor, host string, port int) error {
 	log.Printf("- Max Message Size: %d bytes", s.MaxMessageBytes)
 	log.Printf("- Max Recipients: %d", s.MaxRecipients)
 	log.Printf("- Allow Insecure Auth: %v", s.AllowInsecureAuth)
+	log.Printf("- STARTTLS: %v", s.TLSConfig != nil)
+
+	log.Printf("- Max Connections: %d", processor.config.MaxConnections)
 
-	// Wrap the listener with logging
-	loggingListener := &loggingListener{Listener: listener}
+	// Wrap the listener with logging and the connection limit
+	loggingListener := &loggingListener{Listener: listener, maxConns: int64(processor.config.MaxConnections)}
 
 	// Use the logging listener instead of ListenAndServe
 	return s.Serve(loggingListener)
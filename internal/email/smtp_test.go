@@ -0,0 +1,842 @@
+package email
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// startTestSMTPServer serves the processor over SMTP on a random local port
+func startTestSMTPServer(t *testing.T, processor *Processor) string {
+	return startTestSMTPServerTLS(t, processor, TLSConfig{})
+}
+
+// startTestSMTPServerTLS is startTestSMTPServer with STARTTLS configured
+func startTestSMTPServerTLS(t *testing.T, processor *Processor, tlsConfig TLSConfig) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s, err := newSMTPServer(processor, "localhost", tlsConfig)
+	if err != nil {
+		t.Fatalf("Failed to create SMTP server: %v", err)
+	}
+	go s.Serve(&loggingListener{Listener: l, maxConns: int64(processor.config.MaxConnections)})
+	t.Cleanup(func() { s.Close() })
+
+	return l.Addr().String()
+}
+
+// sendTestMessage sends one message over an existing SMTP client connection
+func sendTestMessage(t *testing.T, c *smtp.Client, from, to, message string) {
+	t.Helper()
+
+	if err := c.Mail(from); err != nil {
+		t.Fatalf("MAIL failed: %v", err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		t.Fatalf("RCPT failed: %v", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+	if _, err := fmt.Fprint(w, message); err != nil {
+		t.Fatalf("Failed to write message: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to finish message: %v", err)
+	}
+}
+
+func TestParseMessage_FoldedHeader(t *testing.T) {
+	raw := "Subject: a very\r\n" +
+		" long subject\r\n" +
+		"\tthat keeps going\r\n" +
+		"X-Custom: one\r\n" +
+		"\r\n" +
+		"body"
+
+	email := ParseMessage([]byte(raw))
+
+	if email.Subject != "a very long subject that keeps going" {
+		t.Errorf("Expected unfolded subject, got %q", email.Subject)
+	}
+	want := "a very\r\n long subject\r\n\tthat keeps going"
+	if got := email.FoldedHeaders["Subject"]; len(got) != 1 || got[0] != want {
+		t.Errorf("Expected the folded subject as received %q, got %q", want, got)
+	}
+	if _, ok := email.FoldedHeaders["X-Custom"]; ok {
+		t.Errorf("Expected only folded headers in FoldedHeaders, got %v", email.FoldedHeaders)
+	}
+	if got := getFirstHeader(email.Headers, "X-Custom"); got != "one" {
+		t.Errorf("Expected X-Custom = one, got %q", got)
+	}
+	if email.Body != "body" {
+		t.Errorf("Expected the body after the header section, got %q", email.Body)
+	}
+}
+
+func TestParseMessage_LeadingContinuation(t *testing.T) {
+	raw := " X-Leading: kept\r\n" +
+		"Subject: hello\r\n" +
+		"\r\n" +
+		"body"
+
+	email := ParseMessage([]byte(raw))
+
+	if got := getFirstHeader(email.Headers, "X-Leading"); got != "kept" {
+		t.Errorf("Expected leading continuation to be kept as a header, got %q", got)
+	}
+	if email.Subject != "hello" || email.Body != "body" {
+		t.Errorf("Expected Subject = hello and the body, got %q and %q", email.Subject, email.Body)
+	}
+
+	email = ParseMessage([]byte(" foo\r\nSubject: hello\r\n\r\nbody"))
+
+	if got := email.Headers["X-Malformed-Header"]; len(got) != 1 || got[0] != "foo" {
+		t.Errorf("Expected leading continuation content in X-Malformed-Header, got %q", got)
+	}
+	if email.Subject != "hello" || email.Body != "body" {
+		t.Errorf("Expected Subject = hello and the body, got %q and %q", email.Subject, email.Body)
+	}
+}
+
+func TestParseMessage_MalformedHeaderLine(t *testing.T) {
+	raw := "Subject: hello\r\n" +
+		"not a header\r\n" +
+		"X-After: still read\r\n" +
+		"\r\n" +
+		"body"
+
+	email := ParseMessage([]byte(raw))
+
+	if email.Subject != "hello" || getFirstHeader(email.Headers, "X-After") != "still read" {
+		t.Errorf("Expected the headers around a malformed line to be read, got %v", email.Headers)
+	}
+	if got := email.Headers["X-Malformed-Header"]; len(got) != 1 || got[0] != "not a header" {
+		t.Errorf("Expected the malformed line in X-Malformed-Header, got %q", got)
+	}
+	if email.Body != "body" {
+		t.Errorf("Expected the body after the header section, got %q", email.Body)
+	}
+}
+
+func TestParseMessage_HeaderNamesIgnoreCase(t *testing.T) {
+	raw := "CC: a@example.com\r\n" +
+		"BCC: b@example.com\r\n" +
+		"DATE: Mon, 02 Jan 2006 15:04:05 -0700\r\n" +
+		"references: <one@example.com> <two@example.com>\r\n" +
+		"MESSAGE-ID: <id@example.com>\r\n" +
+		"\r\n" +
+		"body"
+
+	email := ParseMessage([]byte(raw))
+
+	if len(email.Cc) != 1 || email.Cc[0] != "a@example.com" {
+		t.Errorf("Expected Cc from a CC header, got %q", email.Cc)
+	}
+	if len(email.Bcc) != 1 || email.Bcc[0] != "b@example.com" {
+		t.Errorf("Expected Bcc from a BCC header, got %q", email.Bcc)
+	}
+	if want := time.Date(2006, 1, 2, 22, 4, 5, 0, time.UTC); !email.Date.Equal(want) {
+		t.Errorf("Expected Date from a DATE header, got %v", email.Date)
+	}
+	if len(email.References) != 2 || email.MessageID != "<id@example.com>" {
+		t.Errorf("Expected References and Message-ID, got %q and %q", email.References, email.MessageID)
+	}
+	if _, ok := email.Headers["Cc"]; !ok {
+		t.Errorf("Expected canonical header names, got %v", email.Headers)
+	}
+}
+
+func TestParseMessage_EncodedWords(t *testing.T) {
+	message := strings.Join([]string{
+		"To: =?UTF-8?B?TcO8bGxlciwgSGFucw==?= <hans@example.com>, plain@example.com",
+		"Cc: =?ISO-8859-1?Q?Fran=E7ois?= <francois@example.com>",
+		"Reply-To: =?x-unknown?Q?Someone?= <someone@example.com>",
+		"Subject: =?UTF-8?B?w5xiZXJ3ZWlzdW5n?= =?UTF-8?Q?_f=C3=BCr_M=C3=A4rz?=",
+		"",
+		"body",
+	}, "\r\n")
+	email := ParseMessage([]byte(message))
+
+	if email.Subject != "Überweisung für März" {
+		t.Errorf("Expected decoded subject, got %q", email.Subject)
+	}
+	wantTo := []string{"Müller, Hans <hans@example.com>", "plain@example.com"}
+	if strings.Join(email.HeaderTo, "|") != strings.Join(wantTo, "|") {
+		t.Errorf("Expected decoded To %q, got %q", wantTo, email.HeaderTo)
+	}
+	if len(email.Cc) != 1 || email.Cc[0] != "François <francois@example.com>" {
+		t.Errorf("Expected decoded Cc, got %q", email.Cc)
+	}
+	// Unsupported charsets are kept as received
+	if len(email.ReplyTo) != 1 || email.ReplyTo[0] != "=?x-unknown?Q?Someone?= <someone@example.com>" {
+		t.Errorf("Expected Reply-To as received, got %q", email.ReplyTo)
+	}
+	// The forwarded headers stay as received
+	if got := getFirstHeader(email.Headers, "Subject"); !strings.HasPrefix(got, "=?UTF-8?B?") {
+		t.Errorf("Expected raw Subject header, got %q", got)
+	}
+}
+
+func TestDecodeHeader_Malformed(t *testing.T) {
+	for _, value := range []string{"=?UTF-8?B?not base64!?=", "=?UTF-8?Q?unterminated", "plain subject"} {
+		if got := decodeHeader(value); got != value {
+			t.Errorf("decodeHeader(%q) = %q, want the value unchanged", value, got)
+		}
+	}
+}
+
+func TestGetHeaderFold_Deterministic(t *testing.T) {
+	headers := map[string][]string{
+		"subject": {"lower"},
+		"SUBJECT": {"upper"},
+		"Subject": {"canonical"},
+	}
+	for i := 0; i < 20; i++ {
+		if got := getHeaderFold(headers, "subject"); got != "canonical" {
+			t.Fatalf("Expected the canonical header to win, got %q", got)
+		}
+	}
+
+	delete(headers, "Subject")
+	for i := 0; i < 20; i++ {
+		if got := getHeaderFold(headers, "Subject"); got != "upper" {
+			t.Fatalf("Expected the first name in sorted order, got %q", got)
+		}
+	}
+}
+
+func TestSession_NoStateBleedAcrossMessages(t *testing.T) {
+	var mu sync.Mutex
+	var received []EmailData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data ProcessedData
+		json.NewDecoder(r.Body).Decode(&data)
+		mu.Lock()
+		received = append(received, data.Data)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	first := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	second := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	c, err := smtp.Dial(startTestSMTPServer(t, processor))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	sendTestMessage(t, c, "one@example.com", first.GeneratedEmail,
+		"Subject: first message\r\nX-First: yes\r\n\r\nbody one\r\n")
+	// The second message has no Subject header and must not inherit the first one's
+	sendTestMessage(t, c, "two@example.com", second.GeneratedEmail,
+		"X-Second: yes\r\n\r\nbody two\r\n")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 forwarded emails, got %d", len(received))
+	}
+
+	if received[0].Subject != "first message" || received[0].To != first.GeneratedEmail {
+		t.Errorf("Unexpected first email: subject %q to %q", received[0].Subject, received[0].To)
+	}
+	if received[1].Subject != "" {
+		t.Errorf("Expected second email to have no subject, got %q", received[1].Subject)
+	}
+	if received[1].To != second.GeneratedEmail || received[1].From != "two@example.com" {
+		t.Errorf("Expected second email envelope to be its own, got from %q to %q", received[1].From, received[1].To)
+	}
+	if _, ok := received[1].Headers["X-First"]; ok {
+		t.Error("Expected first message headers not to leak into the second")
+	}
+}
+
+func TestSession_NoSubject(t *testing.T) {
+	var received []EmailData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data ProcessedData
+		json.NewDecoder(r.Body).Decode(&data)
+		received = append(received, data.Data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	c, err := smtp.Dial(startTestSMTPServer(t, processor))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	sendTestMessage(t, c, "sender@example.com", mapping.GeneratedEmail, "X-Custom: yes\r\n\r\nno subject here\r\n")
+
+	if len(received) != 1 || received[0].Subject != "" {
+		t.Fatalf("Expected the email to be forwarded with an empty subject, got %+v", received)
+	}
+
+	var entry database.EmailLog
+	if err := db.Where("mapping_id = ?", mapping.ID).First(&entry).Error; err != nil {
+		t.Fatalf("Expected the email to be logged: %v", err)
+	}
+	if entry.Status != "success" || entry.Subject != database.NoSubject {
+		t.Errorf("Expected a success log with subject %q, got %q with subject %q", database.NoSubject, entry.Status, entry.Subject)
+	}
+}
+
+func TestSession_RecordsClientIP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	c, err := smtp.Dial(startTestSMTPServer(t, processor))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	sendTestMessage(t, c, "sender@example.com", mapping.GeneratedEmail, "Subject: hello\r\n\r\nbody\r\n")
+
+	var entry database.EmailLog
+	if err := db.Where("mapping_id = ?", mapping.ID).First(&entry).Error; err != nil {
+		t.Fatalf("Expected the email to be logged: %v", err)
+	}
+	if entry.ClientIP != "127.0.0.1" {
+		t.Errorf("Expected client IP 127.0.0.1 to be logged, got %q", entry.ClientIP)
+	}
+}
+
+func TestSession_OverloadedReturnsTemporaryFailure(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, MaxInFlight: 1})
+
+	// Fill the only slot with an email whose delivery blocks
+	if err := processor.Process(Email{From: "first@example.com", To: mapping.GeneratedEmail}); err != nil {
+		t.Fatalf("Failed to process first email: %v", err)
+	}
+	if !processor.Overloaded() {
+		t.Fatal("Expected processor to be overloaded")
+	}
+
+	c, err := smtp.Dial(startTestSMTPServer(t, processor))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Mail("second@example.com"); err != nil {
+		t.Fatalf("MAIL failed: %v", err)
+	}
+	if err := c.Rcpt(mapping.GeneratedEmail); err != nil {
+		t.Fatalf("RCPT failed: %v", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+	fmt.Fprint(w, "Subject: second\r\n\r\nbody\r\n")
+	err = w.Close()
+
+	var tpErr *textproto.Error
+	if !errors.As(err, &tpErr) || tpErr.Code != 451 {
+		t.Fatalf("Expected 451 temporary failure, got %v", err)
+	}
+}
+
+func TestSession_SynchronousDeliveryFailure(t *testing.T) {
+	var status atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{
+		StatusActions: map[string]string{"410": database.StatusActionDeadLetter},
+	})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 2, Synchronous: true, Backoff: testBackoff})
+
+	send := func(requestStatus int) error {
+		status.Store(int32(requestStatus))
+		session := &Session{processor: processor, from: "sender@example.com", to: []string{mapping.GeneratedEmail}, remoteAddr: "192.0.2.1:2525"}
+		return session.Data(strings.NewReader("Subject: hello\r\n\r\nbody\r\n"))
+	}
+	deadLetters := func() int {
+		letters, err := db.ListDeadLetters(0)
+		if err != nil {
+			t.Fatalf("Failed to list dead letters: %v", err)
+		}
+		return len(letters)
+	}
+
+	// A failure a retry may fix asks the sender to retry, and isn't kept
+	// here as well
+	var smtpErr *gosmtp.SMTPError
+	if err := send(http.StatusServiceUnavailable); !errors.As(err, &smtpErr) || smtpErr.Code/100 != 4 {
+		t.Fatalf("Expected a 4xx SMTP error for a failing endpoint, got %v", err)
+	}
+	if n := deadLetters(); n != 0 {
+		t.Errorf("Expected no dead letter for an email the sender retries, got %d", n)
+	}
+	var entry database.EmailLog
+	if err := db.Where("mapping_id = ?", mapping.ID).First(&entry).Error; err != nil || entry.Payload != "" {
+		t.Errorf("Expected no payload kept for bulk retry, got %+v (%v)", entry, err)
+	}
+
+	// An endpoint refusing the email for good is a permanent failure
+	if err := send(http.StatusGone); errors.As(err, &smtpErr) && smtpErr.Code/100 == 4 {
+		t.Fatalf("Expected a permanent failure for a dead-lettered email, got %v", err)
+	} else if err == nil {
+		t.Fatal("Expected the refused email to fail")
+	}
+	if n := deadLetters(); n != 1 {
+		t.Errorf("Expected the refused email to be kept as a dead letter, got %d", n)
+	}
+}
+
+func TestSession_BDATChunking(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	// net/smtp has no BDAT support, so speak the protocol directly
+	conn, err := textproto.Dial("tcp", startTestSMTPServer(t, processor))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	expect := func(code int, format string, args ...any) {
+		t.Helper()
+		if format != "" {
+			if err := conn.PrintfLine(format, args...); err != nil {
+				t.Fatalf("Failed to send %q: %v", format, err)
+			}
+		}
+		if _, msg, err := conn.ReadResponse(code); err != nil {
+			t.Fatalf("Expected %d after %q, got %v (%s)", code, format, err, msg)
+		}
+	}
+
+	// Split the message mid-header so chunks must be assembled before parsing
+	chunks := []string{
+		"Subject: chunked del",
+		"ivery\r\nX-Chunk: yes\r\n\r\nfirst part, ",
+		"second part\r\n",
+	}
+
+	expect(220, "")
+	expect(250, "EHLO localhost")
+	expect(250, "MAIL FROM:<sender@example.com>")
+	expect(250, "RCPT TO:<%s>", mapping.GeneratedEmail)
+	for i, chunk := range chunks {
+		last := ""
+		if i == len(chunks)-1 {
+			last = " LAST"
+		}
+		fmt.Fprintf(conn.W, "BDAT %d%s\r\n%s", len(chunk), last, chunk)
+		if err := conn.W.Flush(); err != nil {
+			t.Fatalf("Failed to send chunk %d: %v", i, err)
+		}
+		expect(250, "")
+	}
+
+	if data.Data.Subject != "chunked delivery" {
+		t.Errorf("Expected subject assembled across chunks, got %q", data.Data.Subject)
+	}
+	if !strings.Contains(data.Data.Body, "first part, second part") {
+		t.Errorf("Expected body assembled across chunks, got %q", data.Data.Body)
+	}
+}
+
+func TestSession_RejectsInvalidSender(t *testing.T) {
+	db := newTestDB(t)
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, InvalidSender: InvalidSenderReject})
+	addr := startTestSMTPServer(t, processor)
+
+	// "a..b@example.com" passes go-smtp's path syntax but is not a valid address
+	for _, from := range []string{"", "a..b@example.com"} {
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+
+		err = c.Mail(from)
+		var tpErr *textproto.Error
+		if !errors.As(err, &tpErr) || tpErr.Code != 550 {
+			t.Errorf("Expected 550 for sender %q, got %v", from, err)
+		}
+		c.Close()
+	}
+}
+
+func TestProcessor_DropsInvalidSender(t *testing.T) {
+	called := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{
+		MaxSize:       1024 * 1024,
+		RetryAttempts: 1,
+		Synchronous:   true,
+		InvalidSender: InvalidSenderDrop,
+	})
+
+	for _, from := range []string{"", "not-an-address"} {
+		if err := processor.Process(Email{From: from, To: mapping.GeneratedEmail, Subject: "probe"}); err != nil {
+			t.Errorf("Expected email from %q to be dropped without error, got %v", from, err)
+		}
+	}
+	if called {
+		t.Error("Expected emails with invalid senders not to be forwarded")
+	}
+
+	var dropped int64
+	db.Model(&database.EmailLog{}).Where("status = ?", "dropped").Count(&dropped)
+	if dropped != 2 {
+		t.Errorf("Expected 2 dropped log rows, got %d", dropped)
+	}
+}
+
+func TestSession_ReplyToInPayload(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	c, err := smtp.Dial(startTestSMTPServer(t, processor))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	sendTestMessage(t, c, "noreply@example.com", mapping.GeneratedEmail,
+		"Subject: ticket\r\nReply-To: support@example.com, Help Desk <help@example.com>\r\n\r\nbody\r\n")
+
+	want := []string{"support@example.com", "Help Desk <help@example.com>"}
+	if strings.Join(data.Data.ReplyTo, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected reply_to %v, got %v", want, data.Data.ReplyTo)
+	}
+}
+
+func TestSession_MultipleRecipientsForwardedSeparately(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]EmailData)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data ProcessedData
+		json.NewDecoder(r.Body).Decode(&data)
+		mu.Lock()
+		received[r.URL.Path] = data.Data
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	first := createTestMapping(t, db, ts.URL+"/first", database.MappingOptions{})
+	second := createTestMapping(t, db, ts.URL+"/second", database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	c, err := smtp.Dial(startTestSMTPServer(t, processor))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Mail("sender@example.com"); err != nil {
+		t.Fatalf("MAIL failed: %v", err)
+	}
+	for _, rcpt := range []string{first.GeneratedEmail, second.GeneratedEmail} {
+		if err := c.Rcpt(rcpt); err != nil {
+			t.Fatalf("RCPT failed: %v", err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+	fmt.Fprintf(w, "To: %s, someone@elsewhere.com\r\nCc: %s\r\nSubject: both\r\n\r\nbody\r\n",
+		first.GeneratedEmail, second.GeneratedEmail)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to finish message: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for path, mapping := range map[string]*database.EmailMapping{"/first": first, "/second": second} {
+		data, ok := received[path]
+		if !ok {
+			t.Errorf("Expected a forward to %s", path)
+			continue
+		}
+		if data.To != mapping.GeneratedEmail || data.EnvelopeTo != mapping.GeneratedEmail {
+			t.Errorf("Expected %s forward addressed to %s, got to %q envelope_to %q",
+				path, mapping.GeneratedEmail, data.To, data.EnvelopeTo)
+		}
+		if len(data.HeaderTo) != 2 || data.HeaderTo[1] != "someone@elsewhere.com" {
+			t.Errorf("Expected full header recipient list on %s forward, got %v", path, data.HeaderTo)
+		}
+	}
+}
+
+func TestSession_RejectsOverlongLine(t *testing.T) {
+	called := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true, MaxLineLength: 100})
+
+	c, err := smtp.Dial(startTestSMTPServer(t, processor))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Mail("sender@example.com"); err != nil {
+		t.Fatalf("MAIL failed: %v", err)
+	}
+	if err := c.Rcpt(mapping.GeneratedEmail); err != nil {
+		t.Fatalf("RCPT failed: %v", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+	fmt.Fprintf(w, "Subject: long\r\n\r\n%s\r\n", strings.Repeat("x", 500))
+	err = w.Close()
+
+	var tpErr *textproto.Error
+	if !errors.As(err, &tpErr) || tpErr.Code != 500 {
+		t.Fatalf("Expected 500 line too long, got %v", err)
+	}
+	if called {
+		t.Error("Expected rejected email not to be forwarded")
+	}
+
+	// The connection stays usable for a well-formed message
+	sendTestMessage(t, c, "sender@example.com", mapping.GeneratedEmail,
+		"Subject: short\r\n\r\n"+strings.Repeat("x", 100)+"\r\n")
+	if !called {
+		t.Error("Expected message within the limit to be forwarded")
+	}
+}
+
+func TestSession_RejectsOversizedMessage(t *testing.T) {
+	called := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024, RetryAttempts: 1, Synchronous: true})
+
+	c, err := smtp.Dial(startTestSMTPServer(t, processor))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	// The maximum email size is advertised with SIZE
+	if ok, param := c.Extension("SIZE"); !ok || param != "1024" {
+		t.Errorf("Expected SIZE 1024 to be advertised, got %v %q", ok, param)
+	}
+
+	// A larger announced size is refused at MAIL FROM
+	id, err := c.Text.Cmd("MAIL FROM:<sender@example.com> SIZE=2048")
+	if err != nil {
+		t.Fatalf("Failed to send MAIL: %v", err)
+	}
+	c.Text.StartResponse(id)
+	_, _, err = c.Text.ReadResponse(250)
+	c.Text.EndResponse(id)
+	var tpErr *textproto.Error
+	if !errors.As(err, &tpErr) || tpErr.Code != 552 {
+		t.Errorf("Expected 552 for an announced size over the limit, got %v", err)
+	}
+
+	// A message over the limit is refused during DATA
+	if err := c.Mail("sender@example.com"); err != nil {
+		t.Fatalf("MAIL failed: %v", err)
+	}
+	if err := c.Rcpt(mapping.GeneratedEmail); err != nil {
+		t.Fatalf("RCPT failed: %v", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+	fmt.Fprintf(w, "Subject: too big\r\n\r\n%s", strings.Repeat(strings.Repeat("x", 78)+"\r\n", 30))
+	err = w.Close()
+
+	if !errors.As(err, &tpErr) || tpErr.Code != 552 {
+		t.Fatalf("Expected 552 message too large, got %v", err)
+	}
+	if called {
+		t.Error("Expected oversized email not to be forwarded")
+	}
+	var entry database.EmailLog
+	if err := db.Where("mapping_id = ?", mapping.ID).First(&entry).Error; err != nil {
+		t.Fatalf("Failed to load log entry: %v", err)
+	}
+	if entry.Status != StatusRejectedAtSMTPSize || entry.Subject != "too big" {
+		t.Errorf("Expected %q log with the subject, got %q with %q", StatusRejectedAtSMTPSize, entry.Status, entry.Subject)
+	}
+
+	// The connection stays usable for a message within the limit
+	sendTestMessage(t, c, "sender@example.com", mapping.GeneratedEmail,
+		"Subject: small\r\n\r\n"+strings.Repeat("x", 100)+"\r\n")
+	if !called {
+		t.Error("Expected message within the limit to be forwarded")
+	}
+}
+
+func TestSession_RejectInactiveMapping(t *testing.T) {
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, "http://127.0.0.1:1", database.MappingOptions{})
+	if _, err := db.ToggleEmailMapping(mapping.GeneratedEmail, 1); err != nil {
+		t.Fatalf("Failed to deactivate mapping: %v", err)
+	}
+	active := createTestMapping(t, db, "http://127.0.0.1:1", database.MappingOptions{})
+
+	for _, reject := range []bool{false, true} {
+		processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RejectInactive: reject})
+		addr := startTestSMTPServer(t, processor)
+
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		if err := c.Mail("sender@example.com"); err != nil {
+			t.Fatalf("MAIL failed: %v", err)
+		}
+
+		err = c.Rcpt(mapping.GeneratedEmail)
+		var tpErr *textproto.Error
+		if reject && (!errors.As(err, &tpErr) || tpErr.Code != 550) {
+			t.Errorf("Expected 550 for an inactive mapping, got %v", err)
+		}
+		if !reject && err != nil {
+			t.Errorf("Expected inactive mapping to be accepted when rejection is off, got %v", err)
+		}
+		if err := c.Rcpt(active.GeneratedEmail); err != nil {
+			t.Errorf("Expected active mapping to be accepted (reject=%v), got %v", reject, err)
+		}
+		c.Close()
+	}
+}
+
+func TestSMTPServer_MaxConnections(t *testing.T) {
+	db := newTestDB(t)
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, MaxConnections: 2})
+	addr := startTestSMTPServer(t, processor)
+
+	// greeting opens a raw connection and returns the server's first line
+	greeting := func() (net.Conn, string) {
+		t.Helper()
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read greeting: %v", err)
+		}
+		return conn, line
+	}
+
+	first, line := greeting()
+	if !strings.HasPrefix(line, "220") {
+		t.Fatalf("Expected 220 greeting, got %q", line)
+	}
+	second, line := greeting()
+	defer second.Close()
+	if !strings.HasPrefix(line, "220") {
+		t.Fatalf("Expected 220 greeting, got %q", line)
+	}
+
+	excess, line := greeting()
+	excess.Close()
+	if !strings.HasPrefix(line, "421") {
+		t.Errorf("Expected connection over the limit to get 421, got %q", line)
+	}
+
+	// Closing a connection frees its slot
+	first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, line := greeting()
+		conn.Close()
+		if strings.HasPrefix(line, "220") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a freed slot to accept a new connection, got %q", line)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,141 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// spool buffers emails on local disk while the database is unreachable so
+// they can be replayed instead of dropped
+type spool struct {
+	dir string
+}
+
+// write stores an email in the spool. Files are named by arrival time so
+// replay preserves order, and written via rename so a crash never leaves a
+// partial file behind.
+func (s *spool) write(email Email) error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	data, err := json.Marshal(email)
+	if err != nil {
+		return fmt.Errorf("failed to marshal spooled email: %w", err)
+	}
+
+	name := fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), email.RequestID)
+	tmp, err := os.CreateTemp(s.dir, ".spool-*")
+	if err != nil {
+		return fmt.Errorf("failed to create spool file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to commit spool file: %w", err)
+	}
+	return nil
+}
+
+// files returns the spooled email files, oldest first
+func (s *spool) files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			files = append(files, filepath.Join(s.dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// read loads a spooled email
+func (s *spool) read(path string) (Email, error) {
+	var email Email
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return email, fmt.Errorf("failed to read spool file: %w", err)
+	}
+	if err := json.Unmarshal(data, &email); err != nil {
+		return email, fmt.Errorf("failed to parse spool file %s: %w", path, err)
+	}
+	return email, nil
+}
+
+// ReplaySpool reprocesses spooled emails once the database is reachable
+// again. It stops at the first email whose mapping still can't be looked up
+// and leaves the rest for the next attempt.
+func (p *Processor) ReplaySpool() {
+	if p.spool == nil {
+		return
+	}
+
+	files, err := p.spool.files()
+	if err != nil {
+		log.Printf("Failed to list spooled emails: %v", err)
+		return
+	}
+
+	for _, path := range files {
+		email, err := p.spool.read(path)
+		if err != nil {
+			log.Printf("Skipping unreadable spooled email: %v", err)
+			continue
+		}
+		logger := requestLogger(email.RequestID)
+
+		if _, err := p.db.GetEmailMapping(email.To); err != nil {
+			logger.Printf("Database still unavailable, keeping %d spooled emails: %v", len(files), err)
+			return
+		}
+
+		// Remove first: if the database fails again the email is re-spooled
+		if err := os.Remove(path); err != nil {
+			logger.Printf("Failed to remove spooled email %s: %v", path, err)
+			continue
+		}
+		logger.Printf("Replaying spooled email to %s", email.To)
+		if err := p.processAsync(email, false); err != nil {
+			logger.Printf("Replayed email failed: %v", err)
+		}
+	}
+}
+
+// RunSpoolReplay replays spooled emails, including any left from a previous
+// run, every interval until ctx is done
+func (p *Processor) RunSpoolReplay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.ReplaySpool()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
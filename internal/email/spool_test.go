@@ -0,0 +1,66 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestProcessor_SpoolsDuringDatabaseOutage(t *testing.T) {
+	var received []EmailData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data ProcessedData
+		json.NewDecoder(r.Body).Decode(&data)
+		received = append(received, data.Data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	dir := filepath.Join(t.TempDir(), "spool")
+	processor := New(db, ProcessorConfig{
+		MaxSize:       1024 * 1024,
+		RetryAttempts: 1,
+		Synchronous:   true,
+		SpoolDir:      dir,
+	})
+
+	// Simulate an outage by making the mappings table unreachable
+	if err := db.Exec("ALTER TABLE email_mappings RENAME TO email_mappings_offline").Error; err != nil {
+		t.Fatalf("Failed to simulate outage: %v", err)
+	}
+
+	email := Email{From: "sender@example.com", To: mapping.GeneratedEmail, Subject: "spooled", Body: "body"}
+	if err := processor.Process(email); err != nil {
+		t.Fatalf("Expected email to be spooled during outage, got %v", err)
+	}
+	if files, _ := processor.spool.files(); len(files) != 1 {
+		t.Fatalf("Expected 1 spooled email, got %d", len(files))
+	}
+
+	// Replaying while the database is still down keeps the email
+	processor.ReplaySpool()
+	if files, _ := processor.spool.files(); len(files) != 1 {
+		t.Fatalf("Expected spooled email to be kept during outage, got %d files", len(files))
+	}
+	if len(received) != 0 {
+		t.Fatalf("Expected nothing delivered during outage, got %d", len(received))
+	}
+
+	if err := db.Exec("ALTER TABLE email_mappings_offline RENAME TO email_mappings").Error; err != nil {
+		t.Fatalf("Failed to simulate recovery: %v", err)
+	}
+	processor.ReplaySpool()
+
+	if files, _ := processor.spool.files(); len(files) != 0 {
+		t.Errorf("Expected spool to be empty after recovery, got %d files", len(files))
+	}
+	if len(received) != 1 || received[0].Subject != "spooled" {
+		t.Fatalf("Expected the spooled email to be delivered after recovery, got %+v", received)
+	}
+}
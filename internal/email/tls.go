@@ -0,0 +1,141 @@
+package email
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSConfig configures STARTTLS for the SMTP server
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables client certificate verification against this CA
+	// bundle; senders presenting a verified certificate are authenticated
+	// as the certificate's identity
+	ClientCAFile string
+	// MapClientCertToUser only accepts a client certificate identity that
+	// matches an active user's email
+	MapClientCertToUser bool
+	// MinVersion is the lowest accepted TLS version ("1.0" to "1.3");
+	// defaults to 1.2
+	MinVersion string
+	// CipherSuites restricts TLS 1.0-1.2 cipher suites by Go name, e.g.
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256; empty uses Go's defaults.
+	// TLS 1.3 suites are not configurable.
+	CipherSuites []string
+	// RequireForAuth refuses AUTH until the client has completed STARTTLS,
+	// so credentials are never sent in the clear
+	RequireForAuth bool
+}
+
+// tlsVersions maps configured version strings to crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseMinVersion resolves the configured minimum TLS version
+func parseMinVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", version)
+	}
+	return v, nil
+}
+
+// parseCipherSuites resolves cipher suite names to their IDs
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Enabled reports whether STARTTLS is configured
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// Load builds the server's tls.Config; it returns nil when TLS is not configured
+func (c TLSConfig) Load() (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	minVersion, err := parseMinVersion(c.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := parseCipherSuites(c.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", c.ClientCAFile)
+		}
+		// Client certificates are optional so ordinary senders can still use STARTTLS
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+// clientCertIdentity returns the identity of a verified client certificate:
+// its common name, falling back to the first email or DNS SAN
+func clientCertIdentity(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	cert := state.VerifiedChains[0][0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	}
+	return ""
+}
@@ -0,0 +1,339 @@
+package email
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// testCert is a certificate and key issued by a test CA
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// issueTestCert creates a certificate from template, signed by parent (or self-signed)
+func issueTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// writePEM writes the certificate and key as PEM files and returns their paths
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+// sendWithClientCert sends a message over STARTTLS to a server that maps
+// client certificates to users, presenting a CA-issued certificate for identity
+func sendWithClientCert(t *testing.T, processor *Processor, identity, to string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	ca := issueTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	server := issueTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	client := issueTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: identity},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	certFile, keyFile := server.writePEM(t, dir, "server")
+
+	addr := startTestSMTPServerTLS(t, processor, TLSConfig{
+		CertFile:            certFile,
+		KeyFile:             keyFile,
+		ClientCAFile:        caFile,
+		MapClientCertToUser: true,
+	})
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	err = c.StartTLS(&tls.Config{
+		ServerName: "127.0.0.1",
+		RootCAs:    roots,
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{client.der},
+			PrivateKey:  client.key,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("STARTTLS failed: %v", err)
+	}
+
+	sendTestMessage(t, c, "sender@example.com", to, "Subject: mtls\r\n\r\nbody\r\n")
+}
+
+func TestSession_ClientCertIdentityMapsToUser(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	user, err := db.CreateUser("alice@example.com", "user")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	sendWithClientCert(t, processor, user.Email, mapping.GeneratedEmail)
+
+	if data.Data.AuthenticatedAs != user.Email {
+		t.Errorf("Expected authenticated_as %q from client certificate, got %q", user.Email, data.Data.AuthenticatedAs)
+	}
+}
+
+func TestSession_ClientCertIdentityWithoutUser(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true})
+
+	// A certificate the CA issued for an identity that isn't a user leaves
+	// the session unauthenticated rather than dropping it
+	sendWithClientCert(t, processor, "stranger@example.com", mapping.GeneratedEmail)
+
+	if data.Data.Subject != "mtls" {
+		t.Fatalf("Expected the message to be delivered, got %+v", data.Data)
+	}
+	if data.Data.AuthenticatedAs != "" {
+		t.Errorf("Expected no authenticated identity, got %q", data.Data.AuthenticatedAs)
+	}
+}
+
+func TestSMTPServer_RejectsTLSBelowMinVersion(t *testing.T) {
+	db := newTestDB(t)
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1})
+
+	dir := t.TempDir()
+	server := issueTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil)
+	certFile, keyFile := server.writePEM(t, dir, "server")
+
+	addr := startTestSMTPServerTLS(t, processor, TLSConfig{
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		MinVersion: "1.2",
+	})
+
+	startTLS := func(version uint16) error {
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer c.Close()
+		return c.StartTLS(&tls.Config{
+			InsecureSkipVerify: true,
+			MinVersion:         version,
+			MaxVersion:         version,
+		})
+	}
+
+	if err := startTLS(tls.VersionTLS10); err == nil {
+		t.Error("Expected TLS 1.0 handshake to be rejected")
+	}
+	if err := startTLS(tls.VersionTLS12); err != nil {
+		t.Errorf("Expected TLS 1.2 handshake to succeed, got %v", err)
+	}
+}
+
+func TestTLSConfig_UnknownCipherSuite(t *testing.T) {
+	if _, err := parseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}); err != nil {
+		t.Errorf("Expected known cipher suite to parse, got %v", err)
+	}
+	if _, err := parseCipherSuites([]string{"TLS_NOT_A_SUITE"}); err == nil {
+		t.Error("Expected unknown cipher suite to be rejected")
+	}
+	if _, err := parseMinVersion("1.4"); err == nil {
+		t.Error("Expected unknown TLS version to be rejected")
+	}
+}
+
+func TestSession_TLSInfoInPayload(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data = ProcessedData{}
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, Synchronous: true, IncludeTLSInfo: true})
+
+	server := issueTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil)
+	certFile, keyFile := server.writePEM(t, t.TempDir(), "server")
+	addr := startTestSMTPServerTLS(t, processor, TLSConfig{CertFile: certFile, KeyFile: keyFile})
+
+	t.Run("starttls", func(t *testing.T) {
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer c.Close()
+		if err := c.StartTLS(&tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}); err != nil {
+			t.Fatalf("STARTTLS failed: %v", err)
+		}
+
+		sendTestMessage(t, c, "sender@example.com", mapping.GeneratedEmail, "Subject: tls\r\n\r\nbody\r\n")
+
+		if data.Data.ReceivedTLS == nil || !*data.Data.ReceivedTLS {
+			t.Errorf("Expected received_tls = true, got %v", data.Data.ReceivedTLS)
+		}
+		if data.Data.TLSVersion != "TLS 1.2" {
+			t.Errorf("Expected tls_version TLS 1.2, got %q", data.Data.TLSVersion)
+		}
+		if !strings.HasPrefix(data.Data.TLSCipher, "TLS_") {
+			t.Errorf("Expected a cipher suite name, got %q", data.Data.TLSCipher)
+		}
+	})
+
+	t.Run("plaintext", func(t *testing.T) {
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer c.Close()
+
+		sendTestMessage(t, c, "sender@example.com", mapping.GeneratedEmail, "Subject: plain\r\n\r\nbody\r\n")
+
+		if data.Data.ReceivedTLS == nil || *data.Data.ReceivedTLS {
+			t.Errorf("Expected received_tls = false, got %v", data.Data.ReceivedTLS)
+		}
+		if data.Data.TLSVersion != "" || data.Data.TLSCipher != "" {
+			t.Errorf("Expected empty TLS version and cipher, got %q and %q", data.Data.TLSVersion, data.Data.TLSCipher)
+		}
+	})
+}
+
+func TestSMTPServer_RequireTLSForAuth(t *testing.T) {
+	db := newTestDB(t)
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1})
+
+	server := issueTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil)
+	certFile, keyFile := server.writePEM(t, t.TempDir(), "server")
+	addr := startTestSMTPServerTLS(t, processor, TLSConfig{CertFile: certFile, KeyFile: keyFile, RequireForAuth: true})
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Hello("localhost"); err != nil {
+		t.Fatalf("EHLO failed: %v", err)
+	}
+	if ok, _ := c.Extension("AUTH"); ok {
+		t.Error("Expected AUTH not to be advertised on a plaintext connection")
+	}
+	err = c.Auth(smtp.PlainAuth("", "user@example.com", "secret", "127.0.0.1"))
+	if err == nil || !strings.HasPrefix(err.Error(), "523") {
+		t.Fatalf("Expected AUTH to be refused with 523 before STARTTLS, got %v", err)
+	}
+
+	// A failed AUTH ends the client's connection, so check STARTTLS on a new one
+	c, err = smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	if err := c.StartTLS(&tls.Config{InsecureSkipVerify: true}); err != nil {
+		t.Fatalf("STARTTLS failed: %v", err)
+	}
+	err = c.Auth(smtp.PlainAuth("", "user@example.com", "secret", "127.0.0.1"))
+	if err != nil && strings.HasPrefix(err.Error(), "523") {
+		t.Errorf("Expected AUTH not to require TLS after STARTTLS, got %v", err)
+	}
+}
+
+func TestSMTPServer_RequireTLSForAuthNeedsCertificate(t *testing.T) {
+	processor := New(newTestDB(t), ProcessorConfig{MaxSize: 1024 * 1024})
+	if _, err := newSMTPServer(processor, "localhost", TLSConfig{RequireForAuth: true}); err == nil {
+		t.Error("Expected requiring TLS for AUTH without a certificate to fail")
+	}
+}
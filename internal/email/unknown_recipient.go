@@ -0,0 +1,128 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+)
+
+// Unknown recipient handling modes; the default drops the email and logs it
+const (
+	UnknownRecipientReject = "reject" // 550 at RCPT
+	UnknownRecipientBounce = "bounce" // Accept, drop and reply to the sender
+)
+
+// Default bounce templates, used when none are configured
+const (
+	DefaultBounceSubject = "Undeliverable: {{.Subject}}"
+	DefaultBounceBody    = `Your message to {{.To}} could not be delivered because the address is unknown.
+
+Subject: {{.Subject}}
+`
+)
+
+// BounceNotifier sends the auto-reply for email to an unknown recipient
+type BounceNotifier interface {
+	SendBounce(to, subject, body string) error
+}
+
+// BounceData is what bounce templates can refer to
+type BounceData struct {
+	From    string // Envelope sender the bounce goes to
+	To      string // Unknown recipient
+	Subject string
+}
+
+// BounceTemplate renders the subject and body of bounce replies
+type BounceTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// NewBounceTemplate parses text/template subject and body templates over
+// BounceData; empty ones use DefaultBounceSubject and DefaultBounceBody
+func NewBounceTemplate(subject, body string) (*BounceTemplate, error) {
+	if subject == "" {
+		subject = DefaultBounceSubject
+	}
+	if body == "" {
+		body = DefaultBounceBody
+	}
+
+	subjectTmpl, err := template.New("subject").Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bounce subject template: %w", err)
+	}
+	bodyTmpl, err := template.New("body").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bounce body template: %w", err)
+	}
+	return &BounceTemplate{subject: subjectTmpl, body: bodyTmpl}, nil
+}
+
+// render executes the templates for one bounce
+func (t *BounceTemplate) render(data BounceData) (string, string, error) {
+	var subject, body bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return "", "", fmt.Errorf("failed to render bounce subject: %w", err)
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		return "", "", fmt.Errorf("failed to render bounce body: %w", err)
+	}
+	// A subject spanning lines would break the header it goes into
+	return strings.Join(strings.Fields(subject.String()), " "), body.String(), nil
+}
+
+// bounceUnknownRecipient replies to the sender of an email for an address
+// without any mapping. Automatic mail and mail without a valid sender get
+// no reply, so two auto-responders can't keep answering each other.
+func (p *Processor) bounceUnknownRecipient(logger *log.Logger, email Email) {
+	if p.config.BounceNotifier == nil {
+		logger.Printf("Not bouncing email: no notifier configured")
+		return
+	}
+	if !validSender(email.From) {
+		logger.Printf("Not bouncing email: sender %q is empty or invalid", email.From)
+		return
+	}
+	if autoSubmitted := getHeaderFold(email.Headers, "Auto-Submitted"); isAutoSubmitted(autoSubmitted) {
+		logger.Printf("Not bouncing auto-submitted email (Auto-Submitted: %s)", autoSubmitted)
+		return
+	}
+	switch precedence := strings.ToLower(strings.TrimSpace(getHeaderFold(email.Headers, "Precedence"))); precedence {
+	case "bulk", "list", "junk":
+		logger.Printf("Not bouncing email with Precedence: %s", precedence)
+		return
+	}
+
+	// Email for an inactive mapping ends up here too, but only addresses
+	// without any mapping are bounced
+	known, err := p.db.HasEmailMapping(email.To)
+	if err != nil {
+		logger.Printf("Not bouncing email: %v", err)
+		return
+	}
+	if known {
+		return
+	}
+
+	tmpl := p.config.BounceTemplate
+	if tmpl == nil {
+		if tmpl, err = NewBounceTemplate("", ""); err != nil {
+			logger.Printf("Not bouncing email: %v", err)
+			return
+		}
+	}
+	subject, body, err := tmpl.render(BounceData{From: email.From, To: email.To, Subject: email.Subject})
+	if err != nil {
+		logger.Printf("Not bouncing email: %v", err)
+		return
+	}
+	if err := p.config.BounceNotifier.SendBounce(email.From, subject, body); err != nil {
+		logger.Printf("Failed to send bounce to %q: %v", email.From, err)
+		return
+	}
+	logger.Printf("Sent bounce to %q for unknown recipient %q", email.From, email.To)
+}
@@ -0,0 +1,149 @@
+package email
+
+import (
+	"errors"
+	"net/smtp"
+	"net/textproto"
+	"testing"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+// fakeBounceNotifier records the bounces it is asked to send
+type fakeBounceNotifier struct {
+	to, subject, body []string
+}
+
+func (n *fakeBounceNotifier) SendBounce(to, subject, body string) error {
+	n.to = append(n.to, to)
+	n.subject = append(n.subject, subject)
+	n.body = append(n.body, body)
+	return nil
+}
+
+func TestProcessor_UnknownRecipient(t *testing.T) {
+	tmpl, err := NewBounceTemplate("No such address: {{.To}}", "Hello {{.From}}, {{.To}} is unknown ({{.Subject}})")
+	if err != nil {
+		t.Fatalf("NewBounceTemplate() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		mode        string
+		email       Email
+		wantBounces int
+	}{
+		{
+			name:  "silent drop",
+			email: Email{From: "sender@example.org", Subject: "hello"},
+		},
+		{
+			name:        "bounce",
+			mode:        UnknownRecipientBounce,
+			email:       Email{From: "sender@example.org", Subject: "hello"},
+			wantBounces: 1,
+		},
+		{
+			name:  "no bounce to null sender",
+			mode:  UnknownRecipientBounce,
+			email: Email{From: "", Subject: "hello"},
+		},
+		{
+			name:  "no bounce to auto-submitted",
+			mode:  UnknownRecipientBounce,
+			email: Email{From: "sender@example.org", Subject: "hello", Headers: map[string][]string{"Auto-Submitted": {"auto-replied"}}},
+		},
+		{
+			name:  "no bounce to list mail",
+			mode:  UnknownRecipientBounce,
+			email: Email{From: "sender@example.org", Subject: "hello", Headers: map[string][]string{"Precedence": {"list"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier := &fakeBounceNotifier{}
+			processor := New(newTestDB(t), ProcessorConfig{
+				MaxSize:          1024 * 1024,
+				RetryAttempts:    1,
+				Synchronous:      true,
+				UnknownRecipient: tt.mode,
+				BounceNotifier:   notifier,
+				BounceTemplate:   tmpl,
+			})
+
+			email := tt.email
+			email.To = "nobody@example.com"
+			if err := processor.Process(email); err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+
+			if len(notifier.to) != tt.wantBounces {
+				t.Fatalf("Expected %d bounces, got %d", tt.wantBounces, len(notifier.to))
+			}
+			if tt.wantBounces == 0 {
+				return
+			}
+			if notifier.to[0] != "sender@example.org" {
+				t.Errorf("Expected bounce to the sender, got %q", notifier.to[0])
+			}
+			if notifier.subject[0] != "No such address: nobody@example.com" {
+				t.Errorf("Unexpected bounce subject %q", notifier.subject[0])
+			}
+			if want := "Hello sender@example.org, nobody@example.com is unknown (hello)"; notifier.body[0] != want {
+				t.Errorf("Bounce body = %q, want %q", notifier.body[0], want)
+			}
+		})
+	}
+}
+
+func TestProcessor_UnknownRecipientBounceSkipsInactiveMapping(t *testing.T) {
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, "http://127.0.0.1:1", database.MappingOptions{})
+	if _, err := db.ToggleEmailMapping(mapping.GeneratedEmail, 1); err != nil {
+		t.Fatalf("Failed to deactivate mapping: %v", err)
+	}
+
+	notifier := &fakeBounceNotifier{}
+	processor := New(db, ProcessorConfig{
+		MaxSize:          1024 * 1024,
+		Synchronous:      true,
+		UnknownRecipient: UnknownRecipientBounce,
+		BounceNotifier:   notifier,
+	})
+	if err := processor.Process(Email{From: "sender@example.org", To: mapping.GeneratedEmail}); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if len(notifier.to) != 0 {
+		t.Errorf("Expected no bounce for an inactive mapping, got %d", len(notifier.to))
+	}
+}
+
+func TestSession_RejectsUnknownRecipient(t *testing.T) {
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, "http://127.0.0.1:1", database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, UnknownRecipient: UnknownRecipientReject})
+
+	c, err := smtp.Dial(startTestSMTPServer(t, processor))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Mail("sender@example.org"); err != nil {
+		t.Fatalf("MAIL failed: %v", err)
+	}
+	err = c.Rcpt("nobody@example.com")
+	var tpErr *textproto.Error
+	if !errors.As(err, &tpErr) || tpErr.Code != 550 {
+		t.Errorf("Expected 550 for an unknown recipient, got %v", err)
+	}
+	if err := c.Rcpt(mapping.GeneratedEmail); err != nil {
+		t.Errorf("Expected mapped recipient to be accepted, got %v", err)
+	}
+}
+
+func TestNewBounceTemplate_Invalid(t *testing.T) {
+	if _, err := NewBounceTemplate("{{.Subject", ""); err == nil {
+		t.Error("Expected an error for an invalid subject template")
+	}
+}
@@ -0,0 +1,47 @@
+package email
+
+import "log"
+
+// Payload schema versions. Version 1 is the original payload; version 2 adds
+// version, origin, envelope_to, header_to, reply_to, clean_body, attachments,
+// list_unsubscribe, auto_submitted, precedence, the TLS fields, calendar,
+// headers_flat and folded_headers.
+const (
+	PayloadVersion1 = "1"
+	PayloadVersion2 = "2"
+
+	// PayloadVersion is the version sent unless a mapping pins an older one
+	PayloadVersion = PayloadVersion2
+)
+
+// pinPayloadVersion returns the payload as the given schema version,
+// removing fields the version doesn't have. An empty or unknown version gets
+// the current schema.
+func pinPayloadVersion(logger *log.Logger, payload ProcessedData, version string) ProcessedData {
+	switch version {
+	case "", PayloadVersion:
+		payload.Version = PayloadVersion
+	case PayloadVersion1:
+		payload.Version = PayloadVersion1
+		payload.Origin = ""
+		data := &payload.Data
+		data.EnvelopeTo = ""
+		data.HeaderTo = nil
+		data.ReplyTo = nil
+		data.CleanBody = ""
+		data.Attachments = nil
+		data.ListUnsubscribe = nil
+		data.AutoSubmitted = ""
+		data.Precedence = ""
+		data.ReceivedTLS = nil
+		data.TLSVersion = ""
+		data.TLSCipher = ""
+		data.Calendar = nil
+		data.HeadersFlat = nil
+		data.FoldedHeaders = nil
+	default:
+		logger.Printf("Unknown payload version %q, sending version %s", version, PayloadVersion)
+		payload.Version = PayloadVersion
+	}
+	return payload
+}
@@ -0,0 +1,278 @@
+package email
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webhookRetryAfter is the Retry-After (seconds) sent when the processor is busy
+const webhookRetryAfter = "30"
+
+// BusyGuard wraps an inbound webhook handler so it shares the processor's
+// in-flight limit with the SMTP server. While the limit is reached, requests
+// are answered with 503 and a Retry-After header so the provider retries
+// later instead of the processor being overwhelmed.
+func (p *Processor) BusyGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.Overloaded() {
+			log.Printf("Processor overloaded, deferring webhook from %s", r.RemoteAddr)
+			w.Header().Set("Retry-After", webhookRetryAfter)
+			http.Error(w, "Server busy, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// webhookMaxAge is how old a signed webhook timestamp may be
+const webhookMaxAge = 5 * time.Minute
+
+// webhookSignatureHeader carries the JSON receiver's body signature, in the
+// format endpoints receive in X-Signature: t=<unix time>,v1=<hex signature>
+const webhookSignatureHeader = "X-Signature"
+
+// WebhookAuth verifies inbound webhook signatures: Mailgun posts with the
+// Mailgun scheme, signature = hex(HMAC-SHA256(signingKey, timestamp + token)),
+// and the JSON receiver with a signature over the request body.
+type WebhookAuth struct {
+	signingKey         string
+	insecureSkipVerify bool
+	now                func() time.Time
+}
+
+// NewWebhookAuth creates a webhook verifier. insecureSkipVerify disables
+// verification entirely and must only be used for local development.
+func NewWebhookAuth(signingKey string, insecureSkipVerify bool) *WebhookAuth {
+	if insecureSkipVerify {
+		log.Printf("WARNING: INSECURE: webhook signature verification is DISABLED; anyone can submit email. Never use this outside local development.")
+	}
+	return &WebhookAuth{
+		signingKey:         signingKey,
+		insecureSkipVerify: insecureSkipVerify,
+		now:                time.Now,
+	}
+}
+
+// Verify checks a webhook's timestamp, token and signature
+func (a *WebhookAuth) Verify(timestamp, token, signature string) error {
+	if a.insecureSkipVerify {
+		return nil
+	}
+	if a.signingKey == "" {
+		return fmt.Errorf("no webhook signing key configured")
+	}
+	if timestamp == "" || token == "" || signature == "" {
+		return fmt.Errorf("missing webhook signature")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid webhook timestamp %q", timestamp)
+	}
+	if age := a.now().Sub(time.Unix(ts, 0)); age > webhookMaxAge || age < -webhookMaxAge {
+		return fmt.Errorf("stale webhook timestamp %s", timestamp)
+	}
+
+	mac := hmac.New(sha256.New, []byte(a.signingKey))
+	mac.Write([]byte(timestamp + token))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return fmt.Errorf("invalid webhook signature")
+	}
+	return nil
+}
+
+// VerifyBody checks a JSON webhook's X-Signature header, t=<unix time>,v1=<hex
+// HMAC-SHA256 of "<t>.<body>">. Signing the body means a signature seen in
+// transit can't be used to post a different email.
+func (a *WebhookAuth) VerifyBody(header string, body []byte) error {
+	if a.insecureSkipVerify {
+		return nil
+	}
+	if a.signingKey == "" {
+		return fmt.Errorf("no webhook signing key configured")
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, strings.ToLower(value))
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return fmt.Errorf("missing webhook signature")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid webhook timestamp %q", timestamp)
+	}
+	if age := a.now().Sub(time.Unix(ts, 0)); age > webhookMaxAge || age < -webhookMaxAge {
+		return fmt.Errorf("stale webhook timestamp %s", timestamp)
+	}
+
+	expected := hex.EncodeToString(hmacSHA256([]byte(a.signingKey), timestamp+"."+string(body)))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid webhook signature")
+}
+
+// webhookBodyOverhead is how far a webhook request body may exceed the
+// maximum email size, leaving room for the JSON around the body
+const webhookBodyOverhead = 1024 * 1024
+
+// webhookShutdownTimeout bounds how long the webhook receiver waits for
+// open requests once the processor starts shutting down
+const webhookShutdownTimeout = 30 * time.Second
+
+// webhookResponse is the body of a successful webhook request
+type webhookResponse struct {
+	RequestID string `json:"request_id"`
+}
+
+// WebhookHandler receives inbound email posted as a JSON Email, e.g.
+// {"from": "...", "to": "...", "subject": "...", "body": "..."}, and hands
+// it to Process like the SMTP server does. Connection fields are set from
+// the request rather than the body. Request bodies are verified with the
+// configured WebhookAuth and requests share the processor's in-flight limit.
+func (p *Processor) WebhookHandler() http.Handler {
+	return p.BusyGuard(http.HandlerFunc(p.handleWebhook))
+}
+
+func (p *Processor) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, p.maxSize()+webhookBodyOverhead)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Email too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	auth := p.config.WebhookAuth
+	if err := auth.VerifyBody(r.Header.Get(webhookSignatureHeader), body); err != nil {
+		log.Printf("Rejecting webhook from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "Invalid signature", http.StatusNotAcceptable)
+		return
+	}
+	if auth.insecureSkipVerify {
+		log.Printf("WARNING: INSECURE: accepting unverified webhook from %s", r.RemoteAddr)
+	}
+
+	var email Email
+	if err := json.Unmarshal(body, &email); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid email: %v", err), http.StatusBadRequest)
+		return
+	}
+	if email.To == "" {
+		http.Error(w, "Invalid email: missing to", http.StatusBadRequest)
+		return
+	}
+	if !p.acceptsDomain(email.To) {
+		log.Printf("Rejecting webhook for %s: domain not handled by this server", email.To)
+		http.Error(w, "Recipient domain not handled by this server", http.StatusBadRequest)
+		return
+	}
+
+	email.RequestID = newRequestID()
+	email.ReceivedFrom = r.RemoteAddr
+	email.ReceivedAt = time.Now()
+	email.AuthenticatedAs = ""
+	email.ReceivedTLS = r.TLS != nil
+	email.TLSVersion, email.TLSCipher = "", ""
+	if r.TLS != nil {
+		email.TLSVersion = tls.VersionName(r.TLS.Version)
+		email.TLSCipher = tls.CipherSuiteName(r.TLS.CipherSuite)
+	}
+
+	p.processWebhookEmail(w, email)
+}
+
+// processWebhookEmail processes an email received by webhook and answers
+// with its request ID, or with the status matching the processing error
+func (p *Processor) processWebhookEmail(w http.ResponseWriter, email Email) {
+	if err := p.Process(email); err != nil {
+		switch {
+		case errors.Is(err, ErrTooLarge):
+			http.Error(w, "Email too large", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, ErrOverloaded), errors.Is(err, ErrMaintenance), errors.Is(err, ErrLookupFailed), errors.Is(err, ErrDeliveryFailed):
+			w.Header().Set("Retry-After", webhookRetryAfter)
+			http.Error(w, "Temporarily unable to accept email, try again later", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "Failed to process email", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhookResponse{RequestID: email.RequestID})
+}
+
+// StartWebhookServer starts the webhook receiver and serves until the
+// processor starts shutting down, then stops accepting requests and waits
+// for open ones to finish
+func StartWebhookServer(processor *Processor, host string, port int) error {
+	if processor.config.WebhookAuth == nil {
+		return errors.New("webhook receiver needs webhook authentication configured")
+	}
+	return serveWebhook(processor, host, port, processor.WebhookHandler())
+}
+
+// serveWebhook serves handler until the processor starts shutting down
+func serveWebhook(processor *Processor, host string, port int, handler http.Handler) error {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-processor.closed
+		ctx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Webhook server shutdown: %v", err)
+		}
+	}()
+
+	log.Printf("Starting webhook server at %s", addr)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		<-shutdownDone
+		log.Printf("Webhook server stopped")
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,289 @@
+package email
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/looprock/email-to-api/internal/database"
+)
+
+func TestBusyGuard_ReturnsBusyWhenSaturated(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{MaxSize: 1024 * 1024, RetryAttempts: 1, MaxInFlight: 1})
+
+	handled := 0
+	handler := processor.BusyGuard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled++
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Before saturation requests pass through
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", nil))
+	if rec.Code != http.StatusOK || handled != 1 {
+		t.Fatalf("Expected request to be handled, got %d", rec.Code)
+	}
+
+	// Fill the only slot with an email whose delivery blocks
+	if err := processor.Process(Email{From: "first@example.com", To: mapping.GeneratedEmail}); err != nil {
+		t.Fatalf("Failed to process first email: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 when saturated, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+	if handled != 1 {
+		t.Error("Expected saturated request not to reach the handler")
+	}
+}
+
+func TestWebhookAuth_InsecureSkipVerify(t *testing.T) {
+	body := []byte(`{"to": "a@example.com"}`)
+
+	if err := NewWebhookAuth("key", false).VerifyBody("", body); err == nil {
+		t.Error("Expected unsigned body to be rejected by default")
+	}
+	if err := NewWebhookAuth("", true).VerifyBody("", body); err != nil {
+		t.Errorf("Expected unsigned body to be accepted with verification disabled, got %v", err)
+	}
+}
+
+func TestWebhookAuth_Verify(t *testing.T) {
+	auth := NewWebhookAuth("key", false)
+	now := time.Unix(1700000000, 0)
+	auth.now = func() time.Time { return now }
+
+	sign := func(timestamp, token string) string {
+		mac := hmac.New(sha256.New, []byte("key"))
+		mac.Write([]byte(timestamp + token))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	if err := auth.Verify("1700000000", "tok", sign("1700000000", "tok")); err != nil {
+		t.Errorf("Expected valid signature to verify, got %v", err)
+	}
+	if err := auth.Verify("1700000000", "tok", sign("1700000000", "other")); err == nil {
+		t.Error("Expected wrong signature to fail")
+	}
+	if err := auth.Verify("1699999000", "tok", sign("1699999000", "tok")); err == nil {
+		t.Error("Expected stale timestamp to fail")
+	}
+}
+
+func TestWebhookAuth_VerifyBody(t *testing.T) {
+	auth := NewWebhookAuth("key", false)
+	now := time.Unix(1700000000, 0)
+	auth.now = func() time.Time { return now }
+	body := []byte(`{"to": "a@example.com"}`)
+
+	if err := auth.VerifyBody(signPayload("key", now, body), body); err != nil {
+		t.Errorf("Expected valid signature to verify, got %v", err)
+	}
+	if err := auth.VerifyBody(signPayload("key", now, body), []byte(`{"to": "b@example.com"}`)); err == nil {
+		t.Error("Expected signature over a different body to fail")
+	}
+	if err := auth.VerifyBody(signPayload("other", now, body), body); err == nil {
+		t.Error("Expected signature with the wrong key to fail")
+	}
+	stale := now.Add(-webhookMaxAge - time.Minute)
+	if err := auth.VerifyBody(signPayload("key", stale, body), body); err == nil {
+		t.Error("Expected stale timestamp to fail")
+	}
+	if err := auth.VerifyBody("t=1700000000", body); err == nil {
+		t.Error("Expected header without a signature to fail")
+	}
+}
+
+func TestWebhookHandler_ProcessesJSONEmail(t *testing.T) {
+	var data ProcessedData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{
+		MaxSize:         1024 * 1024,
+		RetryAttempts:   1,
+		Synchronous:     true,
+		AcceptedDomains: []string{"example.com"},
+		WebhookAuth:     NewWebhookAuth("", true),
+	})
+	handler := processor.WebhookHandler()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "192.0.2.9:4321"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post(`{"from": "sender@example.com", "to": "` + mapping.GeneratedEmail + `", "subject": "hello world", "body": "hi", "receivedfrom": "10.0.0.1:25"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp webhookResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.RequestID == "" {
+		t.Errorf("Expected a request ID in the response, got %v (%v)", resp, err)
+	}
+	if data.Data.Subject != "hello world" || data.Data.From != "sender@example.com" {
+		t.Errorf("Expected the email to be forwarded, got %+v", data.Data)
+	}
+	if data.Data.ReceivedFrom != "192.0.2.9:4321" {
+		t.Errorf("Expected received_from from the request, not the body, got %q", data.Data.ReceivedFrom)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"malformed", `{"to": `, http.StatusBadRequest},
+		{"wrong type", `{"to": 42}`, http.StatusBadRequest},
+		{"missing recipient", `{"from": "sender@example.com"}`, http.StatusBadRequest},
+		{"other domain", `{"from": "sender@example.com", "to": "someone@elsewhere.org"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := post(tt.body); rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestWebhookHandler_RequiresSignature(t *testing.T) {
+	processor := New(newTestDB(t), ProcessorConfig{MaxSize: 1024 * 1024, WebhookAuth: NewWebhookAuth("key", false)})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"to": "a@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	processor.WebhookHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotAcceptable {
+		t.Errorf("Expected unsigned request to be rejected with 406, got %d", rec.Code)
+	}
+
+	// Mailgun style query parameters don't sign the body and aren't accepted
+	mac := hmac.New(sha256.New, []byte("key"))
+	timestamp := fmt.Sprint(time.Now().Unix())
+	mac.Write([]byte(timestamp + "tok"))
+	req = httptest.NewRequest("POST", "/?timestamp="+timestamp+"&token=tok&signature="+hex.EncodeToString(mac.Sum(nil)), strings.NewReader(`{"to": "a@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	processor.WebhookHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotAcceptable {
+		t.Errorf("Expected query string signature to be rejected with 406, got %d", rec.Code)
+	}
+}
+
+func TestWebhookHandler_AcceptsSignedBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db := newTestDB(t)
+	mapping := createTestMapping(t, db, ts.URL, database.MappingOptions{})
+	processor := New(db, ProcessorConfig{
+		MaxSize:         1024 * 1024,
+		RetryAttempts:   1,
+		Synchronous:     true,
+		AcceptedDomains: []string{"example.com"},
+		WebhookAuth:     NewWebhookAuth("key", false),
+	})
+
+	body := []byte(`{"from": "sender@example.com", "to": "` + mapping.GeneratedEmail + `", "subject": "signed"}`)
+	post := func(body []byte, signature string) int {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Signature", signature)
+		rec := httptest.NewRecorder()
+		processor.WebhookHandler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	signature := signPayload("key", time.Now(), body)
+	if code := post(body, signature); code != http.StatusOK {
+		t.Fatalf("Expected signed body to be accepted, got %d", code)
+	}
+
+	tampered := []byte(strings.Replace(string(body), "signed", "tampered", 1))
+	if code := post(tampered, signature); code != http.StatusNotAcceptable {
+		t.Errorf("Expected body changed after signing to be rejected with 406, got %d", code)
+	}
+}
+
+func TestStartWebhookServer_StopsOnShutdown(t *testing.T) {
+	processor := New(newTestDB(t), ProcessorConfig{MaxSize: 1024 * 1024, WebhookAuth: NewWebhookAuth("", true)})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- StartWebhookServer(processor, "127.0.0.1", port) }()
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/", port)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusMethodNotAllowed {
+				t.Errorf("Expected GET to be refused with 405, got %d", resp.StatusCode)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Webhook server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	processor.Shutdown(time.Second)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean stop, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook server did not stop after shutdown")
+	}
+}
+
+func TestStartWebhookServer_RequiresAuth(t *testing.T) {
+	processor := New(newTestDB(t), ProcessorConfig{MaxSize: 1024 * 1024})
+	if err := StartWebhookServer(processor, "127.0.0.1", 0); err == nil {
+		t.Error("Expected the webhook server to refuse to start without authentication")
+	}
+}
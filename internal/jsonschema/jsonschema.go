@@ -0,0 +1,413 @@
+// Package jsonschema validates JSON documents against a JSON Schema.
+//
+// It implements the validation keywords payload schemas need: type, enum,
+// const, properties, required, additionalProperties, items, minItems,
+// maxItems, minLength, maxLength, pattern, minimum, maximum, allOf, anyOf,
+// oneOf and not. Annotations such as title, description and format are
+// accepted and ignored. Any other keyword, $ref included, is rejected when
+// the schema is compiled rather than silently skipped.
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// annotations are keywords that don't affect validation
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true,
+	"description": true, "default": true, "examples": true, "format": true,
+	"readOnly": true, "writeOnly": true, "deprecated": true,
+}
+
+// types are the values of the type keyword
+var types = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+// Schema is a compiled schema
+type Schema struct {
+	always *bool // Set for the true and false schemas
+
+	types                []string
+	enum                 []interface{}
+	constant             interface{}
+	hasConst             bool
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	items                *Schema
+	minItems, maxItems   *int
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	minimum, maximum     *float64
+	allOf, anyOf, oneOf  []*Schema
+	not                  *Schema
+}
+
+// ValidationError describes where a document fails its schema
+type ValidationError struct {
+	Path    string // JSON pointer to the failing value, "" for the document
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Compile parses a schema given as JSON
+func Compile(src string) (*Schema, error) {
+	var raw interface{}
+	if err := json.Unmarshal([]byte(src), &raw); err != nil {
+		return nil, fmt.Errorf("not valid JSON: %w", err)
+	}
+	return compile(raw, "")
+}
+
+func compile(raw interface{}, path string) (*Schema, error) {
+	if b, ok := raw.(bool); ok {
+		return &Schema{always: &b}, nil
+	}
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, schemaError(path, "schema must be an object or a boolean")
+	}
+
+	s := &Schema{}
+	// Sorted so the same schema always reports the same error
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, at := obj[key], path+"/"+escape(key)
+		var err error
+		switch key {
+		case "type":
+			s.types, err = compileTypes(value, at)
+		case "enum":
+			values, ok := value.([]interface{})
+			if !ok {
+				return nil, schemaError(at, "must be an array")
+			}
+			s.enum = values
+		case "const":
+			s.constant, s.hasConst = value, true
+		case "properties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, schemaError(at, "must be an object")
+			}
+			s.properties = make(map[string]*Schema, len(props))
+			for name, prop := range props {
+				if s.properties[name], err = compile(prop, at+"/"+escape(name)); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			names, ok := value.([]interface{})
+			if !ok {
+				return nil, schemaError(at, "must be an array of strings")
+			}
+			for _, name := range names {
+				str, ok := name.(string)
+				if !ok {
+					return nil, schemaError(at, "must be an array of strings")
+				}
+				s.required = append(s.required, str)
+			}
+		case "additionalProperties":
+			s.additionalProperties, err = compile(value, at)
+		case "items":
+			s.items, err = compile(value, at)
+		case "not":
+			s.not, err = compile(value, at)
+		case "minItems":
+			s.minItems, err = compileCount(value, at)
+		case "maxItems":
+			s.maxItems, err = compileCount(value, at)
+		case "minLength":
+			s.minLength, err = compileCount(value, at)
+		case "maxLength":
+			s.maxLength, err = compileCount(value, at)
+		case "minimum":
+			s.minimum, err = compileNumber(value, at)
+		case "maximum":
+			s.maximum, err = compileNumber(value, at)
+		case "pattern":
+			str, ok := value.(string)
+			if !ok {
+				return nil, schemaError(at, "must be a string")
+			}
+			if s.pattern, err = regexp.Compile(str); err != nil {
+				return nil, schemaError(at, "invalid pattern: "+err.Error())
+			}
+		case "allOf":
+			s.allOf, err = compileList(value, at)
+		case "anyOf":
+			s.anyOf, err = compileList(value, at)
+		case "oneOf":
+			s.oneOf, err = compileList(value, at)
+		default:
+			if !annotations[key] {
+				return nil, schemaError(at, "unsupported keyword")
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func compileTypes(value interface{}, path string) ([]string, error) {
+	var names []interface{}
+	switch v := value.(type) {
+	case string:
+		names = []interface{}{v}
+	case []interface{}:
+		names = v
+	default:
+		return nil, schemaError(path, "must be a string or an array of strings")
+	}
+
+	var result []string
+	for _, name := range names {
+		str, ok := name.(string)
+		if !ok || !types[str] {
+			return nil, schemaError(path, fmt.Sprintf("unknown type %v", name))
+		}
+		result = append(result, str)
+	}
+	return result, nil
+}
+
+func compileCount(value interface{}, path string) (*int, error) {
+	n, ok := value.(float64)
+	if !ok || n < 0 || n != math.Trunc(n) {
+		return nil, schemaError(path, "must be a non-negative integer")
+	}
+	count := int(n)
+	return &count, nil
+}
+
+func compileNumber(value interface{}, path string) (*float64, error) {
+	n, ok := value.(float64)
+	if !ok {
+		return nil, schemaError(path, "must be a number")
+	}
+	return &n, nil
+}
+
+func compileList(value interface{}, path string) ([]*Schema, error) {
+	items, ok := value.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, schemaError(path, "must be a non-empty array of schemas")
+	}
+	schemas := make([]*Schema, len(items))
+	for i, item := range items {
+		var err error
+		if schemas[i], err = compile(item, path+"/"+strconv.Itoa(i)); err != nil {
+			return nil, err
+		}
+	}
+	return schemas, nil
+}
+
+// schemaError reports a problem with the schema itself, at path
+func schemaError(path, message string) error {
+	if path == "" {
+		return errors.New(message)
+	}
+	return fmt.Errorf("%s: %s", path, message)
+}
+
+// Validate checks a JSON document against the schema, returning a
+// *ValidationError for the first violation found
+func (s *Schema) Validate(data []byte) error {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return &ValidationError{Message: "invalid JSON: " + err.Error()}
+	}
+	return s.validate(doc, "")
+}
+
+func (s *Schema) validate(v interface{}, path string) error {
+	if s.always != nil {
+		if *s.always {
+			return nil
+		}
+		return &ValidationError{Path: path, Message: "no value is allowed here"}
+	}
+
+	if len(s.types) > 0 && !matchesType(v, s.types) {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("expected %s, got %s", strings.Join(s.types, " or "), typeOf(v))}
+	}
+	if s.enum != nil && !contains(s.enum, v) {
+		return &ValidationError{Path: path, Message: "value is not one of the allowed values"}
+	}
+	if s.hasConst && !reflect.DeepEqual(s.constant, v) {
+		return &ValidationError{Path: path, Message: "value does not match the expected constant"}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if err := s.validateObject(v, path); err != nil {
+			return err
+		}
+	case []interface{}:
+		if err := s.validateArray(v, path); err != nil {
+			return err
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.minLength != nil && length < *s.minLength {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("must be at least %d characters", *s.minLength)}
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("must be at most %d characters", *s.maxLength)}
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("does not match pattern %q", s.pattern.String())}
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("must be at least %v", *s.minimum)}
+		}
+		if s.maximum != nil && v > *s.maximum {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("must be at most %v", *s.maximum)}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if len(s.anyOf) > 0 && countValid(s.anyOf, v, path) == 0 {
+		return &ValidationError{Path: path, Message: "does not match any of the anyOf schemas"}
+	}
+	if len(s.oneOf) > 0 {
+		if n := countValid(s.oneOf, v, path); n != 1 {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("must match exactly one of the oneOf schemas, matches %d", n)}
+		}
+	}
+	if s.not != nil && s.not.validate(v, path) == nil {
+		return &ValidationError{Path: path, Message: "must not match the schema in not"}
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(obj map[string]interface{}, path string) error {
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("missing required property %q", name)}
+		}
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sub, ok := s.properties[name]
+		if !ok {
+			sub = s.additionalProperties
+		}
+		if sub == nil {
+			continue
+		}
+		if err := sub.validate(obj[name], path+"/"+escape(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateArray(items []interface{}, path string) error {
+	if s.minItems != nil && len(items) < *s.minItems {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("must have at least %d items", *s.minItems)}
+	}
+	if s.maxItems != nil && len(items) > *s.maxItems {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("must have at most %d items", *s.maxItems)}
+	}
+	if s.items != nil {
+		for i, item := range items {
+			if err := s.items.validate(item, path+"/"+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func countValid(schemas []*Schema, v interface{}, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		if sub.validate(v, path) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func matchesType(v interface{}, names []string) bool {
+	actual := typeOf(v)
+	for _, name := range names {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf names the JSON type of a decoded value; whole numbers are integers
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func contains(values []interface{}, v interface{}) bool {
+	for _, value := range values {
+		if reflect.DeepEqual(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// escape encodes a property name as a JSON pointer token
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
@@ -0,0 +1,85 @@
+package jsonschema
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSchema_Validate(t *testing.T) {
+	schema, err := Compile(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "ticket",
+		"type": "object",
+		"required": ["subject", "priority"],
+		"properties": {
+			"subject": {"type": "string", "minLength": 1, "maxLength": 10},
+			"priority": {"enum": ["low", "high"]},
+			"count": {"type": "integer", "minimum": 0},
+			"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}, "maxItems": 2},
+			"owner": {"anyOf": [{"type": "null"}, {"type": "string", "format": "email"}]}
+		},
+		"additionalProperties": false
+	}`)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		doc      string
+		wantPath string // JSON pointer of the failing value
+		wantErr  bool
+	}{
+		{name: "valid", doc: `{"subject": "disk full", "priority": "high", "count": 3, "tags": ["ops"], "owner": null}`},
+		{name: "missing required", doc: `{"subject": "disk full"}`, wantErr: true},
+		{name: "wrong type", doc: `{"subject": 42, "priority": "low"}`, wantPath: "/subject", wantErr: true},
+		{name: "too long", doc: `{"subject": "much too long", "priority": "low"}`, wantPath: "/subject", wantErr: true},
+		{name: "not in enum", doc: `{"subject": "x", "priority": "urgent"}`, wantPath: "/priority", wantErr: true},
+		{name: "not an integer", doc: `{"subject": "x", "priority": "low", "count": 1.5}`, wantPath: "/count", wantErr: true},
+		{name: "below minimum", doc: `{"subject": "x", "priority": "low", "count": -1}`, wantPath: "/count", wantErr: true},
+		{name: "bad item", doc: `{"subject": "x", "priority": "low", "tags": ["ok", "Not OK"]}`, wantPath: "/tags/1", wantErr: true},
+		{name: "too many items", doc: `{"subject": "x", "priority": "low", "tags": ["a", "b", "c"]}`, wantPath: "/tags", wantErr: true},
+		{name: "no anyOf match", doc: `{"subject": "x", "priority": "low", "owner": 7}`, wantPath: "/owner", wantErr: true},
+		{name: "additional property", doc: `{"subject": "x", "priority": "low", "extra": true}`, wantPath: "/extra", wantErr: true},
+		{name: "not an object", doc: `[]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.Validate([]byte(tt.doc))
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validate() error = %v, want a ValidationError", err)
+			}
+			if validationErr.Path != tt.wantPath {
+				t.Errorf("Error path = %q, want %q (%v)", validationErr.Path, tt.wantPath, err)
+			}
+		})
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{"not JSON", `{"type":`},
+		{"not an object", `"string"`},
+		{"unknown type", `{"type": "text"}`},
+		{"bad pattern", `{"pattern": "("}`},
+		{"negative count", `{"minLength": -1}`},
+		{"unsupported keyword", `{"properties": {"a": {"$ref": "#/defs/a"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile(tt.schema); err == nil {
+				t.Errorf("Compile(%s) succeeded, want an error", tt.schema)
+			}
+		})
+	}
+}
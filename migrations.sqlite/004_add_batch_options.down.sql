@@ -0,0 +1,3 @@
+ALTER TABLE email_mappings DROP COLUMN batch_max_bytes;
+ALTER TABLE email_mappings DROP COLUMN batch_window;
+ALTER TABLE email_mappings DROP COLUMN batch_size;
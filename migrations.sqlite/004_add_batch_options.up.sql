@@ -0,0 +1,4 @@
+-- Per-mapping batching of deliveries
+ALTER TABLE email_mappings ADD COLUMN batch_size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE email_mappings ADD COLUMN batch_window INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE email_mappings ADD COLUMN batch_max_bytes INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- Per-mapping payload key style and custom key renames
+ALTER TABLE email_mappings ADD COLUMN field_naming VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE email_mappings ADD COLUMN field_name_map TEXT;
@@ -0,0 +1,17 @@
+-- Track queued and retrying deliveries
+CREATE TABLE IF NOT EXISTS deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    mapping_id INTEGER NOT NULL,
+    request_id VARCHAR(64),
+    subject TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (mapping_id) REFERENCES email_mappings(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_deliveries_mapping_id ON deliveries(mapping_id);
+CREATE INDEX IF NOT EXISTS idx_deliveries_request_id ON deliveries(request_id);
@@ -0,0 +1,2 @@
+-- Per-mapping option to add a body without quoted replies and signature
+ALTER TABLE email_mappings ADD COLUMN strip_quoted BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- Per-mapping tags added to every email regardless of subject
+ALTER TABLE email_mappings ADD COLUMN static_tags TEXT;
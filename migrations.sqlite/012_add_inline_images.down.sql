@@ -0,0 +1 @@
+ALTER TABLE email_mappings DROP COLUMN inline_images;
@@ -0,0 +1,2 @@
+-- Per-mapping rewriting of cid: references to inline images in the HTML body
+ALTER TABLE email_mappings ADD COLUMN inline_images VARCHAR(10) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Per-mapping pin of the payload schema version
+ALTER TABLE email_mappings ADD COLUMN payload_version VARCHAR(10) NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_email_logs_deleted_at;
+DROP INDEX IF EXISTS idx_email_mappings_deleted_at;
+ALTER TABLE email_logs DROP COLUMN deleted_at;
+ALTER TABLE email_mappings DROP COLUMN deleted_at;
@@ -0,0 +1,5 @@
+-- Tombstones for deleted mappings and their logs, purged after a grace period
+ALTER TABLE email_mappings ADD COLUMN deleted_at DATETIME;
+ALTER TABLE email_logs ADD COLUMN deleted_at DATETIME;
+CREATE INDEX IF NOT EXISTS idx_email_mappings_deleted_at ON email_mappings(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_email_logs_deleted_at ON email_logs(deleted_at);
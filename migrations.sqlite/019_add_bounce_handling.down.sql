@@ -0,0 +1,2 @@
+ALTER TABLE email_mappings DROP COLUMN bounce_endpoint;
+ALTER TABLE email_mappings DROP COLUMN bounce_action;
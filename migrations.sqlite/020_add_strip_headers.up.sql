@@ -0,0 +1,2 @@
+-- Per-mapping header names left out of the forwarded headers map
+ALTER TABLE email_mappings ADD COLUMN strip_headers TEXT;
@@ -0,0 +1,3 @@
+-- Source of each user's last login, for security review
+ALTER TABLE users ADD COLUMN last_login_ip VARCHAR(45) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN last_login_ua TEXT NOT NULL DEFAULT '';
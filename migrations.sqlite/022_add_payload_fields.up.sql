@@ -0,0 +1,2 @@
+-- Per-mapping allowlist of payload data fields to forward
+ALTER TABLE email_mappings ADD COLUMN payload_fields TEXT;
@@ -0,0 +1,3 @@
+-- Per-user time zone and locale for displaying timestamps
+ALTER TABLE users ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN locale VARCHAR(35) NOT NULL DEFAULT '';
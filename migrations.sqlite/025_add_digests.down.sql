@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS digest_entries;
+ALTER TABLE email_mappings DROP COLUMN digest_hour;
+ALTER TABLE email_mappings DROP COLUMN digest_schedule;
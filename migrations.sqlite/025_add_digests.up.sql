@@ -0,0 +1,15 @@
+-- Per-mapping digest delivery and the emails held for the next digest
+ALTER TABLE email_mappings ADD COLUMN digest_schedule VARCHAR(10) NOT NULL DEFAULT '';
+ALTER TABLE email_mappings ADD COLUMN digest_hour INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS digest_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    mapping_id INTEGER NOT NULL,
+    request_id VARCHAR(64),
+    sender TEXT NOT NULL DEFAULT '',
+    subject TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (mapping_id) REFERENCES email_mappings(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_digest_entries_mapping_id ON digest_entries(mapping_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_email_logs_client_ip;
+ALTER TABLE digest_entries DROP COLUMN client_ip;
+ALTER TABLE email_logs DROP COLUMN client_ip;
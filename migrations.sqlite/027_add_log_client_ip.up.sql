@@ -0,0 +1,5 @@
+-- Record the IP address of the client that sent each email
+ALTER TABLE email_logs ADD COLUMN client_ip VARCHAR(45) NOT NULL DEFAULT '';
+ALTER TABLE digest_entries ADD COLUMN client_ip VARCHAR(45) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_email_logs_client_ip ON email_logs(client_ip);
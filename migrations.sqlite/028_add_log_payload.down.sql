@@ -0,0 +1 @@
+ALTER TABLE email_logs DROP COLUMN payload;
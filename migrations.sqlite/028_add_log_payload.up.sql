@@ -0,0 +1,2 @@
+-- Keep the payload of failed deliveries so they can be retried in bulk
+ALTER TABLE email_logs ADD COLUMN payload TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Optional per-mapping secret for signing endpoint payloads
+ALTER TABLE email_mappings ADD COLUMN signing_secret TEXT NOT NULL DEFAULT '';
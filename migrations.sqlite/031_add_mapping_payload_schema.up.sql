@@ -0,0 +1,2 @@
+-- Optional JSON Schema payloads are validated against before sending
+ALTER TABLE email_mappings ADD COLUMN payload_schema TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE email_mappings DROP COLUMN body_template;
+ALTER TABLE email_mappings DROP COLUMN http_method;
@@ -0,0 +1,3 @@
+-- Per-mapping HTTP method and request body template
+ALTER TABLE email_mappings ADD COLUMN http_method TEXT NOT NULL DEFAULT '';
+ALTER TABLE email_mappings ADD COLUMN body_template TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Per-mapping option to add the headers as one string per name
+ALTER TABLE email_mappings ADD COLUMN flatten_headers BOOLEAN NOT NULL DEFAULT FALSE;
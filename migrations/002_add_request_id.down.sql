@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_email_logs_request_id;
+
+ALTER TABLE email_logs DROP COLUMN IF EXISTS request_id;
@@ -0,0 +1,4 @@
+-- Correlate log rows with the request ID sent to endpoints as X-Request-ID
+ALTER TABLE email_logs ADD COLUMN IF NOT EXISTS request_id VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_email_logs_request_id ON email_logs(request_id);
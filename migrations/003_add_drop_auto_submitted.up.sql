@@ -0,0 +1,2 @@
+-- Per-mapping option to filter auto-submitted mail (RFC 3834)
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS drop_auto_submitted BOOLEAN NOT NULL DEFAULT FALSE;
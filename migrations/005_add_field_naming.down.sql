@@ -0,0 +1,2 @@
+ALTER TABLE email_mappings DROP COLUMN IF EXISTS field_name_map;
+ALTER TABLE email_mappings DROP COLUMN IF EXISTS field_naming;
@@ -0,0 +1,2 @@
+-- Per-mapping control over which results are written to email_logs
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS log_level VARCHAR(10) NOT NULL DEFAULT '';
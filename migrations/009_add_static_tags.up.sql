@@ -0,0 +1,2 @@
+-- Per-mapping tags added to every email regardless of subject
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS static_tags TEXT;
@@ -0,0 +1,2 @@
+-- Per-mapping overrides for how endpoint response codes are treated
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS status_actions TEXT;
@@ -0,0 +1,2 @@
+-- Nothing to undo: '{}' and null are equivalent for readers
+SELECT 1;
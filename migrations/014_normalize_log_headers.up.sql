@@ -0,0 +1,2 @@
+-- Store missing log headers as an empty object rather than JSON null
+UPDATE email_logs SET headers = '{}' WHERE headers IS NULL OR headers = '' OR headers = 'null';
@@ -0,0 +1,2 @@
+-- Per-user headers merged into each new mapping
+ALTER TABLE users ADD COLUMN IF NOT EXISTS default_headers TEXT;
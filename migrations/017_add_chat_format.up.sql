@@ -0,0 +1,2 @@
+-- Per-mapping chat message format (slack, teams) instead of the JSON payload
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS chat_format VARCHAR(10) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Per-mapping regular expression a 2xx response body must match to count as delivered
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS success_pattern TEXT NOT NULL DEFAULT '';
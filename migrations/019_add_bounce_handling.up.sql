@@ -0,0 +1,3 @@
+-- Per-mapping handling of delivery status notifications (bounces)
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS bounce_action VARCHAR(10) NOT NULL DEFAULT '';
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS bounce_endpoint TEXT NOT NULL DEFAULT '';
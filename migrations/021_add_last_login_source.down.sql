@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS last_login_ua;
+ALTER TABLE users DROP COLUMN IF EXISTS last_login_ip;
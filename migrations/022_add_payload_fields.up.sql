@@ -0,0 +1,2 @@
+-- Per-mapping allowlist of payload data fields to forward
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS payload_fields TEXT;
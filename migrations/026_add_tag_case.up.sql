@@ -0,0 +1,2 @@
+-- Per-mapping tag casing
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS tag_case VARCHAR(10) NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE email_mappings DROP COLUMN IF EXISTS max_in_flight;
@@ -0,0 +1,2 @@
+-- Per-mapping limit on concurrent deliveries
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS max_in_flight INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE email_mappings DROP COLUMN IF EXISTS body_template;
+ALTER TABLE email_mappings DROP COLUMN IF EXISTS http_method;
@@ -0,0 +1,3 @@
+-- Per-mapping HTTP method and request body template
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS http_method TEXT NOT NULL DEFAULT '';
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS body_template TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,21 @@
+-- Emails that failed after every retry, kept for inspection and replay
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id SERIAL PRIMARY KEY,
+    mapping_id INTEGER NOT NULL,
+    request_id VARCHAR(64),
+    subject TEXT NOT NULL DEFAULT '',
+    from_address TEXT NOT NULL DEFAULT '',
+    endpoint_url TEXT NOT NULL DEFAULT '',
+    headers TEXT,
+    payload TEXT NOT NULL DEFAULT '',
+    payload_size INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'failed',
+    replays INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (mapping_id) REFERENCES email_mappings(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_dead_letters_mapping_id ON dead_letters(mapping_id);
+CREATE INDEX IF NOT EXISTS idx_dead_letters_request_id ON dead_letters(request_id);
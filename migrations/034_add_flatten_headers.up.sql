@@ -0,0 +1,2 @@
+-- Per-mapping option to add the headers as one string per name
+ALTER TABLE email_mappings ADD COLUMN IF NOT EXISTS flatten_headers BOOLEAN NOT NULL DEFAULT FALSE;